@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// Kinds of files "scripts adopt" finds
+const (
+	kindScript  = "script"  // shell script, adopted into ScriptDir
+	kindBinary  = "binary"  // compiled executable, adopted into BinDir
+	kindProgram = "program" // executable in another language, adopted into BinDir
+	kindOther   = "other"   // not executable, left alone
+)
+
+// shellInterpreters are the shebang interpreters of scripts adopted as
+// managed scripts
+var shellInterpreters = map[string]bool{"sh": true, "bash": true, "dash": true, "ksh": true, "zsh": true}
+
+// binaryMagics start executables: ELF, Mach-O (32/64-bit, both byte
+// orders, universal) and PE
+var binaryMagics = [][]byte{
+	[]byte("\x7fELF"),
+	{0xfe, 0xed, 0xfa, 0xce}, {0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe}, {0xcf, 0xfa, 0xed, 0xfe},
+	{0xca, 0xfe, 0xba, 0xbe},
+	[]byte("MZ"),
+}
+
+// AdoptOptions controls "scripts adopt"
+type AdoptOptions struct {
+	Yes    bool // adopt everything without asking
+	Link   bool // symlink to the originals instead of copying them
+	DryRun bool // only show what would be adopted
+}
+
+// AdoptCandidate is a file found by "scripts adopt"
+type AdoptCandidate struct {
+	Path string
+	Name string
+	Kind string
+	// Skip explains why the file isn't adopted, if it isn't
+	Skip string
+}
+
+// destination returns where an adopted file goes
+func (c *AdoptCandidate) destination(config *Config) string {
+	if c.Kind == kindScript {
+		return filepath.Join(config.ScriptDir, c.Name+".sh")
+	}
+	return filepath.Join(config.BinDir, c.Name)
+}
+
+// classifyFile tells a shell script from a compiled or other executable
+func classifyFile(path string, info os.FileInfo) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return kindOther
+	}
+	defer f.Close()
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	executable := info.Mode()&0111 != 0
+	for _, magic := range binaryMagics {
+		if bytes.HasPrefix(head, magic) && executable {
+			return kindBinary
+		}
+	}
+	if shebang := shebangCommand(path); len(shebang) > 0 {
+		interpreter := filepath.Base(shebang[0])
+		if interpreter == "env" && len(shebang) > 1 {
+			interpreter = shebang[len(shebang)-1]
+		}
+		if shellInterpreters[interpreter] {
+			return kindScript
+		}
+		if executable {
+			return kindProgram
+		}
+		return kindOther
+	}
+	if ext := filepath.Ext(path); ext == ".sh" || ext == ".bash" {
+		return kindScript
+	}
+	return kindOther
+}
+
+// scanAdopt classifies the files of a directory, not descending into
+// subdirectories
+func scanAdopt(dir string, config *Config) ([]*AdoptCandidate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+	var candidates []*AdoptCandidate
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			candidates = append(candidates, &AdoptCandidate{Path: path, Name: entry.Name(), Kind: kindOther, Skip: "broken symlink"})
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		candidate := &AdoptCandidate{Path: path, Name: entry.Name(), Kind: classifyFile(path, info)}
+		if candidate.Kind == kindScript {
+			candidate.Name = strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".sh"), ".bash")
+		}
+		switch {
+		case candidate.Kind == kindOther:
+			candidate.Skip = "not a script or executable"
+		case !validName(candidate.Name):
+			candidate.Skip = "invalid name"
+		default:
+			if _, err := os.Stat(candidate.destination(config)); err == nil {
+				candidate.Skip = "already managed"
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// withMetadataStub adds a description stub after a script's shebang,
+// unless it already declares metadata
+func withMetadataStub(content []byte, source string) []byte {
+	if bytes.Contains(content, []byte("# "+metadataPrefix)) {
+		return content
+	}
+	stub := fmt.Sprintf("# %sdescription Adopted from %s; describe it here\n", metadataPrefix, source)
+	if bytes.HasPrefix(content, []byte("#!")) {
+		end := bytes.IndexByte(content, '\n')
+		if end < 0 {
+			return append(append(content, '\n'), stub...)
+		}
+		return append(append(append([]byte{}, content[:end+1]...), stub...), content[end+1:]...)
+	}
+	return append([]byte(stub), content...)
+}
+
+// adoptFile copies (or links) a candidate into management
+func adoptFile(c *AdoptCandidate, link bool, config *Config) error {
+	dest := c.destination(config)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(dest), err)
+	}
+	if link {
+		source, err := filepath.Abs(c.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(source, dest); err != nil {
+			return fmt.Errorf("failed to link %s: %v", c.Name, err)
+		}
+		return nil
+	}
+	content, err := os.ReadFile(c.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", c.Path, err)
+	}
+	if c.Kind == kindScript {
+		_, err := installScript(c.Name, withMetadataStub(content, c.Path), false, "", config)
+		return err
+	}
+	info, err := os.Stat(c.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dest, content, info.Mode().Perm()|0100); err != nil {
+		return fmt.Errorf("failed to copy %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// adopt imports the loose scripts and executables of a directory: shell
+// scripts become managed scripts (with a metadata stub when copied) and
+// other executables go to BinDir. Each is confirmed unless opts.Yes is
+// set; the originals are left in place.
+func adopt(dir string, opts *AdoptOptions, config *Config) error {
+	candidates, err := scanAdopt(dir, config)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		fmt.Printf("No files found in %s\n", dir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tKIND\tADOPT AS")
+	var adoptable []*AdoptCandidate
+	for _, c := range candidates {
+		var target string
+		if c.Skip != "" {
+			target = "skipped: " + c.Skip
+		} else {
+			target = c.destination(config)
+			adoptable = append(adoptable, c)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", filepath.Base(c.Path), c.Kind, target)
+	}
+	w.Flush()
+	if opts.DryRun || len(adoptable) == 0 {
+		return nil
+	}
+	if !opts.Yes && !canPrompt() {
+		return fmt.Errorf("not adopting without confirmation (rerun with --yes)")
+	}
+
+	fmt.Println()
+	reader := bufio.NewReader(os.Stdin)
+	all := opts.Yes
+	adopted := 0
+	for _, c := range adoptable {
+		if !all {
+			fmt.Printf("Adopt %s as %s %s? [y/N/a(ll)/q(uit)] ", filepath.Base(c.Path), c.Kind, c.Name)
+			answer, _ := reader.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "y", "yes":
+			case "a", "all":
+				all = true
+			case "q", "quit":
+				fmt.Printf("Adopted %d of %d files\n", adopted, len(adoptable))
+				return nil
+			default:
+				continue
+			}
+		}
+		if err := adoptFile(c, opts.Link, config); err != nil {
+			return err
+		}
+		adopted++
+		fmt.Printf("Adopted %s as %s %s\n", filepath.Base(c.Path), c.Kind, c.Name)
+	}
+	how := "copied; the originals are untouched"
+	if opts.Link {
+		how = "linked to the originals"
+	}
+	fmt.Printf("Adopted %d of %d files (%s)\n", adopted, len(adoptable), how)
+	return nil
+}
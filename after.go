@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Prerequisite is a script declared with "# scripts:after <name>...
+// [within=<duration>]", which runs before the script declaring it. With
+// within, a successful run that recent is enough and it isn't run again.
+type Prerequisite struct {
+	Name   string
+	Path   string
+	Within time.Duration
+}
+
+// scriptAfter parses the prerequisites a script declares
+func scriptAfter(path string) ([]*Prerequisite, error) {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	var prereqs []*Prerequisite
+	for _, value := range meta.Values["after"] {
+		var names []string
+		var within time.Duration
+		for _, field := range strings.Fields(value) {
+			if duration, ok := strings.CutPrefix(field, "within="); ok {
+				if within, err = time.ParseDuration(duration); err != nil || within <= 0 {
+					return nil, fmt.Errorf("%s: invalid within=%s (use e.g. 30m or 6h)", path, duration)
+				}
+				continue
+			}
+			names = append(names, field)
+		}
+		for _, name := range names {
+			prereqs = append(prereqs, &Prerequisite{Name: name, Within: within})
+		}
+	}
+	return prereqs, nil
+}
+
+// prerequisites resolves a script's prerequisites and theirs, in the
+// order they run. A prerequisite reached several times runs once, and a
+// cycle is an error.
+func prerequisites(name, path string, config *Config) ([]*Prerequisite, error) {
+	var order []*Prerequisite
+	resolved := map[string]*Prerequisite{}
+	var visit func(name, path string, stack []string) error
+	visit = func(name, path string, stack []string) error {
+		stack = append(stack, name)
+		prereqs, err := scriptAfter(path)
+		if err != nil {
+			return err
+		}
+		for _, prereq := range prereqs {
+			for _, seen := range stack {
+				if seen == prereq.Name {
+					return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(stack, " -> "), prereq.Name)
+				}
+			}
+			if existing, ok := resolved[prereq.Name]; ok {
+				// The strictest declaration wins; 0 always runs it
+				existing.Within = min(existing.Within, prereq.Within)
+				continue
+			}
+			if prereq.Path, err = findScript(prereq.Name, config); err != nil {
+				return fmt.Errorf("prerequisite of %s: %v", name, err)
+			}
+			// Refer to it by its file name, as runs are recorded
+			prereq.Name = strings.TrimSuffix(filepath.Base(prereq.Path), ".sh")
+			if err := visit(prereq.Name, prereq.Path, stack); err != nil {
+				return err
+			}
+			resolved[prereq.Name] = prereq
+			order = append(order, prereq)
+		}
+		return nil
+	}
+	if err := visit(name, path, nil); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// lastSuccess returns when a script last ran successfully, or the zero
+// time if it never did
+func lastSuccess(name string, config *Config) (time.Time, error) {
+	records, err := loadHistory(config)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Script == name && !records[i].Skipped && records[i].ExitCode == 0 {
+			return records[i].Start, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// runPrerequisites runs the prerequisites of a script in order, stopping
+// at the first that fails. Their parameters take their defaults.
+func runPrerequisites(name, path string, config *Config) error {
+	prereqs, err := prerequisites(name, path, config)
+	if err != nil {
+		return err
+	}
+	for _, prereq := range prereqs {
+		if prereq.Within > 0 {
+			last, err := lastSuccess(prereq.Name, config)
+			if err != nil {
+				return err
+			}
+			if since := time.Since(last); !last.IsZero() && since <= prereq.Within {
+				fmt.Fprintf(os.Stderr, "Prerequisite %s succeeded %s ago\n", prereq.Name, formatDuration(since.Round(time.Second)))
+				continue
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "Running prerequisite %s\n", prereq.Name)
+		args, err := scriptArgs(prereq.Path, nil, &RunOptions{NoPrompt: true})
+		if err != nil {
+			return fmt.Errorf("prerequisite %s: %v", prereq.Name, err)
+		}
+		renderedPath, removeRendered, err := renderTemplate(prereq.Path, nil, "", config)
+		if err != nil {
+			return fmt.Errorf("prerequisite %s: %v", prereq.Name, err)
+		}
+		workdir, err := scriptWorkdir(prereq.Path, "", config)
+		if err != nil {
+			removeRendered()
+			return fmt.Errorf("prerequisite %s: %v", prereq.Name, err)
+		}
+		cmd, err := scriptCommand(renderedPath, args, config)
+		if err != nil {
+			removeRendered()
+			return fmt.Errorf("prerequisite %s: %v", prereq.Name, err)
+		}
+		cmd.Dir = workdir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		record, err := runRecorded(prereq.Name, cmd, &RunOptions{}, config)
+		removeRendered()
+		if err != nil && record.ExitCode > 0 {
+			return fmt.Errorf("prerequisite %s failed with exit code %d, so %s didn't run", prereq.Name, record.ExitCode, name)
+		}
+		if err != nil {
+			return fmt.Errorf("prerequisite %s: %v", prereq.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// degradedFile records the scripts alert rules marked degraded, inside the
+// state directory
+const degradedFile = "degraded.json"
+
+var degradedMu sync.Mutex
+
+// AlertRule maps runs of matching scripts that end with matching exit
+// codes to actions
+type AlertRule struct {
+	// Script is a glob of script names (default: every script)
+	Script string `json:"script,omitempty"`
+	// ExitCodes is "failed" (default: any non-zero code), or codes and
+	// ranges like "1,3,64-78"
+	ExitCodes string `json:"exitCodes,omitempty"`
+	// Notify shows a desktop notification
+	Notify bool `json:"notify,omitempty"`
+	// Run runs another script, with SCRIPTS_ALERT_SCRIPT and
+	// SCRIPTS_ALERT_EXIT_CODE set
+	Run string `json:"run,omitempty"`
+	// Degrade marks the script degraded in the daemon's /status until its
+	// next successful run
+	Degrade bool `json:"degrade,omitempty"`
+}
+
+// matches reports whether a run triggers the rule
+func (r *AlertRule) matches(record *RunRecord) (bool, error) {
+	if r.Script != "" {
+		ok, err := path.Match(r.Script, record.Script)
+		if err != nil {
+			return false, fmt.Errorf("invalid alert script pattern %q", r.Script)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return matchExitCode(r.ExitCodes, record.ExitCode)
+}
+
+// matchExitCode matches an exit code against "failed" (or "") or a list
+// of codes and ranges
+func matchExitCode(pattern string, code int) (bool, error) {
+	if pattern == "" || pattern == "failed" {
+		return code != 0, nil
+	}
+	for _, part := range strings.Split(pattern, ",") {
+		part = strings.TrimSpace(part)
+		low, high, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(low)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(high)
+		}
+		if err != nil {
+			return false, fmt.Errorf("invalid alert exit codes %q (expected failed, or codes and ranges like 1,3,64-78)", pattern)
+		}
+		if code >= from && code <= to {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Degradation is why a script is marked degraded
+type Degradation struct {
+	RunID    string    `json:"runId"`
+	ExitCode int       `json:"exitCode"`
+	Since    time.Time `json:"since"`
+}
+
+// degradedPath returns where degraded scripts are recorded
+func degradedPath(config *Config) string {
+	return filepath.Join(stateDir(config), degradedFile)
+}
+
+// loadDegraded reads the degraded scripts, keyed by name
+func loadDegraded(config *Config) (map[string]*Degradation, error) {
+	degraded := map[string]*Degradation{}
+	data, err := os.ReadFile(degradedPath(config))
+	if os.IsNotExist(err) {
+		return degraded, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read degraded scripts: %v", err)
+	}
+	if err := json.Unmarshal(data, &degraded); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", degradedPath(config), err)
+	}
+	return degraded, nil
+}
+
+// setDegraded marks a script degraded by a run, or clears it when run is nil
+func setDegraded(name string, run *RunRecord, config *Config) error {
+	degradedMu.Lock()
+	defer degradedMu.Unlock()
+	degraded, err := loadDegraded(config)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		if _, ok := degraded[name]; !ok {
+			return nil
+		}
+		delete(degraded, name)
+	} else {
+		degraded[name] = &Degradation{RunID: run.ID, ExitCode: run.ExitCode, Since: run.Start}
+	}
+	data, err := json.MarshalIndent(degraded, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(degradedPath(config), data, 0644); err != nil {
+		return fmt.Errorf("failed to save degraded scripts: %v", err)
+	}
+	return nil
+}
+
+// applyAlerts runs the actions of the alert rules a finished run triggers.
+// A successful run clears the script's degraded mark. Actions that fail
+// are reported as warnings; they never fail the run itself.
+func applyAlerts(record *RunRecord, config *Config) {
+	if record.ExitCode == 0 {
+		if err := setDegraded(record.Script, nil, config); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	for _, rule := range config.Alerts {
+		ok, err := rule.matches(record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if rule.Degrade {
+			if err := setDegraded(record.Script, record, config); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+		if rule.Notify {
+			message := fmt.Sprintf("%s exited with code %d after %s", record.Script, record.ExitCode, formatDuration(record.Duration()))
+			if err := notify("scripts: "+record.Script, message); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: can't send a notification: %v\n", err)
+			}
+		}
+		if rule.Run != "" {
+			if err := runAlertScript(rule.Run, record, config); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: alert script %s: %v\n", rule.Run, err)
+			}
+		}
+	}
+}
+
+// runAlertScript runs the script of an alert rule, telling it which run
+// triggered it. Its own run is recorded but doesn't trigger alerts.
+func runAlertScript(name string, trigger *RunRecord, config *Config) error {
+	scriptPath, err := findScript(name, config)
+	if err != nil {
+		return err
+	}
+	renderedPath, removeRendered, err := renderTemplate(scriptPath, nil, "", config)
+	if err != nil {
+		return err
+	}
+	defer removeRendered()
+	workdir, err := scriptWorkdir(scriptPath, "", config)
+	if err != nil {
+		return err
+	}
+	cmd, err := scriptCommand(renderedPath, nil, config)
+	if err != nil {
+		return err
+	}
+	cmd.Dir = workdir
+	cmd.Env = append(os.Environ(),
+		"SCRIPTS_ALERT_SCRIPT="+trigger.Script,
+		"SCRIPTS_ALERT_EXIT_CODE="+strconv.Itoa(trigger.ExitCode),
+		"SCRIPTS_ALERT_RUN_ID="+trigger.ID)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	_, err = runRecorded(name, cmd, &RunOptions{NoAlerts: true}, config)
+	return err
+}
+
+// notify shows a desktop notification
+func notify(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(message), strconv.Quote(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		return fmt.Errorf("desktop notifications aren't supported on Windows")
+	default:
+		cmd = exec.Command("notify-send", title, message)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v %s", cmd.Args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
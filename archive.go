@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archivedDir holds, inside ScriptDir, the scripts put away with "scripts
+// archive": kept, but neither run nor listed
+const archivedDir = "archived"
+
+// archiveDir returns where archived scripts are kept
+func archiveDir(config *Config) string {
+	return filepath.Join(config.ScriptDir, archivedDir)
+}
+
+// archivedScripts returns the archived scripts by name
+func archivedScripts(config *Config) []*Script {
+	files, _ := filepath.Glob(filepath.Join(archiveDir(config), "*.sh"))
+	var scripts []*Script
+	for _, file := range files {
+		scripts = append(scripts, &Script{Name: strings.TrimSuffix(filepath.Base(file), ".sh"), Path: file})
+	}
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].Name < scripts[j].Name })
+	return scripts
+}
+
+// archiveScript moves a script into the archive
+func archiveScript(name string, config *Config) error {
+	path := scriptInDir(config.ScriptDir, name)
+	if path == "" {
+		if archived := scriptInDir(archiveDir(config), name); archived != "" {
+			return fmt.Errorf("%s is already archived", name)
+		}
+		return fmt.Errorf("script %s not found in %s", name, config.ScriptDir)
+	}
+	dest := filepath.Join(archiveDir(config), filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("an archived %s already exists in %s; unarchive or remove it first", filepath.Base(path), archiveDir(config))
+	}
+	warnOwner(name, path)
+	if err := os.MkdirAll(archiveDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", archiveDir(config), err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to archive %s: %v", name, err)
+	}
+	fmt.Printf("Archived %s (restore it with 'scripts unarchive %s')\n", name, name)
+	return nil
+}
+
+// unarchiveScript moves an archived script back into ScriptDir
+func unarchiveScript(name string, config *Config) error {
+	path := scriptInDir(archiveDir(config), name)
+	if path == "" {
+		return fmt.Errorf("no archived script %s in %s", name, archiveDir(config))
+	}
+	dest := filepath.Join(config.ScriptDir, filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s exists in %s; remove or rename it first", filepath.Base(path), config.ScriptDir)
+	}
+	warnOwner(name, path)
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to unarchive %s: %v", name, err)
+	}
+	fmt.Printf("Unarchived %s\n", name)
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncStateFile records, inside the state directory, when the scripts were
+// last synced and pulled and what pulls changed that hasn't been reported
+const syncStateFile = "sync.json"
+
+// defaultSyncInterval is how often sync.auto pulls the remote
+const defaultSyncInterval = 30 * time.Minute
+
+// emptyTree is git's empty tree, to diff against before the first commit
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+var syncStateMu sync.Mutex
+
+// SyncChange is a script a pull added, updated or removed
+type SyncChange struct {
+	Name   string `json:"name"`
+	Change string `json:"change"`
+}
+
+// SyncState is what is kept between syncs
+type SyncState struct {
+	LastSync time.Time `json:"lastSync,omitempty"`
+	LastPull time.Time `json:"lastPull,omitempty"`
+	// Unreported are changes pulled in the background, reported on the
+	// next interactive invocation
+	Unreported []*SyncChange `json:"unreported,omitempty"`
+}
+
+// syncStatePath returns where the sync state is kept
+func syncStatePath(config *Config) string {
+	return filepath.Join(stateDir(config), syncStateFile)
+}
+
+// loadSyncState reads the sync state; it is empty before the first sync
+func loadSyncState(config *Config) (*SyncState, error) {
+	state := &SyncState{}
+	data, err := os.ReadFile(syncStatePath(config))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", syncStatePath(config), err)
+	}
+	return state, nil
+}
+
+// updateSyncState changes the sync state with update and saves it
+func updateSyncState(config *Config, update func(state *SyncState)) error {
+	syncStateMu.Lock()
+	defer syncStateMu.Unlock()
+	state, err := loadSyncState(config)
+	if err != nil {
+		return err
+	}
+	update(state)
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(syncStatePath(config), data, 0644); err != nil {
+		return fmt.Errorf("failed to save sync state: %v", err)
+	}
+	return nil
+}
+
+// autoSync reports whether sync.auto is on
+func autoSync(config *Config) bool {
+	return config.Sync != nil && config.Sync.Auto && config.Sync.Remote != ""
+}
+
+// syncInterval returns how often sync.auto pulls
+func syncInterval(config *Config) time.Duration {
+	if config.Sync != nil && config.Sync.Interval != "" {
+		if d, err := time.ParseDuration(config.Sync.Interval); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultSyncInterval
+}
+
+// scriptChanges lists the scripts that differ between two commits, or
+// with to "", between a commit and the working tree, untracked scripts
+// included
+func scriptChanges(dir, from, to string) ([]*SyncChange, error) {
+	args := []string{"diff", "--name-status", "--no-renames", from}
+	if to != "" {
+		args = append(args, to)
+	}
+	output, err := syncGit(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	if to == "" {
+		untracked, err := syncGit(dir, "ls-files", "--others", "--exclude-standard")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range strings.Split(untracked, "\n") {
+			if file != "" {
+				output += "\nA\t" + file
+			}
+		}
+	}
+	changes := map[string]string{"A": "added", "M": "updated", "T": "updated", "D": "removed"}
+	var result []*SyncChange
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		status, file, ok := strings.Cut(line, "\t")
+		if !ok || strings.HasPrefix(filepath.Base(file), ".") {
+			continue
+		}
+		if change, ok := changes[status]; ok {
+			file = strings.TrimSuffix(file, encryptedSuffix)
+			name := strings.TrimSuffix(file, filepath.Ext(file))
+			result = append(result, &SyncChange{Name: filepath.ToSlash(name), Change: change})
+		}
+	}
+	return result, nil
+}
+
+// pullScripts fast-forwards the scripts directory to the remote without
+// committing or pushing anything, returning the scripts it changed. A
+// directory with commits of its own is left alone for "scripts sync".
+func pullScripts(config *Config) ([]*SyncChange, error) {
+	if isOffline() {
+		return nil, errOffline("pulling scripts")
+	}
+	if err := syncRepo(config); err != nil {
+		return nil, err
+	}
+	dir := config.ScriptDir
+	// Encrypted first, so a pull can't overwrite local changes to them
+	if err := keepLocal(config); err != nil {
+		return nil, err
+	}
+	if err := sealFiles(config); err != nil {
+		return nil, err
+	}
+	if _, err := syncGit(dir, "fetch", "--quiet", "origin"); err != nil {
+		return nil, err
+	}
+	branch, err := syncGit(dir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	remote := "origin/" + branch
+	if _, err := syncGit(dir, "rev-parse", "--verify", "--quiet", remote); err != nil {
+		return nil, nil
+	}
+
+	base := "HEAD"
+	if _, err := syncGit(dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		base = emptyTree
+	} else if exec.Command("git", "-C", dir, "merge-base", "--is-ancestor", remote, "HEAD").Run() == nil {
+		return nil, nil
+	} else if exec.Command("git", "-C", dir, "merge-base", "--is-ancestor", "HEAD", remote).Run() != nil {
+		return nil, fmt.Errorf("%s has changes of its own; run 'scripts sync' to merge them with the remote's", dir)
+	}
+	changes, err := scriptChanges(dir, base, remote)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syncGit(dir, "merge", "--ff-only", "--quiet", remote); err != nil {
+		return nil, err
+	}
+	if err := openFiles(config); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// describeChanges summarises pulled scripts as "added a, b; updated c"
+func describeChanges(changes []*SyncChange) string {
+	var parts []string
+	for _, change := range []string{"added", "updated", "removed"} {
+		var names []string
+		for _, c := range changes {
+			if c.Change == change {
+				names = append(names, c.Name)
+			}
+		}
+		if len(names) > 0 {
+			parts = append(parts, change+" "+strings.Join(names, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// runPull pulls the remote for "scripts sync --pull" and sync.auto. Quiet
+// pulls print nothing and leave the changes to be reported on the next
+// interactive invocation.
+func runPull(quiet bool, config *Config) error {
+	changes, err := pullScripts(config)
+	if err != nil {
+		return err
+	}
+	if err := updateSyncState(config, func(state *SyncState) {
+		state.LastPull = time.Now()
+		if quiet {
+			state.Unreported = mergeChanges(state.Unreported, changes)
+		}
+	}); err != nil {
+		return err
+	}
+	if quiet {
+		return nil
+	}
+	if len(changes) == 0 {
+		fmt.Println("Already up to date")
+	} else {
+		fmt.Printf("Pulled %s\n", describeChanges(changes))
+	}
+	return nil
+}
+
+// mergeChanges adds newer changes to unreported ones, keeping one change
+// per script: a script added and then updated is still new
+func mergeChanges(changes, newer []*SyncChange) []*SyncChange {
+	for _, c := range newer {
+		found := false
+		for _, old := range changes {
+			if old.Name != c.Name {
+				continue
+			}
+			found = true
+			switch {
+			case old.Change == "added" && c.Change == "updated":
+			case old.Change == "removed" && c.Change == "added":
+				old.Change = "updated"
+			default:
+				old.Change = c.Change
+			}
+		}
+		if !found {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// startAutoPull pulls in a background process when sync.auto is on and the
+// last pull is older than the interval, so the command isn't kept waiting
+func startAutoPull(config *Config) {
+	if !autoSync(config) || isOffline() || isReadOnly(config) {
+		return
+	}
+	state, err := loadSyncState(config)
+	if err != nil || time.Since(state.LastPull) < syncInterval(config) {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	// Marked now so the next invocations don't start pulls of their own
+	updateSyncState(config, func(state *SyncState) { state.LastPull = time.Now() })
+	cmd := exec.Command(exe, "sync", "--pull", "--quiet")
+	if cmd.Start() == nil {
+		cmd.Process.Release()
+	}
+}
+
+// reportPulledScripts prints, once, the scripts background pulls changed
+func reportPulledScripts(config *Config) {
+	if config.Sync == nil {
+		return
+	}
+	state, err := loadSyncState(config)
+	if err != nil || len(state.Unreported) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", colorize(colorCyan, "Pulled from the scripts remote:"), describeChanges(state.Unreported))
+	updateSyncState(config, func(state *SyncState) { state.Unreported = nil })
+}
+
+// autoPullLoop pulls the remote every interval for as long as the daemon
+// runs, logging what changed
+func autoPullLoop(config *Config, stop <-chan struct{}) {
+	ticker := time.NewTicker(syncInterval(config))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			changes, err := pullScripts(config)
+			if err != nil {
+				fmt.Printf("Auto-sync: %v\n", err)
+				continue
+			}
+			updateSyncState(config, func(state *SyncState) {
+				state.LastPull = time.Now()
+				state.Unreported = mergeChanges(state.Unreported, changes)
+			})
+			if len(changes) > 0 {
+				fmt.Printf("Auto-sync: pulled %s\n", describeChanges(changes))
+			}
+		}
+	}
+}
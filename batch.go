@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// discoverSources lists the compilable entries directly inside dir: source
+// files with a supported extension and project subdirectories
+func discoverSources(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", dir, err)
+	}
+	var sources []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if languageForDir(path) != "" {
+				sources = append(sources, path)
+			}
+		} else if languageForExt(filepath.Ext(entry.Name())) != "" {
+			sources = append(sources, path)
+		}
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// compileDir compiles every source discovered in opts.Dir in parallel
+func compileDir(opts *CompileOptions, config *Config) ([]BuildOutcome, error) {
+	sources, err := discoverSources(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Two sources that would install under the same name can't both win
+	names := make([]string, len(sources))
+	owners := map[string]string{}
+	for i, source := range sources {
+		names[i] = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+		if _, taken := owners[names[i]]; !taken {
+			owners[names[i]] = source
+		}
+	}
+
+	return runBuildPool(len(sources), opts.Jobs, func(i int) BuildOutcome {
+		if owner := owners[names[i]]; owner != sources[i] {
+			return BuildOutcome{
+				Name:   filepath.Base(sources[i]),
+				Status: "failed",
+				Detail: fmt.Sprintf("binary name %s is already used by %s", names[i], filepath.Base(owner)),
+			}
+		}
+
+		var output bytes.Buffer
+		sourceOpts := *opts
+		sourceOpts.Dir = ""
+		sourceOpts.SourcePath = sources[i]
+		sourceOpts.Output = &output
+		result, err := compileSource(&sourceOpts, config)
+		outcome := buildOutcome(names[i], result, err, output.String())
+		if outcome.Status == "rebuilt" {
+			outcome.Status = "compiled"
+		}
+		outcome.Detail = strings.TrimSpace(filepath.Base(sources[i]) + " " + outcome.Detail)
+		return outcome
+	}), nil
+}
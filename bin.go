@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// compilerVersion returns the first line of the version banner of the
+// compiler used for tool, or "" if it can't be determined
+func compilerVersion(tool string, env []string, config *Config) string {
+	compiler := resolveCompiler(tool, config)
+	args := append([]string{}, compiler[1:]...)
+	switch filepath.Base(compiler[0]) {
+	case "go":
+		args = append(args, "version")
+	case "v":
+		args = append(args, "version")
+	default:
+		args = append(args, "--version")
+	}
+	cmd := exec.Command(compiler[0], args...)
+	if len(env) > 0 {
+		// The build environment can select a different toolchain
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+}
+
+// binarySummary is the one-line provenance of a binary
+func binarySummary(record *BinaryRecord) string {
+	if record == nil {
+		return "no build record"
+	}
+	source := record.Source
+	if record.Git != nil {
+		source = record.Git.URL + "@" + shortCommit(record.Git.Commit)
+	}
+	return fmt.Sprintf("%s, built %s, from %s", record.Language, record.BuiltAt.Local().Format("2006-01-02 15:04"), source)
+}
+
+// printBinaryInfo shows everything recorded about how a binary was built
+func printBinaryInfo(name string, config *Config) error {
+	binPath, err := binaryPath(name, config)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return err
+	}
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", name)
+	fmt.Fprintf(w, "Path:\t%s\n", binPath)
+	fmt.Fprintf(w, "Size:\t%d bytes\n", info.Size())
+	fmt.Fprintf(w, "Modified:\t%s\n", info.ModTime().Format("2006-01-02 15:04:05"))
+
+	record := manifest.Binaries[name]
+	if record == nil {
+		fmt.Fprintf(w, "Provenance:\tnone recorded (not built with 'scripts compile')\n")
+		return w.Flush()
+	}
+	if record.Git != nil {
+		fmt.Fprintf(w, "Repository:\t%s\n", record.Git.URL)
+		if record.Git.Ref != "" {
+			fmt.Fprintf(w, "Ref:\t%s\n", record.Git.Ref)
+		}
+		fmt.Fprintf(w, "Commit:\t%s\n", record.Git.Commit)
+		if record.Git.Path != "" {
+			fmt.Fprintf(w, "Path in repo:\t%s\n", record.Git.Path)
+		}
+	} else {
+		fmt.Fprintf(w, "Source:\t%s\n", record.Source)
+	}
+	fmt.Fprintf(w, "Language:\t%s\n", record.Language)
+	if record.Target != "" {
+		fmt.Fprintf(w, "Target:\t%s\n", record.Target)
+	}
+	if record.Backend != "" {
+		fmt.Fprintf(w, "Backend:\t%s\n", record.Backend)
+	}
+	if record.Compiler != "" {
+		fmt.Fprintf(w, "Compiler:\t%s\n", record.Compiler)
+	}
+	if record.CompilerVersion != "" {
+		fmt.Fprintf(w, "Compiler version:\t%s\n", record.CompilerVersion)
+	}
+	if len(record.ConfigFlags) > 0 {
+		fmt.Fprintf(w, "Config flags:\t%s\n", strings.Join(record.ConfigFlags, " "))
+	}
+	if len(record.Flags) > 0 {
+		fmt.Fprintf(w, "Flags:\t%s\n", strings.Join(record.Flags, " "))
+	}
+	if record.CargoBin != "" {
+		fmt.Fprintf(w, "Cargo binary:\t%s\n", record.CargoBin)
+	}
+	if record.Artifact != "" {
+		fmt.Fprintf(w, "Artifact:\t%s\n", record.Artifact)
+	}
+	if record.Tags != "" {
+		fmt.Fprintf(w, "Build tags:\t%s\n", record.Tags)
+	}
+	if record.Features != "" {
+		fmt.Fprintf(w, "Features:\t%s\n", record.Features)
+	}
+	if len(record.BuildEnv) > 0 {
+		fmt.Fprintf(w, "Build env:\t%s\n", strings.Join(record.BuildEnv, " "))
+	}
+	var options []string
+	if record.Small {
+		options = append(options, "--small")
+	}
+	if record.Static {
+		options = append(options, "--static")
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(w, "Options:\t%s\n", strings.Join(options, " "))
+	}
+	if record.Signed != "" {
+		signed := record.Signed
+		if record.Notarized {
+			signed += " (notarized)"
+		}
+		fmt.Fprintf(w, "Signed:\t%s\n", signed)
+	}
+	fmt.Fprintf(w, "Built:\t%s\n", record.BuiltAt.Local().Format("2006-01-02 15:04:05"))
+	if record.Hash != "" {
+		fmt.Fprintf(w, "Build hash:\t%s\n", record.Hash)
+	}
+	return w.Flush()
+}
+
+// removeBinary deletes a binary from BinDir along with its build record and
+// saved versions
+func removeBinary(name string, config *Config) error {
+	binPath, err := binaryPath(name, config)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(binPath); err != nil {
+		return fmt.Errorf("failed to remove binary %s: %v", name, err)
+	}
+	if err := os.RemoveAll(filepath.Join(config.BinDir, versionsDir, name)); err != nil {
+		return fmt.Errorf("failed to remove saved versions of %s: %v", name, err)
+	}
+	removeShim(name, config)
+	os.Remove(buildLogPath(name, config))
+	return forgetBinary(name, config)
+}
+
+// BinaryEntry is a binary as "scripts list" shows it; the language,
+// build time and source come from the manifest, when it has a record
+type BinaryEntry struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	Size     int64      `json:"size"`
+	Modified time.Time  `json:"modified"`
+	Language string     `json:"language,omitempty"`
+	BuiltAt  *time.Time `json:"builtAt,omitempty"`
+	Source   string     `json:"source,omitempty"`
+}
+
+// binaryEntries describes every binary in BinDir
+func binaryEntries(config *Config) ([]*BinaryEntry, error) {
+	binaries, err := binaryNames(config)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*BinaryEntry
+	for _, name := range binaries {
+		binPath, err := binaryPath(name, config)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(binPath)
+		if err != nil {
+			continue
+		}
+		entry := &BinaryEntry{Name: name, Path: binPath, Size: info.Size(), Modified: info.ModTime()}
+		if record := manifest.Binaries[name]; record != nil {
+			builtAt := record.BuiltAt
+			entry.Language, entry.BuiltAt, entry.Source = record.Language, &builtAt, record.Source
+			if record.Git != nil {
+				entry.Source = record.Git.URL + "@" + shortCommit(record.Git.Commit)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// printBinaryList prints a table of the binaries in BinDir with their size,
+// modification time and, from the manifest, language and build time; long
+// adds where each was built from
+func printBinaryList(long bool, config *Config) error {
+	entries, err := binaryEntries(config)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No binaries found in %s\n", config.BinDir)
+		return nil
+	}
+	fmt.Printf("Available binaries (%s):\n", config.BinDir)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "  NAME\tSIZE\tMODIFIED\tLANGUAGE\tBUILT"
+	if long {
+		header += "\tSOURCE"
+	}
+	fmt.Fprintln(w, header)
+	for _, entry := range entries {
+		language, built, source := "-", "-", "no build record"
+		if entry.BuiltAt != nil {
+			language, built, source = entry.Language, entry.BuiltAt.Local().Format("2006-01-02 15:04"), entry.Source
+		}
+		row := fmt.Sprintf("  %s\t%s\t%s\t%s\t%s", entry.Name, humanSize(entry.Size), entry.Modified.Format("2006-01-02 15:04"), language, built)
+		if long {
+			row += "\t" + source
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
+
+// verifyBinaries compares each binary against the checksum recorded when it
+// was built. It returns the number of binaries that failed verification.
+func verifyBinaries(names []string, config *Config) (int, error) {
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return 0, err
+	}
+	if len(names) == 0 {
+		if names, err = binaryNames(config); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		// Recorded binaries that have gone missing count as failures too
+		for name := range manifest.Binaries {
+			if _, err := binaryPath(name, config); err != nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BINARY\tSTATUS")
+	for _, name := range names {
+		record := manifest.Binaries[name]
+		binPath, err := binaryPath(name, config)
+		checksum := ""
+		if err == nil {
+			checksum, err = fileChecksum(binPath)
+		}
+		var status string
+		switch {
+		case err != nil && record != nil:
+			status = "MISSING"
+			failed++
+		case err != nil:
+			status = "not found"
+			failed++
+		case record == nil || record.Checksum == "":
+			status = "untracked (no checksum recorded)"
+		case record.Checksum != checksum:
+			status = "MODIFIED since it was built"
+			failed++
+		default:
+			status = "ok"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, status)
+	}
+	w.Flush()
+	return failed, nil
+}
+
+func printBinUsage() {
+	fmt.Println("Usage: scripts bin <command> [args...]")
+	fmt.Println("  list [--long]        List compiled binaries (--long adds provenance)")
+	fmt.Println("  info <name>          Show how a compiled binary was built")
+	fmt.Println("  verify [<name>...]   Check binaries against the checksums recorded at build time")
+	fmt.Println("  versions <name>      List previous builds kept for rollback")
+	fmt.Println("  rollback <name> [<version>]")
+	fmt.Println("                       Restore the previous build, or the given version")
+	fmt.Println("  rm <name>            Remove a compiled binary, its build record and saved versions")
+}
+
+// runBinCommand handles the "bin" command group
+func runBinCommand(args []string, config *Config) {
+	if len(args) == 0 {
+		printBinUsage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		long := false
+		for _, arg := range args[1:] {
+			if arg != "--long" && arg != "-l" {
+				fmt.Println("Usage: scripts bin list [--long]")
+				os.Exit(1)
+			}
+			long = true
+		}
+		if err := printBinaryList(long, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "verify":
+		failed, err := verifyBinaries(args[1:], config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			fmt.Printf("\n%d binaries failed verification\n", failed)
+			os.Exit(1)
+		}
+	case "versions":
+		if len(args) != 2 {
+			fmt.Println("Usage: scripts bin versions <name>")
+			os.Exit(1)
+		}
+		if err := printBinaryVersions(args[1], config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "rollback":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Println("Usage: scripts bin rollback <name> [<version>]")
+			os.Exit(1)
+		}
+		name, id := args[1], ""
+		if len(args) == 3 {
+			// Accept both "20261016-153000" and "name@20261016-153000"
+			id = strings.TrimPrefix(args[2], name+"@")
+		}
+		version, err := rollbackBinary(name, id, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Rolled back %s to %s@%s\n", name, name, version.ID)
+	case "rm":
+		if len(args) != 2 {
+			fmt.Println("Usage: scripts bin rm <name>")
+			os.Exit(1)
+		}
+		if err := removeBinary(args[1], config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed binary %s\n", args[1])
+	case "info":
+		if len(args) != 2 {
+			fmt.Println("Usage: scripts bin info <name>")
+			os.Exit(1)
+		}
+		if err := printBinaryInfo(args[1], config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown bin command: %s\n", args[0])
+		printBinUsage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildLogsDir holds the compiler output of the last build of each binary,
+// inside BinDir
+const buildLogsDir = ".logs"
+
+// buildLogTail is how many lines of output are shown when a build fails
+const buildLogTail = 20
+
+// spinnerFrames are drawn in turn while a build runs
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// BuildLog captures compiler output to a file while a build runs. Unless
+// the output is streamed with --verbose-build, terminals show a spinner
+// with the elapsed time instead.
+type BuildLog struct {
+	Path    string
+	name    string
+	file    *os.File
+	spinner *spinner
+	verbose bool
+}
+
+// buildLogPath returns where the output of the last build of a binary is kept
+func buildLogPath(name string, config *Config) string {
+	return filepath.Join(config.BinDir, buildLogsDir, name+".log")
+}
+
+// startBuildLog redirects the job's compiler output into the binary's build
+// log. Messages written to job.out() meanwhile keep reaching the terminal.
+func startBuildLog(job *CompileJob, name string, opts *CompileOptions) (*BuildLog, error) {
+	path := buildLogPath(name, job.Config)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create build log directory: %v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build log: %v", err)
+	}
+
+	log := &BuildLog{Path: path, name: name, file: file, verbose: opts.VerboseBuild}
+	switch {
+	case opts.VerboseBuild:
+		job.Log = io.MultiWriter(file, os.Stdout)
+	case isTerminal(os.Stdout) && !isPlain():
+		log.spinner = startSpinner(os.Stdout, "Compiling "+name)
+		job.Output = log.spinner
+		job.Log = file
+	default:
+		job.Log = file
+	}
+	return log, nil
+}
+
+// finish stops capturing output. When the build failed, the compiler's
+// errors are summarised, or the end of its output is shown when they can't
+// be parsed, and the returned error replaces the bare exit status.
+func (log *BuildLog) finish(job *CompileJob, buildErr error) error {
+	if log == nil {
+		return buildErr
+	}
+	if log.spinner != nil {
+		log.spinner.stop()
+		job.Output = nil
+	}
+	job.Log = nil
+	log.file.Close()
+	if buildErr == nil {
+		return nil
+	}
+	if _, ok := buildErr.(*exec.ExitError); ok {
+		buildErr = fmt.Errorf("failed to compile %s (%v)", log.name, buildErr)
+	}
+
+	output, _ := os.ReadFile(log.Path)
+	if diagnostics := parseDiagnostics(string(output)); len(diagnostics) > 0 {
+		printDiagnostics(os.Stdout, diagnostics, sourceDirs(job))
+		fmt.Fprintf(os.Stdout, "Full build log: %s\n", log.Path)
+		return fmt.Errorf("failed to compile %s", log.name)
+	}
+	if !log.verbose {
+		lines := logTail(log.Path, buildLogTail)
+		if len(lines) > 0 {
+			fmt.Fprintf(os.Stdout, "--- last %d lines of build output ---\n", len(lines))
+			for _, line := range lines {
+				fmt.Fprintln(os.Stdout, line)
+			}
+			fmt.Fprintln(os.Stdout, "---")
+		}
+	}
+	fmt.Fprintf(os.Stdout, "Full build log: %s\n", log.Path)
+	return buildErr
+}
+
+// sourceDirs lists the directories file names in a job's diagnostics may be
+// relative to
+func sourceDirs(job *CompileJob) []string {
+	var dirs []string
+	if info, err := os.Stat(job.SourcePath); err == nil && info.IsDir() {
+		dirs = append(dirs, job.SourcePath)
+	} else {
+		dirs = append(dirs, filepath.Dir(job.SourcePath))
+	}
+	if job.Cargo != nil {
+		dirs = append(dirs, filepath.Dir(job.Cargo.ManifestPath), filepath.Dir(job.Cargo.TargetDir))
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, cwd)
+	}
+	return dirs
+}
+
+// logTail returns up to n trailing lines of a file
+func logTail(path string, n int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
+
+// spinner redraws a status line with the elapsed time until stopped. Text
+// written through it clears the line first, so messages aren't mangled.
+type spinner struct {
+	mu      sync.Mutex
+	out     io.Writer
+	label   string
+	start   time.Time
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func startSpinner(out io.Writer, label string) *spinner {
+	s := &spinner{
+		out:     out,
+		label:   label,
+		start:   time.Now(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *spinner) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		s.mu.Lock()
+		elapsed := time.Since(s.start).Seconds()
+		fmt.Fprintf(s.out, "\r\033[K%s %s (%.1fs)", spinnerFrames[frame%len(spinnerFrames)], s.label, elapsed)
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Write prints a message above the status line
+func (s *spinner) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprint(s.out, "\r\033[K")
+	n, err := s.out.Write(p)
+	if err == nil && !strings.HasSuffix(string(p), "\n") {
+		// Keep the status line from being drawn over the message
+		_, err = fmt.Fprintln(s.out)
+	}
+	return n, err
+}
+
+// stop removes the status line
+func (s *spinner) stop() {
+	close(s.done)
+	<-s.stopped
+	fmt.Fprint(s.out, "\r\033[K")
+}
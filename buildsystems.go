@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotExecutables records the modification time of every executable file
+// under root, so the files produced by a build can be told apart afterwards
+func snapshotExecutables(root string) map[string]time.Time {
+	files := map[string]time.Time{}
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case ".git", "CMakeFiles", "meson-private", "meson-logs", "node_modules":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if ext == ".so" || ext == ".dylib" || ext == ".sh" || ext == ".py" || ext == ".o" || ext == ".a" {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil && info.Mode()&0100 != 0 {
+			files[path] = info.ModTime()
+		}
+		return nil
+	})
+	return files
+}
+
+// builtExecutables returns executables that are new or changed since before.
+// When the build was a no-op every executable is considered up to date, so
+// all of them are returned instead.
+func builtExecutables(root string, before map[string]time.Time) []string {
+	var built, all []string
+	for path, modTime := range snapshotExecutables(root) {
+		all = append(all, path)
+		if previous, ok := before[path]; !ok || modTime.After(previous) {
+			built = append(built, path)
+		}
+	}
+	if len(built) == 0 {
+		built = all
+	}
+	sort.Strings(built)
+	return built
+}
+
+// pickArtifact chooses which produced executable to install. An explicit
+// --artifact wins, a single candidate is used directly, and otherwise the
+// user is asked when attached to a terminal.
+func pickArtifact(job *CompileJob, root string, candidates []string) (string, error) {
+	if artifact := job.Artifact; artifact != "" {
+		for _, path := range []string{artifact, filepath.Join(root, artifact)} {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, nil
+			}
+		}
+		for _, candidate := range candidates {
+			if filepath.Base(candidate) == artifact {
+				return candidate, nil
+			}
+		}
+		return "", fmt.Errorf("artifact %s was not produced by the build", artifact)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("build produced no executables; use --artifact <path> to choose one")
+	case 1:
+		return candidates[0], nil
+	}
+
+	if !canPrompt() {
+		return "", fmt.Errorf("build produced several executables (%s); choose one with --artifact <name>", strings.Join(relativePaths(root, candidates), ", "))
+	}
+
+	fmt.Println("Build produced several executables:")
+	for i, candidate := range relativePaths(root, candidates) {
+		fmt.Printf("  %d) %s\n", i+1, candidate)
+	}
+	fmt.Print("Which one should be installed? ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("no executable chosen")
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return "", fmt.Errorf("invalid choice %q", strings.TrimSpace(line))
+	}
+	return candidates[choice-1], nil
+}
+
+func relativePaths(root string, paths []string) []string {
+	var rel []string
+	for _, path := range paths {
+		if r, err := filepath.Rel(root, path); err == nil {
+			path = r
+		}
+		rel = append(rel, path)
+	}
+	return rel
+}
+
+// compileCMake configures a Release build in <project>/build and builds it
+func compileCMake(job *CompileJob) error {
+	buildDir := filepath.Join(job.SourcePath, "build")
+	before := snapshotExecutables(buildDir)
+
+	configure := append([]string{"-S", job.SourcePath, "-B", buildDir, "-DCMAKE_BUILD_TYPE=Release"}, job.Flags...)
+	if err := job.command("cmake", configure...).Run(); err != nil {
+		return fmt.Errorf("cmake configure failed: %v", err)
+	}
+	if err := job.command("cmake", "--build", buildDir, "--config", "Release", "--parallel").Run(); err != nil {
+		return fmt.Errorf("cmake build failed: %v", err)
+	}
+
+	artifact, err := pickArtifact(job, buildDir, builtExecutables(buildDir, before))
+	if err != nil {
+		return err
+	}
+	return copyFile(artifact, job.OutputPath)
+}
+
+// compileMake runs make in the project directory
+func compileMake(job *CompileJob) error {
+	before := snapshotExecutables(job.SourcePath)
+
+	if err := job.command("make", append([]string{"-C", job.SourcePath}, job.Flags...)...).Run(); err != nil {
+		return fmt.Errorf("make failed: %v", err)
+	}
+
+	artifact, err := pickArtifact(job, job.SourcePath, builtExecutables(job.SourcePath, before))
+	if err != nil {
+		return err
+	}
+	return copyFile(artifact, job.OutputPath)
+}
+
+// compileMeson sets up a release build in <project>/builddir and runs ninja
+func compileMeson(job *CompileJob) error {
+	buildDir := filepath.Join(job.SourcePath, "builddir")
+	before := snapshotExecutables(buildDir)
+
+	// Re-running setup on an existing build directory needs --reconfigure
+	setup := []string{"setup", buildDir, job.SourcePath, "--buildtype=release"}
+	if _, err := os.Stat(filepath.Join(buildDir, "build.ninja")); err == nil {
+		setup = append(setup, "--reconfigure")
+	}
+	if err := job.command("meson", append(setup, job.Flags...)...).Run(); err != nil {
+		return fmt.Errorf("meson setup failed: %v", err)
+	}
+	if err := job.command("ninja", "-C", buildDir).Run(); err != nil {
+		return fmt.Errorf("ninja build failed: %v", err)
+	}
+
+	artifact, err := pickArtifact(job, buildDir, builtExecutables(buildDir, before))
+	if err != nil {
+		return err
+	}
+	return copyFile(artifact, job.OutputPath)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// cacheEnv tells scripts where the download cache is
+const cacheEnv = "SCRIPTS_CACHE_DIR"
+
+// cacheIndexFile maps URLs to the blobs they downloaded, inside the cache
+// directory; blobs are named by their SHA-256 under blobs/
+const cacheIndexFile = "index.json"
+
+var cacheMu sync.Mutex
+
+// CacheEntry is a URL downloaded into the cache
+type CacheEntry struct {
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	UsedAt    time.Time `json:"usedAt"`
+}
+
+// cacheDir returns where downloads are cached (default:
+// $XDG_CACHE_HOME/scripts or ~/.cache/scripts)
+func cacheDir(config *Config) string {
+	if config.CacheDir != "" {
+		return expandPath(config.CacheDir)
+	}
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "scripts")
+	}
+	return expandPath("~/.cache/scripts")
+}
+
+// blobPath returns where the content with a checksum is cached
+func blobPath(sum string, config *Config) string {
+	return filepath.Join(cacheDir(config), "blobs", sum)
+}
+
+// loadCacheIndex reads the cached URLs
+func loadCacheIndex(config *Config) (map[string]*CacheEntry, error) {
+	index := map[string]*CacheEntry{}
+	path := filepath.Join(cacheDir(config), cacheIndexFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the download cache: %v", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return index, nil
+}
+
+// saveCacheIndex writes the cached URLs
+func saveCacheIndex(index map[string]*CacheEntry, config *Config) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir(config), cacheIndexFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to save the download cache: %v", err)
+	}
+	return nil
+}
+
+// fetchCached returns the path of a URL's content in the cache,
+// downloading it unless it is cached already. With want set the content
+// must have that SHA-256, and content cached from any URL with it is
+// used. refresh downloads it again regardless.
+func fetchCached(location, want string, refresh bool, config *Config) (string, error) {
+	want = strings.ToLower(want)
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	index, err := loadCacheIndex(config)
+	if err != nil {
+		return "", err
+	}
+
+	sum := want
+	if entry, ok := index[location]; ok && want == "" {
+		sum = entry.SHA256
+	}
+	if sum != "" && !refresh {
+		if info, err := os.Stat(blobPath(sum, config)); err == nil {
+			entry := index[location]
+			if entry == nil {
+				entry = &CacheEntry{SHA256: sum, Size: info.Size(), FetchedAt: time.Now()}
+				index[location] = entry
+			}
+			entry.UsedAt = time.Now()
+			return blobPath(sum, config), saveCacheIndex(index, config)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloading %s\n", location)
+	data, err := fetch(location)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", location, err)
+	}
+	sum = checksum(data)
+	if want != "" && sum != want {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", location, want, sum)
+	}
+	path := blobPath(sum, config)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	// Write under a temporary name so a cached blob is always complete
+	if err := os.WriteFile(path+".download", data, 0644); err != nil {
+		return "", fmt.Errorf("failed to cache %s: %v", location, err)
+	}
+	if err := os.Rename(path+".download", path); err != nil {
+		return "", fmt.Errorf("failed to cache %s: %v", location, err)
+	}
+	now := time.Now()
+	index[location] = &CacheEntry{SHA256: sum, Size: int64(len(data)), FetchedAt: now, UsedAt: now}
+	return path, saveCacheIndex(index, config)
+}
+
+// printCache lists the cached downloads, most recently used first
+func printCache(config *Config) error {
+	index, err := loadCacheIndex(config)
+	if err != nil {
+		return err
+	}
+	if len(index) == 0 {
+		fmt.Printf("The download cache (%s) is empty\n", cacheDir(config))
+		return nil
+	}
+	urls := make([]string, 0, len(index))
+	var total int64
+	blobs := map[string]bool{}
+	for url, entry := range index {
+		urls = append(urls, url)
+		if !blobs[entry.SHA256] {
+			blobs[entry.SHA256] = true
+			total += entry.Size
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return index[urls[i]].UsedAt.After(index[urls[j]].UsedAt) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "URL\tSIZE\tSHA256\tLAST USED")
+	for _, url := range urls {
+		entry := index[url]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", url, humanSize(entry.Size), entry.SHA256[:12], entry.UsedAt.Local().Format("2006-01-02 15:04"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d downloads, %s in %s\n", len(index), humanSize(total), cacheDir(config))
+	return nil
+}
+
+// cleanCache removes the downloads not used for olderThan (e.g. "30d"),
+// or all of them when it is empty
+func cleanCache(olderThan string, config *Config) error {
+	var before time.Time
+	if olderThan != "" {
+		var err error
+		if before, err = parseSince(olderThan, time.Now()); err != nil {
+			return fmt.Errorf("invalid --older-than %q (expected e.g. 30d, 2w or 12h)", olderThan)
+		}
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	index, err := loadCacheIndex(config)
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	removed := 0
+	for url, entry := range index {
+		if before.IsZero() || entry.UsedAt.Before(before) {
+			delete(index, url)
+			removed++
+			continue
+		}
+		kept[entry.SHA256] = true
+	}
+
+	// Remove the blobs no remaining URL refers to
+	var freed int64
+	entries, _ := os.ReadDir(filepath.Join(cacheDir(config), "blobs"))
+	for _, entry := range entries {
+		if kept[entry.Name()] {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			freed += info.Size()
+		}
+		if err := os.Remove(filepath.Join(cacheDir(config), "blobs", entry.Name())); err != nil {
+			return fmt.Errorf("failed to clean the download cache: %v", err)
+		}
+	}
+	if len(entries) > 0 || removed > 0 {
+		if err := saveCacheIndex(index, config); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Removed %d downloads (%s)\n", removed, humanSize(freed))
+	return nil
+}
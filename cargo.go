@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CargoTarget identifies a single binary target inside a Cargo project
+type CargoTarget struct {
+	ManifestPath string
+	TargetDir    string // cargo's target directory (may be a workspace root)
+	BinName      string
+}
+
+// cargoMetadata is the subset of "cargo metadata" output we rely on
+type cargoMetadata struct {
+	TargetDirectory string `json:"target_directory"`
+	Packages        []struct {
+		ManifestPath string `json:"manifest_path"`
+		Targets      []struct {
+			Name    string   `json:"name"`
+			Kind    []string `json:"kind"`
+			SrcPath string   `json:"src_path"`
+		} `json:"targets"`
+	} `json:"packages"`
+}
+
+// findCargoManifest returns the Cargo.toml governing source, which may be a
+// project directory, a Cargo.toml or a .rs file somewhere inside a project.
+// An empty string means the source is not part of a Cargo project.
+func findCargoManifest(source string) string {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return ""
+	}
+	if filepath.Base(absSource) == "Cargo.toml" {
+		return absSource
+	}
+	dir := absSource
+	if info, err := os.Stat(absSource); err != nil || !info.IsDir() {
+		dir = filepath.Dir(absSource)
+	}
+	for {
+		manifest := filepath.Join(dir, "Cargo.toml")
+		if _, err := os.Stat(manifest); err == nil {
+			return manifest
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// resolveCargoTarget asks cargo which binaries the project defines and picks
+// one: the requested --bin, the target whose entry point is the given .rs
+// file, or the only binary in the crate. It returns nil when source is not
+// part of a Cargo project.
+func resolveCargoTarget(source, requestedBin string, config *Config) (*CargoTarget, error) {
+	manifestPath := findCargoManifest(source)
+	if manifestPath == "" {
+		if requestedBin != "" {
+			return nil, fmt.Errorf("--bin requires a Cargo project, but %s is not inside one", source)
+		}
+		return nil, nil
+	}
+
+	cargo := resolveCompiler("cargo", config)
+	args := append(cargo[1:], "metadata", "--no-deps", "--format-version", "1", "--manifest-path", manifestPath)
+	cmd := exec.Command(cargo[0], args...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Cargo manifest %s: %v", manifestPath, err)
+	}
+
+	var metadata cargoMetadata
+	if err := json.Unmarshal(output, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse cargo metadata: %v", err)
+	}
+
+	// Collect the binary targets of the package owning this manifest
+	bins := map[string]string{} // name -> entry point
+	for _, pkg := range metadata.Packages {
+		if filepath.Clean(pkg.ManifestPath) != filepath.Clean(manifestPath) {
+			continue
+		}
+		for _, target := range pkg.Targets {
+			for _, kind := range target.Kind {
+				if kind == "bin" {
+					bins[target.Name] = target.SrcPath
+				}
+			}
+		}
+	}
+	if len(bins) == 0 {
+		return nil, fmt.Errorf("%s does not define any binaries", manifestPath)
+	}
+
+	var names []string
+	for name := range bins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	target := &CargoTarget{ManifestPath: manifestPath, TargetDir: metadata.TargetDirectory}
+	switch {
+	case requestedBin != "":
+		if _, ok := bins[requestedBin]; !ok {
+			return nil, fmt.Errorf("binary %s not found in %s (available: %s)", requestedBin, manifestPath, strings.Join(names, ", "))
+		}
+		target.BinName = requestedBin
+	case len(bins) == 1:
+		target.BinName = names[0]
+	default:
+		// Pointed at one of the entry points directly
+		if absSource, err := filepath.Abs(source); err == nil {
+			for name, srcPath := range bins {
+				if filepath.Clean(srcPath) == absSource {
+					target.BinName = name
+				}
+			}
+		}
+		if target.BinName == "" {
+			return nil, fmt.Errorf("crate defines multiple binaries (%s); choose one with --bin <name>", strings.Join(names, ", "))
+		}
+	}
+	if target.TargetDir == "" {
+		target.TargetDir = filepath.Join(filepath.Dir(manifestPath), "target")
+	}
+	return target, nil
+}
+
+// compileCargo builds the selected binary in release mode and copies it out
+// of cargo's target directory
+func compileCargo(job *CompileJob) error {
+	target := job.Cargo
+	args := []string{"build", "--release", "--manifest-path", target.ManifestPath, "--bin", target.BinName}
+	releaseDir := filepath.Join(target.TargetDir, "release")
+	if job.RustTarget != "" {
+		// Cross builds land in a per-target subdirectory
+		args = append(args, "--target", job.RustTarget)
+		releaseDir = filepath.Join(target.TargetDir, job.RustTarget, "release")
+	}
+	cmd := job.command("cargo", append(args, job.Flags...)...)
+	cmd.Dir = filepath.Dir(target.ManifestPath)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	builtPath := filepath.Join(releaseDir, target.BinName)
+	if job.Target == targetWasm {
+		builtPath += ".wasm"
+	}
+	return copyFile(builtPath, job.OutputPath)
+}
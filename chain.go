@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Chains give their steps a shared workspace directory, and a file where
+// a step can append KEY=VALUE lines to set variables for the steps after it
+const (
+	workspaceEnv = "SCRIPTS_WORKSPACE"
+	chainEnvEnv  = "SCRIPTS_CHAIN_ENV"
+)
+
+// ChainStep is how one script of a chain went
+type ChainStep struct {
+	Name     string
+	Ran      bool
+	ExitCode int
+	Duration time.Duration
+}
+
+// readChainEnv reads the KEY=VALUE lines steps appended to the chain's
+// env file, skipping blank lines and comments
+func readChainEnv(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, _, ok := strings.Cut(line, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid line in %s: %q (expected KEY=VALUE)", chainEnvEnv, line)
+		}
+		env = append(env, line)
+	}
+	return env, scanner.Err()
+}
+
+// runChain runs scripts one after another in a shared workspace. A step
+// is a script name, optionally with arguments in one quoted word
+// ("deploy staging"). Every step runs unless stopOnFail is set, and a
+// summary follows; the chain fails when any step did.
+func runChain(steps []string, stopOnFail bool, config *Config) error {
+	workspace, err := os.MkdirTemp("", "scripts-chain-")
+	if err != nil {
+		return fmt.Errorf("failed to create the chain's workspace: %v", err)
+	}
+	defer os.RemoveAll(workspace)
+	envFile := filepath.Join(workspace, ".chain-env")
+
+	var results []*ChainStep
+	failed := 0
+	for _, step := range steps {
+		words, err := splitQuoted(step)
+		if err != nil || len(words) == 0 {
+			return fmt.Errorf("invalid chain step %q", step)
+		}
+		result := &ChainStep{Name: step}
+		results = append(results, result)
+		if failed > 0 && stopOnFail {
+			continue
+		}
+
+		scriptPath, err := findScript(words[0], config)
+		if err != nil {
+			return fmt.Errorf("chain step %s: %v", step, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(scriptPath), ".sh")
+		args, err := expandPreset(name, words[1:], config)
+		if err != nil {
+			return fmt.Errorf("chain step %s: %v", step, err)
+		}
+		if args, err = scriptArgs(scriptPath, args, &RunOptions{}); err != nil {
+			return fmt.Errorf("chain step %s: %v", step, err)
+		}
+		env, err := readChainEnv(envFile)
+		if err != nil {
+			return err
+		}
+		renderedPath, removeRendered, err := renderTemplate(scriptPath, nil, "", config)
+		if err != nil {
+			return fmt.Errorf("chain step %s: %v", step, err)
+		}
+		workdir, err := scriptWorkdir(scriptPath, "", config)
+		if err != nil {
+			removeRendered()
+			return fmt.Errorf("chain step %s: %v", step, err)
+		}
+		cmd, err := scriptCommand(renderedPath, args, config)
+		if err != nil {
+			removeRendered()
+			return fmt.Errorf("chain step %s: %v", step, err)
+		}
+		cmd.Dir = workdir
+		cmd.Env = append(append(os.Environ(), workspaceEnv+"="+workspace, chainEnvEnv+"="+envFile), env...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		fmt.Println(colorize(colorBold, "==> "+step))
+		record, _ := runRecorded(name, cmd, &RunOptions{}, config)
+		removeRendered()
+		result.Ran = true
+		result.ExitCode, result.Duration = record.ExitCode, record.Duration()
+		if result.ExitCode != 0 {
+			failed++
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tSCRIPT\tRESULT\tDURATION")
+	var total time.Duration
+	for i, result := range results {
+		status, duration := "passed", formatDuration(result.Duration)
+		switch {
+		case !result.Ran:
+			status, duration = "skipped", "-"
+		case result.ExitCode != 0:
+			status = fmt.Sprintf("failed (exit %d)", result.ExitCode)
+		}
+		total += result.Duration
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, result.Name, status, duration)
+	}
+	w.Flush()
+	if failed > 0 {
+		return fmt.Errorf("chain failed: %d of %d steps failed", failed, len(steps))
+	}
+	fmt.Printf("All %d steps passed in %s\n", len(steps), formatDuration(total))
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// Outcomes of a check
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+	checkSkip = "-"
+)
+
+// checkNames are the checks of "scripts check", in the scorecard's order
+var checkNames = []string{"shebang", "executable", "syntax", "shellcheck", "requires", "sources"}
+
+// sourcePattern matches a line sourcing a file, "source file" or ". file"
+var sourcePattern = regexp.MustCompile(`^\s*(?:source|\.)\s+("[^"]+"|'[^']+'|\S+)`)
+
+// CheckResult is the outcome of one check of a script
+type CheckResult struct {
+	Outcome string
+	Detail  string
+}
+
+// Scorecard is how a script did in every check
+type Scorecard struct {
+	Name    string
+	Results map[string]*CheckResult
+}
+
+// score counts the checks passed out of those that ran
+func (s *Scorecard) score() (int, int) {
+	passed, ran := 0, 0
+	for _, result := range s.Results {
+		if result.Outcome == checkSkip {
+			continue
+		}
+		ran++
+		if result.Outcome == checkOK {
+			passed++
+		}
+	}
+	return passed, ran
+}
+
+// failed reports whether any check failed
+func (s *Scorecard) failed() bool {
+	for _, result := range s.Results {
+		if result.Outcome == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+// shellOf returns the shell interpreting a script, from its shebang or,
+// for .sh files without one, sh; "" for other scripts
+func shellOf(path string) string {
+	shebang := shebangCommand(path)
+	if len(shebang) == 0 {
+		if filepath.Ext(path) == ".sh" {
+			return "sh"
+		}
+		return ""
+	}
+	interpreter := filepath.Base(shebang[0])
+	if interpreter == "env" && len(shebang) > 1 {
+		interpreter = shebang[len(shebang)-1]
+	}
+	if shellInterpreters[interpreter] {
+		return interpreter
+	}
+	return ""
+}
+
+// checkSyntax parses a shell script without running it
+func checkSyntax(path, shell string) *CheckResult {
+	if shell == "" {
+		return &CheckResult{Outcome: checkSkip, Detail: "not a shell script"}
+	}
+	output, err := exec.Command(shell, "-n", path).CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return &CheckResult{Outcome: checkSkip, Detail: fmt.Sprintf("%s isn't installed", shell)}
+		}
+		line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		return &CheckResult{Outcome: checkFail, Detail: strings.TrimPrefix(line, path+": ")}
+	}
+	return &CheckResult{Outcome: checkOK}
+}
+
+// checkShellcheck runs shellcheck on a script when it is installed
+func checkShellcheck(path, shell string) *CheckResult {
+	// shellcheck doesn't support zsh
+	if shell == "" || shell == "zsh" {
+		return &CheckResult{Outcome: checkSkip, Detail: "not a script shellcheck supports"}
+	}
+	if _, err := exec.LookPath("shellcheck"); err != nil {
+		return &CheckResult{Outcome: checkSkip, Detail: "shellcheck isn't installed"}
+	}
+	output, err := exec.Command("shellcheck", "--severity=warning", "--format=gcc", "--shell="+shell, path).Output()
+	if err == nil {
+		return &CheckResult{Outcome: checkOK}
+	}
+	if len(output) == 0 {
+		return &CheckResult{Outcome: checkWarn, Detail: fmt.Sprintf("shellcheck failed: %v", err)}
+	}
+	// Issues are "path:line:column: level: message"
+	issues := strings.Split(strings.TrimSpace(string(output)), "\n")
+	first := strings.TrimPrefix(issues[0], path+":")
+	return &CheckResult{Outcome: checkWarn, Detail: fmt.Sprintf("%d issues, first at line %s", len(issues), first)}
+}
+
+// checkRequires checks that the scripts and commands a script declares
+// with "# scripts:requires" are installed
+func checkRequires(meta *ScriptMeta, config *Config) *CheckResult {
+	requires := meta.fields("requires")
+	if len(requires) == 0 {
+		return &CheckResult{Outcome: checkSkip, Detail: "declares no requirements"}
+	}
+	var missing []string
+	for _, req := range requires {
+		if _, err := findScript(req, config); err == nil {
+			continue
+		}
+		if _, err := exec.LookPath(req); err == nil {
+			continue
+		}
+		missing = append(missing, req)
+	}
+	if len(missing) > 0 {
+		return &CheckResult{Outcome: checkFail, Detail: "missing " + strings.Join(missing, ", ")}
+	}
+	return &CheckResult{Outcome: checkOK}
+}
+
+// checkSources checks that the files a script sources exist. Only literal
+// paths are checked (with ~ or $HOME); relative ones are taken relative to
+// the script's directory.
+func checkSources(path string) *CheckResult {
+	f, err := os.Open(path)
+	if err != nil {
+		return &CheckResult{Outcome: checkFail, Detail: err.Error()}
+	}
+	defer f.Close()
+	var missing []string
+	checked := 0
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		match := sourcePattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		source := strings.Trim(match[1], `"'`)
+		source = strings.NewReplacer("$HOME", "~", "${HOME}", "~").Replace(source)
+		if strings.ContainsAny(source, "$`*") {
+			// Computed at run time
+			continue
+		}
+		checked++
+		resolved := expandPath(source)
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(filepath.Dir(path), resolved)
+		}
+		if _, err := os.Stat(resolved); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (line %d)", source, n))
+		}
+	}
+	switch {
+	case len(missing) > 0:
+		return &CheckResult{Outcome: checkFail, Detail: "missing " + strings.Join(missing, ", ")}
+	case checked == 0:
+		return &CheckResult{Outcome: checkSkip, Detail: "sources no literal paths"}
+	}
+	return &CheckResult{Outcome: checkOK}
+}
+
+// scoreScript runs every check on a script
+func scoreScript(name, path string, config *Config) *Scorecard {
+	card := &Scorecard{Name: name, Results: map[string]*CheckResult{}}
+	if hasShebang(path) {
+		card.Results["shebang"] = &CheckResult{Outcome: checkOK}
+	} else {
+		card.Results["shebang"] = &CheckResult{Outcome: checkWarn, Detail: "no shebang, so it runs with sh"}
+	}
+	if isExecutable(path) {
+		card.Results["executable"] = &CheckResult{Outcome: checkOK}
+	} else {
+		card.Results["executable"] = &CheckResult{Outcome: checkWarn, Detail: "not executable (fix with 'scripts ready')"}
+	}
+	shell := shellOf(path)
+	card.Results["syntax"] = checkSyntax(path, shell)
+	card.Results["shellcheck"] = checkShellcheck(path, shell)
+	if meta, err := readMetadata(path); err != nil {
+		card.Results["requires"] = &CheckResult{Outcome: checkFail, Detail: err.Error()}
+	} else {
+		card.Results["requires"] = checkRequires(meta, config)
+	}
+	card.Results["sources"] = checkSources(path)
+	return card
+}
+
+// runChecks prints a scorecard of the given scripts, or of every script
+// with all set, and the problems found. It fails when any check failed.
+func runChecks(names []string, all bool, config *Config) error {
+	var scripts []*Script
+	if all {
+		scripts = listScripts(config)
+	}
+	for _, name := range names {
+		path, err := findScript(name, config)
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, &Script{Name: strings.TrimSuffix(filepath.Base(path), ".sh"), Path: path})
+	}
+	if len(scripts) == 0 {
+		fmt.Println("No scripts to check")
+		return nil
+	}
+
+	var cards []*Scorecard
+	for _, script := range scripts {
+		cards = append(cards, scoreScript(script.Name, script.Path, config))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "SCRIPT\t%s\tSCORE\n", strings.ToUpper(strings.Join(checkNames, "\t")))
+	for _, card := range cards {
+		row := []string{card.Name}
+		for _, check := range checkNames {
+			row = append(row, card.Results[check].Outcome)
+		}
+		passed, ran := card.score()
+		fmt.Fprintf(w, "%s\t%d/%d\n", strings.Join(row, "\t"), passed, ran)
+	}
+	w.Flush()
+
+	failed := 0
+	printedHeader := false
+	for _, card := range cards {
+		if card.failed() {
+			failed++
+		}
+		for _, check := range checkNames {
+			result := card.Results[check]
+			if result.Outcome != checkWarn && result.Outcome != checkFail {
+				continue
+			}
+			if !printedHeader {
+				fmt.Println()
+				printedHeader = true
+			}
+			color := colorYellow
+			if result.Outcome == checkFail {
+				color = colorRed
+			}
+			fmt.Printf("%s %s: %s\n", colorize(color, card.Name+" "+check), result.Outcome, result.Detail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scripts failed a check", failed, len(cards))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// clipboardPreviewLines is how much of a pasted script is shown before
+// asking to install it
+const clipboardPreviewLines = 40
+
+// clipboardCommand returns the command that prints the clipboard: pbpaste
+// on macOS, PowerShell on Windows, and wl-paste, xclip or xsel elsewhere
+func clipboardCommand() ([]string, error) {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbpaste"}}
+	case "windows":
+		candidates = [][]string{{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-paste", "--no-newline"})
+		}
+		candidates = append(candidates,
+			[]string{"xclip", "-selection", "clipboard", "-o"},
+			[]string{"xsel", "--clipboard", "--output"},
+		)
+	}
+	for _, command := range candidates {
+		if _, err := exec.LookPath(command[0]); err == nil {
+			return command, nil
+		}
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("%s not found", candidates[0][0])
+	}
+	return nil, fmt.Errorf("no clipboard tool found - install wl-clipboard (Wayland), xclip or xsel")
+}
+
+// readClipboard returns the clipboard's text
+func readClipboard() (string, error) {
+	command, err := clipboardCommand()
+	if err != nil {
+		return "", err
+	}
+	output, err := exec.Command(command[0], command[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the clipboard with %s: %v", command[0], err)
+	}
+	return strings.ReplaceAll(string(output), "\r\n", "\n"), nil
+}
+
+// addFromClipboard previews the clipboard and installs it as a script once
+// confirmed. Without a terminal to confirm on, yes must be set.
+func addFromClipboard(name string, yes bool, mode string, config *Config) error {
+	content, err := readClipboard()
+	if err != nil {
+		return err
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return fmt.Errorf("the clipboard is empty")
+	}
+	if !strings.HasPrefix(content, "#!") {
+		content = "#!/usr/bin/env bash\n" + content
+	}
+	content += "\n"
+
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	fmt.Printf("Clipboard contents (%d lines):\n", len(lines))
+	for i, line := range lines {
+		if i == clipboardPreviewLines {
+			fmt.Printf("    ... %d more lines\n", len(lines)-clipboardPreviewLines)
+			break
+		}
+		fmt.Printf("%4d | %s\n", i+1, line)
+	}
+
+	if !yes {
+		if !canPrompt() {
+			return fmt.Errorf("not installing without confirmation (rerun with --yes)")
+		}
+		question := fmt.Sprintf("Install as %s.sh?", name)
+		if _, err := os.Stat(filepath.Join(config.ScriptDir, name+".sh")); err == nil {
+			question = fmt.Sprintf("Replace the existing %s.sh?", name)
+		}
+		fmt.Printf("%s [y/N] ", question)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Not installed")
+			return nil
+		}
+	}
+
+	if _, err := installScript(name, []byte(content), true, mode, config); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s to scripts_bin\n", name+".sh")
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// adhocIdentity signs without a certificate; enough for Apple Silicon to run
+// the binary locally, but not for Gatekeeper on other Macs
+const adhocIdentity = "-"
+
+// unsignable lists tools whose output isn't a Mach-O executable, so there is
+// nothing for codesign to sign
+var unsignable = map[string]bool{
+	"shiv":   true,
+	"zipapp": true,
+}
+
+// codesignEnabled reports whether binaries built with tool are signed: only
+// on macOS, only when an identity is configured, and only for executables
+func codesignEnabled(tool string, opts *CompileOptions, config *Config) bool {
+	return runtime.GOOS == "darwin" && config.CodesignIdentity != "" && opts.Target != targetWasm && !unsignable[tool]
+}
+
+// signBinary signs a freshly built binary with the configured identity and,
+// when a notary profile is configured, submits it for notarization. It
+// reports whether the binary was notarized.
+func signBinary(job *CompileJob) (bool, error) {
+	config := job.Config
+	identity := config.CodesignIdentity
+	if _, err := exec.LookPath(resolveCompiler("codesign", config)[0]); err != nil {
+		return false, fmt.Errorf("codesign not found - install the Xcode command line tools (xcode-select --install)")
+	}
+
+	// Notarization requires the hardened runtime and a secure timestamp,
+	// neither of which applies to ad-hoc signatures
+	args := []string{"--force", "--sign", identity}
+	if identity != adhocIdentity {
+		args = append(args, "--options", "runtime", "--timestamp")
+	}
+	if err := job.command("codesign", append(args, job.OutputPath)...).Run(); err != nil {
+		return false, fmt.Errorf("failed to sign binary with %q: %v", identity, err)
+	}
+	fmt.Fprintf(job.out(), "Signed with %s\n", identity)
+
+	if config.NotaryProfile == "" || identity == adhocIdentity {
+		return false, nil
+	}
+	// A binary that was meant to be notarized but wasn't would trip
+	// Gatekeeper on other Macs, which is what signing is for
+	if err := notarizeBinary(job); err != nil {
+		return false, fmt.Errorf("notarization failed, %s was not installed: %v", filepath.Base(job.OutputPath), err)
+	}
+	return true, nil
+}
+
+// notarizeBinary submits a signed binary to Apple's notary service and waits
+// for the verdict. Bare executables can't be stapled, so Gatekeeper checks
+// the ticket online the first time the binary runs on another Mac.
+func notarizeBinary(job *CompileJob) error {
+	tmpDir, err := os.MkdirTemp("", "scripts-notarize-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// notarytool only accepts zip, pkg and dmg uploads
+	archive := filepath.Join(tmpDir, filepath.Base(job.OutputPath)+".zip")
+	if err := job.command("ditto", "-c", "-k", "--keepParent", job.OutputPath, archive).Run(); err != nil {
+		return fmt.Errorf("failed to zip binary for upload: %v", err)
+	}
+	fmt.Fprintf(job.out(), "Submitting for notarization (profile %s)...\n", job.Config.NotaryProfile)
+	if err := job.command("notarytool", "submit", archive, "--keychain-profile", job.Config.NotaryProfile, "--wait").Run(); err != nil {
+		return err
+	}
+	fmt.Fprintln(job.out(), "Notarized")
+	return nil
+}
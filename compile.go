@@ -0,0 +1,959 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CompileOptions describes a single compile request from the command line
+type CompileOptions struct {
+	SourcePath   string
+	BinaryName   string     // empty means use the source file name
+	Flags        []string   // passed verbatim to the underlying compiler
+	CargoBin     string     // binary to build from a multi-binary Cargo crate
+	Artifact     string     // executable to install from a project build
+	Origin       *GitOrigin // set when the source was cloned by compile-git
+	Force        bool       // rebuild even if the sources are unchanged
+	IfChanged    bool       // explicitly request the up-to-date check
+	Output       io.Writer  // where build output goes (default: the terminal)
+	Dir          string     // batch mode: compile every source in this directory
+	Jobs         int        // batch mode: number of parallel builds
+	Watch        bool       // rebuild whenever the source changes
+	Run          bool       // watch mode: run the binary after each build
+	Small        bool       // optimise for size, strip and pack the binary
+	Static       bool       // link statically for minimal containers and servers
+	BuildEnv     []string   // KEY=VAL pairs for the compiler environment
+	Tags         string     // Go build tags
+	Features     string     // Cargo features
+	Backend      string     // Python packaging backend
+	Target       string     // "wasm" builds a WASI module instead of a native binary
+	Smoke        bool       // run the new binary once before installing it
+	SmokeArgs    []string   // arguments for the smoke test run
+	InstallDeps  bool       // install a missing compiler with the package manager
+	VerboseBuild bool       // stream compiler output instead of only logging it
+	Mode         string     // exact permission bits for the binary, e.g. 0755
+}
+
+// out returns the writer build output should go to
+func (opts *CompileOptions) out() io.Writer {
+	if opts.Output != nil {
+		return opts.Output
+	}
+	return os.Stdout
+}
+
+// CompileResult reports what a compile produced
+type CompileResult struct {
+	Name       string
+	OutputPath string
+	UpToDate   bool // the build was skipped because nothing changed
+}
+
+// CompileJob carries everything a language backend needs to produce a binary
+type CompileJob struct {
+	SourcePath string
+	OutputPath string
+	Flags      []string // config defaults followed by command line flags
+	Config     *Config
+	Cargo      *CargoTarget // set when building a binary from a Cargo project
+	Artifact   string       // executable to install from a project build
+	Output     io.Writer    // receives compiler stdout and stderr
+	Env        []string     // extra environment for the compiler, e.g. CGO_ENABLED=0
+	RustTarget string       // target triple for rustc and cargo builds
+	Backend    string       // Python packaging backend
+	Target     string       // "wasm" for WASI modules
+	Log        io.Writer    // receives compiler output when it goes to a build log
+}
+
+// defaultCompilers lists the candidate commands tried, in order, for each
+// tool when the config does not name one explicitly
+var defaultCompilers = map[string][]string{
+	"go":         {"go"},
+	"python":     {"pyinstaller", "python3 -m PyInstaller"},
+	"nuitka":     {"nuitka", "nuitka3", "python3 -m nuitka"},
+	"shiv":       {"shiv", "python3 -m shiv"},
+	"zipapp":     {"python3 -m zipapp"},
+	"v":          {"v"},
+	"rust":       {"rustc"},
+	"cargo":      {"cargo"},
+	"c":          {"gcc", "clang", "cc"},
+	"cpp":        {"g++", "clang++", "c++"},
+	"csharp":     {"dotnet"},
+	"cmake":      {"cmake"},
+	"make":       {"make", "gmake"},
+	"meson":      {"meson"},
+	"ninja":      {"ninja", "samu"},
+	"wasm-c":     {"clang"},
+	"wasm-cpp":   {"clang++"},
+	"wasmtime":   {"wasmtime"},
+	"docker":     {"docker", "podman"},
+	"strip":      {"strip", "llvm-strip"},
+	"upx":        {"upx"},
+	"codesign":   {"codesign"},
+	"notarytool": {"xcrun notarytool"},
+	"ditto":      {"ditto"},
+	"rpmbuild":   {"rpmbuild"},
+}
+
+// resolveCompiler returns the command line (program plus leading arguments)
+// used to invoke the compiler for tool. A configured entry always wins;
+// otherwise the first installed default candidate is used.
+func resolveCompiler(tool string, config *Config) []string {
+	if command := strings.Fields(config.Compilers[tool]); len(command) > 0 {
+		// A bare interpreter such as "python3.12" means "use its PyInstaller"
+		if tool == "python" && len(command) == 1 && strings.HasPrefix(filepath.Base(command[0]), "python") {
+			command = append(command, "-m", "PyInstaller")
+		}
+		return command
+	}
+	candidates := defaultCompilers[tool]
+	for _, candidate := range candidates {
+		command := strings.Fields(candidate)
+		if _, err := exec.LookPath(command[0]); err == nil {
+			return command
+		}
+	}
+	if len(candidates) > 0 {
+		// Nothing installed - return the preferred tool so the error names it
+		return strings.Fields(candidates[0])
+	}
+	return []string{tool}
+}
+
+// configBuildEnv returns the configured build environment for a language as
+// KEY=VAL pairs in a stable order
+func configBuildEnv(lang string, config *Config) []string {
+	var env []string
+	for key, value := range config.BuildEnv[lang] {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// command builds an exec.Cmd for the resolved compiler of tool, wired to
+// the job's output
+func (job *CompileJob) command(tool string, args ...string) *exec.Cmd {
+	if job.Target == targetWasm {
+		tool = wasmTool(tool)
+	}
+	compiler := resolveCompiler(tool, job.Config)
+	cmd := exec.Command(compiler[0], append(compiler[1:], args...)...)
+	if len(job.Env) > 0 {
+		cmd.Env = append(os.Environ(), job.Env...)
+	}
+	if job.Log != nil {
+		cmd.Stdout = job.Log
+		cmd.Stderr = job.Log
+		return cmd
+	}
+	cmd.Stdout = job.out()
+	cmd.Stderr = job.errOut()
+	return cmd
+}
+
+// out returns the writer for the job's regular output
+func (job *CompileJob) out() io.Writer {
+	if job.Output != nil {
+		return job.Output
+	}
+	return os.Stdout
+}
+
+// errOut returns the writer for compiler diagnostics
+func (job *CompileJob) errOut() io.Writer {
+	if job.Output != nil {
+		return job.Output
+	}
+	return os.Stderr
+}
+
+// languageForExt maps a source file extension to the language key used in config
+func languageForExt(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".go":
+		return "go"
+	case ".py":
+		return "python"
+	case ".v":
+		return "v"
+	case ".rs":
+		return "rust"
+	case ".c":
+		return "c"
+	case ".cpp", ".cc", ".cxx":
+		return "cpp"
+	case ".cs", ".csproj":
+		return "csharp"
+	}
+	return ""
+}
+
+// parseCompileArgs parses the arguments following "compile". Everything after
+// a bare "--" is treated as compiler flags and passed through untouched.
+func parseCompileArgs(args []string) (*CompileOptions, error) {
+	opts := &CompileOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			opts.Flags = append(opts.Flags, args[i+1:]...)
+			i = len(args)
+		case arg == "--name" || arg == "-n":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a binary name", arg)
+			}
+			i++
+			opts.BinaryName = args[i]
+		case arg == "--bin":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--bin requires a Cargo binary name")
+			}
+			i++
+			opts.CargoBin = args[i]
+		case arg == "--force" || arg == "-f":
+			opts.Force = true
+		case arg == "--mode":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--mode requires a value")
+			}
+			i++
+			if _, err := parseMode(args[i]); err != nil {
+				return nil, err
+			}
+			opts.Mode = args[i]
+		case arg == "--verbose-build":
+			opts.VerboseBuild = true
+		case arg == "--install-deps":
+			opts.InstallDeps = true
+		case arg == "--if-changed":
+			opts.IfChanged = true
+		case arg == "--dir":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--dir requires a directory")
+			}
+			i++
+			opts.Dir = args[i]
+		case arg == "--jobs" || arg == "-j":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a number", arg)
+			}
+			i++
+			jobs, err := parseJobs(args[i])
+			if err != nil {
+				return nil, err
+			}
+			opts.Jobs = jobs
+		case arg == "--watch" || arg == "-w":
+			opts.Watch = true
+		case arg == "--run":
+			opts.Run = true
+		case arg == "--target":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--target requires a target (wasm)")
+			}
+			i++
+			if args[i] != targetWasm {
+				return nil, fmt.Errorf("unsupported --target %s (supported: wasm)", args[i])
+			}
+			opts.Target = args[i]
+		case arg == "--backend":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--backend requires a Python backend (pyinstaller, nuitka, shiv, zipapp)")
+			}
+			i++
+			opts.Backend = args[i]
+		case arg == "--tags":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--tags requires a comma-separated list of build tags")
+			}
+			i++
+			opts.Tags = args[i]
+		case arg == "--features":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--features requires a comma-separated list of Cargo features")
+			}
+			i++
+			opts.Features = args[i]
+		case arg == "--build-env":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--build-env requires KEY=VAL")
+			}
+			i++
+			if !strings.Contains(args[i], "=") || strings.HasPrefix(args[i], "=") {
+				return nil, fmt.Errorf("invalid --build-env %q: expected KEY=VAL", args[i])
+			}
+			opts.BuildEnv = append(opts.BuildEnv, args[i])
+		case arg == "--static":
+			opts.Static = true
+		case arg == "--small":
+			opts.Small = true
+		case arg == "--smoke":
+			opts.Smoke = true
+			opts.SmokeArgs = defaultSmokeArgs
+		case strings.HasPrefix(arg, "--smoke="):
+			opts.Smoke = true
+			opts.SmokeArgs = strings.Fields(strings.TrimPrefix(arg, "--smoke="))
+		case arg == "--artifact":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--artifact requires an executable name or path")
+			}
+			i++
+			opts.Artifact = args[i]
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			if opts.SourcePath != "" {
+				return nil, fmt.Errorf("only one source may be given")
+			}
+			opts.SourcePath = arg
+		}
+	}
+	if opts.Dir != "" {
+		if opts.SourcePath != "" || opts.BinaryName != "" || opts.CargoBin != "" || opts.Artifact != "" {
+			return nil, fmt.Errorf("--dir cannot be combined with a source, --name, --bin or --artifact")
+		}
+	} else if opts.SourcePath == "" {
+		return nil, fmt.Errorf("no source given")
+	}
+	if opts.Watch && opts.Dir != "" {
+		return nil, fmt.Errorf("--watch cannot be combined with --dir")
+	}
+	if opts.Run && !opts.Watch {
+		return nil, fmt.Errorf("--run requires --watch")
+	}
+	if opts.Force && opts.IfChanged {
+		return nil, fmt.Errorf("--force and --if-changed cannot be combined")
+	}
+	if opts.Target == targetWasm && (opts.Static || opts.Smoke) {
+		return nil, fmt.Errorf("--target wasm cannot be combined with --static or --smoke")
+	}
+	return opts, nil
+}
+
+func compileSource(opts *CompileOptions, config *Config) (*CompileResult, error) {
+	sourcePath := opts.SourcePath
+	perms, err := resolvePermissions(config, opts.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Work out the language and default binary name from the source, which
+	// may be a single file, a project directory or a Go package path
+	var lang, defaultName string
+	info, statErr := os.Stat(sourcePath)
+	switch {
+	case statErr == nil && info.IsDir():
+		lang = languageForDir(sourcePath)
+		if lang == "" {
+			return nil, fmt.Errorf("no supported project found in directory %s", sourcePath)
+		}
+		absDir, err := filepath.Abs(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", sourcePath, err)
+		}
+		defaultName = filepath.Base(absDir)
+	case os.IsNotExist(statErr):
+		if !isGoPackagePath(sourcePath) {
+			return nil, fmt.Errorf("source file %s does not exist", sourcePath)
+		}
+		lang = "go"
+		defaultName = path.Base(strings.TrimSuffix(sourcePath, "/..."))
+	case filepath.Base(sourcePath) == "Cargo.toml":
+		lang = "rust"
+	default:
+		// Get file extension to determine language
+		ext := strings.ToLower(filepath.Ext(sourcePath))
+		lang = languageForExt(ext)
+		if lang == "" {
+			// Fall back to the shebang or the first few lines
+			detected, err := languageForContent(sourcePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", sourcePath, err)
+			}
+			if detected == "" {
+				if ext != "" {
+					return nil, fmt.Errorf("unsupported file extension: %s", ext)
+				}
+				return nil, fmt.Errorf("could not detect the language of %s (add an extension or a shebang)", sourcePath)
+			}
+			lang = detected
+		}
+		defaultName = strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+	}
+
+	// Cargo projects are named after the binary target being built
+	var cargoTarget *CargoTarget
+	if lang == "rust" {
+		target, err := resolveCargoTarget(sourcePath, opts.CargoBin, config)
+		if err != nil {
+			return nil, err
+		}
+		if target != nil {
+			cargoTarget = target
+			defaultName = target.BinName
+		}
+	} else if opts.CargoBin != "" {
+		return nil, fmt.Errorf("--bin is only supported for Rust (Cargo) projects")
+	}
+
+	// Create output directory if it doesn't exist
+	wasm := opts.Target == targetWasm
+	binDir := wasmDir(config)
+	if !wasm {
+		dir, err := installDir(lang, config)
+		if err != nil {
+			return nil, err
+		}
+		binDir = dir
+	}
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create bin directory: %v", err)
+	}
+
+	// Use provided binary name or default to source file name
+	name := opts.BinaryName
+	if name == "" {
+		name = defaultName
+	}
+	if wasm {
+		name = wasmName(name)
+	}
+	outputPath, err := filepath.Abs(filepath.Join(binDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output path: %v", err)
+	}
+
+	tool := lang
+	backend := ""
+	switch {
+	case cargoTarget != nil:
+		tool = "cargo"
+	case lang == "python":
+		if backend, err = pythonBackend(opts, config); err != nil {
+			return nil, err
+		}
+		tool = pythonBackends[backend]
+	case opts.Backend != "":
+		return nil, fmt.Errorf("--backend is only supported for Python")
+	}
+	if wasm && tool == lang {
+		tool = wasmTool(lang)
+	}
+	if opts.Tags != "" && lang != "go" {
+		return nil, fmt.Errorf("--tags is only supported for Go")
+	}
+	if opts.Features != "" && tool != "cargo" {
+		return nil, fmt.Errorf("--features is only supported for Rust (Cargo) projects")
+	}
+
+	// Config defaults come first so command line flags can override them
+	var flags []string
+	if opts.Small {
+		flags = append(flags, sizeFlags[tool]...)
+	}
+	if opts.Tags != "" {
+		flags = append(flags, "-tags", opts.Tags)
+	}
+	if opts.Features != "" {
+		flags = append(flags, "--features", opts.Features)
+	}
+	flags = append(flags, config.CompileFlags[lang]...)
+	flags = append(flags, opts.Flags...)
+
+	// Compilers insist on the right extension, so a source detected from its
+	// contents is built from a copy that has one
+	buildSource := sourcePath
+	if statErr == nil && !info.IsDir() && languageForExt(filepath.Ext(sourcePath)) != lang && cargoTarget == nil {
+		stageDir, staged, err := stageDetectedSource(sourcePath, defaultName, lang)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(stageDir)
+		buildSource = staged
+	}
+
+	job := &CompileJob{
+		SourcePath: buildSource,
+		OutputPath: outputPath,
+		Flags:      flags,
+		Config:     config,
+		Cargo:      cargoTarget,
+		Artifact:   opts.Artifact,
+		Output:     opts.Output,
+		Env:        append(toolchainEnv(lang, config), configBuildEnv(lang, config)...),
+		Backend:    backend,
+		Target:     opts.Target,
+	}
+	if wasm {
+		if err := prepareWasm(job, lang); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Static {
+		if err := prepareStatic(job, lang, tool); err != nil {
+			return nil, err
+		}
+	}
+
+	// Command line variables come last so they override config and --static
+	job.Env = append(job.Env, opts.BuildEnv...)
+
+	// Skip the build when neither the sources nor the build settings have
+	// changed since the binary was last produced
+	hash := buildHash(sourcePath, lang, job.Flags, job.Env, opts, cargoTarget, config)
+	if !opts.Force && hash != "" {
+		if manifest, err := loadManifest(config); err == nil {
+			record := manifest.Binaries[name]
+			if _, err := os.Stat(outputPath); err == nil && record != nil && record.Hash == hash {
+				// Permissions aren't part of the build, so a changed policy
+				// still applies
+				if err := perms.apply(outputPath); err != nil {
+					return nil, fmt.Errorf("failed to set permissions on %s: %v", outputPath, err)
+				}
+				fmt.Fprintf(opts.out(), "%s is up to date (use --force to rebuild)\n", name)
+				return &CompileResult{Name: name, OutputPath: outputPath, UpToDate: true}, nil
+			}
+		}
+	}
+
+	// Fail with an install hint rather than the compiler's exec error
+	if err := ensureTools(tool, opts, config); err != nil {
+		return nil, err
+	}
+	if err := checkToolchain(job, lang, tool); err != nil {
+		return nil, err
+	}
+
+	// --small reports the new size against the binary being replaced
+	replacedSize := int64(-1)
+	if info, err := os.Stat(outputPath); err == nil && opts.Small {
+		replacedSize = info.Size()
+	}
+
+	// The binary is built next to the installed one and only moved into
+	// place once it succeeds (and passes its smoke test), so a failed build
+	// leaves the current binary and its saved versions alone
+	stageDir, err := os.MkdirTemp(binDir, ".build-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(stageDir)
+	job.OutputPath = filepath.Join(stageDir, name)
+
+	// Interactive builds keep the compiler output in a log, shown on failure;
+	// batch builds already capture it in opts.Output
+	var buildLog *BuildLog
+	if opts.Output == nil {
+		var logErr error
+		if buildLog, logErr = startBuildLog(job, name, opts); logErr != nil {
+			fmt.Fprintf(opts.out(), "Warning: %v\n", logErr)
+		}
+	}
+
+	switch lang {
+	case "go":
+		err = compileGo(job)
+	case "python":
+		err = compilePython(job)
+	case "v":
+		err = compileV(job)
+	case "rust":
+		err = compileRust(job)
+	case "c":
+		err = compileC(job)
+	case "cpp":
+		err = compileCpp(job)
+	case "csharp":
+		err = compileCSharp(job)
+	case "cmake":
+		err = compileCMake(job)
+	case "make":
+		err = compileMake(job)
+	case "meson":
+		err = compileMeson(job)
+	}
+	err = buildLog.finish(job, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Make binary executable
+	if err := perms.apply(job.OutputPath); err != nil {
+		return nil, fmt.Errorf("failed to make binary executable: %v", err)
+	}
+
+	if opts.Small {
+		if err := shrinkBinary(job, tool, replacedSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// Sign after shrinking, which would invalidate the signature, and before
+	// the smoke test, since macOS kills unsigned binaries on Apple Silicon
+	notarized := false
+	if codesignEnabled(tool, opts, config) {
+		if notarized, err = signBinary(job); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Smoke {
+		if err := smokeTest(job.OutputPath, opts.SmokeArgs, opts); err != nil {
+			return nil, fmt.Errorf("smoke test failed, %s was not installed: %v", name, err)
+		}
+	}
+
+	// Keep the build being replaced so a bad build can be rolled back
+	if err := archiveBinary(name, config); err != nil {
+		fmt.Fprintf(opts.out(), "Warning: %v\n", err)
+	}
+	if err := os.Rename(job.OutputPath, outputPath); err != nil {
+		return nil, fmt.Errorf("failed to install %s: %v", name, err)
+	}
+
+	if !wasm {
+		if err := placeBinary(name, outputPath, config); err != nil {
+			fmt.Fprintf(opts.out(), "Warning: %v\n", err)
+		}
+	}
+
+	// Remember how the binary was built
+	record := &BinaryRecord{
+		Source:          sourcePath,
+		Language:        lang,
+		BuiltAt:         time.Now(),
+		Hash:            hash,
+		Flags:           opts.Flags,
+		ConfigFlags:     config.CompileFlags[lang],
+		Compiler:        strings.Join(resolveCompiler(tool, config), " "),
+		CompilerVersion: compilerVersion(tool, job.Env, config),
+		CargoBin:        opts.CargoBin,
+		Artifact:        opts.Artifact,
+		Small:           opts.Small,
+		Static:          opts.Static,
+		BuildEnv:        opts.BuildEnv,
+		Tags:            opts.Tags,
+		Features:        opts.Features,
+		Backend:         backend,
+		Target:          opts.Target,
+		Mode:            opts.Mode,
+		Git:             opts.Origin,
+	}
+	if codesignEnabled(tool, opts, config) {
+		record.Signed = config.CodesignIdentity
+		record.Notarized = notarized
+	}
+	if checksum, err := fileChecksum(outputPath); err == nil {
+		record.Checksum = checksum
+	}
+	if opts.Origin != nil {
+		// The clone is temporary, so only the path inside the repo is kept
+		record.Source = opts.Origin.Path
+	} else if statErr != nil {
+		// Import paths only resolve from inside their module
+		record.WorkDir, _ = os.Getwd()
+	} else if absSource, err := filepath.Abs(sourcePath); err == nil {
+		record.Source = absSource
+	}
+	if err := recordBinary(name, record, config); err != nil {
+		fmt.Fprintf(opts.out(), "Warning: %v\n", err)
+	}
+
+	if opts.Origin != nil {
+		fmt.Fprintf(opts.out(), "Compiled %s@%s to %s\n", opts.Origin.URL, shortCommit(opts.Origin.Commit), outputPath)
+	} else {
+		fmt.Fprintf(opts.out(), "Compiled %s to %s\n", sourcePath, outputPath)
+	}
+	return &CompileResult{Name: name, OutputPath: outputPath}, nil
+}
+
+// languageForDir detects which language a project directory is written in
+func languageForDir(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		return "go"
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.go")); len(files) > 0 {
+		return "go"
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Cargo.toml")); err == nil {
+		return "rust"
+	}
+	if _, err := os.Stat(filepath.Join(dir, "meson.build")); err == nil {
+		return "meson"
+	}
+	if _, err := os.Stat(filepath.Join(dir, "CMakeLists.txt")); err == nil {
+		return "cmake"
+	}
+	for _, makefile := range []string{"GNUmakefile", "makefile", "Makefile"} {
+		if _, err := os.Stat(filepath.Join(dir, makefile)); err == nil {
+			return "make"
+		}
+	}
+	return ""
+}
+
+// isGoPackagePath reports whether a non-existent source looks like a Go
+// import path that "go list" can resolve from the current module
+func isGoPackagePath(source string) bool {
+	if filepath.Ext(source) != "" && !strings.HasSuffix(source, "/...") {
+		return false
+	}
+	if !strings.Contains(source, "/") {
+		return false
+	}
+	return exec.Command("go", "list", source).Run() == nil
+}
+
+func compileGo(job *CompileJob) error {
+	args := append([]string{"build"}, job.Flags...)
+	args = append(args, "-o", job.OutputPath)
+
+	info, err := os.Stat(job.SourcePath)
+	switch {
+	case err != nil:
+		// Package import path, resolved by the go tool
+		return job.command("go", append(args, job.SourcePath)...).Run()
+	case info.IsDir():
+		// Build the package from inside its directory so its module is used
+		cmd := job.command("go", append(args, ".")...)
+		cmd.Dir = job.SourcePath
+		return cmd.Run()
+	}
+
+	needsModule, err := goFileNeedsModule(job.SourcePath)
+	if err != nil {
+		return err
+	}
+	if needsModule {
+		return compileGoWithTempModule(job, args)
+	}
+	return job.command("go", append(args, job.SourcePath)...).Run()
+}
+
+// goFileNeedsModule reports whether a standalone Go file imports packages
+// outside the standard library without having a go.mod to resolve them
+func goFileNeedsModule(sourcePath string) (bool, error) {
+	absPath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return false, err
+	}
+	for dir := filepath.Dir(absPath); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return false, nil
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), sourcePath, nil, parser.ImportsOnly)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s: %v", sourcePath, err)
+	}
+	for _, imp := range file.Imports {
+		importPath := strings.Trim(imp.Path.Value, `"`)
+		// Standard library packages never have a dot in their first element
+		if strings.Contains(strings.Split(importPath, "/")[0], ".") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compileGoWithTempModule builds a single Go file with external imports by
+// copying it into a throwaway module and letting "go mod tidy" fetch them
+func compileGoWithTempModule(job *CompileJob, buildArgs []string) error {
+	tmpDir, err := os.MkdirTemp("", "scripts_gomod_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp module directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source, err := os.ReadFile(job.SourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read source file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.go"), source, 0644); err != nil {
+		return fmt.Errorf("failed to copy source into temp module: %v", err)
+	}
+
+	fmt.Fprintln(job.out(), "Creating temporary module for external imports")
+	moduleName := strings.TrimSuffix(filepath.Base(job.OutputPath), filepath.Ext(job.OutputPath))
+	for _, args := range [][]string{{"mod", "init", moduleName}, {"mod", "tidy"}} {
+		cmd := job.command("go", args...)
+		cmd.Dir = tmpDir
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go %s failed: %v", strings.Join(args, " "), err)
+		}
+	}
+
+	cmd := job.command("go", append(buildArgs, ".")...)
+	cmd.Dir = tmpDir
+	return cmd.Run()
+}
+
+func compileV(job *CompileJob) error {
+	args := append([]string{"-prod"}, job.Flags...)
+	args = append(args, "-o", job.OutputPath, job.SourcePath)
+	return job.command("v", args...).Run()
+}
+
+func compileRust(job *CompileJob) error {
+	if job.Cargo != nil {
+		return compileCargo(job)
+	}
+
+	// Single file compilation with rustc
+	args := append([]string{}, job.Flags...)
+	if job.RustTarget != "" {
+		args = append(args, "--target", job.RustTarget)
+	}
+	args = append(args, "-o", job.OutputPath, job.SourcePath)
+	return job.command("rust", args...).Run()
+}
+
+func compileC(job *CompileJob) error {
+	// Flags go after the source so that linker flags like -lm resolve correctly
+	args := append([]string{"-o", job.OutputPath, job.SourcePath}, job.Flags...)
+	return job.command("c", args...).Run()
+}
+
+func compileCpp(job *CompileJob) error {
+	args := append([]string{"-o", job.OutputPath, job.SourcePath}, job.Flags...)
+	return job.command("cpp", args...).Run()
+}
+
+func compileCSharp(job *CompileJob) error {
+	sourcePath := job.SourcePath
+	projectPath := sourcePath
+	if strings.ToLower(filepath.Ext(sourcePath)) == ".cs" {
+		// Use the project next to the source file if there is exactly one,
+		// otherwise wrap the single file in a throwaway console project
+		projects, _ := filepath.Glob(filepath.Join(filepath.Dir(sourcePath), "*.csproj"))
+		if len(projects) == 1 {
+			projectPath = projects[0]
+		} else {
+			tmpDir, err := os.MkdirTemp("", "scripts_dotnet_")
+			if err != nil {
+				return fmt.Errorf("failed to create temp project directory: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			name := strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+			projectPath = filepath.Join(tmpDir, name+".csproj")
+			project := `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <OutputType>Exe</OutputType>
+    <TargetFramework>net8.0</TargetFramework>
+    <ImplicitUsings>enable</ImplicitUsings>
+    <Nullable>enable</Nullable>
+  </PropertyGroup>
+</Project>
+`
+			if err := os.WriteFile(projectPath, []byte(project), 0644); err != nil {
+				return fmt.Errorf("failed to write temp project: %v", err)
+			}
+			source, err := os.ReadFile(sourcePath)
+			if err != nil {
+				return fmt.Errorf("failed to read source file: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(tmpDir, filepath.Base(sourcePath)), source, 0644); err != nil {
+				return fmt.Errorf("failed to copy source into temp project: %v", err)
+			}
+		}
+	}
+
+	publishDir, err := os.MkdirTemp("", "scripts_publish_")
+	if err != nil {
+		return fmt.Errorf("failed to create publish directory: %v", err)
+	}
+	defer os.RemoveAll(publishDir)
+
+	args := []string{"publish", projectPath,
+		"-c", "Release",
+		"-r", dotnetRuntimeID(),
+		"--self-contained", "false",
+		"-p:PublishSingleFile=true",
+		"-o", publishDir}
+	if err := job.command("csharp", append(args, job.Flags...)...).Run(); err != nil {
+		return fmt.Errorf("dotnet publish failed: %v (make sure the .NET SDK is installed)", err)
+	}
+
+	// The published executable is named after the project's assembly
+	assemblyName := strings.TrimSuffix(filepath.Base(projectPath), filepath.Ext(projectPath))
+	if runtime.GOOS == "windows" {
+		assemblyName += ".exe"
+	}
+	publishedPath := filepath.Join(publishDir, assemblyName)
+	if _, err := os.Stat(publishedPath); err != nil {
+		return fmt.Errorf("published executable %s not found", assemblyName)
+	}
+	return moveFile(publishedPath, job.OutputPath)
+}
+
+// dotnetRuntimeID maps the current platform to a .NET runtime identifier
+func dotnetRuntimeID() string {
+	osName := runtime.GOOS
+	switch osName {
+	case "darwin":
+		osName = "osx"
+	case "windows":
+		osName = "win"
+	}
+	arch := runtime.GOARCH
+	if arch == "amd64" {
+		arch = "x64"
+	}
+	return osName + "-" + arch
+}
+
+// moveFile renames src to dst, falling back to a copy when they are on
+// different filesystems (e.g. a temp dir on tmpfs)
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst, replacing dst and keeping src's permissions
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", src, err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", src, err)
+	}
+
+	// Remove first so a running binary at dst is replaced rather than
+	// overwritten in place ("text file busy")
+	_ = os.Remove(dst)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s to %s: %v", src, dst, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// commandNames are the commands completed in place of a script name
+var commandNames = []string{
+	"add", "adopt", "archive", "bin", "cache", "chain", "check", "compile", "compile-git", "completion", "containerize", "deprecate", "diff", "doctor", "env", "export-shell", "fetch", "fsck", "help", "history",
+	"hooks", "install", "list", "new", "outdated", "package", "preset", "publish", "ready", "rebuild", "replay", "rm",
+	"run-wasm", "search", "serve", "shell", "stats", "sync", "tap", "test", "unarchive", "update", "upgrade", "var", "versions", "which",
+}
+
+// subcommandNames are the subcommands of command groups
+var subcommandNames = map[string][]string{
+	"tap":        {"add", "list", "update", "remove"},
+	"bin":        {"list", "verify", "versions", "rollback", "rm"},
+	"history":    {"export"},
+	"cache":      {"list", "clean"},
+	"hooks":      {"list", "run", "install"},
+	"completion": {"bash", "zsh", "fish"},
+	"var":        {"set", "get", "list", "rm"},
+	"preset":     {"save", "list", "rm"},
+	"sync":       {"status"},
+}
+
+// scriptCommands are the commands whose first argument is a script name
+var scriptCommands = []string{"archive", "deprecate", "diff", "env", "publish", "ready", "replay", "rm", "stats", "test", "update", "upgrade", "versions", "which"}
+
+// runFlags are the flags accepted before a script name, with whether they
+// take a value
+var runFlags = map[string]bool{
+	"--record": false, "--no-prompt": false, "--sudo": false, "--user": true, "--skip-unchanged": false,
+	"--inputs": true, "--force": false, "--wait": false, "--queue": false,
+	"--var": true, "--filter": true, "--grep": true, "--json": false, "--cwd": true, "--strict": false,
+}
+
+// complete returns the completions of the last word of a command line,
+// given the words after "scripts". After a script name it offers the
+// flags the script declares with "# scripts:flag" and the choices of its
+// parameters. Nothing is returned where file names are expected, so the
+// shell completes those.
+func complete(words []string, config *Config) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current, before := words[len(words)-1], words[:len(words)-1]
+
+	// Skip the run flags before a script name
+	i := 0
+	for i < len(before) && strings.HasPrefix(before[i], "-") {
+		if runFlags[before[i]] {
+			i++
+		}
+		i++
+	}
+	if i > len(before) {
+		// Completing the value of a run flag
+		return nil
+	}
+
+	var candidates []string
+	switch {
+	case i == len(before) && strings.HasPrefix(current, "-"):
+		for flag := range runFlags {
+			candidates = append(candidates, flag)
+		}
+		slices.Sort(candidates)
+	case i == len(before):
+		if i == 0 {
+			candidates = append(candidates, commandNames...)
+		}
+		candidates = append(candidates, scriptNames(config)...)
+	case i == 0 && slices.Contains(commandNames, before[0]):
+		candidates = completeCommand(before[0], before[1:], config)
+	default:
+		candidates = completeScriptArgs(before[i], before[i+1:], current, config)
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// completeCommand completes the arguments of a command
+func completeCommand(command string, args []string, config *Config) []string {
+	if command == "chain" {
+		// Every step of a chain is a script
+		return append(scriptNames(config), "--stop-on-fail")
+	}
+	if len(args) > 0 {
+		return nil
+	}
+	if command == "unarchive" {
+		var names []string
+		for _, script := range archivedScripts(config) {
+			names = append(names, script.Name)
+		}
+		return names
+	}
+	if subcommands, ok := subcommandNames[command]; ok {
+		return subcommands
+	}
+	if slices.Contains(scriptCommands, command) {
+		return scriptNames(config)
+	}
+	return nil
+}
+
+// completeScriptArgs completes an argument of a script from its metadata:
+// its flags when the word starts with "-", else the choices of the
+// parameter at that position, or its presets for a first word starting
+// with "@"
+func completeScriptArgs(name string, args []string, current string, config *Config) []string {
+	path, err := findScript(name, config)
+	if err != nil {
+		return nil
+	}
+	meta, err := readMetadata(path)
+	if err != nil {
+		return nil
+	}
+	if len(args) == 0 && strings.HasPrefix(current, presetPrefix) {
+		return presetNames(strings.TrimSuffix(filepath.Base(path), ".sh"), config)
+	}
+	if strings.HasPrefix(current, "-") {
+		var flags []string
+		for _, value := range meta.Values["flag"] {
+			if fields := strings.Fields(value); len(fields) > 0 {
+				flags = append(flags, fields[0])
+			}
+		}
+		return flags
+	}
+
+	position := 0
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			position++
+		}
+	}
+	if position >= len(meta.Params) {
+		return nil
+	}
+	param := meta.Params[position]
+	switch {
+	case len(param.Choices) > 0:
+		return param.Choices
+	case param.Type == "bool":
+		return []string{"true", "false"}
+	}
+	return nil
+}
+
+// scriptNames returns the names of every script
+func scriptNames(config *Config) []string {
+	var names []string
+	for _, script := range listScripts(config) {
+		names = append(names, script.Name)
+	}
+	return names
+}
+
+// completionScript returns the completion script for a shell, which asks
+// "scripts __complete" for the candidates
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (expected bash, zsh or fish)", shell)
+}
+
+const bashCompletion = `# bash completion for scripts
+_scripts() {
+    local IFS=$'\n'
+    COMPREPLY=($(scripts __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _scripts scripts
+`
+
+const zshCompletion = `#compdef scripts
+# zsh completion for scripts
+_scripts() {
+    local -a candidates
+    candidates=(${(f)"$(scripts __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    if (( ${#candidates} )); then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+compdef _scripts scripts
+`
+
+const fishCompletion = `# fish completion for scripts
+function __scripts_complete
+    set -l candidates (scripts __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c scripts -f -a '(__scripts_complete)'
+`
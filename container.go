@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultBaseImage is the base image used when --base isn't given
+const defaultBaseImage = "alpine:latest"
+
+// ContainerOptions describes a containerize request from the command line
+type ContainerOptions struct {
+	Name   string // script or binary to wrap
+	Base   string // base image, e.g. alpine:latest or scratch
+	Tag    string // image tag (default: <name>:latest)
+	Output string // write the build context here instead of building
+}
+
+func parseContainerizeArgs(args []string) (*ContainerOptions, error) {
+	opts := &ContainerOptions{Base: defaultBaseImage}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--base" || arg == "--tag" || arg == "--output" || arg == "-o":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			switch arg {
+			case "--base":
+				opts.Base = args[i]
+			case "--tag":
+				opts.Tag = args[i]
+			default:
+				opts.Output = args[i]
+			}
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			if opts.Name != "" {
+				return nil, fmt.Errorf("only one script or binary may be given")
+			}
+			opts.Name = arg
+		}
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("no script or binary given")
+	}
+	if opts.Tag == "" {
+		opts.Tag = opts.Name + ":latest"
+	}
+	return opts, nil
+}
+
+// containerize wraps a script or compiled binary into an OCI image built
+// with docker (or podman), or just writes the build context with --output
+func containerize(opts *ContainerOptions, config *Config) error {
+	// Scripts win over binaries, as when running by name
+	path, err := findScript(opts.Name, config)
+	isScript := true
+	if err != nil {
+		isScript = false
+		if path, err = binaryPath(opts.Name, config); err != nil {
+			return fmt.Errorf("%s is neither a script in %s nor a binary in %s", opts.Name, config.ScriptDir, config.BinDir)
+		}
+	}
+
+	var setup []string
+	if isScript {
+		interpreter := scriptInterpreter(path)
+		if opts.Base == "scratch" {
+			return fmt.Errorf("scripts need an interpreter, so they can't use the scratch base image")
+		}
+		if strings.HasPrefix(opts.Base, "alpine") && interpreter != "" && interpreter != "sh" {
+			// Alpine only ships busybox sh
+			setup = append(setup, "RUN apk add --no-cache "+interpreter)
+		}
+	} else if manifest, err := loadManifest(config); err == nil {
+		record := manifest.Binaries[opts.Name]
+		native := record == nil || (record.Language != "python" && record.Target == "")
+		if native && (record == nil || !record.Static) && (opts.Base == "scratch" || strings.HasPrefix(opts.Base, "alpine")) {
+			fmt.Printf("Warning: %s was not built with --static and may not run on %s (rebuild with 'scripts compile <source> --static')\n", opts.Name, opts.Base)
+		}
+	}
+
+	contextDir := opts.Output
+	if contextDir == "" {
+		tmpDir, err := os.MkdirTemp("", "scripts-containerize-")
+		if err != nil {
+			return fmt.Errorf("failed to create build context: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		contextDir = tmpDir
+	} else if err := os.MkdirAll(contextDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", contextDir, err)
+	}
+
+	if err := copyFile(path, filepath.Join(contextDir, opts.Name)); err != nil {
+		return err
+	}
+	dockerfile := dockerfileFor(opts.Name, opts.Base, setup)
+	if err := os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		return fmt.Errorf("failed to write Dockerfile: %v", err)
+	}
+
+	if opts.Output != "" {
+		fmt.Printf("Wrote Dockerfile and %s to %s\n", opts.Name, contextDir)
+		fmt.Printf("Build it with: docker build -t %s %s\n", opts.Tag, contextDir)
+		return nil
+	}
+
+	engine := resolveCompiler("docker", config)
+	if _, err := exec.LookPath(engine[0]); err != nil {
+		return fmt.Errorf("no container engine found (install docker or podman, or use --output to write the build context)")
+	}
+	cmd := exec.Command(engine[0], append(engine[1:], "build", "-t", opts.Tag, contextDir)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("image build failed: %v", err)
+	}
+	fmt.Printf("Built image %s\n", opts.Tag)
+	return nil
+}
+
+// dockerfileFor renders the Dockerfile wrapping a single executable
+func dockerfileFor(name, base string, setup []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", base)
+	for _, line := range setup {
+		fmt.Fprintln(&b, line)
+	}
+	fmt.Fprintf(&b, "COPY --chmod=0755 %s /usr/local/bin/%s\n", name, name)
+	fmt.Fprintf(&b, "ENTRYPOINT [\"/usr/local/bin/%s\"]\n", name)
+	return b.String()
+}
+
+// scriptInterpreter returns the interpreter named by a script's shebang,
+// e.g. "bash" for "#!/usr/bin/env bash"
+func scriptInterpreter(path string) string {
+	fields := shebangCommand(path)
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = filepath.Base(fields[1])
+	}
+	return interpreter
+}
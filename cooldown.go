@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// scriptCooldown returns how long a script declares must pass between its
+// runs with "# scripts:cooldown 5m", or 0 when it declares none
+func scriptCooldown(path string) (time.Duration, error) {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return 0, err
+	}
+	value := meta.value("cooldown")
+	if value == "" {
+		return 0, nil
+	}
+	cooldown, err := time.ParseDuration(value)
+	if err != nil || cooldown < 0 {
+		return 0, fmt.Errorf("%s: invalid cooldown %q (use e.g. 30s, 5m or 1h)", path, value)
+	}
+	return cooldown, nil
+}
+
+// lastRun returns the most recent run of a script that executed, or nil
+func lastRun(name string, config *Config) (*RunRecord, error) {
+	records, err := loadHistory(config)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Script == name && !records[i].Skipped {
+			return records[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// checkCooldown refuses a run within the script's cooldown of its last
+// run, or with --wait sleeps until the cooldown is over. --force skips the
+// check.
+func checkCooldown(name, path string, opts *RunOptions, config *Config) error {
+	if opts.Force {
+		return nil
+	}
+	cooldown, err := scriptCooldown(path)
+	if err != nil || cooldown == 0 {
+		return err
+	}
+	last, err := lastRun(name, config)
+	if err != nil || last == nil {
+		return err
+	}
+	since := time.Since(last.Start)
+	if since >= cooldown {
+		return nil
+	}
+	remaining := cooldown - since
+	if !opts.Wait {
+		return fmt.Errorf("%s ran %s ago and has a cooldown of %s; try again in %s (or use --wait or --force)",
+			name, formatDuration(since.Round(time.Second)), cooldown, formatDuration(remaining.Round(time.Second)))
+	}
+	fmt.Fprintf(os.Stderr, "Waiting %s for the cooldown of %s\n", formatDuration(remaining.Round(time.Second)), name)
+	time.Sleep(remaining)
+	return nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+)
+
+// setCredential is unsupported; Windows has no setuid
+func setCredential(cmd *exec.Cmd, u *user.User) error {
+	return fmt.Errorf("running as another user isn't supported on Windows")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// deprecatedKey marks a script deprecated, with an optional hint:
+// "# scripts:deprecated use deploy-v2 instead"
+const deprecatedKey = "deprecated"
+
+// deprecation reports whether a script is deprecated, and its hint
+func deprecation(path string) (bool, string) {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return false, ""
+	}
+	if _, ok := meta.Values[deprecatedKey]; !ok {
+		return false, ""
+	}
+	return true, meta.value(deprecatedKey)
+}
+
+// warnDeprecated prints a warning when a script about to run is
+// deprecated; with strict set it refuses to run it instead
+func warnDeprecated(name, path string, strict bool) error {
+	deprecated, hint := deprecation(path)
+	if !deprecated {
+		return nil
+	}
+	message := name + " is deprecated"
+	if hint != "" {
+		message += ": " + hint
+	}
+	if strict {
+		return fmt.Errorf("%s (not running it with --strict)", message)
+	}
+	fmt.Fprintln(os.Stderr, colorize(colorYellow, "Warning: ")+message)
+	return nil
+}
+
+// deprecateScript marks a script deprecated by adding the metadata line
+// after its shebang, replacing any earlier one; undo removes it
+func deprecateScript(name, use, message string, undo bool, config *Config) error {
+	path := scriptInDir(config.ScriptDir, name)
+	if path == "" {
+		return fmt.Errorf("script %s not found in %s", name, config.ScriptDir)
+	}
+	if use != "" {
+		if _, err := findScript(use, config); err != nil {
+			return fmt.Errorf("replacement %s not found", use)
+		}
+		if message == "" {
+			message = fmt.Sprintf("use %s instead", use)
+		}
+	}
+	warnOwner(name, path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	marker := "# " + metadataPrefix + deprecatedKey
+	var lines []string
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed == marker || strings.HasPrefix(trimmed, marker+" ") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !undo {
+		line := strings.TrimSpace(marker+" "+message) + "\n"
+		at := 0
+		if len(lines) > 0 && strings.HasPrefix(lines[0], "#!") {
+			at = 1
+			if !strings.HasSuffix(lines[0], "\n") {
+				lines[0] += "\n"
+			}
+		}
+		lines = append(lines[:at], append([]string{line}, lines[at:]...)...)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	switch {
+	case undo:
+		fmt.Printf("%s is no longer deprecated\n", name)
+	case message != "":
+		fmt.Printf("Marked %s deprecated (%s)\n", name, message)
+	default:
+		fmt.Printf("Marked %s deprecated\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// packageManagers are tried in order to find how to install a missing
+// compiler on this machine
+var packageManagers = []string{"brew", "apt-get", "dnf", "pacman"}
+
+// toolPackages names the package providing each tool, per package manager.
+// "pip" packages are installed with the Python that runs them.
+var toolPackages = map[string]map[string]string{
+	"go":       {"brew": "go", "apt-get": "golang-go", "dnf": "golang", "pacman": "go"},
+	"python":   {"pip": "pyinstaller"},
+	"nuitka":   {"pip": "nuitka"},
+	"shiv":     {"pip": "shiv"},
+	"v":        {"brew": "vlang"},
+	"rust":     {"brew": "rust", "apt-get": "rustc", "dnf": "rust", "pacman": "rust"},
+	"cargo":    {"brew": "rust", "apt-get": "cargo", "dnf": "cargo", "pacman": "rust"},
+	"c":        {"brew": "gcc", "apt-get": "gcc", "dnf": "gcc", "pacman": "gcc"},
+	"cpp":      {"brew": "gcc", "apt-get": "g++", "dnf": "gcc-c++", "pacman": "gcc"},
+	"csharp":   {"brew": "dotnet", "apt-get": "dotnet-sdk-8.0", "dnf": "dotnet-sdk-8.0", "pacman": "dotnet-sdk"},
+	"cmake":    {"brew": "cmake", "apt-get": "cmake", "dnf": "cmake", "pacman": "cmake"},
+	"make":     {"brew": "make", "apt-get": "make", "dnf": "make", "pacman": "make"},
+	"meson":    {"brew": "meson", "apt-get": "meson", "dnf": "meson", "pacman": "meson"},
+	"ninja":    {"brew": "ninja", "apt-get": "ninja-build", "dnf": "ninja-build", "pacman": "ninja"},
+	"wasm-c":   {"brew": "llvm", "apt-get": "clang", "dnf": "clang", "pacman": "clang"},
+	"wasm-cpp": {"brew": "llvm", "apt-get": "clang", "dnf": "clang", "pacman": "clang"},
+	"upx":      {"brew": "upx", "apt-get": "upx-ucl", "dnf": "upx", "pacman": "upx"},
+	"strip":    {"brew": "binutils", "apt-get": "binutils", "dnf": "binutils", "pacman": "binutils"},
+}
+
+// toolManualInstall points at install instructions for tools no package
+// manager ships
+var toolManualInstall = map[string]string{
+	"v":     "https://github.com/vlang/v#installing-v-from-source",
+	"rust":  "https://rustup.rs",
+	"cargo": "https://rustup.rs",
+}
+
+// requiredTools lists the tools a build with tool runs, beyond tool itself
+var requiredTools = map[string][]string{
+	"meson": {"ninja"},
+}
+
+// toolInstalled reports whether the command resolved for tool can run,
+// including the module for "python3 -m <module>" commands
+func toolInstalled(tool string, config *Config) bool {
+	command := resolveCompiler(tool, config)
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return false
+	}
+	if len(command) >= 3 && command[1] == "-m" {
+		return exec.Command(command[0], "-c", "import "+command[2]).Run() == nil
+	}
+	return true
+}
+
+// detectPackageManager returns the first supported package manager found
+func detectPackageManager() string {
+	for _, manager := range packageManagers {
+		if manager == "brew" && runtime.GOOS != "darwin" {
+			// Linuxbrew exists, but the system packages are the safer default
+			continue
+		}
+		if _, err := exec.LookPath(manager); err == nil {
+			return manager
+		}
+	}
+	return ""
+}
+
+// installCommand returns the command that installs tool on this machine, or
+// nil when no known package provides it
+func installCommand(tool string) []string {
+	packages := toolPackages[tool]
+	if pkg, ok := packages["pip"]; ok {
+		return []string{"python3", "-m", "pip", "install", "--user", pkg}
+	}
+	manager := detectPackageManager()
+	pkg, ok := packages[manager]
+	if !ok {
+		return nil
+	}
+	var command []string
+	switch manager {
+	case "brew":
+		command = []string{"brew", "install", pkg}
+	case "apt-get":
+		command = []string{"apt-get", "install", "-y", pkg}
+	case "dnf":
+		command = []string{"dnf", "install", "-y", pkg}
+	case "pacman":
+		command = []string{"pacman", "-S", "--noconfirm", pkg}
+	}
+	if manager != "brew" && os.Geteuid() != 0 {
+		command = append([]string{"sudo"}, command...)
+	}
+	return command
+}
+
+// ensureTools checks that the tools a build needs are installed. Missing
+// ones are installed with --install-deps; otherwise the error says how to
+// install them.
+func ensureTools(tool string, opts *CompileOptions, config *Config) error {
+	for _, needed := range append([]string{tool}, requiredTools[tool]...) {
+		if toolInstalled(needed, config) {
+			continue
+		}
+		name := strings.Join(resolveCompiler(needed, config), " ")
+		install := installCommand(needed)
+		if install == nil {
+			if url, ok := toolManualInstall[needed]; ok {
+				return fmt.Errorf("%s not found - install it from %s", name, url)
+			}
+			return fmt.Errorf("%s not found - install it, or set compilers.%s in the config to the command to use", name, needed)
+		}
+		if !opts.InstallDeps {
+			return fmt.Errorf("%s not found - install it with '%s' (or rerun with --install-deps)", name, strings.Join(install, " "))
+		}
+
+		fmt.Fprintf(opts.out(), "%s not found, installing: %s\n", name, strings.Join(install, " "))
+		cmd := exec.Command(install[0], install[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = opts.out()
+		cmd.Stderr = opts.out()
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to install %s: %v", name, err)
+		}
+		if !toolInstalled(needed, config) {
+			return fmt.Errorf("%s is still not available after installing it (check your PATH)", name)
+		}
+	}
+	return nil
+}
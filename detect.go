@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourceExt is the extension each language's compiler expects on a source
+// file, used when building a source detected by its contents
+var sourceExt = map[string]string{
+	"go":     ".go",
+	"python": ".py",
+	"v":      ".v",
+	"rust":   ".rs",
+	"c":      ".c",
+	"cpp":    ".cpp",
+	"csharp": ".cs",
+}
+
+// detectLines is how far into a file content detection looks
+const detectLines = 40
+
+// languageForContent detects the language of a source file without a known
+// extension from its shebang or its first few lines
+func languageForContent(sourcePath string) (string, error) {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 0; n < detectLines && scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 0 && strings.HasPrefix(line, "#!") {
+			if lang := languageForShebang(line); lang != "" {
+				return lang, nil
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "//go:build"), strings.HasPrefix(line, "package main"):
+			return "go", nil
+		case strings.HasPrefix(line, "#include <iostream>"), strings.HasPrefix(line, "#include <string>"),
+			strings.HasPrefix(line, "using namespace std"):
+			return "cpp", nil
+		case strings.HasPrefix(line, "#include"):
+			return "c", nil
+		case strings.HasPrefix(line, "fn main()"), strings.HasPrefix(line, "use std::"):
+			return "rust", nil
+		case strings.HasPrefix(line, "using System"), strings.HasPrefix(line, "Console.Write"):
+			return "csharp", nil
+		case strings.HasPrefix(line, "if __name__ =="):
+			return "python", nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// languageForShebang maps an interpreter line such as "#!/usr/bin/env
+// python3" to a language
+func languageForShebang(line string) string {
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+	// Skip /usr/bin/env and its options to get to the interpreter
+	interpreter, args := filepath.Base(fields[0]), fields[1:]
+	if interpreter == "env" {
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return ""
+		}
+		interpreter, args = filepath.Base(args[0]), args[1:]
+	}
+
+	switch {
+	case strings.HasPrefix(interpreter, "python"):
+		return "python"
+	case interpreter == "gorun", interpreter == "go" && len(args) > 0 && args[0] == "run":
+		return "go"
+	case interpreter == "v":
+		return "v"
+	case interpreter == "rust-script", interpreter == "cargo" && len(args) > 0 && args[0] == "script":
+		return "rust"
+	case interpreter == "dotnet-script":
+		return "csharp"
+	}
+	return ""
+}
+
+// stageDetectedSource copies a source whose language was detected from its
+// contents to a temp file with the extension its compiler expects. The
+// caller removes the returned directory.
+func stageDetectedSource(sourcePath, name, lang string) (string, string, error) {
+	stageDir, err := os.MkdirTemp("", "scripts-source-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	staged := filepath.Join(stageDir, name+sourceExt[lang])
+	if err := copyFile(sourcePath, staged); err != nil {
+		os.RemoveAll(stageDir)
+		return "", "", err
+	}
+	return stageDir, staged, nil
+}
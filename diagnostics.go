@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDiagnostics is how many errors a failed build summary shows
+const maxDiagnostics = 10
+
+// Diagnostic is a compiler error pointing at a place in the source
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+var (
+	// gcc, clang and V: main.c:4:5: error: message
+	gccDiagnostic = regexp.MustCompile(`^(.+?):(\d+):(\d+): (?:fatal )?error: (.+)$`)
+	// go: ./main.go:4:5: message
+	goDiagnostic = regexp.MustCompile(`^(\S+\.go):(\d+):(\d+): (.+)$`)
+	// rustc and cargo: "error[E0425]: message" followed by " --> src/main.rs:4:5"
+	rustError    = regexp.MustCompile(`^error(?:\[E\d+\])?: (.+)$`)
+	rustLocation = regexp.MustCompile(`^\s*--> (.+):(\d+):(\d+)$`)
+	// dotnet: Program.cs(4,5): error CS1002: message [project]
+	csharpDiagnostic = regexp.MustCompile(`^(.+?)\((\d+),(\d+)\): error (\w+: .+?)(?: \[.+\])?$`)
+)
+
+// String formats the diagnostic the way compilers print it
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// parseDiagnostics extracts the errors from compiler output, in order and
+// without duplicates
+func parseDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := map[Diagnostic]bool{}
+	add := func(file, line, column, message string) {
+		d := Diagnostic{File: file, Message: strings.TrimSpace(message)}
+		d.Line, _ = strconv.Atoi(line)
+		d.Column, _ = strconv.Atoi(column)
+		if !seen[d] {
+			seen[d] = true
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if m := gccDiagnostic.FindStringSubmatch(line); m != nil {
+			add(m[1], m[2], m[3], m[4])
+		} else if m := csharpDiagnostic.FindStringSubmatch(line); m != nil {
+			add(m[1], m[2], m[3], m[4])
+		} else if m := goDiagnostic.FindStringSubmatch(line); m != nil {
+			add(m[1], m[2], m[3], m[4])
+		} else if m := rustError.FindStringSubmatch(line); m != nil {
+			// Summary lines such as "could not compile" have no location
+			for j := i + 1; j < len(lines) && j <= i+3; j++ {
+				if loc := rustLocation.FindStringSubmatch(lines[j]); loc != nil {
+					add(loc[1], loc[2], loc[3], m[1])
+					break
+				}
+			}
+		}
+	}
+	return diagnostics
+}
+
+// printDiagnostics summarises a failed build's errors, each followed by the
+// offending source line with the error position marked
+func printDiagnostics(w io.Writer, diagnostics []Diagnostic, dirs []string) {
+	noun := "errors"
+	if len(diagnostics) == 1 {
+		noun = "error"
+	}
+	fmt.Fprintf(w, "%s\n", colorize(colorBold, fmt.Sprintf("Build failed with %d %s:", len(diagnostics), noun)))
+	for i, d := range diagnostics {
+		if i == maxDiagnostics {
+			fmt.Fprintf(w, "  ... and %d more\n", len(diagnostics)-maxDiagnostics)
+			break
+		}
+		fmt.Fprintf(w, "  %s:%d:%d: %s\n", d.File, d.Line, d.Column, colorize(colorRed, d.Message))
+		source, ok := sourceLine(d.File, d.Line, dirs)
+		if !ok {
+			continue
+		}
+		gutter := fmt.Sprintf("%6d | ", d.Line)
+		fmt.Fprintf(w, "%s%s\n", gutter, source)
+		if d.Column > 0 && d.Column <= len(source)+1 {
+			// Keep tabs so the marker lines up with the source
+			indent := strings.Map(func(r rune) rune {
+				if r == '\t' {
+					return r
+				}
+				return ' '
+			}, source[:d.Column-1])
+			fmt.Fprintf(w, "%s%s%s\n", strings.Repeat(" ", len(gutter)-2), "| ", indent+colorize(colorRed, "^"))
+		}
+	}
+}
+
+// sourceLine reads a line of a file named in a diagnostic, which may be
+// relative to any of the directories the compiler ran in
+func sourceLine(file string, line int, dirs []string) (string, bool) {
+	candidates := []string{file}
+	if !filepath.IsAbs(file) {
+		candidates = nil
+		for _, dir := range dirs {
+			candidates = append(candidates, filepath.Join(dir, file))
+		}
+	}
+	for _, path := range candidates {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			if n == line {
+				return strings.TrimRight(scanner.Text(), "\r"), true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
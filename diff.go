@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// diffFiles prints a unified diff from file a to file b under the given
+// labels, colored when color is set, and reports whether they differ. It
+// uses diff, or git when diff isn't installed.
+func diffFiles(a, b, labelA, labelB string, color bool) (bool, error) {
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("diff"); err == nil {
+		cmd = exec.Command("diff", "-u", "--label", labelA, "--label", labelB, a, b)
+	} else if _, err := exec.LookPath("git"); err == nil {
+		cmd = exec.Command("git", "diff", "--no-index", "--no-color", "--", a, b)
+	} else {
+		return false, fmt.Errorf("neither diff nor git is installed")
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	// Both exit with status 1 when the files differ
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	} else if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.SplitAfter(out.String(), "\n") {
+		code := ""
+		switch {
+		case !color:
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+			code = colorBold
+		case strings.HasPrefix(line, "+"):
+			code = colorGreen
+		case strings.HasPrefix(line, "-"):
+			code = colorRed
+		case strings.HasPrefix(line, "@@"):
+			code = colorCyan
+		}
+		if code != "" {
+			line = "\033[" + code + "m" + strings.TrimSuffix(line, "\n") + "\033[0m\n"
+		}
+		fmt.Print(line)
+	}
+	return out.Len() > 0, nil
+}
+
+// showDiff prints a unified diff from the file at path to content
+func showDiff(path string, content []byte) error {
+	tmp, err := os.CreateTemp("", "scripts-diff-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+	_, err = diffFiles(path, tmp.Name(), path, path+" (new)", colorEnabled())
+	return err
+}
+
+// diffScript prints how the local file at path differs from a managed
+// script, as the changes "scripts add --force" would make
+func diffScript(name, path string, color bool, config *Config) (bool, error) {
+	script, err := findScript(name, config)
+	if err != nil {
+		return false, err
+	}
+	if info, err := os.Stat(path); err != nil {
+		return false, fmt.Errorf("can't read %s: %v", path, err)
+	} else if info.IsDir() {
+		return false, fmt.Errorf("%s is a directory", path)
+	}
+	return diffFiles(script, path, script, path, color)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// pathHintFile records, in the state directory, that the hint about BinDir
+// missing from PATH was shown
+const pathHintFile = "path-hint-shown"
+
+// pathDir returns the directory that must be on PATH to run the compiled
+// binaries by name: the shims with the language layout and shims, else
+// BinDir
+func pathDir(config *Config) string {
+	if layout, _ := binLayout(config); layout == layoutLanguage && config.BinShims {
+		return filepath.Join(expandPath(config.BinDir), shimsDir)
+	}
+	return expandPath(config.BinDir)
+}
+
+// onPath reports whether a directory is on PATH
+func onPath(dir string) bool {
+	want := filepath.Clean(dir)
+	for _, entry := range filepath.SplitList(os.Getenv("PATH")) {
+		entry = filepath.Clean(expandPath(entry))
+		if entry == want || (runtime.GOOS == "windows" && strings.EqualFold(entry, want)) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellProfile returns the startup file of the user's shell and the line
+// that puts dir on PATH in it
+func shellProfile(dir string) (string, string) {
+	switch filepath.Base(os.Getenv("SHELL")) {
+	case "fish":
+		return expandPath("~/.config/fish/config.fish"), fmt.Sprintf("fish_add_path %q", dir)
+	case "zsh":
+		profile := expandPath("~/.zshrc")
+		if zdotdir := os.Getenv("ZDOTDIR"); zdotdir != "" {
+			profile = filepath.Join(zdotdir, ".zshrc")
+		}
+		return profile, fmt.Sprintf("export PATH=\"%s:$PATH\"", dir)
+	case "bash":
+		return expandPath("~/.bashrc"), fmt.Sprintf("export PATH=\"%s:$PATH\"", dir)
+	}
+	return expandPath("~/.profile"), fmt.Sprintf("export PATH=\"%s:$PATH\"", dir)
+}
+
+// pathHint prints, once, how to put BinDir on PATH when it isn't. It is
+// meant for people at a terminal, so runs from cron or CI stay quiet, as
+// do later calls; 'scripts doctor' still reports it.
+func pathHint(config *Config) {
+	dir := pathDir(config)
+	if runtime.GOOS == "windows" || !isTerminal(os.Stderr) || onPath(dir) {
+		return
+	}
+	marker := filepath.Join(stateDir(config), pathHintFile)
+	if _, err := os.Stat(marker); err == nil {
+		return
+	}
+	if err := os.MkdirAll(stateDir(config), 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return
+	}
+	_, line := shellProfile(dir)
+	fmt.Fprintf(os.Stderr, "Hint: %s isn't on your PATH, so compiled binaries can't be run by name. Add it with:\n", dir)
+	fmt.Fprintf(os.Stderr, "  %s\n", line)
+	fmt.Fprintln(os.Stderr, "or run 'scripts doctor --fix-path' (this hint is only shown once)")
+}
+
+// fixPath adds the line putting BinDir on PATH to the user's shell
+// profile, unless it is there already
+func fixPath(config *Config) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("add %s to your PATH in the environment variable settings", pathDir(config))
+	}
+	dir := pathDir(config)
+	profile, line := shellProfile(dir)
+	content, err := os.ReadFile(profile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", profile, err)
+	}
+	if strings.Contains(string(content), line) {
+		fmt.Printf("%s already adds %s to PATH; open a new shell to pick it up\n", profile, dir)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(profile), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(profile), err)
+	}
+	f, err := os.OpenFile(profile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", profile, err)
+	}
+	defer f.Close()
+	prefix := ""
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		prefix = "\n"
+	}
+	if _, err := fmt.Fprintf(f, "%s\n# Added by 'scripts doctor --fix-path'\n%s\n", prefix, line); err != nil {
+		return fmt.Errorf("failed to write %s: %v", profile, err)
+	}
+	fmt.Printf("Added %s to PATH in %s; open a new shell (or source it) to pick it up\n", dir, profile)
+	return nil
+}
+
+// runDoctor checks the installation, returning an error when something
+// needs fixing
+func runDoctor(config *Config) error {
+	problems := 0
+	check := func(ok bool, good, bad string) {
+		if ok {
+			fmt.Println(statusMark(true) + good)
+			return
+		}
+		problems++
+		fmt.Println(statusMark(false) + bad)
+	}
+
+	info, err := os.Stat(expandPath(config.ScriptDir))
+	check(err == nil && info.IsDir(),
+		fmt.Sprintf("Scripts directory %s exists", config.ScriptDir),
+		fmt.Sprintf("Scripts directory %s doesn't exist (set scriptDir in the config)", config.ScriptDir))
+	dir := pathDir(config)
+	check(onPath(dir),
+		fmt.Sprintf("%s is on PATH", dir),
+		fmt.Sprintf("%s isn't on PATH, so compiled binaries can't be run by name (fix it with 'scripts doctor --fix-path')", dir))
+
+	switch {
+	case problems == 1:
+		return fmt.Errorf("1 problem found")
+	case problems > 1:
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// encryptedSuffix is added to the name of a file's encrypted copy, the one
+// committed and pushed in its place
+const encryptedSuffix = ".age"
+
+// defaultAgeIdentity is where the age identity (private key) that
+// sync.encrypt uses is kept when sync.identity isn't set
+const defaultAgeIdentity = "~/.config/scripts/age.key"
+
+// ageIdentity returns the path of the age identity file
+func ageIdentity(config *Config) string {
+	if config.Sync != nil && config.Sync.Identity != "" {
+		return expandPath(config.Sync.Identity)
+	}
+	return expandPath(defaultAgeIdentity)
+}
+
+// encrypting reports whether sync.encrypt names any files
+func encrypting(config *Config) bool {
+	return config.Sync != nil && len(config.Sync.Encrypt) > 0
+}
+
+// checkAge makes sure age is installed and the identity file exists
+func checkAge(config *Config) error {
+	if _, err := exec.LookPath("age"); err != nil {
+		return fmt.Errorf("sync.encrypt needs age (https://age-encryption.org), which isn't installed")
+	}
+	identity := ageIdentity(config)
+	if _, err := os.Stat(identity); err != nil {
+		return fmt.Errorf("no age identity at %s (create one with 'age-keygen -o %s' and copy it to your other machines)", identity, identity)
+	}
+	return nil
+}
+
+// ageRecipient returns the public key of the identity, which files are
+// encrypted to
+func ageRecipient(config *Config) (string, error) {
+	output, err := exec.Command("age-keygen", "-y", ageIdentity(config)).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the public key of %s: %v", ageIdentity(config), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ageRun runs age with data on stdin, returning its output
+func ageRun(data []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("age", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("age failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// decryptFile returns the plaintext of an encrypted file
+func decryptFile(path string, config *Config) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ageRun(data, "-d", "-i", ageIdentity(config))
+}
+
+// encryptedFiles returns the files of the scripts directory that
+// sync.encrypt names, by path relative to it
+func encryptedFiles(config *Config) ([]string, error) {
+	patterns := &ScriptsIgnore{dir: config.ScriptDir}
+	for _, pattern := range config.Sync.Encrypt {
+		if rule := parseIgnoreRule(pattern); rule != nil {
+			patterns.rules = append(patterns.rules, rule)
+		}
+	}
+	var files []string
+	err := filepath.WalkDir(config.ScriptDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, encryptedSuffix) && patterns.ignored(path, false) {
+			rel, _ := filepath.Rel(config.ScriptDir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// sealFiles encrypts the files sync.encrypt names next to themselves, as
+// <file>.age, before they are committed; keepLocal keeps the plaintext out
+// of git. A file whose encrypted copy already decrypts to it is left alone,
+// since age never encrypts the same way twice.
+func sealFiles(config *Config) error {
+	if !encrypting(config) {
+		return nil
+	}
+	if err := checkAge(config); err != nil {
+		return err
+	}
+	files, err := encryptedFiles(config)
+	if err != nil {
+		return err
+	}
+	recipient, err := ageRecipient(config)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := filepath.Join(config.ScriptDir, filepath.FromSlash(file))
+		plaintext, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if current, err := decryptFile(path+encryptedSuffix, config); err == nil && bytes.Equal(current, plaintext) {
+			continue
+		}
+		sealed, err := ageRun(plaintext, "-e", "-a", "-r", recipient)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", file, err)
+		}
+		if err := os.WriteFile(path+encryptedSuffix, sealed, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path+encryptedSuffix, err)
+		}
+	}
+	return nil
+}
+
+// openFiles decrypts the encrypted files in the repository after a merge
+// or pull, writing the plaintext files that differ. Machines that don't
+// set sync.encrypt and have no identity leave them encrypted.
+func openFiles(config *Config) error {
+	output, err := syncGit(config.ScriptDir, "ls-files", "--", "*"+encryptedSuffix)
+	if err != nil || output == "" {
+		return err
+	}
+	if err := checkAge(config); err != nil {
+		if !encrypting(config) {
+			return nil
+		}
+		return err
+	}
+	for _, sealed := range strings.Split(output, "\n") {
+		file := strings.TrimSuffix(sealed, encryptedSuffix)
+		path := filepath.Join(config.ScriptDir, filepath.FromSlash(file))
+		plaintext, err := decryptFile(path+encryptedSuffix, config)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %v", sealed, err)
+		}
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, plaintext) {
+			continue
+		}
+		if err := os.WriteFile(path, plaintext, 0700); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+	}
+	return keepLocal(config)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// secretMarkers are parts of variable names whose values "scripts env"
+// masks
+var secretMarkers = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "PASS", "KEY", "CREDENTIAL", "AUTH", "PRIVATE", "SESSION", "COOKIE"}
+
+// runEnv adds what scripts gives every script to its environment: the
+// download cache and, inside a project, its root
+func runEnv(env []string, config *Config) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(env, cacheEnv+"="+cacheDir(config))
+	if cwd, err := os.Getwd(); err == nil {
+		if root := projectRoot(cwd, config); root != "" {
+			env = append(env, projectRootEnv+"="+root)
+		}
+	}
+	return env
+}
+
+// isSecret reports whether a variable's name suggests its value is secret
+func isSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// printScriptEnv prints the environment a script would get when run with
+// opts, built the way a run builds it, with secret-looking values masked
+// and the variables scripts sets marked
+func printScriptEnv(name string, opts *RunOptions, config *Config) error {
+	scriptPath, err := findScript(name, config)
+	if err != nil {
+		return err
+	}
+	cmd, err := scriptCommand(scriptPath, nil, config)
+	if err != nil {
+		return err
+	}
+	if opts.User != "" {
+		cmd, err = asUser(cmd, opts.User, config)
+	} else if opts.Sudo {
+		cmd, err = withSudo(cmd, config)
+	}
+	if err != nil {
+		return err
+	}
+	env := runEnv(cmd.Env, config)
+
+	// sudo resets the environment but for the variables it preserves
+	throughSudo := filepath.Base(cmd.Path) == "sudo"
+	kept := preservedEnv(config)
+
+	inherited := map[string]string{}
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		inherited[key] = value
+	}
+	values := map[string]string{}
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if throughSudo && !slices.Contains(kept, key) {
+			continue
+		}
+		// Later entries win, as they do for exec
+		values[key] = value
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		if isSecret(key) && value != "" {
+			value = "********"
+		}
+		line := key + "=" + value
+		if original, ok := inherited[key]; !ok || original != values[key] {
+			line += "    # set by scripts"
+		}
+		fmt.Println(line)
+	}
+	if throughSudo {
+		fmt.Println("# Through sudo only the variables in sudoEnv and those set by scripts are kept; sudo sets HOME, PATH, USER and others")
+		fmt.Println("# according to sudoers (see 'sudo -V')")
+	}
+	return nil
+}
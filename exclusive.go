@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// locksDir holds the lock files of exclusive scripts, inside the state
+// directory
+const locksDir = "locks"
+
+// errLocked is returned by lockFile when another process holds the lock
+var errLocked = errors.New("locked")
+
+// isExclusive reports whether a script declares "# scripts:exclusive",
+// allowing only one run of it at a time
+func isExclusive(path string) (bool, error) {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return false, err
+	}
+	_, ok := meta.Values["exclusive"]
+	return ok, nil
+}
+
+// lockRun takes the run lock of an exclusive script, returning a function
+// that releases it. When the script is already running it fails, or with
+// queue waits for the running one to finish. Scripts that aren't
+// exclusive aren't locked.
+func lockRun(name, path string, queue bool, config *Config) (func(), error) {
+	exclusive, err := isExclusive(path)
+	if err != nil || !exclusive {
+		return func() {}, err
+	}
+	dir := filepath.Join(stateDir(config), locksDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+	lock := filepath.Join(dir, name+".lock")
+
+	unlock, err := lockPath(lock, false)
+	if err == errLocked && queue {
+		fmt.Fprintf(os.Stderr, "Queued %s; it runs when the current run finishes\n", name)
+		unlock, err = lockPath(lock, true)
+	}
+	if err == errLocked {
+		return nil, fmt.Errorf("%s is already running and is exclusive (use --queue to run it after)", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %v", name, err)
+	}
+	return unlock, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// exportShells are the formats of "scripts export-shell"
+var exportShells = []string{"bash", "zsh", "fish"}
+
+// shellFunctionName matches the script names that make portable shell
+// function names
+var shellFunctionName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// exportShell prints a shell function per script that runs it through
+// scripts, so history, hooks and the other run features still apply. The
+// format defaults to the user's shell; prefix is put before every name.
+// Private scripts are left out unless includePrivate is set.
+func exportShell(format, prefix string, includePrivate bool, config *Config) error {
+	if format == "" {
+		format = filepath.Base(os.Getenv("SHELL"))
+		if !slices.Contains(exportShells, format) {
+			format = "bash"
+		}
+	}
+	if !slices.Contains(exportShells, format) {
+		return fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(exportShells, ", "))
+	}
+
+	fmt.Printf("# Functions for the scripts managed by scripts, generated by 'scripts export-shell --format %s'\n", format)
+	if format == "fish" {
+		fmt.Println("# Load them with: scripts export-shell --format fish | source")
+	} else {
+		fmt.Printf("# Load them with: source <(scripts export-shell --format %s)\n", format)
+	}
+	for _, script := range listScripts(config) {
+		name := prefix + script.Name
+		// A function named scripts would call itself
+		if !shellFunctionName.MatchString(name) || name == "scripts" {
+			fmt.Printf("# skipped %s: not a valid function name\n", script.Name)
+			continue
+		}
+		if !includePrivate && isPrivate(script.Path) {
+			continue
+		}
+		description := ""
+		if meta, err := readMetadata(script.Path); err == nil {
+			description = meta.value("description")
+		}
+		if format == "fish" {
+			if description == "" {
+				description = "scripts " + script.Name
+			}
+			fmt.Printf("function %s --description %s\n    command scripts %s $argv\nend\n", name, fishQuote(description), script.Name)
+			continue
+		}
+		if description != "" {
+			fmt.Printf("# %s\n", description)
+		}
+		fmt.Printf("%s() { command scripts %s \"$@\"; }\n", name, script.Name)
+	}
+	return nil
+}
+
+// fishQuote single-quotes a string for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
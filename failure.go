@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// failureTailLines is how many lines of stderr a failure summary shows
+const failureTailLines = 15
+
+// stderrTail keeps the last lines a script writes to stderr, for the
+// failure summary
+type stderrTail struct {
+	mu     sync.Mutex
+	max    int
+	lines  []string
+	writer *lineWriter
+}
+
+func newStderrTail(max int) *stderrTail {
+	tail := &stderrTail{max: max}
+	tail.writer = &lineWriter{emit: tail.add}
+	return tail
+}
+
+func (t *stderrTail) add(line string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return nil
+}
+
+// printFailure summarizes a run that exited non-zero: its exit code and
+// duration, the end of its stderr and where the session was recorded
+func printFailure(record *RunRecord, tail *stderrTail, config *Config) {
+	tail.writer.flush()
+	fmt.Printf("%s %s failed with exit code %d after %s\n", colorize(colorRed, "Error:"), record.Script,
+		record.ExitCode, formatDuration(record.Duration()))
+	if len(tail.lines) > 0 {
+		fmt.Printf("Last %d lines of stderr:\n", len(tail.lines))
+		for _, line := range tail.lines {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if record.Recorded {
+		fmt.Printf("Recording: %s\n", recordingPath(record.ID, config))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// OutputFilter post-processes the stdout of a run: "jq" runs it through a
+// jq program once the script is done, "grep" keeps the lines matching a
+// regular expression as they are written
+type OutputFilter struct {
+	Kind    string
+	Expr    string
+	pattern *regexp.Regexp
+}
+
+// parseFilter parses a --filter value, "<kind>:<expression>"
+func parseFilter(spec string) (*OutputFilter, error) {
+	kind, expr, ok := strings.Cut(spec, ":")
+	if !ok || expr == "" {
+		return nil, fmt.Errorf("invalid --filter %q (expected jq:<program> or grep:<pattern>)", spec)
+	}
+	filter := &OutputFilter{Kind: kind, Expr: expr}
+	switch kind {
+	case "jq":
+		if _, err := exec.LookPath("jq"); err != nil {
+			return nil, fmt.Errorf("--filter jq: needs jq installed (https://jqlang.github.io/jq/)")
+		}
+	case "grep":
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grep pattern %q: %v", expr, err)
+		}
+		filter.pattern = pattern
+	default:
+		return nil, fmt.Errorf("unknown filter %q (expected jq or grep)", kind)
+	}
+	return filter, nil
+}
+
+// wrap returns the writer feeding the filter, which writes its result to
+// out, and a function finishing it once the script is done
+func (f *OutputFilter) wrap(out io.Writer) (io.Writer, func() error) {
+	if f.Kind == "grep" {
+		lines := &lineWriter{emit: func(line string) error {
+			if !f.pattern.MatchString(line) {
+				return nil
+			}
+			_, err := fmt.Fprintln(out, line)
+			return err
+		}}
+		return lines, func() error {
+			lines.flush()
+			return nil
+		}
+	}
+
+	// jq needs the whole document, so the output is collected first
+	var collected bytes.Buffer
+	return &collected, func() error {
+		cmd := exec.Command("jq", f.Expr)
+		cmd.Stdin = &collected
+		cmd.Stdout = out
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("jq filter %s failed: %v", f.Expr, err)
+		}
+		return nil
+	}
+}
+
+// filterOutput chains filters in the order given, the last writing to out.
+// It returns the writer for the script's stdout and a function finishing
+// every filter, in order, once the script is done.
+func filterOutput(filters []*OutputFilter, out io.Writer) (io.Writer, func() error) {
+	var finishes []func() error
+	for i := len(filters) - 1; i >= 0; i-- {
+		var finish func() error
+		out, finish = filters[i].wrap(out)
+		finishes = append([]func() error{finish}, finishes...)
+	}
+	return out, func() error {
+		for _, finish := range finishes {
+			if err := finish(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
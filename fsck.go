@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// FsckProblem is something in ScriptDir or BinDir that will fail at run
+// time
+type FsckProblem struct {
+	Name   string
+	Path   string
+	Detail string
+}
+
+// brokenLinks finds the symlinks of a directory whose target is gone
+func brokenLinks(dir string) []*FsckProblem {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var problems []*FsckProblem
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		target, _ := os.Readlink(path)
+		problems = append(problems, &FsckProblem{
+			Name:   entry.Name(),
+			Path:   path,
+			Detail: fmt.Sprintf("broken symlink to %s", target),
+		})
+	}
+	return problems
+}
+
+// missingInterpreter returns the interpreter a script's shebang names when
+// it isn't installed, or ""
+func missingInterpreter(path string) string {
+	shebang := shebangCommand(path)
+	if len(shebang) == 0 {
+		return ""
+	}
+	if _, err := os.Stat(shebang[0]); err != nil {
+		return shebang[0]
+	}
+	if filepath.Base(shebang[0]) != "env" {
+		return ""
+	}
+	// The program env runs is its first argument that isn't an option or
+	// a variable assignment
+	for _, arg := range shebang[1:] {
+		if strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+			continue
+		}
+		if _, err := exec.LookPath(arg); err != nil {
+			return arg
+		}
+		return ""
+	}
+	return ""
+}
+
+// missingLibraries lists the shared libraries a binary links against that
+// can't be found, using ldd on Linux and otool on macOS. Files that aren't
+// dynamically linked, and systems without either tool, have none.
+func missingLibraries(path string) []string {
+	var missing []string
+	switch runtime.GOOS {
+	case "linux":
+		output, err := exec.Command("ldd", path).Output()
+		if err != nil {
+			return nil
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if name, _, ok := strings.Cut(strings.TrimSpace(line), " => not found"); ok {
+				missing = append(missing, name)
+			}
+		}
+	case "darwin":
+		output, err := exec.Command("otool", "-L", path).Output()
+		if err != nil {
+			return nil
+		}
+		// The first line names the binary; the others are its libraries
+		lines := strings.Split(string(output), "\n")
+		for _, line := range lines[1:] {
+			lib, _, _ := strings.Cut(strings.TrimSpace(line), " (")
+			// System libraries live in the dyld cache, not on disk, and
+			// @rpath and friends are resolved by the loader
+			if lib == "" || strings.HasPrefix(lib, "@") || strings.HasPrefix(lib, "/usr/lib/") || strings.HasPrefix(lib, "/System/") {
+				continue
+			}
+			if _, err := os.Stat(lib); err != nil {
+				missing = append(missing, lib)
+			}
+		}
+	}
+	return missing
+}
+
+// fsckProblems finds what will fail confusingly at run time: broken
+// symlinks in ScriptDir and BinDir, scripts whose interpreter isn't
+// installed and binaries missing shared libraries
+func fsckProblems(config *Config) []*FsckProblem {
+	var problems []*FsckProblem
+	dirs := scriptDirs(config)
+	dirs = append(dirs, binaryDirs(config)...)
+	if shims := filepath.Join(config.BinDir, shimsDir); !slices.Contains(dirs, shims) {
+		dirs = append(dirs, shims)
+	}
+	for _, dir := range dirs {
+		problems = append(problems, brokenLinks(dir)...)
+	}
+
+	for _, script := range listScripts(config) {
+		if _, err := os.Stat(script.Path); err != nil {
+			continue
+		}
+		if configured, _ := configuredInterp(script.Path, config); configured != nil {
+			continue
+		}
+		if interpreter := missingInterpreter(script.Path); interpreter != "" {
+			problems = append(problems, &FsckProblem{
+				Name:   script.Name,
+				Path:   script.Path,
+				Detail: fmt.Sprintf("interpreter %s isn't installed", interpreter),
+			})
+		}
+	}
+
+	binaries, _ := binaryNames(config)
+	for _, name := range binaries {
+		path, err := binaryPath(name, config)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || classifyFile(path, info) != kindBinary {
+			continue
+		}
+		if missing := missingLibraries(path); len(missing) > 0 {
+			problems = append(problems, &FsckProblem{
+				Name:   name,
+				Path:   path,
+				Detail: "missing shared libraries " + strings.Join(missing, ", "),
+			})
+		}
+	}
+	return problems
+}
+
+// printFsckProblems prints problems indented under a heading, for "scripts
+// list"
+func printFsckProblems(problems []*FsckProblem) {
+	fmt.Println(colorize(colorRed, "Broken (see 'scripts fsck'):"))
+	for _, problem := range problems {
+		fmt.Printf("  %s (%s)\n", problem.Name, problem.Detail)
+	}
+}
+
+// runFsck reports every problem fsckProblems finds, failing when there are
+// any
+func runFsck(config *Config) error {
+	problems := fsckProblems(config)
+	if len(problems) == 0 {
+		fmt.Println(statusMark(true) + "No broken symlinks, missing interpreters or missing libraries")
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s%s: %s\n", statusMark(false), problem.Path, problem.Detail)
+	}
+	if len(problems) == 1 {
+		return fmt.Errorf("1 problem found")
+	}
+	return fmt.Errorf("%d problems found", len(problems))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// parseCompileGitArgs parses the arguments following "compile-git". The
+// repository URL takes the place of the source; --ref and --path are
+// specific to git builds and everything else is shared with compile.
+func parseCompileGitArgs(args []string) (*CompileOptions, *GitOrigin, error) {
+	origin := &GitOrigin{}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--":
+			rest = append(rest, args[i:]...)
+			i = len(args)
+		case "--ref", "--path":
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "--ref" {
+				origin.Ref = args[i]
+			} else {
+				origin.Path = args[i]
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	opts, err := parseCompileArgs(rest)
+	if err != nil {
+		if err.Error() == "no source given" {
+			return nil, nil, fmt.Errorf("no repository URL given")
+		}
+		return nil, nil, err
+	}
+	origin.URL = opts.SourcePath
+	return opts, origin, nil
+}
+
+// repoName derives a directory name from a repository URL
+func repoName(url string) string {
+	name := strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return "repo"
+	}
+	return name
+}
+
+// compileFromGit shallow-clones a repository at the requested ref, builds it
+// with the detected build system and records where the binary came from
+func compileFromGit(opts *CompileOptions, origin *GitOrigin, config *Config) (*CompileResult, error) {
+	if isOffline() {
+		return nil, errOffline("fetching " + origin.URL)
+	}
+	tmpDir, err := os.MkdirTemp("", "scripts_git_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Clone into a directory named after the repository so the default
+	// binary name is sensible
+	repoDir := filepath.Join(tmpDir, repoName(origin.URL))
+	ref := origin.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// init + fetch works for branches, tags and commit hashes alike
+	fmt.Fprintf(opts.out(), "Fetching %s (%s)\n", origin.URL, ref)
+	steps := [][]string{
+		{"init", "--quiet", repoDir},
+		{"-C", repoDir, "remote", "add", "origin", origin.URL},
+		{"-C", repoDir, "fetch", "--quiet", "--depth", "1", "origin", ref},
+		{"-C", repoDir, "checkout", "--quiet", "FETCH_HEAD"},
+	}
+	for _, step := range steps {
+		cmd := exec.Command("git", step...)
+		cmd.Stdout = opts.out()
+		cmd.Stderr = os.Stderr
+		if opts.Output != nil {
+			cmd.Stderr = opts.Output
+		}
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("git %s failed: %v", step[len(step)-2], err)
+		}
+	}
+
+	commit, err := exec.Command("git", "-C", repoDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine checked out commit: %v", err)
+	}
+	origin.Commit = strings.TrimSpace(string(commit))
+
+	opts.SourcePath = repoDir
+	if origin.Path != "" {
+		opts.SourcePath = filepath.Join(repoDir, filepath.FromSlash(origin.Path))
+	}
+	opts.Origin = origin
+	return compileSource(opts, config)
+}
+
+// shortCommit abbreviates a commit hash for display
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"scripts/scriptspb"
+)
+
+// grpcServer serves the daemon over gRPC (scriptspb/scripts.proto), sharing
+// the HTTP server's runs, tokens and metrics
+type grpcServer struct {
+	scriptspb.UnimplementedScriptsServer
+	*Server
+}
+
+// newGRPCServer returns a gRPC server for the daemon
+func newGRPCServer(s *Server) *grpc.Server {
+	server := grpc.NewServer()
+	scriptspb.RegisterScriptsServer(server, &grpcServer{Server: s})
+	return server
+}
+
+// matchToken returns the configured token an Authorization header sends, or
+// nil
+func matchToken(header string, config *Config) *ServeToken {
+	for _, token := range serveTokens(config) {
+		if subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+token.Token)) == 1 {
+			return token
+		}
+	}
+	return nil
+}
+
+// authenticate checks the call's "authorization" metadata as authenticated
+// checks HTTP requests
+func (g *grpcServer) authenticate(ctx context.Context, role string) (*ServeToken, error) {
+	if len(serveTokens(g.config)) == 0 {
+		return nil, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	token := matchToken(header, g.config)
+	if token == nil {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+	if !token.can(role) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may only run scripts", token)
+	}
+	return token, nil
+}
+
+// grpcError turns a runError's HTTP status into the matching gRPC code
+func grpcError(err error) error {
+	var re *runError
+	if !errors.As(err, &re) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.Internal
+	switch re.status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, re.message)
+}
+
+// runMessage converts a run record for gRPC
+func runMessage(record *RunRecord) *scriptspb.Run {
+	return &scriptspb.Run{
+		Id:         record.ID,
+		Script:     record.Script,
+		Start:      timestamppb.New(record.Start),
+		DurationMs: record.DurationMs,
+		ExitCode:   int32(record.ExitCode),
+		Result:     string(record.Result),
+	}
+}
+
+func (g *grpcServer) ListScripts(ctx context.Context, _ *scriptspb.ListScriptsRequest) (*scriptspb.ListScriptsResponse, error) {
+	token, err := g.authenticate(ctx, serveRoleRun)
+	if err != nil {
+		return nil, err
+	}
+	resp := &scriptspb.ListScriptsResponse{}
+	for _, script := range listScripts(g.config) {
+		if token == nil || token.allows(script.Name) {
+			resp.Names = append(resp.Names, script.Name)
+		}
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) RunScript(req *scriptspb.RunScriptRequest, stream scriptspb.Scripts_RunScriptServer) error {
+	token, err := g.authenticate(stream.Context(), serveRoleRun)
+	if err != nil {
+		return err
+	}
+	run, err := g.prepareRun(req.Script, &RunRequest{Args: req.Args, Force: req.Force, Queue: req.Queue, Vars: req.Vars}, token)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer run.close()
+
+	started := &scriptspb.RunStarted{Id: run.id, Script: run.name}
+	if err := stream.Send(&scriptspb.RunEvent{Event: &scriptspb.RunEvent_Started{Started: started}}); err != nil {
+		return err
+	}
+	// A client that goes away doesn't stop the run, which is still recorded
+	// and logged
+	output := func(which scriptspb.Output_Stream) func(p []byte) {
+		return func(p []byte) {
+			chunk := &scriptspb.Output{Stream: which, Data: slices.Clone(p)}
+			stream.Send(&scriptspb.RunEvent{Event: &scriptspb.RunEvent_Output{Output: chunk}})
+		}
+	}
+	record, err := g.execute(run, output(scriptspb.Output_STREAM_STDOUT), output(scriptspb.Output_STREAM_STDERR))
+	if err != nil {
+		return grpcError(err)
+	}
+	return stream.Send(&scriptspb.RunEvent{Event: &scriptspb.RunEvent_Finished{Finished: runMessage(record)}})
+}
+
+func (g *grpcServer) GetRunLog(req *scriptspb.GetRunLogRequest, stream scriptspb.Scripts_GetRunLogServer) error {
+	token, err := g.authenticate(stream.Context(), serveRoleRun)
+	if err != nil {
+		return err
+	}
+	if !validRunID(req.Id) {
+		return status.Errorf(codes.NotFound, "no run %s", req.Id)
+	}
+	if _, err := findRun(req.Id, token, g.config); err != nil {
+		return grpcError(err)
+	}
+	file, err := os.Open(runLogPath(req.Id, g.config))
+	if err != nil {
+		return status.Errorf(codes.NotFound, "no log kept for run %s", req.Id)
+	}
+	defer file.Close()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&scriptspb.Output{Data: slices.Clone(buf[:n])}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read log of run %s: %v", req.Id, err)
+		}
+	}
+}
+
+func (g *grpcServer) ListRuns(ctx context.Context, req *scriptspb.ListRunsRequest) (*scriptspb.ListRunsResponse, error) {
+	token, err := g.authenticate(ctx, serveRoleRun)
+	if err != nil {
+		return nil, err
+	}
+	records, err := loadHistory(g.config)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &scriptspb.ListRunsResponse{}
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if (req.Script != "" && record.Script != req.Script) || (token != nil && !token.allows(record.Script)) {
+			continue
+		}
+		if req.Limit > 0 && len(resp.Runs) == int(req.Limit) {
+			break
+		}
+		resp.Runs = append(resp.Runs, runMessage(record))
+	}
+	return resp, nil
+}
+
+// listenGRPC starts serving gRPC on addr in the background
+func listenGRPC(addr string, server *grpc.Server) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	go server.Serve(listener)
+	return listener.Addr(), nil
+}
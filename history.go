@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyFile is the run log inside the state directory, one JSON record
+// per line so runs can be appended cheaply
+const historyFile = "history.jsonl"
+
+// historyMaxSize is the size at which the history is rotated to
+// history.jsonl.1, replacing the previous one, so reading it on every run
+// stays cheap
+const historyMaxSize = 4 << 20
+
+// RunRecord describes one run of a script
+type RunRecord struct {
+	ID         string    `json:"id,omitempty"`
+	Script     string    `json:"script"`
+	Start      time.Time `json:"start"`
+	DurationMs int64     `json:"durationMs"`
+	ExitCode   int       `json:"exitCode"`
+	Recorded   bool      `json:"recorded,omitempty"`
+	// InputsHash fingerprints the inputs of a --skip-unchanged run
+	InputsHash string `json:"inputsHash,omitempty"`
+	// Skipped marks a --skip-unchanged run that didn't execute
+	Skipped bool `json:"skipped,omitempty"`
+	// Result is the JSON the script wrote to $SCRIPTS_RESULT
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Duration returns how long the run took
+func (r *RunRecord) Duration() time.Duration {
+	return time.Duration(r.DurationMs) * time.Millisecond
+}
+
+// stateDir returns where run history and other state is kept: the stateDir
+// config setting, else $XDG_STATE_HOME/scripts or ~/.local/state/scripts
+func stateDir(config *Config) string {
+	if config.StateDir != "" {
+		return expandPath(config.StateDir)
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "scripts")
+	}
+	return expandPath("~/.local/state/scripts")
+}
+
+// historyPath returns the path of the run history file
+func historyPath(config *Config) string {
+	return filepath.Join(stateDir(config), historyFile)
+}
+
+// recordRun appends a run to the history, rotating it once it has grown
+// beyond historyMaxSize
+func recordRun(record *RunRecord, config *Config) error {
+	if err := os.MkdirAll(stateDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(config), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open run history: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write run history: %v", err)
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > historyMaxSize {
+		if err := os.Rename(historyPath(config), historyPath(config)+".1"); err != nil {
+			return fmt.Errorf("failed to rotate run history: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadHistory reads every recorded run since the last rotation but one,
+// oldest first. Lines that can't be parsed (e.g. from an interrupted write)
+// are skipped.
+func loadHistory(config *Config) ([]*RunRecord, error) {
+	var records []*RunRecord
+	for _, path := range []string{historyPath(config) + ".1", historyPath(config)} {
+		var err error
+		if records, err = readHistoryFile(path, records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// readHistoryFile appends the runs recorded in a history file to records
+func readHistoryFile(path string, records []*RunRecord) ([]*RunRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run history: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Records carry results of up to maxResultSize, beyond the default limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := &RunRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil || record.Script == "" {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read run history: %v", err)
+	}
+	return records, nil
+}
+
+// historyFormats are the formats history export writes
+var historyFormats = []string{"json", "csv"}
+
+// HistoryFilter selects runs from the history. A zero filter selects all.
+type HistoryFilter struct {
+	Script string
+	Since  time.Time
+	// Status is "ok", "failed" or an exact exit code
+	Status string
+}
+
+// matches reports whether a run passes the filter
+func (f *HistoryFilter) matches(record *RunRecord) bool {
+	if f.Script != "" && record.Script != f.Script {
+		return false
+	}
+	if !f.Since.IsZero() && record.Start.Before(f.Since) {
+		return false
+	}
+	switch f.Status {
+	case "":
+	case "ok":
+		return record.ExitCode == 0
+	case "failed":
+		return record.ExitCode != 0
+	default:
+		code, _ := strconv.Atoi(f.Status)
+		return record.ExitCode == code
+	}
+	return true
+}
+
+// parseSince turns "30d", "2w", "12h", a Go duration or a date
+// (2006-01-02) into the earliest time to include
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, suffix)); err == nil && strings.HasSuffix(value, suffix) && n >= 0 {
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (expected e.g. 30d, 2w, 12h or 2006-01-02)", value)
+}
+
+// parseStatus validates an exit status filter
+func parseStatus(value string) (string, error) {
+	switch value {
+	case "ok", "success", "0":
+		return "ok", nil
+	case "failed", "failure":
+		return "failed", nil
+	}
+	if _, err := strconv.Atoi(value); err != nil {
+		return "", fmt.Errorf("invalid --status %q (expected ok, failed or an exit code)", value)
+	}
+	return value, nil
+}
+
+// exportHistory writes the runs passing the filter, oldest first
+func exportHistory(w io.Writer, format string, filter *HistoryFilter, config *Config) error {
+	records, err := loadHistory(config)
+	if err != nil {
+		return err
+	}
+	selected := []*RunRecord{}
+	for _, record := range records {
+		if filter.matches(record) {
+			selected = append(selected, record)
+		}
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(selected)
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"id", "script", "start", "duration_ms", "exit_code"})
+		for _, record := range selected {
+			writer.Write([]string{
+				record.ID,
+				record.Script,
+				record.Start.Format(time.RFC3339),
+				strconv.FormatInt(record.DurationMs, 10),
+				strconv.Itoa(record.ExitCode),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(historyFormats, ", "))
+}
+
+func printHistoryUsage() {
+	fmt.Println("Usage: scripts history <command> [args...]")
+	fmt.Println("  export --format json|csv [--since <age>] [--script <name>] [--status ok|failed|<code>] [--output <file>]")
+	fmt.Println("                       Write recorded runs for spreadsheets and dashboards")
+}
+
+// runHistoryCommand handles the "history" command group
+func runHistoryCommand(args []string, config *Config) {
+	if len(args) == 0 {
+		printHistoryUsage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "export":
+		format, output := "", ""
+		filter := &HistoryFilter{}
+		for i := 1; i < len(args); i++ {
+			arg := args[i]
+			if !strings.HasPrefix(arg, "-") || i+1 >= len(args) {
+				printHistoryUsage()
+				os.Exit(1)
+			}
+			i++
+			var err error
+			switch arg {
+			case "--format":
+				format = args[i]
+			case "--output", "-o":
+				output = args[i]
+			case "--script":
+				filter.Script = args[i]
+			case "--since":
+				filter.Since, err = parseSince(args[i], time.Now())
+			case "--status":
+				filter.Status, err = parseStatus(args[i])
+			default:
+				fmt.Printf("Unknown flag: %s\n", arg)
+				printHistoryUsage()
+				os.Exit(1)
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if format == "" {
+			fmt.Printf("Error: --format is required (%s)\n", strings.Join(historyFormats, ", "))
+			os.Exit(1)
+		}
+
+		w := io.Writer(os.Stdout)
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				fmt.Printf("Error: failed to create %s: %v\n", output, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := exportHistory(w, format, filter, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown history command: %s\n", args[0])
+		printHistoryUsage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// HookStep is one script run by a git hook
+type HookStep struct {
+	Script string `json:"script"`
+	// Files is a glob the files must match to be passed to the script,
+	// against the base name unless it has a slash (default: every file).
+	// The step is skipped when none match.
+	Files string `json:"files,omitempty"`
+}
+
+// matches reports whether a file is for the step
+func (s *HookStep) matches(file string) bool {
+	if s.Files == "" {
+		return true
+	}
+	name := file
+	if !strings.Contains(s.Files, "/") {
+		name = path.Base(file)
+	}
+	ok, _ := path.Match(s.Files, name)
+	return ok
+}
+
+// HookResult is how one step of a hook went
+type HookResult struct {
+	Step     *HookStep
+	Files    int
+	Skipped  bool
+	ExitCode int
+	Duration time.Duration
+}
+
+func printHooksUsage() {
+	fmt.Println("Usage: scripts hooks <command> [args...]")
+	fmt.Println("  list                          List the configured hooks and their scripts")
+	fmt.Println("  run <hook> [files...]         Run a hook's scripts on the staged (or given) files")
+	fmt.Println("  install <hook> [--force]      Make the current git repository run the hook")
+}
+
+// runHooksCommand handles the "hooks" command group
+func runHooksCommand(args []string, config *Config) {
+	if len(args) == 0 {
+		printHooksUsage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		if len(args) != 1 {
+			fmt.Println("Usage: scripts hooks list")
+			os.Exit(1)
+		}
+		printHooks(config)
+	case "run":
+		if len(args) < 2 {
+			fmt.Println("Usage: scripts hooks run <hook> [files...]")
+			os.Exit(1)
+		}
+		if err := runHook(args[1], args[2:], config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "install":
+		usage := "Usage: scripts hooks install <hook> [--force]"
+		force := false
+		var hook string
+		for _, arg := range args[1:] {
+			switch {
+			case arg == "--force":
+				force = true
+			case strings.HasPrefix(arg, "-") || hook != "":
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				hook = arg
+			}
+		}
+		if hook == "" {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := installHook(hook, force, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown hooks command: %s\n", args[0])
+		printHooksUsage()
+		os.Exit(1)
+	}
+}
+
+// printHooks lists the configured hooks
+func printHooks(config *Config) {
+	if len(config.Hooks) == 0 {
+		fmt.Println("No hooks configured (add them under \"hooks\" in the config)")
+		return
+	}
+	var hooks []string
+	for hook := range config.Hooks {
+		hooks = append(hooks, hook)
+	}
+	sort.Strings(hooks)
+	for _, hook := range hooks {
+		fmt.Printf("%s:\n", hook)
+		for _, step := range config.Hooks[hook] {
+			if step.Files != "" {
+				fmt.Printf("  %s (%s)\n", step.Script, step.Files)
+			} else {
+				fmt.Printf("  %s\n", step.Script)
+			}
+		}
+	}
+}
+
+// stagedFiles lists the files added, copied, modified or renamed in the
+// index, relative to the repository root
+func stagedFiles() ([]string, error) {
+	output, err := exec.Command("git", "diff", "--cached", "--name-only", "--diff-filter=ACMR", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged files (not in a git repository?): %v", err)
+	}
+	var files []string
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// runHook runs every script of a hook, in order, on the staged files from
+// the repository root, or on the files given. All of them run even when
+// one fails, and a summary follows; the hook fails when any script did.
+func runHook(hook string, files []string, config *Config) error {
+	steps, ok := config.Hooks[hook]
+	if !ok || len(steps) == 0 {
+		return fmt.Errorf("no scripts configured for hook %s (add them under \"hooks\" in the config)", hook)
+	}
+	// Staged files are relative to the repository root, given ones to the
+	// current directory
+	var dir string
+	if len(files) == 0 {
+		root, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+		if err != nil {
+			return fmt.Errorf("hooks run on the staged files of a git repository; run it inside one or give the files")
+		}
+		dir = strings.TrimSpace(string(root))
+		if files, err = stagedFiles(); err != nil {
+			return err
+		}
+	}
+
+	var results []*HookResult
+	failed := 0
+	for _, step := range steps {
+		result := &HookResult{Step: step}
+		results = append(results, result)
+		var selected []string
+		for _, file := range files {
+			if step.matches(file) {
+				selected = append(selected, file)
+			}
+		}
+		result.Files = len(selected)
+		if len(selected) == 0 {
+			result.Skipped = true
+			continue
+		}
+
+		scriptPath, err := findScript(step.Script, config)
+		if err != nil {
+			return fmt.Errorf("hook %s: %v", hook, err)
+		}
+		renderedPath, removeRendered, err := renderTemplate(scriptPath, nil, "", config)
+		if err != nil {
+			return fmt.Errorf("hook %s: %v", hook, err)
+		}
+		cmd, err := scriptCommand(renderedPath, selected, config)
+		if err != nil {
+			removeRendered()
+			return fmt.Errorf("hook %s: %v", hook, err)
+		}
+		cmd.Dir = dir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		fmt.Println(colorize(colorBold, fmt.Sprintf("==> %s (%d files)", step.Script, len(selected))))
+		record, _ := runRecorded(strings.TrimSuffix(filepath.Base(scriptPath), ".sh"), cmd, &RunOptions{}, config)
+		removeRendered()
+		result.ExitCode, result.Duration = record.ExitCode, record.Duration()
+		if result.ExitCode != 0 {
+			failed++
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCRIPT\tFILES\tRESULT\tDURATION")
+	for _, result := range results {
+		status, duration := "passed", formatDuration(result.Duration)
+		switch {
+		case result.Skipped:
+			status, duration = "skipped", "-"
+		case result.ExitCode != 0:
+			status = fmt.Sprintf("failed (exit %d)", result.ExitCode)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", result.Step.Script, result.Files, status, duration)
+	}
+	w.Flush()
+	if failed > 0 {
+		return fmt.Errorf("hook %s failed: %d of %d scripts failed", hook, failed, len(steps))
+	}
+	return nil
+}
+
+// installHook makes the current git repository run a hook through
+// "scripts hooks run"
+func installHook(hook string, force bool, config *Config) error {
+	if _, ok := config.Hooks[hook]; !ok {
+		return fmt.Errorf("no scripts configured for hook %s (add them under \"hooks\" in the config)", hook)
+	}
+	dir, err := exec.Command("git", "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return fmt.Errorf("not in a git repository")
+	}
+	hookPath := filepath.Join(strings.TrimSpace(string(dir)), hook)
+	if _, err := os.Stat(hookPath); err == nil && !force {
+		return fmt.Errorf("%s already exists (use --force to replace it)", hookPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(hookPath), 0755); err != nil {
+		return fmt.Errorf("failed to create hooks directory: %v", err)
+	}
+	content := fmt.Sprintf("#!/bin/sh\n# Installed by 'scripts hooks install %s'\nexec scripts hooks run %s\n", hook, hook)
+	if err := os.WriteFile(hookPath, []byte(content), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %v", hookPath, err)
+	}
+	fmt.Printf("Installed %s hook in %s\n", hook, hookPath)
+	return nil
+}
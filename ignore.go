@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scriptsIgnoreFile lists, in gitignore syntax, the files of a scripts
+// directory that aren't scripts: editor backups, .bak copies, lib/ helpers
+const scriptsIgnoreFile = ".scriptsignore"
+
+// IgnoreRule is one pattern of a .scriptsignore file
+type IgnoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool // "!pattern" re-includes what an earlier rule ignored
+	dirOnly bool // "pattern/" only matches directories
+}
+
+// ScriptsIgnore holds the rules of a directory's .scriptsignore, which
+// apply to paths relative to it
+type ScriptsIgnore struct {
+	dir   string
+	rules []*IgnoreRule
+}
+
+// globToRegexp translates a gitignore glob: "*" and "?" stay within a path
+// segment, "**" spans segments and [...] classes are kept
+func globToRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// parseIgnoreRule parses a line of a .scriptsignore file, returning nil for
+// blank lines, comments and patterns that can't be used
+func parseIgnoreRule(line string) *IgnoreRule {
+	line = strings.TrimRight(line, " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	rule := &IgnoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	// Patterns with a slash are relative to the directory; others match
+	// at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := "^" + globToRegexp(line) + "$"
+	if !anchored {
+		expr = "^(?:.*/)?" + globToRegexp(line) + "$"
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	rule.pattern = pattern
+	return rule
+}
+
+// loadIgnore reads a directory's .scriptsignore; a missing file ignores
+// nothing
+func loadIgnore(dir string) *ScriptsIgnore {
+	ignore := &ScriptsIgnore{dir: dir}
+	f, err := os.Open(filepath.Join(dir, scriptsIgnoreFile))
+	if err != nil {
+		return ignore
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule := parseIgnoreRule(scanner.Text()); rule != nil {
+			ignore.rules = append(ignore.rules, rule)
+		}
+	}
+	return ignore
+}
+
+// matches applies the rules to a slash-separated relative path, the last
+// matching rule winning
+func (ig *ScriptsIgnore) matches(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range ig.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// ignored reports whether a path under the directory is ignored, itself or
+// through one of its parent directories (which, as with git, can't be
+// re-included from)
+func (ig *ScriptsIgnore) ignored(path string, isDir bool) bool {
+	if len(ig.rules) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(ig.dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.matches(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.matches(strings.Join(parts, "/"), isDir)
+}
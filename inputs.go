@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// globPattern compiles a glob where "**" matches any number of directories
+func globPattern(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// matchInputs returns the files matching the glob patterns, sorted
+func matchInputs(patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(expandPath(pattern))
+		if !strings.Contains(pattern, "**") {
+			matches, err := filepath.Glob(filepath.FromSlash(pattern))
+			if err != nil {
+				return nil, fmt.Errorf("invalid --inputs %q: %v", pattern, err)
+			}
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && !info.IsDir() {
+					seen[match] = true
+				}
+			}
+			continue
+		}
+
+		// Walk from the directory before the first wildcard
+		root := "."
+		if i := strings.IndexAny(pattern, "*?["); i > 0 {
+			if dir := pattern[:strings.LastIndex(pattern[:i], "/")+1]; dir != "" {
+				root = strings.TrimSuffix(dir, "/")
+				if root == "" {
+					root = "/"
+				}
+			}
+		}
+		re, err := globPattern(strings.TrimPrefix(pattern, "./"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --inputs %q: %v", pattern, err)
+		}
+		err = filepath.WalkDir(filepath.FromSlash(root), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if re.MatchString(filepath.ToSlash(path)) {
+				seen[path] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	files := make([]string, 0, len(seen))
+	for file := range seen {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// inputsHash fingerprints a run: the script, its arguments and the path and
+// content of every input file
+func inputsHash(scriptPath string, args []string, files []string) (string, error) {
+	h := sha256.New()
+	for _, path := range append([]string{scriptPath}, files...) {
+		fmt.Fprintf(h, "%s\x00", path)
+		if err := hashFile(h, path); err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	for _, arg := range args {
+		fmt.Fprintf(h, "arg\x00%s\x00", arg)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// skipUnchanged decides a --skip-unchanged run: it fingerprints the inputs
+// into opts.InputsHash and, when the last successful run had the same
+// fingerprint, records a skipped run and reports true
+func skipUnchanged(name, scriptPath string, args []string, opts *RunOptions, config *Config) (bool, error) {
+	files, err := matchInputs(opts.Inputs)
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: no files match --inputs %s\n", strings.Join(opts.Inputs, " "))
+	}
+	if opts.InputsHash, err = inputsHash(scriptPath, args, files); err != nil {
+		return false, fmt.Errorf("failed to hash inputs: %v", err)
+	}
+
+	records, err := loadHistory(config)
+	if err != nil {
+		return false, err
+	}
+	var last *RunRecord
+	for _, record := range records {
+		if record.Script == name && record.InputsHash != "" && !record.Skipped && record.ExitCode == 0 {
+			last = record
+		}
+	}
+	if last == nil || last.InputsHash != opts.InputsHash {
+		return false, nil
+	}
+
+	now := time.Now()
+	skipped := &RunRecord{ID: newRunID(now), Script: name, Start: now, InputsHash: opts.InputsHash, Skipped: true}
+	if err := recordRun(skipped, config); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "Skipped %s: %d inputs unchanged since the successful run at %s\n",
+		name, len(files), last.Start.Local().Format("2006-01-02 15:04:05"))
+	return true, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Binary layouts: everything directly in BinDir, or one subdirectory per
+// language (BinDir/go, BinDir/rust, ...)
+const (
+	layoutFlat     = "flat"
+	layoutLanguage = "language"
+)
+
+// shimsDir holds symlinks to every binary when a language layout is used, so
+// a single PATH entry still covers all of them
+const shimsDir = "shims"
+
+// languageDirs are the subdirectories of BinDir used by the language layout
+var languageDirs = map[string]bool{
+	"go": true, "python": true, "v": true, "rust": true, "c": true, "cpp": true,
+	"csharp": true, "cmake": true, "make": true, "meson": true,
+}
+
+// binLayout returns the configured layout, defaulting to flat
+func binLayout(config *Config) (string, error) {
+	switch config.BinLayout {
+	case "", layoutFlat:
+		return layoutFlat, nil
+	case layoutLanguage:
+		return layoutLanguage, nil
+	}
+	return "", fmt.Errorf("unknown binLayout %q in config (expected %q or %q)", config.BinLayout, layoutFlat, layoutLanguage)
+}
+
+// installDir returns the directory a binary of the given language is
+// installed into
+func installDir(lang string, config *Config) (string, error) {
+	layout, err := binLayout(config)
+	if err != nil {
+		return "", err
+	}
+	if layout == layoutLanguage {
+		return filepath.Join(config.BinDir, lang), nil
+	}
+	return config.BinDir, nil
+}
+
+// binaryDirs lists the directories binaries may live in: BinDir itself and
+// its language subdirectories. Binaries stay findable when the layout
+// changes, until they are next rebuilt into their new place.
+func binaryDirs(config *Config) []string {
+	dirs := []string{config.BinDir}
+	entries, err := os.ReadDir(config.BinDir)
+	if err != nil {
+		return dirs
+	}
+	for _, entry := range entries {
+		// Other directories in BinDir (e.g. unpacked applications) aren't ours
+		if entry.IsDir() && languageDirs[entry.Name()] {
+			dirs = append(dirs, filepath.Join(config.BinDir, entry.Name()))
+		}
+	}
+	return dirs
+}
+
+// binaryPath finds where a binary (or a WASI module, named *.wasm) is
+// installed
+func binaryPath(name string, config *Config) (string, error) {
+	if strings.HasSuffix(name, ".wasm") {
+		path := filepath.Join(wasmDir(config), name)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("module %s not found in %s", name, wasmDir(config))
+		}
+		return path, nil
+	}
+	for _, dir := range binaryDirs(config) {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("binary %s not found in %s", name, config.BinDir)
+}
+
+// binaryNames lists the executables in BinDir and its language
+// subdirectories (excluding the scripts binary itself)
+func binaryNames(config *Config) ([]string, error) {
+	if _, err := os.Stat(config.BinDir); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var binaries []string
+	for _, dir := range binaryDirs(config) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || name == "scripts" || seen[name] {
+				continue
+			}
+			// Check if it's executable
+			if isExecutable(filepath.Join(dir, name)) {
+				seen[name] = true
+				binaries = append(binaries, name)
+			}
+		}
+	}
+	sort.Strings(binaries)
+	return binaries, nil
+}
+
+// placeBinary finishes installing a binary at path: copies left elsewhere by
+// an earlier layout are removed and, with shims enabled, a shim is linked
+func placeBinary(name, path string, config *Config) error {
+	for _, dir := range binaryDirs(config) {
+		if other := filepath.Join(dir, name); other != path {
+			if info, err := os.Stat(other); err == nil && !info.IsDir() {
+				os.Remove(other)
+			}
+		}
+	}
+	if !config.BinShims {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(config.BinDir, shimsDir), 0755); err != nil {
+		return fmt.Errorf("failed to create shims directory: %v", err)
+	}
+	shim := filepath.Join(config.BinDir, shimsDir, name)
+	target, err := filepath.Rel(filepath.Dir(shim), path)
+	if err != nil {
+		target = path
+	}
+	os.Remove(shim)
+	if err := os.Symlink(target, shim); err != nil {
+		return fmt.Errorf("failed to create shim for %s: %v", name, err)
+	}
+	return nil
+}
+
+// removeShim deletes a binary's shim, if there is one
+func removeShim(name string, config *Config) {
+	os.Remove(filepath.Join(config.BinDir, shimsDir, name))
+}
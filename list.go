@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// listFormats are the output formats of "scripts list"
+var listFormats = []string{"table", "json", "plain"}
+
+// ListOptions controls "scripts list"
+type ListOptions struct {
+	Format   string // table, json or plain
+	Long     bool   // show where binaries were built from
+	Archived bool   // include archived scripts
+	Owner    string // only scripts this person owns or maintains
+}
+
+// ScriptEntry is a script as "scripts list --format json" shows it
+type ScriptEntry struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Executable  bool     `json:"executable"`
+	System      bool     `json:"system,omitempty"`
+	Archived    bool     `json:"archived,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Maintainers []string `json:"maintainers,omitempty"`
+}
+
+// BrokenEntry is a problem "scripts list --format json" reports
+type BrokenEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
+
+// ListOutput is everything "scripts list --format json" prints
+type ListOutput struct {
+	Scripts  []*ScriptEntry `json:"scripts"`
+	Binaries []*BinaryEntry `json:"binaries"`
+	Broken   []*BrokenEntry `json:"broken"`
+}
+
+// scriptEntry describes a script for "scripts list --format json"
+func scriptEntry(script *Script, archived bool) *ScriptEntry {
+	owner, maintainers := scriptOwners(script.Path)
+	return &ScriptEntry{Name: script.Name, Path: script.Path, Executable: isExecutable(script.Path), System: script.System, Archived: archived, Owner: owner, Maintainers: maintainers}
+}
+
+// ownedScripts keeps the scripts someone owns or maintains, or all of them
+// for no one
+func ownedScripts(scripts []*Script, owner string) []*Script {
+	if owner == "" {
+		return scripts
+	}
+	var owned []*Script
+	for _, script := range scripts {
+		if ownedBy(script.Path, owner) {
+			owned = append(owned, script)
+		}
+	}
+	return owned
+}
+
+// printList shows the available scripts and binaries: as tables for people,
+// as JSON, or as bare names one per line ("plain") for shell loops. With
+// opts.Owner, only that person's scripts are shown, and no binaries.
+func printList(opts *ListOptions, config *Config) error {
+	scripts := ownedScripts(listScripts(config), opts.Owner)
+	var archived []*Script
+	if opts.Archived {
+		archived = ownedScripts(archivedScripts(config), opts.Owner)
+	}
+	switch opts.Format {
+	case "json":
+		output := &ListOutput{Scripts: []*ScriptEntry{}, Binaries: []*BinaryEntry{}, Broken: []*BrokenEntry{}}
+		for _, script := range scripts {
+			output.Scripts = append(output.Scripts, scriptEntry(script, false))
+		}
+		for _, script := range archived {
+			output.Scripts = append(output.Scripts, scriptEntry(script, true))
+		}
+		if opts.Owner == "" {
+			binaries, err := binaryEntries(config)
+			if err != nil {
+				return err
+			}
+			output.Binaries = append(output.Binaries, binaries...)
+			for _, problem := range fsckProblems(config) {
+				output.Broken = append(output.Broken, &BrokenEntry{Name: problem.Name, Path: problem.Path, Problem: problem.Detail})
+			}
+		}
+		data, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	case "plain":
+		for _, script := range append(scripts, archived...) {
+			fmt.Println(script.Name)
+		}
+		if opts.Owner != "" {
+			return nil
+		}
+		binaries, err := binaryNames(config)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, binary := range binaries {
+			fmt.Println(binary)
+		}
+		return nil
+	}
+
+	hasOutput := false
+
+	// List scripts, the user's shadowing system-wide ones
+	if len(scripts) > 0 {
+		fmt.Println("Available scripts:")
+		for _, script := range scripts {
+			status := "not executable"
+			if isExecutable(script.Path) {
+				status = "executable"
+			}
+			if script.System {
+				status += ", system"
+			}
+			if deprecated, _ := deprecation(script.Path); deprecated {
+				status += ", deprecated"
+			}
+			if isPrivate(script.Path) {
+				status += ", private"
+			}
+			if owner, _ := scriptOwners(script.Path); owner != "" {
+				status += ", owner " + owner
+			}
+			fmt.Printf("  %s (%s)\n", script.Name, status)
+		}
+		hasOutput = true
+	}
+
+	// List binaries
+	if binaries, err := binaryNames(config); err == nil && len(binaries) > 0 && opts.Owner == "" {
+		if hasOutput {
+			fmt.Println()
+		}
+		if err := printBinaryList(opts.Long, config); err != nil {
+			return err
+		}
+		hasOutput = true
+	}
+
+	if len(archived) > 0 {
+		if hasOutput {
+			fmt.Println()
+		}
+		fmt.Printf("Archived scripts (%s):\n", archiveDir(config))
+		for _, script := range archived {
+			fmt.Printf("  %s\n", script.Name)
+		}
+		hasOutput = true
+	}
+
+	if problems := fsckProblems(config); len(problems) > 0 && opts.Owner == "" {
+		if hasOutput {
+			fmt.Println()
+		}
+		printFsckProblems(problems)
+		hasOutput = true
+	}
+
+	if !hasOutput && opts.Owner != "" {
+		fmt.Printf("No scripts owned or maintained by %s.\n", opts.Owner)
+	} else if !hasOutput {
+		fmt.Println("No scripts or binaries found.")
+		fmt.Printf("Scripts directory: %s\n", config.ScriptDir)
+		fmt.Printf("Binaries directory: %s\n", config.BinDir)
+	}
+	return nil
+}
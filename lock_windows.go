@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// errorSharingViolation is returned when another process has the file open
+const errorSharingViolation syscall.Errno = 32
+
+// lockPath takes an exclusive lock on the file at path by opening it
+// without sharing, creating it, and polling for it when wait is set and
+// otherwise failing with errLocked. The lock is released by the returned
+// function, or when the process exits.
+func lockPath(path string, wait bool) (func(), error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return func() { syscall.CloseHandle(handle) }, nil
+		}
+		if !errors.Is(err, errorSharingViolation) {
+			return nil, err
+		}
+		if !wait {
+			return nil, errLocked
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
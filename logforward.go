@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// logForwardTargets are the system logs logForward can send runs to
+var logForwardTargets = []string{"syslog", "journald"}
+
+// LogForwarder sends a run's output and lifecycle events to the system log,
+// tagged with the script name
+type LogForwarder interface {
+	Info(msg string) error
+	Err(msg string) error
+	Close() error
+}
+
+// forwardRun tees a script command's output into the configured system log
+// and logs its start. The returned function logs how the run ended and
+// closes the forwarder; it is nil when forwarding is off.
+func forwardRun(name string, cmd *exec.Cmd, config *Config) (func(*RunRecord), error) {
+	if config.LogForward == "" {
+		return nil, nil
+	}
+	forwarder, err := openLogForwarder(config.LogForward, name)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := &lineWriter{emit: forwarder.Info}
+	stderr := &lineWriter{emit: forwarder.Err}
+	cmd.Stdout = teeWriter(cmd.Stdout, stdout)
+	cmd.Stderr = teeWriter(cmd.Stderr, stderr)
+	forwarder.Info(fmt.Sprintf("started %s", strings.Join(cmd.Args, " ")))
+
+	return func(record *RunRecord) {
+		stdout.flush()
+		stderr.flush()
+		if record.ExitCode == 0 {
+			forwarder.Info(fmt.Sprintf("finished in %s", formatDuration(record.Duration())))
+		} else {
+			forwarder.Err(fmt.Sprintf("failed with exit status %d after %s", record.ExitCode, formatDuration(record.Duration())))
+		}
+		forwarder.Close()
+	}, nil
+}
+
+// teeWriter adds w to an existing command output, which may be unset
+func teeWriter(existing io.Writer, w io.Writer) io.Writer {
+	if existing == nil {
+		return w
+	}
+	return io.MultiWriter(existing, w)
+}
+
+// lineWriter passes each complete line written to it to emit
+type lineWriter struct {
+	mu      sync.Mutex
+	emit    func(string) error
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimRight(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush emits a trailing line without a newline
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 {
+		w.emit(string(w.pending))
+		w.pending = nil
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "fmt"
+
+// openLogForwarder connects to the system log; Windows has neither syslog
+// nor journald
+func openLogForwarder(target, identifier string) (LogForwarder, error) {
+	return nil, fmt.Errorf("logForward %q is not supported on Windows", target)
+}
@@ -2,16 +2,125 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+
+	"golang.org/x/term"
 )
 
 type Config struct {
 	ScriptDir string `json:"scriptDir"`
 	BinDir    string `json:"binDir"`
+	// SystemScriptDir holds scripts shared by every user of the machine,
+	// found after ScriptDir's (default: /usr/local/share/scripts)
+	SystemScriptDir string `json:"systemScriptDir,omitempty"`
+	// NormalizeNames finds scripts ignoring case, dashes and underscores
+	// when no name matches exactly (e.g. "GitPrune" runs gitprune.sh)
+	NormalizeNames bool `json:"normalizeNames,omitempty"`
+	// ReadOnly disables the commands that change scripts and binaries, so
+	// only running them is left (also set with SCRIPTS_READONLY=1)
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// DefaultCommand is run by a bare "scripts", with its arguments (e.g.
+	// "list" or "deploy staging"), instead of printing the help
+	DefaultCommand string `json:"defaultCommand,omitempty"`
+	// Plain keeps output plain, like --plain: no colours, spinners or
+	// symbols (also set with SCRIPTS_PLAIN=1)
+	Plain bool `json:"plain,omitempty"`
+	// CompileFlags holds default compiler flags per language (e.g. "c": ["-O2"])
+	CompileFlags map[string][]string `json:"compileFlags,omitempty"`
+	// Compilers overrides the command used per tool (e.g. "c": "clang")
+	Compilers map[string]string `json:"compilers,omitempty"`
+	// KeepVersions is how many previous builds to keep per binary for
+	// rollback, and previous versions per script replaced by "scripts
+	// update" (0 uses the default, negative disables versioning)
+	KeepVersions int `json:"keepVersions,omitempty"`
+	// PythonBackend packages Python with pyinstaller (default), nuitka, shiv or zipapp
+	PythonBackend string `json:"pythonBackend,omitempty"`
+	// BuildEnv sets compiler environment variables per language
+	// (e.g. "make": {"CC": "musl-gcc"}, "rust": {"RUSTFLAGS": "-C target-cpu=native"})
+	BuildEnv map[string]map[string]string `json:"buildEnv,omitempty"`
+	// BinLayout is "flat" (default) or "language" to install binaries into
+	// per-language subdirectories of BinDir
+	BinLayout string `json:"binLayout,omitempty"`
+	// BinShims links every binary into BinDir/shims so one PATH entry covers
+	// all language subdirectories
+	BinShims bool `json:"binShims,omitempty"`
+	// WasmDir is where --target wasm modules go (default: BinDir/wasm)
+	WasmDir string `json:"wasmDir,omitempty"`
+	// WasiSysroot is the wasi-libc sysroot for C/C++ WASM builds
+	WasiSysroot string `json:"wasiSysroot,omitempty"`
+	// MuslTarget is the Rust target used by --static (default: the host's musl target)
+	MuslTarget string `json:"muslTarget,omitempty"`
+	// AutoReady makes a script executable when it is run without the
+	// execute bit, instead of running it through its shebang interpreter
+	AutoReady bool `json:"autoReady,omitempty"`
+	// Permissions controls the permission bits ready, add and compile apply
+	// (default: add owner execute to the existing bits)
+	Permissions *PermissionPolicy `json:"permissions,omitempty"`
+	// Toolchains pins the toolchain version per language (e.g. "go": "1.22.3",
+	// "rust": "1.78"); builds fail when the pinned version can't be used
+	Toolchains map[string]string `json:"toolchains,omitempty"`
+	// CodesignIdentity signs binaries on macOS after they are built, e.g.
+	// "Developer ID Application: Name (TEAMID)", or "-" for ad-hoc signing
+	CodesignIdentity string `json:"codesignIdentity,omitempty"`
+	// NotaryProfile is the notarytool keychain profile used to notarize
+	// signed binaries (see 'xcrun notarytool store-credentials')
+	NotaryProfile string `json:"notaryProfile,omitempty"`
+	// CacheDir holds the downloads of "scripts fetch" (default:
+	// $XDG_CACHE_HOME/scripts or ~/.cache/scripts)
+	CacheDir string `json:"cacheDir,omitempty"`
+	// StateDir holds the run history (default: $XDG_STATE_HOME/scripts or
+	// ~/.local/state/scripts)
+	StateDir string `json:"stateDir,omitempty"`
+	// LogForward sends script output and run events to "syslog" or
+	// "journald", tagged with the script name
+	LogForward string `json:"logForward,omitempty"`
+	// Serve configures the daemon started by "scripts serve"
+	Serve *ServeConfig `json:"serve,omitempty"`
+	// Triggers runs scripts from the daemon when messages arrive on MQTT
+	// topics or NATS subjects
+	Triggers *TriggersConfig `json:"triggers,omitempty"`
+	// Registry is the URL of a registry index.json used by "scripts search
+	// --remote" and "scripts install <name>"
+	Registry string `json:"registry,omitempty"`
+	// PublishTap is the tap "scripts publish" pushes to by default
+	PublishTap string `json:"publishTap,omitempty"`
+	// SudoEnv lists the environment variables "scripts --sudo" keeps (names,
+	// or prefixes like "AWS_*")
+	SudoEnv []string `json:"sudoEnv,omitempty"`
+	// Alerts are actions taken when a script exits with a matching code
+	Alerts []*AlertRule `json:"alerts,omitempty"`
+	// Vars are the values of template placeholders like {{env}}, for
+	// scripts with "# scripts:template"; stored variables and --var
+	// override them
+	Vars map[string]string `json:"vars,omitempty"`
+	// Interp runs scripts with a given interpreter command line instead of
+	// their shebang, by script name (e.g. "deploy": "bash -euo pipefail")
+	Interp map[string]string `json:"interp,omitempty"`
+	// Sync keeps the scripts directory in sync with a git remote
+	Sync *SyncConfig `json:"sync,omitempty"`
+	// Strict rejects unknown config keys and, for scripts declaring their
+	// flags, undeclared flags, suggesting what was probably meant
+	Strict bool `json:"strict,omitempty"`
+	// StrictMode runs shell scripts with "bash -euo pipefail", except those
+	// declaring "# scripts:no-strict"
+	StrictMode bool `json:"strictMode,omitempty"`
+	// ProjectMarkers are the files or directories marking a project root,
+	// for "# scripts:workdir git-root" and $SCRIPTS_PROJECT_ROOT (default:
+	// [".git"])
+	ProjectMarkers []string `json:"projectMarkers,omitempty"`
+	// Presets are saved arguments per script and preset name, run as
+	// "scripts <script> @<preset>"
+	Presets map[string]map[string][]string `json:"presets,omitempty"`
+	// Hooks lists the scripts each git hook runs, in order (e.g.
+	// "pre-commit"), for "scripts hooks"
+	Hooks map[string][]*HookStep `json:"hooks,omitempty"`
 }
 
 func isExecutable(path string) bool {
@@ -23,14 +132,9 @@ func isExecutable(path string) bool {
 	return mode&0100 != 0
 }
 
-func makeExecutable(path string) error {
-	info, err := os.Stat(path)
-	if err != nil {
-		return err
-	}
-	mode := info.Mode()
-	newMode := mode | 0100 // Add execute permission for owner
-	return os.Chmod(path, newMode)
+// isTerminal reports whether f is attached to an interactive terminal
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
 }
 
 func expandPath(path string) string {
@@ -84,7 +188,11 @@ func loadConfig() (*Config, error) {
 
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		// Create default config
+		// Ask at a terminal; elsewhere (installers, cron) create the default
+		// config and say where it is
+		if canPrompt() {
+			return runSetupWizard(configPath)
+		}
 		defaultConfig := &Config{
 			ScriptDir: expandPath("~/code/personal/scripts/scripts_bin"),
 			BinDir:    expandPath("~/opt/programs"),
@@ -92,6 +200,7 @@ func loadConfig() (*Config, error) {
 		if err := saveConfig(defaultConfig); err != nil {
 			return nil, fmt.Errorf("failed to create default config: %v", err)
 		}
+		fmt.Fprintf(os.Stderr, "Created a default config at %s (scripts in %s); edit it, or delete it and run scripts in a terminal to set it up\n", configPath, defaultConfig.ScriptDir)
 		return defaultConfig, nil
 	}
 
@@ -105,6 +214,11 @@ func loadConfig() (*Config, error) {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
+	if config.Strict {
+		if err := checkConfigKeys(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", configPath, err)
+		}
+	}
 
 	return &config, nil
 }
@@ -159,43 +273,12 @@ func saveConfig(config *Config) error {
 	return nil
 }
 
-func readyScripts(paths []string) error {
-	for _, path := range paths {
-		// If path is a directory, find all .sh files in it
-		if info, err := os.Stat(path); err == nil && info.IsDir() {
-			files, err := filepath.Glob(filepath.Join(path, "*.sh"))
-			if err != nil {
-				return fmt.Errorf("failed to glob %s: %v", path, err)
-			}
-			for _, file := range files {
-				if !isExecutable(file) {
-					fmt.Printf("Making %s executable\n", filepath.Base(file))
-					if err := makeExecutable(file); err != nil {
-						return fmt.Errorf("failed to make %s executable: %v", file, err)
-					}
-				} else {
-					fmt.Printf("%s is already executable\n", filepath.Base(file))
-				}
-			}
-		} else {
-			// Handle single file
-			if !strings.HasSuffix(path, ".sh") {
-				path = path + ".sh"
-			}
-			if !isExecutable(path) {
-				fmt.Printf("Making %s executable\n", filepath.Base(path))
-				if err := makeExecutable(path); err != nil {
-					return fmt.Errorf("failed to make %s executable: %v", path, err)
-				}
-			} else {
-				fmt.Printf("%s is already executable\n", filepath.Base(path))
-			}
-		}
+func addScript(scriptPath, mode string, config *Config) error {
+	perms, err := resolvePermissions(config, mode)
+	if err != nil {
+		return err
 	}
-	return nil
-}
 
-func addScript(scriptPath string, config *Config) error {
 	// Check if source script exists
 	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
 		return fmt.Errorf("script %s does not exist", scriptPath)
@@ -221,12 +304,15 @@ func addScript(scriptPath string, config *Config) error {
 		return fmt.Errorf("failed to read source script: %v", err)
 	}
 
+	if _, err := os.Stat(destPath); err == nil {
+		warnOwner(scriptName, destPath)
+	}
 	if err := os.WriteFile(destPath, sourceData, 0644); err != nil {
 		return fmt.Errorf("failed to write script to scripts_bin: %v", err)
 	}
 
 	// Make it executable
-	if err := makeExecutable(destPath); err != nil {
+	if err := perms.apply(destPath); err != nil {
 		return fmt.Errorf("failed to make script executable: %v", err)
 	}
 
@@ -234,174 +320,454 @@ func addScript(scriptPath string, config *Config) error {
 	return nil
 }
 
-func compileSource(sourcePath, binaryName string, config *Config) error {
-	// Check if source file exists
-	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-		return fmt.Errorf("source file %s does not exist", sourcePath)
-	}
-
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(config.BinDir, 0755); err != nil {
-		return fmt.Errorf("failed to create bin directory: %v", err)
-	}
-
-	// Get file extension to determine language
-	ext := strings.ToLower(filepath.Ext(sourcePath))
-
-	// Use provided binary name or default to source file name
-	name := binaryName
-	if name == "" {
-		name = strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
-	}
-	outputPath := filepath.Join(config.BinDir, name)
-
-	var err error
-	switch ext {
-	case ".go":
-		err = compileGo(sourcePath, outputPath)
-	case ".py":
-		err = compilePython(sourcePath, outputPath)
-	case ".v":
-		err = compileV(sourcePath, outputPath)
-	case ".rs":
-		err = compileRust(sourcePath, outputPath)
-	case ".c":
-		err = compileC(sourcePath, outputPath)
-	case ".cpp", ".cc", ".cxx":
-		err = compileCpp(sourcePath, outputPath)
-	default:
-		return fmt.Errorf("unsupported file extension: %s", ext)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	// Make binary executable
-	if err := makeExecutable(outputPath); err != nil {
-		return fmt.Errorf("failed to make binary executable: %v", err)
-	}
-
-	fmt.Printf("Compiled %s to %s\n", sourcePath, outputPath)
-	return nil
-}
-
-func compileGo(sourcePath, outputPath string) error {
-	cmd := exec.Command("go", "build", "-o", outputPath, sourcePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-func compilePython(sourcePath, outputPath string) error {
-	// Use PyInstaller to create standalone executable
-	cmd := exec.Command("pyinstaller", "--onefile", "--distpath", filepath.Dir(outputPath), "--name", filepath.Base(outputPath), sourcePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("PyInstaller compilation failed: %v (make sure PyInstaller is installed)", err)
-	}
-
-	// PyInstaller creates files in dist directory, move to final location
-	distPath := filepath.Join(filepath.Dir(outputPath), filepath.Base(outputPath))
-	if _, err := os.Stat(distPath); err == nil {
-		return os.Rename(distPath, outputPath)
-	}
-	return nil
-}
-
-func compileV(sourcePath, outputPath string) error {
-	cmd := exec.Command("v", "-prod", "-o", outputPath, sourcePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-func compileRust(sourcePath, outputPath string) error {
-	// Check if this is a Cargo project
-	dir := filepath.Dir(sourcePath)
-	if _, err := os.Stat(filepath.Join(dir, "Cargo.toml")); err == nil {
-		// Cargo project
-		cmd := exec.Command("cargo", "build", "--release")
-		cmd.Dir = dir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return err
-		}
-		// Copy binary from target/release/ to output path
-		binaryName := strings.TrimSuffix(filepath.Base(sourcePath), ".rs")
-		srcPath := filepath.Join(dir, "target", "release", binaryName)
-		return exec.Command("cp", srcPath, outputPath).Run()
-	} else {
-		// Single file compilation with rustc
-		cmd := exec.Command("rustc", "-o", outputPath, sourcePath)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
-	}
-}
-
-func compileC(sourcePath, outputPath string) error {
-	cmd := exec.Command("gcc", "-o", outputPath, sourcePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-func compileCpp(sourcePath, outputPath string) error {
-	cmd := exec.Command("g++", "-o", outputPath, sourcePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
 func printHelp() {
 	fmt.Println("scripts - A tool for managing and running shell scripts and compiling binaries")
 	fmt.Println()
 	fmt.Println("USAGE:")
 	fmt.Println("  scripts <script_name> [args...]    Run a script from scripts_bin/")
-	fmt.Println("  scripts list                        List available scripts and binaries")
-	fmt.Println("  scripts ready <script_name> [-a]    Make scripts in scripts_bin executable")
+	fmt.Println("  scripts --record <script_name> [args...]    Run a script and record its terminal session")
+	fmt.Println("  scripts --no-prompt <script_name> [args...]    Run a script without asking for missing parameters")
+	fmt.Println("  scripts --sudo <script_name> [args...]    Run a script as root through sudo")
+	fmt.Println("  scripts --user <name> <script_name> [args...]    Run a script as another user")
+	fmt.Println("  scripts --skip-unchanged --inputs <glob> <script_name>    Skip the run if its inputs are unchanged")
+	fmt.Println("  scripts --force|--wait <script_name> [args...]    Run a script within its cooldown, or wait it out")
+	fmt.Println("  scripts --queue <script_name> [args...]    Run an exclusive script once its current run finishes")
+	fmt.Println("  scripts --offline <command> [args...]    Fail fast instead of using the network")
+	fmt.Println("  scripts --plain <command> [args...]    Plain output for screen readers and dumb terminals")
+	fmt.Println("  scripts list [--long] [--archived] [--owner <name>] [--format <f>]    List available scripts and binaries")
+	fmt.Println("  scripts archive|unarchive <name>    Put a script away without deleting it, or restore it")
+	fmt.Println("  scripts deprecate <name> [--use <other>] [--undo]    Warn when a script is run")
+	fmt.Println("  scripts sync [--ours|--theirs|--pull|status]    Sync the scripts directory with its git remote")
+	fmt.Println("  scripts ready <script_name> [-a] [-r] [--check]    Make scripts in scripts_bin executable")
 	fmt.Println("  scripts add <script.sh>             Add script to scripts_bin/")
-	fmt.Println("  scripts compile <source> [--name <binary>]    Compile source to binary")
+	fmt.Println("  scripts add --clipboard <name>      Add the clipboard's contents as a script")
+	fmt.Println("  scripts tap <command> [args...]     Share script collections from git (add, list, update, remove)")
+	fmt.Println("  scripts install <name|tap/script>   Install a script from the registry or a tap")
+	fmt.Println("  scripts search <term> [--remote]    Search installed and tap scripts, or the registry")
+	fmt.Println("  scripts publish <name>              Bundle a script and its dependencies to share it")
+	fmt.Println("  scripts diff <name> <path>          Show how a local file differs from a managed script")
+	fmt.Println("  scripts update <name> <path>        Replace a managed script with a changed local copy")
+	fmt.Println("  scripts versions <name>             List a script's saved previous versions")
+	fmt.Println("  scripts outdated                    List installs with a newer version at their origin")
+	fmt.Println("  scripts upgrade <name>... | --all   Update installs from their tap, registry or URL")
+	fmt.Println("  scripts new <name> [--from \"<cmd>\" | --from-history]    Create a script wrapping a command")
+	fmt.Println("  scripts compile <source> [--name <binary>] [-- <flags>]    Compile source to binary")
+	fmt.Println("  scripts compile --dir <dir> [--jobs <n>]    Compile every source in a directory")
+	fmt.Println("  scripts compile-git <url> [--ref <ref>] [--path <dir>]    Build a binary from a git repository")
+	fmt.Println("  scripts bin <command> [args...]     Manage compiled binaries (list, info, verify, versions, rollback, rm)")
+	fmt.Println("  scripts rebuild [<binary>...] [--all] [--jobs <n>]    Recompile tracked binaries")
 	fmt.Println("  scripts rm <script_name> [--bin]    Remove script or binary")
+	fmt.Println("  scripts which <name>                Print the path of a script or binary")
+	fmt.Println("  scripts completion bash|zsh|fish    Print a shell completion script")
+	fmt.Println("  scripts shell                       Start an interactive prompt for scripts and commands")
+	fmt.Println("  scripts hooks <command> [args...]   Run managed scripts as git hooks (list, run, install)")
+	fmt.Println("  scripts env [--sudo|--user <name>] <name>    Show the environment a script would get")
+	fmt.Println("  scripts fetch <url> [--sha256 <hex>]    Download a file once and print its cached path")
+	fmt.Println("  scripts adopt <dir> [--yes] [--link]    Import a directory of loose scripts and binaries")
+	fmt.Println("  scripts export-shell [--format zsh]    Print shell functions running every script")
+	fmt.Println("  scripts doctor [--fix-path]         Check the installation, e.g. that binaries are on PATH")
+	fmt.Println("  scripts fsck                        Find broken symlinks, missing interpreters and libraries")
+	fmt.Println("  scripts check <name>... | -a        Score scripts on shebang, syntax, shellcheck and dependencies")
+	fmt.Println("  scripts chain <name>... [--stop-on-fail]    Run scripts one after another with a shared workspace")
+	fmt.Println("  scripts preset save <name> <preset> -- [args...]    Save arguments to run as 'scripts <name> @<preset>'")
+	fmt.Println("  scripts var set|get|list|rm [<key>] [<value>]    Manage stored template variables")
+	fmt.Println("  scripts cache list|clean            List or remove cached downloads")
+	fmt.Println("  scripts run-wasm <name> [args...]   Run a WASM module with wasmtime")
+	fmt.Println("  scripts containerize <name> [--base <image>]    Build a container image around a script or binary")
+	fmt.Println("  scripts package <binary> --format deb|rpm|tar.gz [--version <v>]    Package a binary for distribution")
+	fmt.Println("  scripts stats [<script_name>] [--top [n]]    Show run statistics from the run history")
+	fmt.Println("  scripts history export --format json|csv [--since 30d]    Export the run history")
+	fmt.Println("  scripts test [<script_name>]        Run the tests of scripts in scripts_bin/")
+	fmt.Println("  scripts replay <run-id|script_name> [--speed <n>]    Play back a recorded run")
+	fmt.Println("  scripts serve [--addr <host:port>] [--grpc-addr <host:port>]  Run scripts and compiles over HTTP (and gRPC), with Prometheus metrics")
 	fmt.Println("  scripts help                        Show this help message")
 	fmt.Println("  scripts -h                          Show this help message")
 	fmt.Println("  scripts --help                      Show this help message")
 	fmt.Println()
 	fmt.Println("COMMANDS:")
 	fmt.Println("  <script_name>    Run the specified script (must be in scripts_bin/)")
+	fmt.Println("                   Scripts without the execute bit run through their shebang interpreter,")
+	fmt.Println("                   or are made executable first when autoReady is set in the config")
+	fmt.Println("                   Parameters declared in the script header are prompted for when missing:")
+	fmt.Println("                     # scripts:param env choices=staging,production default=staging Target environment")
+	fmt.Println("                     # scripts:param count type=int pattern=[1-9][0-9]* How many to keep")
+	fmt.Println("                   --no-prompt before the name uses the defaults instead (as do runs without a terminal)")
+	fmt.Println("                   --record before the name captures the session (asciicast v2) for 'scripts replay'")
+	fmt.Println("                   --sudo before the name runs it as root through sudo, keeping the environment")
+	fmt.Println("                   variables listed in sudoEnv and those scripts sets (such as $SCRIPTS_RESULT);")
+	fmt.Println("                   the run is still recorded in your history")
+	fmt.Println("                   --user <name> before the name runs it as that user (e.g. a service account):")
+	fmt.Println("                   directly when scripts runs as root, otherwise with 'sudo -u' if sudoers allows it")
+	fmt.Println("                   --skip-unchanged --inputs <glob> (repeatable, ** matches directories) skips the run")
+	fmt.Println("                   when the script, its arguments and the matching files are the same as at the last")
+	fmt.Println("                   successful run; the skip is recorded in the history")
+	fmt.Println("                   Scripts declaring a cooldown refuse to run again until it has passed since their")
+	fmt.Println("                   last run; --force runs anyway and --wait waits until the cooldown is over:")
+	fmt.Println("                     # scripts:cooldown 5m")
+	fmt.Println("                   Scripts run after the scripts they declare as prerequisites, which with within=")
+	fmt.Println("                   only run when they haven't succeeded that recently:")
+	fmt.Println("                     # scripts:after backup-db within=6h")
+	fmt.Println("                   Scripts declaring '# scripts:template' have {{name}} placeholders filled in from")
+	fmt.Println("                   --var key=value before the name, 'scripts var set' and \"vars\" in the config")
+	fmt.Println("                   --filter jq:<program> runs the script's stdout through jq, and --grep <pattern>")
+	fmt.Println("                   (a regular expression) keeps the matching lines; several apply in order")
+	fmt.Println("                   Scripts may write a JSON result to the file named by $SCRIPTS_RESULT; it is kept")
+	fmt.Println("                   in the history, and --json prints the run and its result as JSON on stdout (the")
+	fmt.Println("                   script's own stdout goes to stderr)")
+	fmt.Println("                   --cwd <dir> runs the script in that directory; scripts may declare one instead,")
+	fmt.Println("                   relative to the script's own directory:")
+	fmt.Println("                     # scripts:workdir ../data")
+	fmt.Println("                   'git-root' runs it from the project root above the current directory, which")
+	fmt.Println("                   scripts also get as $SCRIPTS_PROJECT_ROOT")
+	fmt.Println("                   Scripts declaring '# scripts:deprecated [hint]' print a warning when run;")
+	fmt.Println("                   --strict refuses to run them instead")
+	fmt.Println("                   Scripts declaring '# scripts:exclusive' run one at a time; starting one while it")
+	fmt.Println("                   runs fails, or with --queue waits for the current run to finish")
+	fmt.Println("                   When a script fails, its exit code, duration, the last 15 lines of its stderr and")
+	fmt.Println("                   its recording (with --record) are summarized")
 	fmt.Println("                   Example: scripts gitprune --dry-run")
 	fmt.Println()
+	fmt.Println("  env              Print the environment variables a script would run with, as NAME=value lines")
+	fmt.Println("                   sorted by name; values of names like *TOKEN*, *SECRET*, *PASSWORD* or *KEY* are")
+	fmt.Println("                   masked, and variables set by scripts are marked. --sudo and --user <name> show")
+	fmt.Println("                   what is left through sudo (the variables in sudoEnv and those scripts sets)")
+	fmt.Println()
+	fmt.Println("  adopt            Classify the files of a directory such as ~/bin and import them one by one:")
+	fmt.Println("                   shell scripts become managed scripts (with a description stub in their header)")
+	fmt.Println("                   and other executables are copied to the binaries directory. Files already")
+	fmt.Println("                   managed are skipped and the originals are left in place")
+	fmt.Println("                   --yes adopts everything without asking, --link symlinks to the originals")
+	fmt.Println("                   instead of copying, and --dry-run only shows the classification")
+	fmt.Println()
+	fmt.Println("  export-shell     Print a shell function per script, calling 'scripts <name>' so history and")
+	fmt.Println("                   the other run features still apply, to load from your shell's startup file:")
+	fmt.Println("                     source <(scripts export-shell --format zsh)")
+	fmt.Println("                   --format is bash, zsh or fish (default: your $SHELL), and --prefix <prefix>")
+	fmt.Println("                   names the functions <prefix><name> to avoid clashing with other commands;")
+	fmt.Println("                   private scripts are left out unless --include-private is given")
+	fmt.Println()
+	fmt.Println("  check            Check scripts for problems and print a scorecard: shebang, execute bit, syntax")
+	fmt.Println("                   (sh/bash -n), shellcheck (when installed), '# scripts:requires' dependencies")
+	fmt.Println("                   installed and 'source'd files existing; -a checks every script and it exits")
+	fmt.Println("                   1 when any check failed (warnings don't count)")
+	fmt.Println("  doctor           Check that the scripts directory exists and the binaries directory is on PATH")
+	fmt.Println("                   --fix-path adds the export line to your shell's startup file (~/.bashrc,")
+	fmt.Println("                   ~/.zshrc, fish's config.fish or ~/.profile). Runs and builds at a terminal")
+	fmt.Println("                   print the line once when the directory is missing from PATH")
+	fmt.Println("  fsck             Find what would fail confusingly at run time: broken symlinks in the scripts")
+	fmt.Println("                   and binaries directories, scripts whose shebang interpreter isn't installed")
+	fmt.Println("                   and binaries missing shared libraries (ldd on Linux, otool on macOS); 'list'")
+	fmt.Println("                   flags them too")
+	fmt.Println()
+	fmt.Println("  chain            Run scripts in order and summarize how each went; a step with arguments is quoted")
+	fmt.Println("                   (\"deploy staging\"). Steps share a workspace directory, $SCRIPTS_WORKSPACE, and")
+	fmt.Println("                   KEY=VALUE lines a step appends to $SCRIPTS_CHAIN_ENV are set for the steps after it")
+	fmt.Println("                   Every step runs even after a failure unless --stop-on-fail is given")
+	fmt.Println("                   Example: scripts chain build \"deploy staging\" smoke-test --stop-on-fail")
+	fmt.Println()
+	fmt.Println("  preset           Save a script's arguments under a name and run them with @<name>:")
+	fmt.Println("                     scripts preset save gitprune weekly -- --force --age 7d")
+	fmt.Println("                     scripts gitprune @weekly")
+	fmt.Println("                   Arguments after @<name> are appended. 'preset list [<script>]' shows them and")
+	fmt.Println("                   'preset rm <script> <name>' removes one; they are kept in the config")
+	fmt.Println()
+	fmt.Println("  var              Store the variables template scripts use: set <key> <value>, get <key>, list")
+	fmt.Println("                   and rm <key>. --var key=value on a run and \"vars\" in the config also set them;")
+	fmt.Println("                   --var wins over stored variables, which win over the config")
+	fmt.Println()
+	fmt.Println("  fetch            Download a URL into the cache unless it is there already, and print the path")
+	fmt.Println("                   Files are stored by SHA-256, so --sha256 also finds one fetched from another URL")
+	fmt.Println("                   and verifies new downloads; --refresh downloads it again")
+	fmt.Println("                   Scripts get the cache directory as $SCRIPTS_CACHE_DIR")
+	fmt.Println("                   Example: tar xzf \"$(scripts fetch https://example.com/tool.tar.gz)\"")
+	fmt.Println()
+	fmt.Println("  cache            list shows the cached downloads; clean [--older-than 30d] removes them (those")
+	fmt.Println("                   not used for that long)")
+	fmt.Println()
+	fmt.Println("  hooks            Run the scripts configured for a git hook on the staged files, e.g.")
+	fmt.Println("                   \"hooks\": {\"pre-commit\": [{\"script\": \"shellcheck\", \"files\": \"*.sh\"}, {\"script\": \"no-secrets\"}]}")
+	fmt.Println("                   - list shows the configured hooks")
+	fmt.Println("                   - run <hook> [files...] runs every script with the matching files as arguments")
+	fmt.Println("                     and summarizes them; it fails when any script failed")
+	fmt.Println("                   - install <hook> [--force] writes the repository's git hook to call 'run'")
+	fmt.Println("                   Example: scripts hooks install pre-commit")
+	fmt.Println()
+	fmt.Println("  shell            Start a prompt that runs scripts and commands without the 'scripts' prefix,")
+	fmt.Println("                   with tab completion and the session's history (up/down); 'exit' or Ctrl-D leaves")
+	fmt.Println("                   Lines piped to it run one after another")
+	fmt.Println()
+	fmt.Println("  completion       Print a completion script for bash, zsh or fish, completing commands, script")
+	fmt.Println("                   names, and each script's declared flags and parameter choices:")
+	fmt.Println("                     # scripts:flag --dry-run Show what would change")
+	fmt.Println("                   Example: source <(scripts completion bash)")
+	fmt.Println()
 	fmt.Println("  list             List all available scripts in scripts_bin/ and binaries in ~/opt/programs/")
-	fmt.Println("                   Shows script names with executable status and available binaries")
+	fmt.Println("                   Shows script names with executable status, and binaries with their size,")
+	fmt.Println("                   modification time and (from the build records) language and build time")
+	fmt.Println("                   Scripts from the system-wide directory (/usr/local/share/scripts) are marked")
+	fmt.Println("                   \"system\"; a script of yours with the same name takes precedence")
+	fmt.Println("                   Files matched by scripts_bin/.scriptsignore (gitignore syntax, e.g. *~,")
+	fmt.Println("                   *.bak.sh, lib/) are left out here, in completion and in 'ready -a'")
+	fmt.Println("                   --long adds the source each binary was built from")
+	fmt.Println("                   --archived includes scripts put away with 'scripts archive'")
+	fmt.Println("                   --owner <name> shows only the scripts <name> owns or maintains")
+	fmt.Println("                   --format json prints everything as JSON (sizes in bytes, RFC 3339 times);")
+	fmt.Println("                   --format plain prints bare names one per line, for shell loops")
 	fmt.Println("                   Example: scripts list")
+	fmt.Println("                            scripts list --format json | jq -r '.binaries[] | select(.size > 10000000) | .name'")
+	fmt.Println()
+	fmt.Println("  archive          Move a script to scripts_bin/archived/ instead of deleting it; archived scripts")
+	fmt.Println("                   aren't run, completed or listed (unless 'list --archived')")
+	fmt.Println("                   'scripts unarchive <name>' moves it back")
+	fmt.Println()
+	fmt.Println("  deprecate        Mark a script deprecated, so running it prints a warning (or fails with --strict)")
+	fmt.Println("                   --use <other> names its replacement, --message <text> sets the hint, --undo")
+	fmt.Println("                   removes the mark; it is the '# scripts:deprecated <hint>' header line")
+	fmt.Println("                   Example: scripts deprecate deploy --use deploy-v2")
+	fmt.Println()
+	fmt.Println("  sync             Commit local changes to scripts_bin, merge the remote's (sync.remote in the")
+	fmt.Println("                   config) and push. Scripts changed on both machines are shown as a diff, to")
+	fmt.Println("                   keep ours, take theirs or edit the merge in $EDITOR; --ours or --theirs")
+	fmt.Println("                   picks a side for all of them without asking")
+	fmt.Println("                   --pull only fast-forwards to the remote; with sync.auto, this happens")
+	fmt.Println("                   in the background every sync.interval (and in 'scripts serve'), and")
+	fmt.Println("                   new and updated scripts are reported on the next invocation")
+	fmt.Println("                   'scripts sync status' lists scripts only here, only on the remote, or")
+	fmt.Println("                   modified on either side, and when they were last synced")
+	fmt.Println("                   Files named in sync.encrypt are pushed encrypted with age, as <file>.age, and")
+	fmt.Println("                   decrypted after a pull with the identity in sync.identity")
+	fmt.Println("                   Example: scripts sync")
 	fmt.Println()
 	fmt.Println("  ready            Make scripts in scripts_bin executable")
 	fmt.Println("                   - <script_name> makes script_name.sh in scripts_bin executable")
-	fmt.Println("                   - -a or --all makes all .sh files in scripts_bin executable")
+	fmt.Println("                   - -a or --all makes all scripts in scripts_bin executable: .sh, .py, .rb, .pl")
+	fmt.Println("                     and extensionless files starting with a shebang")
+	fmt.Println("                   - -r or --recursive includes subdirectories (hidden ones are skipped)")
+	fmt.Println("                   - --all-users also sets the group and other execute bits (a+x)")
+	fmt.Println("                   - --check only reports scripts lacking the execute bit, exiting 1 if there are any")
 	fmt.Println("                   Examples:")
 	fmt.Println("                     scripts ready myscript")
 	fmt.Println("                     scripts ready -a")
+	fmt.Println("                     scripts ready -a --recursive --all-users")
+	fmt.Println("                     scripts ready --check")
 	fmt.Println()
 	fmt.Println("  add              Copy script to scripts_bin and make executable")
 	fmt.Println("                   Examples:")
 	fmt.Println("                     scripts add myscript.sh")
 	fmt.Println("                     scripts add ./path/to/script.sh")
+	fmt.Println("                     scripts add deploy.sh --mode 0750")
+	fmt.Println("                     scripts add --clipboard snippet")
+	fmt.Println("                   --clipboard reads pbpaste, wl-paste, xclip or xsel, previews the text and asks")
+	fmt.Println("                   before installing it (--yes skips the question; a bash shebang is added if missing)")
+	fmt.Println()
+	fmt.Println("  tap              Manage taps: git repositories of shared scripts (*.sh at the root or in scripts/)")
+	fmt.Println("                   - add <git-url> [--name <name>] clones one (named after the repository by default)")
+	fmt.Println("                   - list [<tap>] shows taps, or the scripts a tap provides")
+	fmt.Println("                   - update [<tap>...] pulls the latest scripts; remove <tap> deletes the clone")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts tap add https://github.com/acme/ops-scripts --name ops")
+	fmt.Println("                     scripts tap list ops")
+	fmt.Println()
+	fmt.Println("  install          Install a script from a tap, URL or published archive, or a script or binary")
+	fmt.Println("                   from the registry")
+	fmt.Println("                   (--force replaces existing scripts); registry downloads are checked against")
+	fmt.Println("                   the sha256 in the index and nothing is installed on a mismatch")
+	fmt.Println("                   Examples: scripts install ops/rotate-logs")
+	fmt.Println("                             scripts install rotate-logs")
+	fmt.Println("                             scripts install https://example.com/scripts/cleanup.sh")
+	fmt.Println()
+	fmt.Println("  search           Find scripts by name among installed and tap scripts; with --remote, search")
+	fmt.Println("                   the names and descriptions in the registry index (the registry config key)")
+	fmt.Println()
+	fmt.Println("  publish          Bundle a script with the scripts it requires into <name>-<version>.tar.gz")
+	fmt.Println("                   (with a bundle.json manifest and a .sha256 file), or commit and push them to")
+	fmt.Println("                   a tap with --tap (publishTap in the config makes that the default).")
+	fmt.Println("                   Header comments describe the bundle:")
+	fmt.Println("                     # scripts:description Rotate and compress logs")
+	fmt.Println("                     # scripts:version 1.2.0")
+	fmt.Println("                     # scripts:requires gzip common-lib")
+	fmt.Println("                   Requirements that are installed scripts are bundled; others are listed as")
+	fmt.Println("                   commands, checked when the archive is installed with 'scripts install <archive>'")
+	fmt.Println("                   Private scripts aren't published, nor bundled, without --include-private")
+	fmt.Println()
+	fmt.Println("  diff             Show a unified diff from a managed script to a local file, i.e. what")
+	fmt.Println("                   'scripts add' would change; colored on terminals (--color/--no-color)")
+	fmt.Println("                   Exits with status 0 when they match, 1 when they differ")
+	fmt.Println("                   Example: scripts diff deploy ./deploy.sh")
+	fmt.Println()
+	fmt.Println("  update           Replace a managed script with a local file, only if the content differs:")
+	fmt.Println("                   the diff is shown, the replaced version is saved (up to keepVersions) and")
+	fmt.Println("                   the script keeps its permissions and install origin")
+	fmt.Println("                   Example: scripts update deploy ./deploy.sh")
+	fmt.Println()
+	fmt.Println("  versions         List the previous versions of a script saved by 'scripts update'")
+	fmt.Println()
+	fmt.Println("  outdated         List scripts and binaries installed from a tap, the registry or a URL whose")
+	fmt.Println("                   origin has changed since (run 'scripts tap update' first to refresh taps)")
+	fmt.Println()
+	fmt.Println("  upgrade          Reinstall outdated installs from their origin, showing each script's diff and")
+	fmt.Println("                   asking before replacing it (--yes applies without asking)")
+	fmt.Println("                   Examples: scripts upgrade rotate-logs")
+	fmt.Println("                             scripts upgrade --all --yes")
+	fmt.Println()
+	fmt.Println("  new              Create a bash script in scripts_bin with a shebang and 'set -euo pipefail'")
+	fmt.Println("                   - --from \"<command>\" wraps a one-liner or pipeline")
+	fmt.Println("                   - --from-history wraps your last shell command ($HISTFILE, bash, zsh or fish history)")
+	fmt.Println("                   - without either, creates a skeleton to edit; --force replaces an existing script")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts new biggest --from \"du -ah . | sort -rh | head -20\"")
+	fmt.Println("                     scripts new redeploy --from-history")
 	fmt.Println()
 	fmt.Println("  compile          Compile source code to binary in ~/opt/programs/")
-	fmt.Println("                   Supported: Go, Python, V, Rust, C, C++")
+	fmt.Println("                   Supported: Go, Python, V, Rust, C, C++, C#, Meson/CMake/Make projects")
 	fmt.Println("                   Use --name to specify custom binary name")
+	fmt.Println("                   Use --bin to pick a binary from a multi-binary Cargo crate")
+	fmt.Println("                   Directories with meson.build, CMakeLists.txt or a Makefile are built as projects;")
+	fmt.Println("                   use --artifact to choose the executable when several are produced")
+	fmt.Println("                   Arguments after -- are passed to the compiler verbatim")
+	fmt.Println("                   Use --dir to compile every source in a directory (--jobs N in parallel)")
+	fmt.Println("                   Use --watch to rebuild on save, adding --run to start the binary each time")
+	fmt.Println("                   Unchanged sources are skipped as up to date; --force rebuilds anyway")
+	fmt.Println("                   and --if-changed makes the check explicit (e.g. in cron jobs)")
+	fmt.Println("                   Use --backend to package Python with pyinstaller (default), nuitka, shiv or zipapp")
+	fmt.Println("                   Use --tags a,b for Go build tags and --features x,y for Cargo features")
+	fmt.Println("                   Use --build-env KEY=VAL (repeatable) to set compiler environment variables")
+	fmt.Println("                   Use --target wasm to build a WASI module (Go, Rust, C/C++ via clang) into ~/opt/programs/wasm")
+	fmt.Println("                   Use --static for statically linked Go, C, C++ and Rust (musl) binaries")
+	fmt.Println("                   Use --small for size-optimised flags, stripping and UPX packing (when installed)")
+	fmt.Println("                   Use --smoke to run the new binary with --help (or --smoke=\"<args>\") before")
+	fmt.Println("                   installing it; a failing build leaves the installed binary untouched")
+	fmt.Println("                   Compiler output goes to ~/opt/programs/.logs/<name>.log behind a progress line; a failed")
+	fmt.Println("                   build summarises the errors with their source lines (or shows the end of the log)")
+	fmt.Println("                   Use --verbose-build to stream the output instead")
+	fmt.Println("                   A missing compiler is reported with the brew/apt/dnf/pacman command that installs it;")
+	fmt.Println("                   --install-deps runs that command and continues the build")
 	fmt.Println("                   Examples:")
 	fmt.Println("                     scripts compile main.go")
 	fmt.Println("                     scripts compile main.go --name myapp")
+	fmt.Println("                     scripts compile ./cmd/mytool")
+	fmt.Println("                     scripts compile ./mycrate --bin server")
+	fmt.Println("                     scripts compile ./cproject --artifact tool")
+	fmt.Println("                     scripts compile --dir ./tools --jobs 4")
+	fmt.Println("                     scripts compile --watch main.go --run")
 	fmt.Println("                     scripts compile program.py --name tool")
+	fmt.Println("                     scripts compile program.py --backend zipapp")
 	fmt.Println("                     scripts compile hello.c -n utility")
+	fmt.Println("                     scripts compile main.go --small")
+	fmt.Println("                     scripts compile tool.c --static")
+	fmt.Println("                     scripts compile main.go --target wasm")
+	fmt.Println("                     scripts compile main.go --build-env GOARCH=arm64")
+	fmt.Println("                     scripts compile ./cmd/tool --tags sqlite,netgo")
+	fmt.Println("                     scripts compile ./mycrate --features tls")
+	fmt.Println("                     scripts compile main.go --smoke=\"--version\"")
+	fmt.Println("                     scripts compile hello.c -- -O3 -Wall -static")
+	fmt.Println()
+	fmt.Println("  compile-git      Shallow-clone a repository, detect its build system and install the binary")
+	fmt.Println("                   The repository URL, ref and commit are recorded with the binary")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts compile-git https://github.com/user/tool")
+	fmt.Println("                     scripts compile-git https://github.com/user/tool --ref v1.2.0 --path cmd/tool")
+	fmt.Println()
+	fmt.Println("  bin              Manage compiled binaries in ~/opt/programs")
+	fmt.Println("                   - list [--long] lists binaries, optionally with provenance")
+	fmt.Println("                   - info <name> shows source, language, compiler version, flags, build time and hash")
+	fmt.Println("                   - verify [<name>...] checks binaries against the checksum recorded at build time")
+	fmt.Println("                   - versions <name> lists the previous builds kept for rollback")
+	fmt.Println("                   - rollback <name> [<version>] restores the previous (or given) build")
+	fmt.Println("                   - rm <name> removes a binary, its build record and saved versions")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts bin info myapp")
+	fmt.Println("                     scripts bin verify")
+	fmt.Println("                     scripts bin rollback myapp")
+	fmt.Println("                     scripts bin rm myapp")
+	fmt.Println()
+	fmt.Println("  rebuild          Recompile binaries whose sources changed since they were built")
+	fmt.Println("                   Uses the build record kept for every compiled binary")
+	fmt.Println("                   --all rebuilds everything, --jobs sets the number of parallel builds")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts rebuild")
+	fmt.Println("                     scripts rebuild --all --jobs 4")
+	fmt.Println("                     scripts rebuild myapp")
+	fmt.Println()
+	fmt.Println("  containerize     Generate a Dockerfile wrapping a script or compiled binary and build a local image")
+	fmt.Println("                   - --base <image> picks the base image (default: alpine:latest; scratch for static binaries)")
+	fmt.Println("                   - --tag <tag> names the image (default: <name>:latest)")
+	fmt.Println("                   - --output <dir> only writes the Dockerfile and executable to <dir>")
+	fmt.Println("                   Uses docker, or podman if docker isn't installed")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts containerize myapp")
+	fmt.Println("                     scripts containerize myapp --base scratch --tag registry.local/myapp:1.0")
+	fmt.Println()
+	fmt.Println("  package          Package a compiled binary as a .deb, .rpm or .tar.gz with a .sha256 checksum file")
+	fmt.Println("                   - --format deb|rpm|tar.gz picks the package type (rpm needs rpmbuild)")
+	fmt.Println("                   - --version <v> sets the package version (default: the build date)")
+	fmt.Println("                   - --output <dir> writes the package to <dir> (default: current directory)")
+	fmt.Println("                   Packages install the binary to /usr/local/bin")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts package myapp --format deb --version 1.2.0")
+	fmt.Println("                     scripts package myapp --format tar.gz -o dist")
+	fmt.Println()
+	fmt.Println("  stats            Summarise recorded script runs: run count, success rate, average, p50 and p95")
+	fmt.Println("                   durations and last run per script")
+	fmt.Println("                   - <script_name> shows the details for one script")
+	fmt.Println("                   - --top [n] lists only the n most run scripts (default 10)")
+	fmt.Println("                   Every run is recorded in ~/.local/state/scripts/history.jsonl (see stateDir)")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts stats --top")
+	fmt.Println("                     scripts stats backup")
+	fmt.Println()
+	fmt.Println("  history          Export recorded script runs (run ID, script, start, duration and exit code)")
+	fmt.Println("                   - export --format json|csv writes them to stdout, or to --output <file>")
+	fmt.Println("                   - --since <age> keeps recent runs only (30d, 2w, 12h or a date such as 2026-01-31)")
+	fmt.Println("                   - --script <name> and --status ok|failed|<code> filter by script and exit status")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts history export --format csv --since 30d -o runs.csv")
+	fmt.Println("                     scripts history export --format json --script backup --status failed")
+	fmt.Println()
+	fmt.Println("  test             Run <script>_test.sh files from scripts_bin/ and scripts_bin/tests/")
+	fmt.Println("                   Each test_* function (or the whole file if it has none) runs in bash with a fresh")
+	fmt.Println("                   TMPDIR as its working directory; $SCRIPTS_DIR points at scripts_bin")
+	fmt.Println("                   Assertions: assert_eq, assert_ne, assert_contains, assert_file_exists,")
+	fmt.Println("                   assert_success, assert_failure, run (sets $status and $output) and fail")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts test")
+	fmt.Println("                     scripts test backup")
+	fmt.Println()
+	fmt.Println("  replay           Play back a run recorded with 'scripts --record <script_name>'")
+	fmt.Println("                   Takes the run ID printed after the run, or a script name for its latest recording")
+	fmt.Println("                   Recordings are asciicast v2 files in ~/.local/state/scripts/recordings, so")
+	fmt.Println("                   'asciinema play' can play them too; --speed <n> plays faster or slower")
+	fmt.Println("                   Examples:")
+	fmt.Println("                     scripts --record deploy production")
+	fmt.Println("                     scripts replay deploy --speed 2")
+	fmt.Println()
+	fmt.Println("  serve            Run an HTTP daemon (default 127.0.0.1:7878, or serve.addr in the config)")
+	fmt.Println("                   - GET /scripts lists scripts, POST /run/<name> runs one ({\"args\": [...]})")
+	fmt.Println("                     (with \"Accept: text/event-stream\", its output streams as server-sent events)")
+	fmt.Println("                   - GET /runs/<id> shows a finished run, GET /runs/<id>/log its output")
+	fmt.Println("                   - POST /compile compiles a source ({\"source\": \"...\", \"name\": \"...\"})")
+	fmt.Println("                   - GET /metrics exposes run and compile counters and durations for Prometheus")
+	fmt.Println("                   - GET /status is 503 while alert rules mark a script degraded, else 200")
+	fmt.Println("                   --grpc-addr (or serve.grpcAddr) also serves gRPC: listing and running scripts")
+	fmt.Println("                   with streamed output, run logs and history (see scriptspb/scripts.proto)")
+	fmt.Println("                   Set serve.token to require \"Authorization: Bearer <token>\" (except for /metrics")
+	fmt.Println("                   and /status); serve.tokens adds tokens limited to a role (run, or manage to")
+	fmt.Println("                   also compile) and to some scripts, e.g. one for CI that runs only deploy")
+	fmt.Println("                   With triggers set, messages on MQTT topics or NATS subjects run scripts, with")
+	fmt.Println("                   args from the message ({{payload}}, {{payload.<field>}}, {{topic}}, {{topic.<n>}})")
+	fmt.Println("                   With sync.auto, the daemon also pulls the scripts remote every sync.interval")
+	fmt.Println("                   Example: scripts serve --addr 0.0.0.0:7878")
+	fmt.Println()
+	fmt.Println("  run-wasm         Run a WASI module built with 'compile --target wasm' using wasmtime")
+	fmt.Println("                   The module can access the current directory")
+	fmt.Println("                   Example: scripts run-wasm mytool --verbose")
+	fmt.Println()
+	fmt.Println("  which            Print the path of a script, or of a binary in ~/opt/programs")
+	fmt.Println("                   (including language subdirectories when binLayout is \"language\")")
+	fmt.Println("                   Example: scripts which myapp")
 	fmt.Println()
 	fmt.Println("  rm               Remove script from scripts_bin or binary from ~/opt/programs")
-	fmt.Println("                   Use --bin to remove compiled binaries")
+	fmt.Println("                   Use --bin to remove compiled binaries (same as 'scripts bin rm')")
 	fmt.Println("                   Examples:")
 	fmt.Println("                     scripts rm myscript")
 	fmt.Println("                     scripts rm --bin myapp")
@@ -418,21 +784,41 @@ func printHelp() {
 	fmt.Println("  scripts compile main.go       # Compile Go program to binary")
 	fmt.Println("  scripts rm myscript           # Remove myscript.sh from scripts_bin")
 	fmt.Println("  scripts rm --bin myapp        # Remove myapp binary from ~/opt/programs")
+	fmt.Println("  scripts bin verify            # Check binaries haven't changed since they were built")
 	fmt.Println("  scripts help                  # Show this help")
 	fmt.Println()
 	fmt.Println("NOTES:")
 	fmt.Println("  - Scripts must be in the scripts_bin/ directory")
-	fmt.Println("  - Use 'scripts ready' if you get 'permission denied' errors")
+	fmt.Println("  - Set logForward to syslog or journald in the config to send script output to the system log")
+	fmt.Println("  - Use 'scripts ready' if scripts lose their execute bit (or set autoReady in the config)")
+	fmt.Println("  - Set normalizeNames in the config to find scripts ignoring case, dashes and underscores")
+	fmt.Println("  - Downloads honour HTTPS_PROXY, HTTP_PROXY and NO_PROXY; --offline before the command (or")
+	fmt.Println("    SCRIPTS_OFFLINE=1) makes anything needing the network fail at once, and registry searches use")
+	fmt.Println("    the index cached by the last successful fetch")
+	fmt.Println("  - --plain before the command (or SCRIPTS_PLAIN=1, or plain in the config) drops colours, spinners")
+	fmt.Println("    and symbols (PASS/FAIL replace ticks and crosses); questions are only asked when both stdin and")
+	fmt.Println("    stdout are terminals, falling back to defaults, --yes or a clear error otherwise")
+	fmt.Println("  - The first run in a terminal asks where scripts and binaries go (delete the config to ask again)")
+	fmt.Println("  - Set defaultCommand in the config (e.g. \"list\") to run it when scripts is run without arguments")
+	fmt.Println("  - Set strict in the config to reject unknown config keys, and flags scripts don't declare with")
+	fmt.Println("    '# scripts:flag', suggesting the closest match (e.g. scirptDir -> scriptDir)")
+	fmt.Println("  - Mark work-sensitive scripts '# scripts:visibility private' to keep them out of export-shell,")
+	fmt.Println("    publish and the sync remote (unless sync.encrypt names them); 'scripts list' marks them")
+	fmt.Println("  - '# scripts:owner <name>' and '# scripts:maintainer <name>...' say who looks after a script in")
+	fmt.Println("    a shared directory; add, update, rm, deprecate and archive warn anyone else ($SCRIPTS_USER")
+	fmt.Println("    or the login name) changing it")
+	fmt.Println("  - Set readOnly in the config (or SCRIPTS_READONLY=1) to only allow running scripts: add, rm, new,")
+	fmt.Println("    install, upgrade, update, ready, compile, rebuild, tap add/update/remove and bin rollback/rm are refused")
 	fmt.Println("  - Compiled binaries are placed in ~/opt/programs/ (add to PATH)")
-	fmt.Println("  - PyInstaller required for Python compilation")
+	fmt.Println("  - PyInstaller required for Python compilation (or choose another --backend)")
+	fmt.Println("  - Sources without an extension are detected from their shebang or first lines")
+	fmt.Println("  - Compiler commands can be overridden per language via \"compilers\" in .config.json")
+	fmt.Println("  - .NET SDK required for C# compilation (.cs or .csproj)")
 	fmt.Println("  - No sudo needed - uses your user permissions")
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		printHelp()
-		os.Exit(1)
-	}
+	os.Args = stripPlainFlag(stripOfflineFlag(os.Args))
 
 	// Load configuration
 	config, err := loadConfig()
@@ -441,7 +827,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	// A bare "scripts" runs the configured default command, if any
+	if len(os.Args) < 2 {
+		if config.DefaultCommand == "" {
+			printHelp()
+			os.Exit(1)
+		}
+		words, err := splitQuoted(config.DefaultCommand)
+		if err != nil || len(words) == 0 {
+			fmt.Printf("Error: invalid defaultCommand %q in the config\n", config.DefaultCommand)
+			os.Exit(1)
+		}
+		os.Args = append(os.Args, words...)
+	}
+
 	command := os.Args[1]
+	if config.Plain {
+		plain = true
+	}
+
+	if isReadOnly(config) {
+		if blocked := blockedCommand(os.Args[1:]); blocked != "" {
+			fmt.Printf("Error: 'scripts %s' is disabled in read-only mode (readOnly in the config, or %s)\n", blocked, readOnlyEnv)
+			os.Exit(1)
+		}
+	}
+
+	if command != "sync" && canPrompt() {
+		reportPulledScripts(config)
+		startAutoPull(config)
+	}
 
 	// Handle help commands
 	if command == "help" || command == "-h" || command == "--help" {
@@ -451,49 +866,111 @@ func main() {
 
 	if command == "ready" {
 		// Handle ready command (make scripts in scripts_bin executable)
+		usage := "Usage: scripts ready <script_name> [-a|--all] [--recursive] [--all-users] [--mode <octal>] [--check]"
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: scripts ready <script_name> [-a|--all]")
-			fmt.Println("  <script_name> makes script_name.sh in scripts_bin executable")
-			fmt.Println("  -a|--all makes all .sh files in scripts_bin executable")
+			fmt.Println(usage)
+			fmt.Println("  <script_name> makes script_name.sh (or script_name as given) in scripts_bin executable")
+			fmt.Println("  -a|--all makes all scripts in scripts_bin executable (.sh, .py, .rb, ... and files with a shebang)")
+			fmt.Println("  -r|--recursive includes scripts in subdirectories")
+			fmt.Println("  --all-users also sets the group and other execute bits")
+			fmt.Println("  --mode <octal> sets exact permission bits, e.g. 0755 (overrides the permissions config)")
+			fmt.Println("  --check only reports scripts that aren't executable (exit status 1 if any)")
 			os.Exit(1)
 		}
 
-		if os.Args[2] == "-a" || os.Args[2] == "--all" {
-			// Make all scripts in scripts_bin executable
-			if err := readyScripts([]string{config.ScriptDir}); err != nil {
-				fmt.Printf("Error: %v\n", err)
-				os.Exit(1)
-			}
-			return
-		}
-
-		// Handle specific script name (no flags allowed)
+		all, check, allUsers := false, false, false
+		mode := ""
+		readyOpts := &ReadyOptions{}
+		var names []string
 		for i := 2; i < len(os.Args); i++ {
 			arg := os.Args[i]
-			if strings.HasPrefix(arg, "-") {
+			switch {
+			case arg == "-a" || arg == "--all":
+				all = true
+			case arg == "-r" || arg == "--recursive":
+				readyOpts.Recursive = true
+			case arg == "--all-users":
+				allUsers = true
+			case arg == "--mode":
+				if i+1 >= len(os.Args) {
+					fmt.Println(usage)
+					os.Exit(1)
+				}
+				i++
+				mode = os.Args[i]
+			case arg == "--check":
+				check = true
+			case strings.HasPrefix(arg, "-"):
 				fmt.Printf("Unknown flag: %s\n", arg)
-				fmt.Println("Usage: scripts ready <script_name>")
+				fmt.Println(usage)
 				os.Exit(1)
+			default:
+				names = append(names, arg)
 			}
 		}
 
-		// Only one script name allowed
-		if len(os.Args) != 3 {
-			fmt.Println("Usage: scripts ready <script_name>")
+		// Only one script name allowed, and not together with --all; a lone
+		// --recursive or --all-users means every script
+		if len(names) > 1 || (all && len(names) > 0) {
+			fmt.Println(usage)
 			os.Exit(1)
 		}
 
-		scriptName := os.Args[2]
-		scriptPath := filepath.Join(config.ScriptDir, scriptName+".sh")
-
-		// Check if script exists in scripts_bin
-		if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-			fmt.Printf("Script %s not found in scripts_bin (%s)\n", scriptName, config.ScriptDir)
+		perms, err := resolvePermissions(config, mode)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
+		if allUsers {
+			perms.Execute = 0111
+		}
+		readyOpts.Perms = perms
+
+		paths := []string{config.ScriptDir}
+		if len(names) == 1 {
+			scriptPath := scriptInDir(config.ScriptDir, names[0])
+			if scriptPath == "" {
+				// Other interpreters' scripts are named with their extension
+				scriptPath = filepath.Join(config.ScriptDir, names[0])
+			}
+			// Check if script exists in scripts_bin
+			if info, err := os.Stat(scriptPath); err != nil || info.IsDir() {
+				fmt.Printf("Script %s not found in scripts_bin (%s)\n", names[0], config.ScriptDir)
+				os.Exit(1)
+			}
+			paths = []string{scriptPath}
+		}
+
+		if check {
+			// Report only, for verification steps in CI or dotfiles repos
+			checked, problems, err := checkScripts(paths, readyOpts)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if len(problems) > 0 {
+				fmt.Printf("%d of %d scripts are not ready (run 'scripts ready -a' to fix)\n", len(problems), checked)
+				os.Exit(1)
+			}
+			fmt.Printf("All %d scripts are executable\n", checked)
+			return
+		}
+
+		if len(names) == 0 {
+			// Make all scripts in scripts_bin executable
+			if err := readyScripts(paths, readyOpts); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
 
 		// Make the script executable
-		if err := makeExecutable(scriptPath); err != nil {
+		scriptName := names[0]
+		if err := perms.apply(paths[0]); err != nil {
 			fmt.Printf("Error making %s executable: %v\n", scriptName, err)
 			os.Exit(1)
 		}
@@ -504,111 +981,760 @@ func main() {
 
 	if command == "add" {
 		// Handle new add command (copy script to scripts_bin)
-		if len(os.Args) != 3 {
-			fmt.Println("Usage: scripts add <script.sh>")
+		var args []string
+		mode := ""
+		clipboard, yes := false, false
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--mode" && i+1 < len(os.Args):
+				i++
+				mode = os.Args[i]
+			case arg == "--clipboard":
+				clipboard = true
+			case arg == "--yes" || arg == "-y":
+				yes = true
+			case strings.HasPrefix(arg, "-"):
+				args = nil
+				i = len(os.Args)
+			default:
+				args = append(args, arg)
+			}
+		}
+		if len(args) != 1 {
+			fmt.Println("Usage: scripts add <script.sh> [--mode <octal>]")
+			fmt.Println("       scripts add --clipboard <name> [--yes] [--mode <octal>]")
 			fmt.Println("  Copy script to scripts_bin and make executable")
+			fmt.Println("  --clipboard: install the clipboard's contents as <name>.sh after previewing them")
+			fmt.Println("  --yes: install without asking for confirmation")
+			fmt.Println("  --mode: exact permission bits, e.g. 0755 (default: the permissions config)")
 			os.Exit(1)
 		}
 
-		scriptPath := os.Args[2]
-		if err := addScript(scriptPath, config); err != nil {
+		if clipboard {
+			name := strings.TrimSuffix(args[0], ".sh")
+			if !validName(name) {
+				fmt.Printf("Error: invalid script name %q\n", name)
+				os.Exit(1)
+			}
+			if err := addFromClipboard(name, yes, mode, config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		scriptPath := args[0]
+		if err := addScript(scriptPath, mode, config); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	if command == "compile" {
-		// Handle compile command
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: scripts compile <source> [--name <binary_name>]")
-			fmt.Println("  Compile source code to binary in ~/opt/programs/")
-			fmt.Println("  Supported: Go, Python, V, Rust, C, C++")
-			fmt.Println("  --name: specify custom binary name (default: source file name)")
-			os.Exit(1)
-		}
-
-		sourcePath := os.Args[2]
-		binaryName := "" // empty means use default name
+	if command == "tap" {
+		// Handle tap command group (shared script repositories)
+		runTapCommand(os.Args[2:], config)
+		return
+	}
 
-		// Parse optional --name flag
-		if len(os.Args) >= 4 {
-			if os.Args[3] == "--name" || os.Args[3] == "-n" {
-				if len(os.Args) != 5 {
-					fmt.Println("Usage: scripts compile <source> --name <binary_name>")
-					os.Exit(1)
-				}
-				binaryName = os.Args[4]
-			} else {
-				fmt.Println("Usage: scripts compile <source> [--name <binary_name>]")
+	if command == "install" {
+		// Handle install command (install a script from a tap or the registry)
+		usage := "Usage: scripts install <name|tap/script|url|archive> [--force] [--mode <octal>]"
+		var ref, mode string
+		force := false
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--force" || arg == "-f":
+				force = true
+			case arg == "--mode" && i+1 < len(os.Args):
+				i++
+				mode = os.Args[i]
+			case strings.HasPrefix(arg, "-") || ref != "":
+				fmt.Println(usage)
 				os.Exit(1)
+			default:
+				ref = arg
 			}
 		}
+		if ref == "" {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		install := installFromTap
+		if isArchive(ref) {
+			install = installArchive
+		} else if strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://") {
+			install = installFromURL
+		} else if !strings.Contains(ref, "/") {
+			install = installFromRegistry
+		}
+		if err := install(ref, force, mode, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-		if err := compileSource(sourcePath, binaryName, config); err != nil {
+	if command == "publish" {
+		// Handle publish command (share a script as an archive or through a tap)
+		opts, err := parsePublishArgs(os.Args[2:], config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: scripts publish <name> [--version <v>] [--output <dir> | --tap <tap>] [--include-private]")
+			os.Exit(1)
+		}
+		if err := publish(opts, config); err != nil {
 			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	if command == "rm" {
-		// Handle rm command
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: scripts rm <name> [--bin]")
-			fmt.Println("  Remove script from scripts_bin/ or binary from ~/opt/programs/")
-			fmt.Println("  Use --bin to remove compiled binaries")
+	if command == "update" {
+		// Handle update command (replace a managed script if its content changed)
+		if len(os.Args) != 4 {
+			fmt.Println("Usage: scripts update <name> <path>")
+			os.Exit(1)
+		}
+		if err := updateScript(os.Args[2], os.Args[3], config); err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
+		return
+	}
 
-		var name string
-		isBinary := false
+	if command == "versions" {
+		// Handle versions command (list a script's saved previous versions)
+		if len(os.Args) != 3 {
+			fmt.Println("Usage: scripts versions <name>")
+			os.Exit(1)
+		}
+		if err := printScriptVersions(strings.TrimSuffix(os.Args[2], ".sh"), config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-		// Check if second argument is a flag
-		if strings.HasPrefix(os.Args[2], "--") || strings.HasPrefix(os.Args[2], "-") {
-			if os.Args[2] == "--bin" || os.Args[2] == "-b" {
-				isBinary = true
-				if len(os.Args) < 4 {
-					fmt.Println("Usage: scripts rm --bin <binary_name>")
-					os.Exit(1)
-				}
-				name = os.Args[3]
-			} else {
-				fmt.Println("Usage: scripts rm <name> [--bin]")
-				os.Exit(1)
-			}
-		} else {
-			// os.Args[2] is the name
-			name = os.Args[2]
-			// Check for extra arguments
-			if len(os.Args) > 3 {
-				fmt.Println("Usage: scripts rm <name>")
+	if command == "diff" {
+		// Handle diff command (compare a managed script with a local file)
+		usage := "Usage: scripts diff <name> <path> [--color | --no-color]"
+		var positional []string
+		color := colorEnabled()
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "--color":
+				color = true
+			case arg == "--no-color":
+				color = false
+			case strings.HasPrefix(arg, "-"):
+				fmt.Println(usage)
 				os.Exit(1)
+			default:
+				positional = append(positional, arg)
 			}
 		}
+		if len(positional) != 2 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		differ, err := diffScript(positional[0], positional[1], color, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(2)
+		}
+		// Like diff, exit with status 1 when there are differences
+		if differ {
+			os.Exit(1)
+		}
+		return
+	}
 
-		if isBinary {
-			// Remove binary from ~/opt/programs
-			binPath := filepath.Join(config.BinDir, name)
-			if _, err := os.Stat(binPath); os.IsNotExist(err) {
-				fmt.Printf("Binary %s not found in %s\n", name, config.BinDir)
-				os.Exit(1)
+	if command == "outdated" {
+		// Handle outdated command (list installs with newer versions at their origin)
+		if len(os.Args) > 2 {
+			fmt.Println("Usage: scripts outdated")
+			os.Exit(1)
+		}
+		if err := printOutdated(config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "upgrade" {
+		// Handle upgrade command (reinstall scripts and binaries from their origin)
+		usage := "Usage: scripts upgrade <name>... | --all [--yes]"
+		var names []string
+		all, yes := false, false
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "--all":
+				all = true
+			case arg == "--yes" || arg == "-y":
+				yes = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				names = append(names, arg)
+			}
+		}
+		if all == (len(names) > 0) {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := upgrade(names, yes, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "search" {
+		// Handle search command (find scripts locally, in taps or the registry)
+		usage := "Usage: scripts search <term> [--remote]"
+		var term string
+		remote := false
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "--remote" || arg == "-r":
+				remote = true
+			case strings.HasPrefix(arg, "-") || term != "":
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				term = arg
+			}
+		}
+		if term == "" {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		search := searchLocal
+		if remote {
+			search = searchRegistry
+		}
+		if err := search(term, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "new" {
+		// Handle new command (create a script, e.g. from a one-liner)
+		opts, err := parseNewArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: scripts new <name> [--from \"<command>\" | --from-history] [--force] [--mode <octal>]")
+			os.Exit(1)
+		}
+		if err := newScript(opts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "adopt" {
+		// Handle adopt command (import a directory of loose scripts)
+		usage := "Usage: scripts adopt <dir> [--yes] [--link] [--dry-run]"
+		opts := &AdoptOptions{}
+		var dir string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "--yes" || arg == "-y":
+				opts.Yes = true
+			case arg == "--link":
+				opts.Link = true
+			case arg == "--dry-run":
+				opts.DryRun = true
+			case strings.HasPrefix(arg, "-") || dir != "":
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				dir = arg
+			}
+		}
+		if dir == "" {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := adopt(expandPath(dir), opts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "export-shell" {
+		// Handle export-shell command (shell functions for every script)
+		usage := "Usage: scripts export-shell [--format bash|zsh|fish] [--prefix <prefix>] [--include-private]"
+		var format, prefix string
+		includePrivate := false
+		args := os.Args[2:]
+		for len(args) > 0 {
+			switch {
+			case args[0] == "--include-private":
+				includePrivate = true
+				args = args[1:]
+				continue
+			case args[0] == "--format" && len(args) > 1:
+				format = args[1]
+			case args[0] == "--prefix" && len(args) > 1:
+				prefix = args[1]
+			default:
+				fmt.Println(usage)
+				os.Exit(1)
+			}
+			args = args[2:]
+		}
+		if err := exportShell(format, prefix, includePrivate, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "check" {
+		// Handle check command (health scan of scripts)
+		var names []string
+		all := false
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "-a" || arg == "--all":
+				all = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Printf("Error: unknown flag %s\n", arg)
+				os.Exit(1)
+			default:
+				names = append(names, arg)
+			}
+		}
+		if len(names) == 0 && !all {
+			fmt.Println("Usage: scripts check <name>... | -a")
+			fmt.Println("  Check scripts for problems: shebang, execute bit, syntax, shellcheck,")
+			fmt.Println("  declared dependencies and sourced files; -a checks every script")
+			os.Exit(1)
+		}
+		if err := runChecks(names, all, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "fsck" {
+		// Handle fsck command (find scripts and binaries that can't run)
+		if len(os.Args) != 2 {
+			fmt.Println("Usage: scripts fsck")
+			fmt.Println("  Find broken symlinks, scripts with missing interpreters and binaries")
+			fmt.Println("  with missing shared libraries")
+			os.Exit(1)
+		}
+		if err := runFsck(config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "doctor" {
+		// Handle doctor command (check the installation)
+		switch {
+		case len(os.Args) == 2:
+			if err := runDoctor(config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		case len(os.Args) == 3 && os.Args[2] == "--fix-path":
+			if err := fixPath(config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Println("Usage: scripts doctor [--fix-path]")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "compile" {
+		// Handle compile command
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: scripts compile <source> [--name <binary_name>] [-- <compiler flags>]")
+			fmt.Println("  Compile source code to binary in ~/opt/programs/")
+			fmt.Println("  Supported: Go, Python, V, Rust, C, C++, C#, Meson/CMake/Make projects")
+			fmt.Println("  --name: specify custom binary name (default: source file name)")
+			fmt.Println("  --dir: compile every supported source in a directory (--jobs for parallelism)")
+			fmt.Println("  --watch: rebuild on every save (--run starts the fresh binary after each build)")
+			fmt.Println("  --force: rebuild even if nothing changed")
+			fmt.Println("  --if-changed: only build when sources or flags changed (the default)")
+			fmt.Println("  --backend <name>: Python packager: pyinstaller (default), nuitka, shiv or zipapp")
+			fmt.Println("  --tags <list>: Go build tags (go build -tags)")
+			fmt.Println("  --features <list>: Cargo features (cargo build --features)")
+			fmt.Println("  --build-env KEY=VAL: set an environment variable for the compiler (repeatable)")
+			fmt.Println("  --target wasm: build a WASI module into the wasm directory instead of a native binary")
+			fmt.Println("  --static: link statically (CGO_ENABLED=0 for Go, -static for C/C++, musl for Rust)")
+			fmt.Println("  --small: optimise for size, strip symbols and pack with UPX when available")
+			fmt.Println("  --smoke[=\"<args>\"]: run the new binary once (default --help) and only install it if it succeeds")
+			fmt.Println("  --verbose-build: stream compiler output instead of only writing it to the build log")
+			fmt.Println("  --mode <octal>: exact permission bits for the binary, e.g. 0755 (default: the permissions config)")
+			fmt.Println("  --install-deps: install a missing compiler with the system package manager")
+			fmt.Println("  --: pass everything after it to the compiler verbatim")
+			os.Exit(1)
+		}
+
+		opts, err := parseCompileArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: scripts compile <source> [--name <binary_name>] [-- <compiler flags>]")
+			os.Exit(1)
+		}
+
+		if opts.Dir != "" {
+			fmt.Printf("Compiling sources in %s:\n", opts.Dir)
+			outcomes, err := compileDir(opts, config)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if len(outcomes) == 0 {
+				fmt.Println("  No supported sources found.")
+				return
+			}
+			fmt.Println()
+			if failed := printBuildSummary(outcomes); failed > 0 {
+				fmt.Printf("\n%d of %d builds failed\n", failed, len(outcomes))
+				os.Exit(1)
+			}
+			return
+		}
+
+		if opts.Watch {
+			if err := watchAndCompile(opts, opts.Run, config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		if _, err := compileSource(opts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		pathHint(config)
+		return
+	}
+
+	if command == "compile-git" {
+		// Handle compile-git command (build a binary straight from a repository)
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: scripts compile-git <url> [--ref <ref>] [--path <dir>] [--name <binary_name>] [-- <compiler flags>]")
+			fmt.Println("  Shallow-clone a repository, build it and install the binary to ~/opt/programs/")
+			fmt.Println("  --ref: branch, tag or commit to build (default: the remote's HEAD)")
+			fmt.Println("  --path: directory inside the repository to build (e.g. cmd/tool)")
+			os.Exit(1)
+		}
+
+		opts, origin, err := parseCompileGitArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: scripts compile-git <url> [--ref <ref>] [--path <dir>] [--name <binary_name>] [-- <compiler flags>]")
+			os.Exit(1)
+		}
+
+		if _, err := compileFromGit(opts, origin, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "containerize" {
+		// Handle containerize command (wrap a script or binary in an OCI image)
+		opts, err := parseContainerizeArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: scripts containerize <name> [--base <image>] [--tag <tag>] [--output <dir>]")
+			os.Exit(1)
+		}
+		if err := containerize(opts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "package" {
+		// Handle package command (build a deb, rpm or tarball from a binary)
+		opts, err := parsePackageArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			fmt.Println("Usage: scripts package <binary> --format deb|rpm|tar.gz [--version <v>] [--output <dir>]")
+			os.Exit(1)
+		}
+		if err := packageBinary(opts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "test" {
+		// Handle test command (run the tests of scripts in scripts_bin)
+		if len(os.Args) > 3 || (len(os.Args) == 3 && strings.HasPrefix(os.Args[2], "-")) {
+			fmt.Println("Usage: scripts test [<script_name>]")
+			os.Exit(1)
+		}
+		name := ""
+		if len(os.Args) == 3 {
+			name = os.Args[2]
+		}
+		tests, err := discoverTests(name, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(tests) == 0 {
+			if name != "" {
+				fmt.Printf("No tests found for %s (expected %s%s or %s/%s%s)\n", name, name, testSuffix, testDir, name, testSuffix)
+				os.Exit(1)
+			}
+			fmt.Printf("No tests found (add <script>%s files to scripts_bin or scripts_bin/%s)\n", testSuffix, testDir)
+			return
+		}
+		failed, err := runTests(tests, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println()
+		if failed > 0 {
+			fmt.Println(colorize(colorRed, fmt.Sprintf("%d passed, %d failed", len(tests)-failed, failed)))
+			os.Exit(1)
+		}
+		fmt.Println(colorize(colorGreen, fmt.Sprintf("%d passed", len(tests))))
+		return
+	}
+
+	if command == "serve" {
+		// Handle serve command (run the HTTP daemon)
+		addr, grpcAddr := "", ""
+		for i := 2; i < len(os.Args); i++ {
+			if (os.Args[i] != "--addr" && os.Args[i] != "--grpc-addr") || i+1 >= len(os.Args) {
+				fmt.Println("Usage: scripts serve [--addr <host:port>] [--grpc-addr <host:port>]")
+				os.Exit(1)
+			}
+			if os.Args[i] == "--addr" {
+				addr = os.Args[i+1]
+			} else {
+				grpcAddr = os.Args[i+1]
+			}
+			i++
+		}
+		if err := serve(addr, grpcAddr, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "replay" {
+		// Handle replay command (play back a run recorded with --record)
+		usage := "Usage: scripts replay <run-id|script_name> [--speed <n>]"
+		speed := 1.0
+		var target string
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--speed" && i+1 < len(os.Args):
+				i++
+				n, err := strconv.ParseFloat(os.Args[i], 64)
+				if err != nil || n <= 0 {
+					fmt.Printf("Error: invalid --speed %q\n", os.Args[i])
+					os.Exit(1)
+				}
+				speed = n
+			case strings.HasPrefix(arg, "-") || target != "":
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				target = arg
+			}
+		}
+		if target == "" {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := replaySession(target, speed, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "history" {
+		// Handle history command group (export the run history)
+		runHistoryCommand(os.Args[2:], config)
+		return
+	}
+
+	if command == "stats" {
+		// Handle stats command (summarise the run history)
+		top := 0
+		var name string
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--top":
+				top = 10
+				if i+1 < len(os.Args) && !strings.HasPrefix(os.Args[i+1], "-") {
+					n, err := strconv.Atoi(os.Args[i+1])
+					if err != nil || n < 1 {
+						fmt.Printf("Error: invalid --top count %q\n", os.Args[i+1])
+						os.Exit(1)
+					}
+					top = n
+					i++
+				}
+			case strings.HasPrefix(arg, "-"):
+				fmt.Printf("Unknown flag: %s\n", arg)
+				fmt.Println("Usage: scripts stats [<script_name>] [--top [n]]")
+				os.Exit(1)
+			case name == "":
+				name = arg
+			default:
+				fmt.Println("Usage: scripts stats [<script_name>] [--top [n]]")
+				os.Exit(1)
+			}
+		}
+		if name != "" && top > 0 {
+			fmt.Println("Usage: scripts stats [<script_name>] [--top [n]]")
+			os.Exit(1)
+		}
+
+		var err error
+		if name != "" {
+			err = printScriptStats(name, config)
+		} else {
+			err = printStats(top, config)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "rebuild" {
+		// Handle rebuild command (recompile tracked binaries)
+		all := false
+		jobs := 0
+		var names []string
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--all" || arg == "-a":
+				all = true
+			case arg == "--jobs" || arg == "-j":
+				if i+1 >= len(os.Args) {
+					fmt.Println("Usage: scripts rebuild [<binary>...] [--all] [--jobs <n>]")
+					os.Exit(1)
+				}
+				i++
+				n, err := parseJobs(os.Args[i])
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				jobs = n
+			case strings.HasPrefix(arg, "-"):
+				fmt.Printf("Unknown flag: %s\n", arg)
+				fmt.Println("Usage: scripts rebuild [<binary>...] [--all] [--jobs <n>]")
+				os.Exit(1)
+			default:
+				names = append(names, arg)
+			}
+		}
+
+		fmt.Println("Rebuilding binaries:")
+		outcomes, err := rebuildBinaries(names, all, jobs, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(outcomes) == 0 {
+			fmt.Println("  No tracked binaries. Compile something with 'scripts compile' first.")
+			return
+		}
+		fmt.Println()
+		if failed := printBuildSummary(outcomes); failed > 0 {
+			fmt.Printf("\n%d of %d rebuilds failed\n", failed, len(outcomes))
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "rm" {
+		// Handle rm command
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: scripts rm <name> [--bin]")
+			fmt.Println("  Remove script from scripts_bin/ or binary from ~/opt/programs/")
+			fmt.Println("  Use --bin to remove compiled binaries")
+			os.Exit(1)
+		}
+
+		var name string
+		isBinary := false
+
+		// Check if second argument is a flag
+		if strings.HasPrefix(os.Args[2], "--") || strings.HasPrefix(os.Args[2], "-") {
+			if os.Args[2] == "--bin" || os.Args[2] == "-b" {
+				isBinary = true
+				if len(os.Args) < 4 {
+					fmt.Println("Usage: scripts rm --bin <binary_name>")
+					os.Exit(1)
+				}
+				name = os.Args[3]
+			} else {
+				fmt.Println("Usage: scripts rm <name> [--bin]")
+				os.Exit(1)
+			}
+		} else {
+			// os.Args[2] is the name
+			name = os.Args[2]
+			// Check for extra arguments
+			if len(os.Args) > 3 {
+				fmt.Println("Usage: scripts rm <name>")
+				os.Exit(1)
 			}
+		}
 
-			if err := os.Remove(binPath); err != nil {
-				fmt.Printf("Error removing binary %s: %v\n", name, err)
+		if isBinary {
+			// Remove binary from ~/opt/programs (same as "scripts bin rm")
+			if err := removeBinary(name, config); err != nil {
+				fmt.Printf("Error: %v\n", err)
 				os.Exit(1)
 			}
 
 			fmt.Printf("Removed binary %s\n", name)
 		} else {
-			// Remove script from scripts_bin
-			scriptPath := filepath.Join(config.ScriptDir, name+".sh")
-			if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-				fmt.Printf("Script %s not found in %s\n", name, config.ScriptDir)
+			// Remove script from scripts_bin, by name or full file name
+			scriptPath := scriptInDir(config.ScriptDir, name)
+			if scriptPath == "" {
+				if system, err := findScript(name, config); err == nil && filepath.Dir(system) != config.ScriptDir {
+					fmt.Printf("%s is a system-wide script (%s); only an administrator can remove it\n", name, system)
+				} else {
+					fmt.Printf("Script %s not found in %s\n", name, config.ScriptDir)
+				}
 				os.Exit(1)
 			}
 
+			warnOwner(name, scriptPath)
 			if err := os.Remove(scriptPath); err != nil {
 				fmt.Printf("Error removing script %s: %v\n", name, err)
 				os.Exit(1)
@@ -619,93 +1745,476 @@ func main() {
 		return
 	}
 
-	if command == "list" {
-		// Handle list command (show available scripts and binaries)
-		if len(os.Args) > 2 {
-			fmt.Println("Usage: scripts list")
-			fmt.Println("  Show all available scripts in scripts_bin/ and binaries in ~/opt/programs/")
+	if command == "archive" || command == "unarchive" {
+		// Handle archive and unarchive commands (put scripts away without deleting them)
+		if len(os.Args) != 3 || strings.HasPrefix(os.Args[2], "-") {
+			fmt.Printf("Usage: scripts %s <name>\n", command)
+			if command == "archive" {
+				fmt.Println("  Move a script to scripts_bin/archived/, where it isn't run or listed")
+			} else {
+				fmt.Println("  Move an archived script back into scripts_bin/")
+			}
 			os.Exit(1)
 		}
+		archive := archiveScript
+		if command == "unarchive" {
+			archive = unarchiveScript
+		}
+		if err := archive(os.Args[2], config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-		hasOutput := false
-
-		// List scripts
-		if _, err := os.Stat(config.ScriptDir); err == nil {
-			// Get all .sh files in scripts_bin
-			files, err := filepath.Glob(filepath.Join(config.ScriptDir, "*.sh"))
-			if err == nil && len(files) > 0 {
-				fmt.Println("Available scripts:")
-				for _, file := range files {
-					scriptName := strings.TrimSuffix(filepath.Base(file), ".sh")
-					status := "not executable"
-					if isExecutable(file) {
-						status = "executable"
-					}
-					fmt.Printf("  %s (%s)\n", scriptName, status)
-				}
-				hasOutput = true
-			}
-		}
-
-		// List binaries
-		if _, err := os.Stat(config.BinDir); err == nil {
-			// Get all files in bin directory (excluding directories and the scripts binary itself)
-			entries, err := os.ReadDir(config.BinDir)
-			if err == nil {
-				var binaries []string
-				for _, entry := range entries {
-					if !entry.IsDir() && entry.Name() != "scripts" {
-						// Check if it's executable
-						binPath := filepath.Join(config.BinDir, entry.Name())
-						if isExecutable(binPath) {
-							binaries = append(binaries, entry.Name())
-						}
-					}
+	if command == "deprecate" {
+		// Handle deprecate command (warn when a script is run)
+		usage := "Usage: scripts deprecate <name> [--use <other>] [--message <text>] [--undo]"
+		var name, use, message string
+		undo := false
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case (arg == "--use" || arg == "--message") && i+1 < len(os.Args):
+				i++
+				if arg == "--use" {
+					use = os.Args[i]
+				} else {
+					message = os.Args[i]
 				}
+			case arg == "--undo":
+				undo = true
+			case strings.HasPrefix(arg, "-") || name != "":
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				name = arg
+			}
+		}
+		if name == "" || (undo && (use != "" || message != "")) {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := deprecateScript(name, use, message, undo, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-				if len(binaries) > 0 {
-					if hasOutput {
-						fmt.Println()
-					}
-					fmt.Printf("Available binaries (%s):\n", config.BinDir)
-					for _, binary := range binaries {
-						fmt.Printf("  %s\n", binary)
-					}
-					hasOutput = true
-				}
+	if command == "sync" {
+		// Handle sync command (merge the scripts directory with its git remote)
+		if len(os.Args) > 2 && os.Args[2] == "status" {
+			if len(os.Args) != 3 {
+				fmt.Println("Usage: scripts sync status")
+				fmt.Println("  Show scripts only here, only on the remote, or modified, and the last sync")
+				os.Exit(1)
+			}
+			if err := printSyncStatus(config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		usage := func() {
+			fmt.Println("Usage: scripts sync [--ours|--theirs] | scripts sync --pull [--quiet] | scripts sync status")
+			fmt.Println("  Commit local changes, merge the remote's and push; scripts changed on")
+			fmt.Println("  both machines are shown as a diff to keep ours, take theirs or edit")
+			fmt.Println("  --pull only fast-forwards to the remote, --quiet leaving what changed")
+			fmt.Println("  to be reported on the next invocation")
+			os.Exit(1)
+		}
+		opts := &SyncOptions{}
+		for _, arg := range os.Args[2:] {
+			switch {
+			case (arg == "--ours" || arg == "--theirs") && opts.Resolve == resolveAsk:
+				opts.Resolve = strings.TrimPrefix(arg, "--")
+			case arg == "--pull":
+				opts.Pull = true
+			case arg == "--quiet":
+				opts.Quiet = true
+			default:
+				usage()
+			}
+		}
+		if (opts.Pull && opts.Resolve != resolveAsk) || (opts.Quiet && !opts.Pull) {
+			usage()
+		}
+		var err error
+		if opts.Pull {
+			err = runPull(opts.Quiet, config)
+		} else {
+			err = runSync(opts, config)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "bin" {
+		// Handle bin command group (manage compiled binaries)
+		runBinCommand(os.Args[2:], config)
+		return
+	}
+
+	if command == "run-wasm" {
+		// Handle run-wasm command (run a WASI module built with --target wasm)
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: scripts run-wasm <name> [args...]")
+			os.Exit(1)
+		}
+		if err := runWasm(os.Args[2], os.Args[3:], config); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
 			}
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		if !hasOutput {
-			fmt.Println("No scripts or binaries found.")
-			fmt.Printf("Scripts directory: %s\n", config.ScriptDir)
-			fmt.Printf("Binaries directory: %s\n", config.BinDir)
+	if command == "chain" {
+		// Handle chain command (run scripts one after another)
+		usage := "Usage: scripts chain <script> [<script>...] [--stop-on-fail]"
+		stopOnFail := false
+		var steps []string
+		for _, arg := range os.Args[2:] {
+			switch {
+			case arg == "--stop-on-fail":
+				stopOnFail = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				steps = append(steps, arg)
+			}
+		}
+		if len(steps) == 0 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := runChain(steps, stopOnFail, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
 		return
 	}
 
-	// Handle running scripts
-	scriptName := command
-	scriptPath := filepath.Join(config.ScriptDir, scriptName+".sh")
+	if command == "preset" {
+		// Handle preset command group (saved arguments per script)
+		runPresetCommand(os.Args[2:], config)
+		return
+	}
 
-	// Check if the script exists
-	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-		fmt.Printf("Script %s not found in %s\n", scriptName, config.ScriptDir)
-		os.Exit(1)
+	if command == "var" {
+		// Handle var command group (stored template variables)
+		runVarCommand(os.Args[2:], config)
+		return
+	}
+
+	if command == "env" {
+		// Handle env command (preview the environment a script would get)
+		usage := "Usage: scripts env [--sudo | --user <name>] <script_name>"
+		opts, args, err := parseRunArgs(os.Args[2:])
+		if err != nil || len(args) != 1 || opts.Record || opts.NoPrompt || opts.SkipUnchanged || opts.Force || opts.Wait || opts.Queue {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := printScriptEnv(args[0], opts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "fetch" {
+		// Handle fetch command (download through the cache, printing the path)
+		usage := "Usage: scripts fetch <url> [--sha256 <hex>] [--refresh]"
+		var location, sum string
+		refresh := false
+		for i := 2; i < len(os.Args); i++ {
+			arg := os.Args[i]
+			switch {
+			case arg == "--sha256" && i+1 < len(os.Args):
+				i++
+				sum = os.Args[i]
+			case arg == "--refresh":
+				refresh = true
+			case strings.HasPrefix(arg, "-") || location != "":
+				fmt.Println(usage)
+				os.Exit(1)
+			default:
+				location = arg
+			}
+		}
+		if location == "" {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		path, err := fetchCached(location, sum, refresh, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(path)
+		return
+	}
+
+	if command == "cache" {
+		// Handle cache command (inspect and clean the download cache)
+		usage := "Usage: scripts cache list | clean [--older-than <age>]"
+		if len(os.Args) == 3 && (os.Args[2] == "list" || os.Args[2] == "ls") {
+			if err := printCache(config); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if len(os.Args) < 3 || os.Args[2] != "clean" || (len(os.Args) != 3 && (len(os.Args) != 5 || os.Args[3] != "--older-than")) {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		olderThan := ""
+		if len(os.Args) == 5 {
+			olderThan = os.Args[4]
+		}
+		if err := cleanCache(olderThan, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "hooks" {
+		// Handle hooks command group (git hooks built from managed scripts)
+		runHooksCommand(os.Args[2:], config)
+		return
+	}
+
+	if command == "shell" {
+		// Handle shell command (an interactive prompt for scripts commands)
+		if len(os.Args) != 2 {
+			fmt.Println("Usage: scripts shell")
+			os.Exit(1)
+		}
+		if err := runShell(config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "completion" {
+		// Handle completion command (print a shell completion script)
+		if len(os.Args) != 3 {
+			fmt.Println("Usage: scripts completion bash|zsh|fish")
+			os.Exit(1)
+		}
+		script, err := completionScript(os.Args[2])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(script)
+		return
+	}
+
+	if command == "__complete" {
+		// Handle __complete (the candidates completion scripts ask for)
+		for _, candidate := range complete(os.Args[2:], config) {
+			fmt.Println(candidate)
+		}
+		return
+	}
+
+	if command == "which" {
+		// Handle which command (print where a script or binary lives)
+		if len(os.Args) != 3 {
+			fmt.Println("Usage: scripts which <name>")
+			os.Exit(1)
+		}
+		name := os.Args[2]
+		if scriptPath, err := findScript(name, config); err == nil {
+			fmt.Println(scriptPath)
+			return
+		}
+		binPath, err := binaryPath(name, config)
+		if err != nil {
+			fmt.Printf("%s is neither a script in %s nor a binary in %s\n", name, config.ScriptDir, config.BinDir)
+			os.Exit(1)
+		}
+		fmt.Println(binPath)
+		return
+	}
+
+	if command == "list" {
+		// Handle list command (show available scripts and binaries)
+		usage := func() {
+			fmt.Println("Usage: scripts list [--long] [--archived] [--owner <name>] [--format table|json|plain]")
+			fmt.Println("  Show all available scripts in scripts_bin/ and binaries in ~/opt/programs/")
+			fmt.Println("  --long: include where each binary was built from")
+			fmt.Println("  --archived: include scripts put away with 'scripts archive'")
+			fmt.Println("  --owner: only scripts <name> owns or maintains (# scripts:owner, # scripts:maintainer)")
+			fmt.Println("  --format: table (the default), json, or plain names one per line")
+			fmt.Println("  Broken symlinks, missing interpreters and missing libraries are flagged (see 'scripts fsck')")
+			os.Exit(1)
+		}
+		listOpts := &ListOptions{Format: "table"}
+		for i := 2; i < len(os.Args); i++ {
+			switch arg := os.Args[i]; {
+			case arg == "--long" || arg == "-l":
+				listOpts.Long = true
+			case arg == "--archived":
+				listOpts.Archived = true
+			case arg == "--owner" && i+1 < len(os.Args):
+				i++
+				listOpts.Owner = os.Args[i]
+			case arg == "--format" && i+1 < len(os.Args):
+				i++
+				listOpts.Format = os.Args[i]
+				if !slices.Contains(listFormats, listOpts.Format) {
+					fmt.Printf("Error: unknown format %q (use table, json or plain)\n", listOpts.Format)
+					os.Exit(1)
+				}
+			default:
+				usage()
+			}
+		}
+		if err := printList(listOpts, config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Check if the script is executable
-	if !isExecutable(scriptPath) {
-		fmt.Printf("Script %s is not executable. Run 'scripts ready %s' to make it executable.\n", scriptName, scriptName)
+	// Handle running scripts, after any run flags
+	runOpts, runArgs, err := parseRunArgs(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		fmt.Println(runUsage)
 		os.Exit(1)
 	}
+	// Find the script, the user's taking precedence over system-wide ones
+	scriptPath, err := findScript(runArgs[0], config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// With normalizeNames the script may be named differently than typed
+	scriptName := strings.TrimSuffix(filepath.Base(scriptPath), ".sh")
+	if err := warnDeprecated(scriptName, scriptPath, runOpts.Strict); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	pathHint(config)
 
-	// Execute the script
-	cmd := exec.Command(scriptPath, os.Args[2:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	// Execute the script, with its preset's arguments for "@<preset>"
+	args, err := expandPreset(scriptName, runArgs[1:], config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if config.Strict {
+		if err := checkScriptFlags(scriptPath, args); err != nil {
+			fmt.Printf("Error: %s: %v\n", scriptName, err)
+			os.Exit(1)
+		}
+	}
+	args, err = scriptArgs(scriptPath, args, runOpts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Exclusive scripts hold their lock until scripts exits; a queued run
+	// then decides on skipping and cooldowns after the run it waited for
+	unlock, err := lockRun(scriptName, scriptPath, runOpts.Queue, config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer unlock()
+	if runOpts.SkipUnchanged {
+		skipped, err := skipUnchanged(scriptName, scriptPath, args, runOpts, config)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if skipped {
+			return
+		}
+	}
+	if err := checkCooldown(scriptName, scriptPath, runOpts, config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := runPrerequisites(scriptName, scriptPath, config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Templates run as a rendered copy, removed once the run is over
+	renderedPath, removeRendered, err := renderTemplate(scriptPath, runOpts.Vars, runOpts.User, config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	workdir, err := scriptWorkdir(scriptPath, runOpts.Cwd, config)
+	if err != nil {
+		removeRendered()
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	cmd, err := scriptCommand(renderedPath, args, config)
+	if err == nil {
+		cmd.Dir = workdir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if runOpts.User != "" {
+			cmd, err = asUser(cmd, runOpts.User, config)
+		} else if runOpts.Sudo {
+			cmd, err = withSudo(cmd, config)
+		}
+	}
+	if err != nil {
+		removeRendered()
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if runOpts.JSON {
+		// Keep stdout for the result
+		cmd.Stdout = os.Stderr
+	}
+	finishFilters := func() error { return nil }
+	if len(runOpts.Filters) > 0 {
+		cmd.Stdout, finishFilters = filterOutput(runOpts.Filters, cmd.Stdout)
+	}
+	tail := newStderrTail(failureTailLines)
+	cmd.Stderr = teeWriter(cmd.Stderr, tail.writer)
+	record, err := runRecorded(scriptName, cmd, runOpts, config)
+	removeRendered()
+	if filterErr := finishFilters(); filterErr != nil && err == nil {
+		fmt.Printf("Error: %v\n", filterErr)
+		os.Exit(1)
+	}
+	if runOpts.JSON && record.ExitCode != -1 {
+		if err := printRunResult(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if record.ExitCode != 0 {
+			os.Exit(1)
+		}
+		return
+	}
 	if err != nil {
+		if runOpts.User != "" && errors.Is(err, os.ErrPermission) {
+			fmt.Printf("Error: %s can't run %s: %v (the script and its directory must be readable by them)\n", runOpts.User, scriptName, err)
+			os.Exit(1)
+		}
+		if record.ExitCode > 0 {
+			printFailure(record, tail, config)
+			os.Exit(1)
+		}
 		fmt.Printf("Error running script %s: %v\n", scriptName, err)
 		os.Exit(1)
 	}
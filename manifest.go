@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestFile is the central record of compiled binaries, kept in BinDir
+// next to the binaries it describes
+const manifestFile = ".scripts-manifest.json"
+
+// GitOrigin records where a binary built by compile-git came from
+type GitOrigin struct {
+	URL    string `json:"url"`
+	Ref    string `json:"ref,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Commit string `json:"commit"`
+}
+
+// BinaryRecord describes how a binary in BinDir was produced
+type BinaryRecord struct {
+	Source          string     `json:"source"`
+	Language        string     `json:"language"`
+	BuiltAt         time.Time  `json:"builtAt"`
+	Hash            string     `json:"hash,omitempty"`        // sources plus build settings
+	Checksum        string     `json:"checksum,omitempty"`    // sha256 of the installed binary
+	Flags           []string   `json:"flags,omitempty"`       // command line compiler flags
+	ConfigFlags     []string   `json:"configFlags,omitempty"` // default flags from config
+	Compiler        string     `json:"compiler,omitempty"`
+	CompilerVersion string     `json:"compilerVersion,omitempty"`
+	CargoBin        string     `json:"cargoBin,omitempty"`
+	Artifact        string     `json:"artifact,omitempty"`
+	Small           bool       `json:"small,omitempty"`    // built with --small
+	Static          bool       `json:"static,omitempty"`   // built with --static
+	BuildEnv        []string   `json:"buildEnv,omitempty"` // command line --build-env pairs
+	Tags            string     `json:"tags,omitempty"`     // Go build tags
+	Features        string     `json:"features,omitempty"` // Cargo features
+	Backend         string     `json:"backend,omitempty"`  // Python packaging backend
+	Target          string     `json:"target,omitempty"`   // "wasm" for WASI modules
+	Mode            string     `json:"mode,omitempty"`     // --mode permission bits
+	Signed          string     `json:"signed,omitempty"`   // codesign identity (macOS)
+	Notarized       bool       `json:"notarized,omitempty"`
+	WorkDir         string     `json:"workDir,omitempty"` // for Go import paths
+	Git             *GitOrigin `json:"git,omitempty"`
+}
+
+// Manifest maps binary names to their build records and saved versions
+type Manifest struct {
+	Binaries map[string]*BinaryRecord    `json:"binaries"`
+	Versions map[string][]*BinaryVersion `json:"versions,omitempty"`
+}
+
+func manifestPath(config *Config) string {
+	return filepath.Join(config.BinDir, manifestFile)
+}
+
+// loadManifest reads the manifest, returning an empty one if none exists yet
+func loadManifest(config *Config) (*Manifest, error) {
+	manifest := &Manifest{Binaries: map[string]*BinaryRecord{}}
+	data, err := os.ReadFile(manifestPath(config))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if manifest.Binaries == nil {
+		manifest.Binaries = map[string]*BinaryRecord{}
+	}
+	return manifest, nil
+}
+
+func saveManifest(manifest *Manifest, config *Config) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := os.WriteFile(manifestPath(config), data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// manifestMu serialises manifest updates from concurrent builds
+var manifestMu sync.Mutex
+
+// recordBinary stores (or replaces) the build record for a binary
+func recordBinary(name string, record *BinaryRecord, config *Config) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return err
+	}
+	manifest.Binaries[name] = record
+	return saveManifest(manifest, config)
+}
+
+// forgetBinary drops a binary's build record and saved versions, if it has any
+func forgetBinary(name string, config *Config) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return err
+	}
+	_, hasRecord := manifest.Binaries[name]
+	_, hasVersions := manifest.Versions[name]
+	if !hasRecord && !hasVersions {
+		return nil
+	}
+	delete(manifest.Binaries, name)
+	delete(manifest.Versions, name)
+	return saveManifest(manifest, config)
+}
+
+// hashSkipDirs are build output and VCS directories that never count as
+// sources when hashing a project directory
+var hashSkipDirs = map[string]bool{
+	".git":         true,
+	"target":       true,
+	"build":        true,
+	"builddir":     true,
+	"bin":          true,
+	"obj":          true,
+	"dist":         true,
+	"node_modules": true,
+	"__pycache__":  true,
+}
+
+// buildHash fingerprints everything that influences a build: the source
+// contents, the language, the compiler command and all flags and options.
+// An empty result means the sources cannot be fingerprinted (e.g. a Go
+// import path), in which case the binary is always rebuilt.
+func buildHash(sourcePath, lang string, flags, env []string, opts *CompileOptions, cargo *CargoTarget, config *Config) string {
+	root := sourcePath
+	if cargo != nil {
+		root = filepath.Dir(cargo.ManifestPath)
+	}
+
+	h := sha256.New()
+	info, err := os.Stat(root)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		if err := hashDir(h, root); err != nil {
+			return ""
+		}
+	} else if err := hashFile(h, root); err != nil {
+		return ""
+	}
+
+	// Building a different source into the same binary is always a change
+	identity, _ := filepath.Abs(sourcePath)
+	if opts.Origin != nil {
+		identity = opts.Origin.URL + "#" + opts.Origin.Path
+	}
+	fmt.Fprintf(h, "\x00source=%s", identity)
+
+	tool := lang
+	if cargo != nil {
+		tool = "cargo"
+	}
+	fmt.Fprintf(h, "\x00lang=%s\x00compiler=%s\x00flags=%s", lang, strings.Join(resolveCompiler(tool, config), " "), strings.Join(flags, "\x00"))
+	fmt.Fprintf(h, "\x00bin=%s\x00artifact=%s", opts.CargoBin, opts.Artifact)
+	if len(env) > 0 {
+		fmt.Fprintf(h, "\x00env=%s", strings.Join(env, "\x00"))
+	}
+	if opts.Small {
+		// Stripping and packing happen after the compiler runs
+		fmt.Fprintf(h, "\x00small")
+	}
+	if opts.Target != "" {
+		fmt.Fprintf(h, "\x00target=%s", opts.Target)
+	}
+	if codesignEnabled(tool, opts, config) {
+		// A newly configured identity should sign existing binaries on rebuild
+		fmt.Fprintf(h, "\x00codesign=%s\x00notary=%s", config.CodesignIdentity, config.NotaryProfile)
+	}
+	if opts.Static {
+		// Go and Rust static builds change the environment or target, not flags
+		fmt.Fprintf(h, "\x00static=%s", muslTarget(config))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// hashDir hashes every regular file below root in a stable order
+func hashDir(w io.Writer, root string) error {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && hashSkipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		// In-tree build products would otherwise change the hash of the
+		// very sources they were built from
+		switch filepath.Ext(path) {
+		case ".o", ".obj", ".a", ".so", ".dylib":
+			return nil
+		case "":
+			if info, err := d.Info(); err == nil && info.Mode()&0100 != 0 {
+				return nil
+			}
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, path := range files {
+		rel, _ := filepath.Rel(root, path)
+		fmt.Fprintf(w, "\x00%s\x00", filepath.ToSlash(rel))
+		if err := hashFile(w, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileChecksum returns the hex sha256 of a file's contents
+func fileChecksum(path string) (string, error) {
+	h := sha256.New()
+	if err := hashFile(h, path); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// metadataPrefix starts a metadata line in a script's header comment, e.g.
+// "# scripts:param env choices=staging,production"
+const metadataPrefix = "scripts:"
+
+// ScriptMeta is the metadata declared in a script's header
+type ScriptMeta struct {
+	Params []*ScriptParam
+	// Values holds every metadata line by key, in order
+	Values map[string][]string
+}
+
+// readMetadata parses the "# scripts:<key> <value>" lines of a script's
+// leading comment block, which ends at the first line that isn't a comment
+// or blank
+func readMetadata(path string) (*ScriptMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta := &ScriptMeta{Values: map[string][]string{}}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#!") {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		comment := strings.TrimSpace(strings.TrimLeft(line, "#"))
+		if !strings.HasPrefix(comment, metadataPrefix) {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimPrefix(comment, metadataPrefix), " ")
+		value = strings.TrimSpace(value)
+		meta.Values[key] = append(meta.Values[key], value)
+		if key == "param" {
+			param, err := parseParam(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			meta.Params = append(meta.Params, param)
+		}
+	}
+	return meta, scanner.Err()
+}
+
+// fields returns the space or comma separated words of a metadata key
+func (m *ScriptMeta) fields(key string) []string {
+	var fields []string
+	for _, value := range m.Values[key] {
+		fields = append(fields, strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })...)
+	}
+	return fields
+}
+
+// value returns the last value of a metadata key
+func (m *ScriptMeta) value(key string) string {
+	values := m.Values[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// splitQuoted splits on spaces, keeping double-quoted text together
+func splitQuoted(s string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inQuotes, inField := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			inField = true
+		case r == ' ' && !inQuotes:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runBuckets and compileBuckets are the histogram bucket bounds in seconds
+var (
+	runBuckets     = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900}
+	compileBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+)
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// operationMetrics tracks one script's runs or one binary's compiles
+type operationMetrics struct {
+	total    uint64
+	failures uint64
+	duration *histogram
+}
+
+// Metrics collects the daemon's run and compile statistics and writes them
+// in the Prometheus text exposition format
+type Metrics struct {
+	mu         sync.Mutex
+	started    time.Time
+	runs       map[string]*operationMetrics
+	compiles   map[string]*operationMetrics
+	inProgress int
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		started:  time.Now(),
+		runs:     map[string]*operationMetrics{},
+		compiles: map[string]*operationMetrics{},
+	}
+}
+
+// startRun marks a run as in progress until the returned function records
+// its outcome
+func (m *Metrics) startRun() func(script string, duration time.Duration, failed bool) {
+	m.mu.Lock()
+	m.inProgress++
+	m.mu.Unlock()
+	return func(script string, duration time.Duration, failed bool) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.inProgress--
+		observe(m.runs, runBuckets, script, duration, failed)
+	}
+}
+
+// recordCompile records the outcome of a compile
+func (m *Metrics) recordCompile(binary string, duration time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.compiles, compileBuckets, binary, duration, failed)
+}
+
+func observe(ops map[string]*operationMetrics, bounds []float64, key string, duration time.Duration, failed bool) {
+	op := ops[key]
+	if op == nil {
+		op = &operationMetrics{duration: newHistogram(bounds)}
+		ops[key] = op
+	}
+	op.total++
+	if failed {
+		op.failures++
+	}
+	op.duration.observe(duration.Seconds())
+}
+
+// write renders every metric in the Prometheus text format
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP scripts_daemon_start_time_seconds When the daemon started, in seconds since the epoch.")
+	fmt.Fprintln(w, "# TYPE scripts_daemon_start_time_seconds gauge")
+	fmt.Fprintf(w, "scripts_daemon_start_time_seconds %d\n", m.started.Unix())
+	fmt.Fprintln(w, "# HELP scripts_runs_in_progress Script runs currently executing.")
+	fmt.Fprintln(w, "# TYPE scripts_runs_in_progress gauge")
+	fmt.Fprintf(w, "scripts_runs_in_progress %d\n", m.inProgress)
+
+	writeOperations(w, m.runs, "script", "scripts_runs", "Script runs")
+	writeOperations(w, m.compiles, "binary", "scripts_compiles", "Compiles")
+}
+
+// writeOperations writes the total, failure and duration series of one kind
+// of operation, labelled by label
+func writeOperations(w io.Writer, ops map[string]*operationMetrics, label, prefix, what string) {
+	keys := make([]string, 0, len(ops))
+	for key := range ops {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s_total %s started through the daemon.\n", prefix, what)
+	fmt.Fprintf(w, "# TYPE %s_total counter\n", prefix)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s_total{%s=%s} %d\n", prefix, label, quoteLabel(key), ops[key].total)
+	}
+
+	name := strings.TrimSuffix(prefix, "s") + "_failures_total"
+	fmt.Fprintf(w, "# HELP %s %s that failed.\n", name, what)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", name, label, quoteLabel(key), ops[key].failures)
+	}
+
+	name = strings.TrimSuffix(prefix, "s") + "_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s How long %s took.\n", name, strings.ToLower(what))
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, key := range keys {
+		h := ops[key].duration
+		value := quoteLabel(key)
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"%g\"} %d\n", name, label, value, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%s,le=\"+Inf\"} %d\n", name, label, value, h.count)
+		fmt.Fprintf(w, "%s_sum{%s=%s} %g\n", name, label, value, h.sum)
+		fmt.Fprintf(w, "%s_count{%s=%s} %d\n", name, label, value, h.count)
+	}
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns a quoted label value
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scriptPreamble starts every script created by 'scripts new': bash with
+// the usual safety flags, so a failing step stops the script
+const scriptPreamble = "#!/usr/bin/env bash\nset -euo pipefail\n"
+
+// NewScriptOptions describes a 'scripts new' request
+type NewScriptOptions struct {
+	Name        string
+	From        string // command to wrap
+	FromHistory bool   // wrap the last command from the shell history
+	Force       bool   // overwrite an existing script
+	Mode        string
+}
+
+// parseNewArgs parses the arguments of 'scripts new'
+func parseNewArgs(args []string) (*NewScriptOptions, error) {
+	opts := &NewScriptOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--from" || arg == "--mode":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "--from" {
+				opts.From = args[i]
+			} else {
+				opts.Mode = args[i]
+			}
+		case arg == "--from-history":
+			opts.FromHistory = true
+		case arg == "--force" || arg == "-f":
+			opts.Force = true
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		case opts.Name != "":
+			return nil, fmt.Errorf("only one script name may be given")
+		default:
+			opts.Name = strings.TrimSuffix(arg, ".sh")
+		}
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("no script name given")
+	}
+	if !validName(opts.Name) {
+		return nil, fmt.Errorf("invalid script name %q", opts.Name)
+	}
+	if opts.From != "" && opts.FromHistory {
+		return nil, fmt.Errorf("--from and --from-history can't be combined")
+	}
+	return opts, nil
+}
+
+// newScript creates a script wrapping a command, or a skeleton to fill in
+func newScript(opts *NewScriptOptions, config *Config) error {
+	command, origin := opts.From, "a shell command"
+	if opts.FromHistory {
+		var historyFile string
+		var err error
+		command, historyFile, err = lastHistoryCommand()
+		if err != nil {
+			return err
+		}
+		origin = historyFile
+	}
+
+	var body string
+	if command == "" {
+		body = fmt.Sprintf("# %s: describe what this script does\n\necho \"TODO: implement %s\"\n", opts.Name, opts.Name)
+	} else {
+		body = fmt.Sprintf("# %s: created from %s on %s\n\n%s\n", opts.Name, origin, time.Now().Format("2006-01-02"), command)
+	}
+	path, err := installScript(opts.Name, []byte(scriptPreamble+body), opts.Force, opts.Mode, config)
+	if err != nil {
+		return err
+	}
+	if command == "" {
+		fmt.Printf("Created %s - edit it to add the commands to run\n", path)
+	} else {
+		fmt.Printf("Created %s running:\n  %s\n", path, strings.ReplaceAll(command, "\n", "\n  "))
+	}
+	return nil
+}
+
+// validName reports whether name can be used as a file name in a managed
+// directory
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".")
+}
+
+// installScript writes a script into ScriptDir and applies the permission
+// policy, refusing to replace an existing script unless force is set
+func installScript(name string, content []byte, force bool, mode string, config *Config) (string, error) {
+	perms, err := resolvePermissions(config, mode)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(config.ScriptDir, name+".sh")
+	if _, err := os.Stat(path); err == nil && !force {
+		return "", fmt.Errorf("script %s already exists (use --force to replace it)", name)
+	}
+	if err := os.MkdirAll(config.ScriptDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create scripts directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write script to scripts_bin: %v", err)
+	}
+	if err := perms.apply(path); err != nil {
+		return "", fmt.Errorf("failed to make script executable: %v", err)
+	}
+	return path, nil
+}
+
+// historyFiles are the default bash, zsh and fish history files
+var historyFiles = []string{
+	"~/.bash_history",
+	"~/.zsh_history",
+	"~/.local/share/fish/fish_history",
+}
+
+// lastHistoryCommand returns the most recent command in $HISTFILE when it
+// is exported, else in the most recently written default history, skipping
+// invocations of scripts itself
+func lastHistoryCommand() (string, string, error) {
+	var newest string
+	var newestTime time.Time
+	if histFile := os.Getenv("HISTFILE"); histFile != "" {
+		newest = expandPath(histFile)
+	} else {
+		for _, file := range historyFiles {
+			file = expandPath(file)
+			if info, err := os.Stat(file); err == nil && info.ModTime().After(newestTime) {
+				newest, newestTime = file, info.ModTime()
+			}
+		}
+	}
+	if newest == "" {
+		return "", "", fmt.Errorf("no shell history found (set HISTFILE, or use --from \"<command>\")")
+	}
+
+	commands, err := readHistory(newest)
+	if err != nil {
+		return "", "", err
+	}
+	for i := len(commands) - 1; i >= 0; i-- {
+		command := strings.TrimSpace(commands[i])
+		if command == "" || command == "scripts" || strings.HasPrefix(command, "scripts ") {
+			continue
+		}
+		return command, newest, nil
+	}
+	return "", "", fmt.Errorf("no commands found in %s", newest)
+}
+
+// readHistory parses a bash, zsh (including extended) or fish history file
+func readHistory(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shell history: %v", err)
+	}
+	defer f.Close()
+
+	fish := strings.HasSuffix(path, "fish_history")
+	var commands []string
+	continued := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case fish:
+			if cmd, ok := strings.CutPrefix(line, "- cmd: "); ok {
+				cmd = strings.NewReplacer(`\n`, "\n", `\\`, `\`).Replace(cmd)
+				commands = append(commands, cmd)
+			}
+			continue
+		case continued:
+			// zsh stores multi-line commands with trailing backslashes
+			commands[len(commands)-1] += "\n" + line
+		case strings.HasPrefix(line, "#") && len(line) > 1 && strings.Trim(line[1:], "0123456789") == "":
+			// bash HISTTIMEFORMAT timestamps
+			continue
+		default:
+			if strings.HasPrefix(line, ": ") {
+				// zsh extended history: ": <start>:<elapsed>;<command>"
+				if _, cmd, ok := strings.Cut(line, ";"); ok {
+					line = cmd
+				}
+			}
+			commands = append(commands, line)
+		}
+		continued = strings.HasSuffix(line, `\`)
+		if continued {
+			last := commands[len(commands)-1]
+			commands[len(commands)-1] = strings.TrimSuffix(last, `\`)
+		}
+	}
+	return commands, scanner.Err()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// offlineEnv turns on offline mode like --offline
+const offlineEnv = "SCRIPTS_OFFLINE"
+
+// offline is set by --offline before the command
+var offline bool
+
+// stripOfflineFlag removes --offline from the flags before the command or
+// script name, turning offline mode on
+func stripOfflineFlag(args []string) []string {
+	args, offline = stripLeadingFlag(args, "--offline", offline)
+	return args
+}
+
+// stripLeadingFlag removes a global flag from the flags before the command
+// or script name, skipping the values of run flags. It reports whether the
+// flag was there, or set already.
+func stripLeadingFlag(args []string, flag string, set bool) ([]string, bool) {
+	stripped := []string{args[0]}
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if args[i] == flag {
+			set = true
+			continue
+		}
+		stripped = append(stripped, args[i])
+		if runFlags[args[i]] && i+1 < len(args) {
+			i++
+			stripped = append(stripped, args[i])
+		}
+	}
+	return append(stripped, args[i:]...), set
+}
+
+// isOffline reports whether network access is off, with --offline or
+// SCRIPTS_OFFLINE=1
+func isOffline() bool {
+	switch strings.ToLower(os.Getenv(offlineEnv)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return offline
+}
+
+// errOffline explains that what needs the network
+func errOffline(what string) error {
+	return fmt.Errorf("%s needs the network, but scripts is offline (--offline or %s)", what, offlineEnv)
+}
+
+// indexCachePath returns where the last index fetched from a registry is
+// kept, for offline use
+func indexCachePath(registry string, config *Config) string {
+	sum := sha256.Sum256([]byte(registry))
+	return filepath.Join(cacheDir(config), "registry", hex.EncodeToString(sum[:8])+".json")
+}
+
+// cacheIndex keeps a registry index fetched from the network
+func cacheIndex(data []byte, config *Config) {
+	path := indexCachePath(config.Registry, config)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		os.WriteFile(path, data, 0644)
+	}
+}
+
+// cachedIndex returns the index last fetched from the registry and when
+func cachedIndex(config *Config) ([]byte, time.Time, error) {
+	path := indexCachePath(config.Registry, config)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("no cached index for %s", config.Registry)
+	}
+	data, err := os.ReadFile(path)
+	return data, info.ModTime(), err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SyncConfig keeps the scripts directory in sync with a git remote
+type SyncConfig struct {
+	// Remote is the git URL the scripts directory is cloned from and
+	// pushed to
+	Remote string `json:"remote,omitempty"`
+	// Auto pulls the remote in the background every Interval, and by the
+	// daemon, reporting new and updated scripts on the next invocation
+	Auto bool `json:"auto,omitempty"`
+	// Interval is how often Auto pulls, as a duration like "30m" (default
+	// defaultSyncInterval)
+	Interval string `json:"interval,omitempty"`
+	// Encrypt names, in gitignore syntax, the files of the scripts
+	// directory that are only pushed encrypted with age, as <file>.age
+	Encrypt []string `json:"encrypt,omitempty"`
+	// Identity is the age identity file those are encrypted to and
+	// decrypted with (default defaultAgeIdentity)
+	Identity string `json:"identity,omitempty"`
+}
+
+// Where the setup wizard suggests putting things: a scripts directory in
+// the home directory and binaries in the user's usual bin directory
+const (
+	suggestedScriptDir = "~/scripts"
+	suggestedBinDir    = "~/.local/bin"
+)
+
+// ask prints a question with its default answer and returns the answer,
+// or the default for an empty one
+func ask(in *bufio.Reader, question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, _ := in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// askYes asks a yes/no question, yes being the default
+func askYes(in *bufio.Reader, question string) bool {
+	fmt.Printf("%s [Y/n] ", question)
+	answer, _ := in.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// cloneScripts clones a remote into the scripts directory, unless the
+// directory already has files in it
+func cloneScripts(remote, dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		fmt.Printf("%s isn't empty, so it wasn't cloned into; sync will use the remote from now on\n", dir)
+		return nil
+	}
+	if isOffline() {
+		return errOffline("cloning " + remote)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(dir), err)
+	}
+	os.Remove(dir)
+	cmd := exec.Command("git", "clone", remote, dir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to clone %s: %v", remote, err)
+	}
+	return nil
+}
+
+// runSetupWizard asks where scripts and binaries go on the first run, sets
+// up PATH and optionally clones the scripts from git, then saves the
+// config at configPath
+func runSetupWizard(configPath string) (*Config, error) {
+	in := bufio.NewReader(os.Stdin)
+	fmt.Println(colorize(colorBold, "Welcome to scripts! Let's set it up (press Enter to accept a suggestion)."))
+	fmt.Println()
+
+	config := &Config{}
+	config.ScriptDir = expandPath(ask(in, "Where should your scripts live?", suggestedScriptDir))
+	config.BinDir = expandPath(ask(in, "Where should compiled binaries go?", suggestedBinDir))
+	if remote := ask(in, "Git remote to sync your scripts with (leave empty to skip)", ""); remote != "" {
+		config.Sync = &SyncConfig{Remote: remote}
+		if err := cloneScripts(remote, config.ScriptDir); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	for _, dir := range []string{config.ScriptDir, config.BinDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+	}
+
+	if err := saveConfig(config); err != nil {
+		return nil, err
+	}
+	if !onPath(pathDir(config)) {
+		profile, _ := shellProfile(pathDir(config))
+		if askYes(in, fmt.Sprintf("%s isn't on your PATH. Add it in %s?", pathDir(config), profile)) {
+			if err := fixPath(config); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+	fmt.Println()
+	fmt.Printf("Saved the config to %s; edit it (or delete it to run this again) any time.\n", configPath)
+	fmt.Println("Try 'scripts new hello' to write your first script, or 'scripts adopt ~/bin' to bring existing ones.")
+	fmt.Println()
+	return config, nil
+}
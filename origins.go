@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// originsFile records where installed scripts and binaries came from,
+// inside the state directory
+const originsFile = "installed.json"
+
+// Where an install came from
+const (
+	originTap      = "tap"
+	originRegistry = "registry"
+	originURL      = "url"
+	originArchive  = "archive"
+)
+
+var originsMu sync.Mutex
+
+// Origin is where an installed script or binary came from, so it can be
+// checked for updates
+type Origin struct {
+	// Kind is "tap", "registry", "url" or "archive"
+	Kind string `json:"kind"`
+	// Source is "tap/script", the registry package name, or the URL or
+	// path of the script or archive
+	Source  string `json:"source"`
+	Version string `json:"version,omitempty"`
+	// Checksum is the SHA-256 of what was installed
+	Checksum    string    `json:"checksum"`
+	Binary      bool      `json:"binary,omitempty"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+// label describes the installed version, falling back to the checksum for
+// sources without versions
+func (o *Origin) label() string {
+	if o.Version != "" {
+		return o.Version
+	}
+	return o.Checksum[:min(12, len(o.Checksum))]
+}
+
+// path returns where the install lives now
+func (o *Origin) path(name string, config *Config) (string, error) {
+	if o.Binary {
+		return binaryPath(name, config)
+	}
+	path := filepath.Join(config.ScriptDir, name+".sh")
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// latest fetches the current content of the origin, returning it with an
+// Origin describing it
+func (o *Origin) latest(config *Config) ([]byte, *Origin, error) {
+	latest := &Origin{Kind: o.Kind, Source: o.Source, Binary: o.Binary}
+	var content []byte
+	switch o.Kind {
+	case originTap:
+		tapName, scriptName, _ := strings.Cut(o.Source, "/")
+		tap, err := loadTap(tapName, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		path, ok := tap.scripts()[scriptName]
+		if !ok {
+			return nil, nil, fmt.Errorf("tap %s no longer has %s", tapName, scriptName)
+		}
+		if content, err = os.ReadFile(path); err != nil {
+			return nil, nil, err
+		}
+		latest.Version = shortCommit(tap.Commit)
+	case originRegistry:
+		pkg, err := findPackage(o.Source, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if content, err = downloadPackage(pkg, config); err != nil {
+			return nil, nil, err
+		}
+		latest.Version = pkg.Version
+	case originURL:
+		var err error
+		if content, err = fetch(o.Source); err != nil {
+			return nil, nil, err
+		}
+	case originArchive:
+		data, err := fetch(o.Source)
+		if err != nil {
+			return nil, nil, err
+		}
+		bundle, files, err := readBundle(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		content = files[bundle.Scripts[0].Name]
+		latest.Version = bundle.Version
+	default:
+		return nil, nil, fmt.Errorf("unknown origin %q", o.Kind)
+	}
+	latest.Checksum = checksum(content)
+	return content, latest, nil
+}
+
+// originsPath returns where origins are recorded
+func originsPath(config *Config) string {
+	return filepath.Join(stateDir(config), originsFile)
+}
+
+// loadOrigins reads the recorded origins, keyed by script or binary name
+func loadOrigins(config *Config) (map[string]*Origin, error) {
+	origins := map[string]*Origin{}
+	data, err := os.ReadFile(originsPath(config))
+	if os.IsNotExist(err) {
+		return origins, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read installed origins: %v", err)
+	}
+	if err := json.Unmarshal(data, &origins); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", originsPath(config), err)
+	}
+	return origins, nil
+}
+
+// saveOrigins writes the recorded origins
+func saveOrigins(origins map[string]*Origin, config *Config) error {
+	if err := os.MkdirAll(stateDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	data, err := json.MarshalIndent(origins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(originsPath(config), data, 0644); err != nil {
+		return fmt.Errorf("failed to save installed origins: %v", err)
+	}
+	return nil
+}
+
+// recordOrigin remembers where an install came from
+func recordOrigin(name string, origin *Origin, config *Config) error {
+	originsMu.Lock()
+	defer originsMu.Unlock()
+	origins, err := loadOrigins(config)
+	if err != nil {
+		return err
+	}
+	origin.InstalledAt = time.Now()
+	origins[name] = origin
+	return saveOrigins(origins, config)
+}
+
+// Update is a newer version of an installed script or binary
+type Update struct {
+	Name    string
+	Path    string
+	Current *Origin
+	Latest  *Origin
+	Content []byte
+}
+
+// findUpdates checks the named installs (all of them when names is empty)
+// against their origins. Origins that can't be reached are reported and
+// skipped.
+func findUpdates(names []string, config *Config) ([]*Update, error) {
+	origins, err := loadOrigins(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		for name := range origins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var updates []*Update
+	for _, name := range names {
+		origin, ok := origins[name]
+		if !ok {
+			return nil, fmt.Errorf("%s wasn't installed from a tap, the registry or a URL", name)
+		}
+		path, err := origin.path(name, config)
+		if err != nil {
+			// Uninstalled since; nothing to update
+			continue
+		}
+		content, latest, err := origin.latest(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't check %s for updates: %v\n", name, err)
+			continue
+		}
+		if latest.Checksum != origin.Checksum {
+			updates = append(updates, &Update{Name: name, Path: path, Current: origin, Latest: latest, Content: content})
+		}
+	}
+	return updates, nil
+}
+
+// printOutdated lists installs whose origin has changed
+func printOutdated(config *Config) error {
+	updates, err := findUpdates(nil, config)
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 {
+		fmt.Println("Everything is up to date")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tINSTALLED\tLATEST")
+	for _, update := range updates {
+		fmt.Fprintf(w, "%s\t%s %s\t%s\t%s\n", update.Name, update.Current.Kind, update.Current.Source,
+			update.Current.label(), update.Latest.label())
+	}
+	return w.Flush()
+}
+
+// upgrade installs the updates for the named installs (or all of them),
+// showing each script's diff and asking first unless yes is set
+func upgrade(names []string, yes bool, config *Config) error {
+	updates, err := findUpdates(names, config)
+	if err != nil {
+		return err
+	}
+	if len(updates) == 0 {
+		fmt.Println("Everything is up to date")
+		return nil
+	}
+	if !yes && !canPrompt() {
+		return fmt.Errorf("not upgrading without confirmation (rerun with --yes)")
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for _, update := range updates {
+		fmt.Printf("%s: %s -> %s (%s %s)\n", update.Name, update.Current.label(), update.Latest.label(),
+			update.Current.Kind, update.Current.Source)
+		if installed, err := fileChecksum(update.Path); err == nil && installed != update.Current.Checksum {
+			fmt.Printf("Warning: %s has local changes, which the upgrade replaces\n", update.Path)
+		}
+		if update.Current.Binary {
+			fmt.Printf("Binary changed (%s)\n", humanSize(int64(len(update.Content))))
+		} else if err := showDiff(update.Path, update.Content); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't show the changes: %v\n", err)
+		}
+
+		if !yes {
+			fmt.Printf("Upgrade %s? [y/N] ", update.Name)
+			answer, _ := in.ReadString('\n')
+			if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+				fmt.Printf("Skipped %s\n", update.Name)
+				continue
+			}
+		}
+
+		if update.Current.Binary {
+			_, err = installDownloadedBinary(update.Name, update.Content, true, "", config)
+		} else {
+			_, err = installScript(update.Name, update.Content, true, "", config)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upgrade %s: %v", update.Name, err)
+		}
+		if err := recordOrigin(update.Name, update.Latest, config); err != nil {
+			return err
+		}
+		fmt.Printf("Upgraded %s to %s\n", update.Name, update.Latest.label())
+	}
+	return nil
+}
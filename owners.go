@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Metadata keys naming who looks after a script on a shared scripts
+// directory: "# scripts:owner alice" and "# scripts:maintainer bob, carol"
+const (
+	ownerKey      = "owner"
+	maintainerKey = "maintainer"
+)
+
+// userEnv names the team member running the tool when the login name
+// doesn't (a shared account, say)
+const userEnv = "SCRIPTS_USER"
+
+// scriptOwners returns a script's owner and maintainers
+func scriptOwners(path string) (string, []string) {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return "", nil
+	}
+	return meta.value(ownerKey), meta.fields(maintainerKey)
+}
+
+// currentUser returns the name owners are compared with: $SCRIPTS_USER, or
+// the login name
+func currentUser() string {
+	if name := os.Getenv(userEnv); name != "" {
+		return name
+	}
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	// Windows names are DOMAIN\user
+	return filepath.Base(strings.ReplaceAll(u.Username, `\`, "/"))
+}
+
+// ownedBy reports whether someone owns or maintains a script
+func ownedBy(path, name string) bool {
+	owner, maintainers := scriptOwners(path)
+	return strings.EqualFold(owner, name) || slices.ContainsFunc(maintainers, func(m string) bool {
+		return strings.EqualFold(m, name)
+	})
+}
+
+// warnOwner warns before a change to a script owned by someone else; the
+// change still goes ahead
+func warnOwner(name, path string) {
+	owner, maintainers := scriptOwners(path)
+	if owner == "" && len(maintainers) == 0 {
+		return
+	}
+	me := currentUser()
+	if me != "" && ownedBy(path, me) {
+		return
+	}
+	var who []string
+	if owner != "" {
+		who = append(who, "owned by "+owner)
+	}
+	if len(maintainers) > 0 {
+		who = append(who, "maintained by "+strings.Join(maintainers, ", "))
+	}
+	message := fmt.Sprintf("%s is %s", name, strings.Join(who, " and "))
+	if me != "" {
+		message += fmt.Sprintf(", not %s", me)
+	}
+	fmt.Fprintln(os.Stderr, colorize(colorYellow, "Warning: ")+message)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// packageFormats are the package types "scripts package" can produce
+var packageFormats = []string{"deb", "rpm", "tar.gz"}
+
+// PackageOptions describes a package request from the command line
+type PackageOptions struct {
+	Name    string // binary to package
+	Format  string // deb, rpm or tar.gz
+	Version string // package version (default: the build date)
+	Output  string // directory the package is written to
+}
+
+func parsePackageArgs(args []string) (*PackageOptions, error) {
+	opts := &PackageOptions{Output: "."}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--format" || arg == "--version" || arg == "--output" || arg == "-o":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			switch arg {
+			case "--format":
+				opts.Format = args[i]
+			case "--version":
+				opts.Version = args[i]
+			default:
+				opts.Output = args[i]
+			}
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			if opts.Name != "" {
+				return nil, fmt.Errorf("only one binary may be given")
+			}
+			opts.Name = arg
+		}
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("no binary given")
+	}
+	switch opts.Format {
+	case "deb", "rpm", "tar.gz":
+	case "":
+		return nil, fmt.Errorf("--format is required (%s)", strings.Join(packageFormats, ", "))
+	default:
+		return nil, fmt.Errorf("unsupported format %q (supported: %s)", opts.Format, strings.Join(packageFormats, ", "))
+	}
+	if opts.Version != "" && strings.ContainsAny(opts.Version, " /_") {
+		return nil, fmt.Errorf("invalid version %q: no spaces, slashes or underscores", opts.Version)
+	}
+	return opts, nil
+}
+
+// packageBinary builds an installable package from a managed binary and
+// writes a sha256 checksum file next to it
+func packageBinary(opts *PackageOptions, config *Config) error {
+	binPath, err := binaryPath(opts.Name, config)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(opts.Name, ".wasm") {
+		return fmt.Errorf("WASM modules can't be packaged; run them with 'scripts run-wasm'")
+	}
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return err
+	}
+
+	// Default the version to the build date so newer builds sort higher
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return err
+	}
+	record := manifest.Binaries[opts.Name]
+	builtAt := info.ModTime()
+	if record != nil && !record.BuiltAt.IsZero() {
+		builtAt = record.BuiltAt
+	}
+	version := opts.Version
+	if version == "" {
+		version = builtAt.Format("2006.01.02.1504")
+	}
+	description := fmt.Sprintf("%s, packaged by scripts", opts.Name)
+	if record != nil {
+		description = fmt.Sprintf("%s (%s)", opts.Name, binarySummary(record))
+	}
+
+	if err := os.MkdirAll(opts.Output, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", opts.Output, err)
+	}
+
+	var pkgPath string
+	switch opts.Format {
+	case "tar.gz":
+		pkgPath = filepath.Join(opts.Output, fmt.Sprintf("%s-%s-%s-%s.tar.gz", opts.Name, version, runtime.GOOS, runtime.GOARCH))
+		err = writeTarball(pkgPath, opts.Name, version, binPath)
+	case "deb":
+		arch := debArch()
+		pkgPath = filepath.Join(opts.Output, fmt.Sprintf("%s_%s_%s.deb", opts.Name, version, arch))
+		err = writeDeb(pkgPath, opts.Name, version, arch, description, binPath)
+	case "rpm":
+		pkgPath, err = buildRpm(opts, version, description, binPath, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(pkgPath)
+	if err != nil {
+		return err
+	}
+	sumLine := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(pkgPath))
+	if err := os.WriteFile(pkgPath+".sha256", []byte(sumLine), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum: %v", err)
+	}
+	fmt.Printf("Packaged %s %s as %s\n", opts.Name, version, pkgPath)
+	fmt.Printf("sha256: %s\n", checksum)
+	return nil
+}
+
+// tarEntry is a file to put into a tar archive
+type tarEntry struct {
+	Name string
+	Mode int64
+	Data []byte
+}
+
+// writeTarGz writes entries, with their parent directories, as a gzipped
+// tar archive
+func writeTarGz(w io.Writer, entries []tarEntry) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	seenDirs := map[string]bool{}
+	for _, entry := range entries {
+		// Package tools expect every parent directory to have an entry
+		var parents []string
+		name := strings.TrimPrefix(entry.Name, "./")
+		for dir := filepath.Dir(name); dir != "." && !seenDirs[dir]; dir = filepath.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+			seenDirs[dir] = true
+		}
+		// Keep the "./" prefix dpkg uses on every member
+		prefix := strings.TrimSuffix(entry.Name, name)
+		for _, dir := range parents {
+			hdr := &tar.Header{Name: prefix + dir + "/", Mode: 0755, Typeflag: tar.TypeDir, ModTime: now}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+		}
+		hdr := &tar.Header{Name: entry.Name, Mode: entry.Mode, Size: int64(len(entry.Data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeTarball packages the binary as <name>-<version>/<name>
+func writeTarball(pkgPath, name, version, binPath string) error {
+	data, err := os.ReadFile(binPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", binPath, err)
+	}
+	f, err := os.Create(pkgPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", pkgPath, err)
+	}
+	defer f.Close()
+	return writeTarGz(f, []tarEntry{{Name: name + "-" + version + "/" + name, Mode: 0755, Data: data}})
+}
+
+// debArch maps the host architecture to Debian's name for it
+func debArch() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	}
+	return runtime.GOARCH
+}
+
+// rpmArch maps the host architecture to RPM's name for it
+func rpmArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "i686"
+	}
+	return runtime.GOARCH
+}
+
+// packager returns the name and email recorded as the package maintainer,
+// taken from git when it is configured
+func packager() string {
+	name, _ := exec.Command("git", "config", "user.name").Output()
+	email, _ := exec.Command("git", "config", "user.email").Output()
+	if n, e := strings.TrimSpace(string(name)), strings.TrimSpace(string(email)); n != "" && e != "" {
+		return fmt.Sprintf("%s <%s>", n, e)
+	}
+	username := "scripts"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s <%s@%s>", username, username, hostname)
+}
+
+// writeDeb assembles a .deb (an ar archive of debian-binary, control.tar.gz
+// and data.tar.gz) installing the binary to /usr/local/bin
+func writeDeb(pkgPath, name, version, arch, description, binPath string) error {
+	data, err := os.ReadFile(binPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", binPath, err)
+	}
+
+	control := fmt.Sprintf("Package: %s\nVersion: %s\nArchitecture: %s\nMaintainer: %s\nInstalled-Size: %d\nSection: utils\nPriority: optional\nDescription: %s\n",
+		debPackageName(name), version, arch, packager(), (len(data)+1023)/1024, description)
+	var controlTar, dataTar bytes.Buffer
+	if err := writeTarGz(&controlTar, []tarEntry{{Name: "./control", Mode: 0644, Data: []byte(control)}}); err != nil {
+		return err
+	}
+	if err := writeTarGz(&dataTar, []tarEntry{{Name: "./usr/local/bin/" + name, Mode: 0755, Data: data}}); err != nil {
+		return err
+	}
+
+	f, err := os.Create(pkgPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", pkgPath, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("!<arch>\n"); err != nil {
+		return err
+	}
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlTar.Bytes()},
+		{"data.tar.gz", dataTar.Bytes()},
+	}
+	for _, member := range members {
+		// ar headers are fixed-width ASCII fields; members are 2-byte aligned
+		header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name, time.Now().Unix(), 0, 0, "100644", len(member.data))
+		if _, err := f.WriteString(header); err != nil {
+			return err
+		}
+		if _, err := f.Write(member.data); err != nil {
+			return err
+		}
+		if len(member.data)%2 == 1 {
+			if _, err := f.WriteString("\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// debPackageName lowercases a binary name and replaces characters Debian
+// doesn't allow in package names
+func debPackageName(name string) string {
+	name = strings.ToLower(name)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '+' || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// buildRpm generates a spec file and runs rpmbuild, since the RPM format is
+// impractical to write by hand
+func buildRpm(opts *PackageOptions, version, description, binPath string, config *Config) (string, error) {
+	rpmbuild := resolveCompiler("rpmbuild", config)
+	if _, err := exec.LookPath(rpmbuild[0]); err != nil {
+		return "", fmt.Errorf("rpmbuild not found - install rpm-build (or rpm) to create RPM packages")
+	}
+
+	topDir, err := os.MkdirTemp("", "scripts-rpm-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(topDir)
+
+	// RPM versions can't contain dashes
+	rpmVersion := strings.ReplaceAll(version, "-", ".")
+	spec := fmt.Sprintf(`Name: %s
+Version: %s
+Release: 1
+Summary: %s
+License: Proprietary
+Packager: %s
+AutoReqProv: no
+
+%%description
+%s
+
+%%install
+mkdir -p %%{buildroot}/usr/local/bin
+install -m 0755 %s %%{buildroot}/usr/local/bin/%s
+
+%%files
+/usr/local/bin/%s
+`, opts.Name, rpmVersion, description, packager(), description, binPath, opts.Name, opts.Name)
+	specPath := filepath.Join(topDir, opts.Name+".spec")
+	if err := os.WriteFile(specPath, []byte(spec), 0644); err != nil {
+		return "", fmt.Errorf("failed to write spec file: %v", err)
+	}
+
+	args := append(append([]string{}, rpmbuild[1:]...), "--define", "_topdir "+topDir, "--target", rpmArch(), "-bb", specPath)
+	cmd := exec.Command(rpmbuild[0], args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("rpmbuild failed: %v", err)
+	}
+
+	rpmName := fmt.Sprintf("%s-%s-1.%s.rpm", opts.Name, rpmVersion, rpmArch())
+	pkgPath := filepath.Join(opts.Output, rpmName)
+	if err := moveFile(filepath.Join(topDir, "RPMS", rpmArch(), rpmName), pkgPath); err != nil {
+		return "", err
+	}
+	return pkgPath, nil
+}
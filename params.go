@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// paramTypes are the value types a parameter can declare
+var paramTypes = []string{"string", "int", "bool"}
+
+// ScriptParam is a positional parameter declared in a script's header:
+//
+//	# scripts:param <name> [type=int|bool] [default=<v>] [choices=a,b] [pattern=<re>] [description]
+type ScriptParam struct {
+	Name        string
+	Type        string
+	Default     string
+	HasDefault  bool
+	Choices     []string
+	Pattern     *regexp.Regexp
+	Description string
+}
+
+// parseParam parses the value of a "scripts:param" metadata line
+func parseParam(value string) (*ScriptParam, error) {
+	fields, err := splitQuoted(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("scripts:param needs a name")
+	}
+	param := &ScriptParam{Name: fields[0], Type: "string"}
+	var description []string
+	for _, field := range fields[1:] {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			description = append(description, field)
+			continue
+		}
+		switch key {
+		case "type":
+			if !slices.Contains(paramTypes, val) {
+				return nil, fmt.Errorf("parameter %s: unknown type %q (expected %s)", param.Name, val, strings.Join(paramTypes, ", "))
+			}
+			param.Type = val
+		case "default":
+			param.Default, param.HasDefault = val, true
+		case "choices":
+			param.Choices = strings.Split(val, ",")
+		case "pattern":
+			param.Pattern, err = regexp.Compile("^(?:" + val + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: invalid pattern: %v", param.Name, err)
+			}
+		default:
+			description = append(description, field)
+		}
+	}
+	param.Description = strings.Join(description, " ")
+	if param.HasDefault {
+		if param.Default, err = param.validate(param.Default); err != nil {
+			return nil, fmt.Errorf("parameter %s: default %v", param.Name, err)
+		}
+	}
+	return param, nil
+}
+
+// validate checks a value against the parameter's type, choices and
+// pattern, returning it normalised (bools become "true" or "false")
+func (p *ScriptParam) validate(value string) (string, error) {
+	switch p.Type {
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("%q is not a whole number", value)
+		}
+	case "bool":
+		switch strings.ToLower(value) {
+		case "y", "yes", "true", "1":
+			value = "true"
+		case "n", "no", "false", "0":
+			value = "false"
+		default:
+			return "", fmt.Errorf("%q is not yes or no", value)
+		}
+	}
+	if len(p.Choices) > 0 && !slices.Contains(p.Choices, value) {
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(p.Choices, ", "))
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(value) {
+		return "", fmt.Errorf("%q doesn't match %s", value, strings.TrimSuffix(strings.TrimPrefix(p.Pattern.String(), "^(?:"), ")$"))
+	}
+	return value, nil
+}
+
+// usage describes the parameter for prompts and errors
+func (p *ScriptParam) usage() string {
+	text := p.Name
+	if p.Description != "" {
+		text += " (" + p.Description + ")"
+	}
+	switch {
+	case len(p.Choices) > 0:
+		text += " [" + strings.Join(p.Choices, "/") + "]"
+	case p.Type == "bool":
+		text += " [y/n]"
+	}
+	if p.HasDefault {
+		text += " (default: " + p.Default + ")"
+	}
+	return text
+}
+
+// scriptArgs resolves the parameters a script declares before it runs.
+// Prompting needs a terminal for both the question and the answer, and can
+// be turned off with --no-prompt.
+func scriptArgs(path string, args []string, opts *RunOptions) ([]string, error) {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	prompt := !opts.NoPrompt && canPrompt()
+	return resolveParams(meta.Params, args, prompt, os.Stdin, os.Stdout)
+}
+
+// resolveParams validates the arguments given for a script's declared
+// parameters and fills in the missing ones: by prompting when prompt is
+// set, else from their defaults. Arguments beyond the declared parameters
+// are passed through unchecked.
+func resolveParams(params []*ScriptParam, args []string, prompt bool, in io.Reader, out io.Writer) ([]string, error) {
+	resolved := append([]string(nil), args...)
+	for i, param := range params {
+		if i < len(args) {
+			value, err := param.validate(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", param.Name, err)
+			}
+			resolved[i] = value
+		}
+	}
+	if len(args) >= len(params) {
+		return resolved, nil
+	}
+
+	reader := bufio.NewReader(in)
+	for _, param := range params[len(args):] {
+		if !prompt {
+			if !param.HasDefault {
+				return nil, fmt.Errorf("missing parameter %s", param.usage())
+			}
+			resolved = append(resolved, param.Default)
+			continue
+		}
+		value, err := promptParam(param, reader, out)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, value)
+	}
+	return resolved, nil
+}
+
+// promptParam asks for a parameter until a valid value (or the default,
+// for an empty answer) is given
+func promptParam(param *ScriptParam, reader *bufio.Reader, out io.Writer) (string, error) {
+	for {
+		fmt.Fprintf(out, "%s: ", param.usage())
+		line, err := reader.ReadString('\n')
+		answer := strings.TrimSpace(line)
+		if err != nil && answer == "" {
+			fmt.Fprintln(out)
+			return "", fmt.Errorf("no value given for %s", param.Name)
+		}
+		if answer == "" && param.HasDefault {
+			return param.Default, nil
+		}
+		if answer == "" {
+			fmt.Fprintf(out, "%s is required\n", param.Name)
+			continue
+		}
+		value, verr := param.validate(answer)
+		if verr == nil {
+			return value, nil
+		}
+		fmt.Fprintf(out, "Invalid %s: %v\n", param.Name, verr)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %v", param.Name, verr)
+		}
+	}
+}
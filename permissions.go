@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Who gets execute permission when no exact mode is configured
+const (
+	executeOwner = "owner"
+	executeGroup = "group"
+	executeAll   = "all"
+)
+
+// PermissionPolicy is the config section controlling the permission bits
+// ready, add and compile apply
+type PermissionPolicy struct {
+	// Mode sets exact permission bits, e.g. "0755"; otherwise execute bits
+	// are added to the file's existing permissions
+	Mode string `json:"mode,omitempty"`
+	// Execute is who gets the added execute bits: owner (default), group or all
+	Execute string `json:"execute,omitempty"`
+	// HonorUmask drops the bits the process umask excludes
+	HonorUmask bool `json:"honorUmask,omitempty"`
+}
+
+// Permissions is a resolved permission policy
+type Permissions struct {
+	Mode    os.FileMode // exact bits to set, when Exact
+	Exact   bool
+	Execute os.FileMode // execute bits added otherwise
+	Umask   bool
+}
+
+// resolvePermissions combines the configured policy with a --mode flag,
+// which wins over the config
+func resolvePermissions(config *Config, modeFlag string) (*Permissions, error) {
+	policy := PermissionPolicy{}
+	if config.Permissions != nil {
+		policy = *config.Permissions
+	}
+	perms := &Permissions{Umask: policy.HonorUmask}
+	switch policy.Execute {
+	case "", executeOwner:
+		perms.Execute = 0100
+	case executeGroup:
+		perms.Execute = 0110
+	case executeAll:
+		perms.Execute = 0111
+	default:
+		return nil, fmt.Errorf("unknown permissions.execute %q in config (expected %s, %s or %s)", policy.Execute, executeOwner, executeGroup, executeAll)
+	}
+
+	mode := policy.Mode
+	if modeFlag != "" {
+		mode = modeFlag
+	}
+	if mode != "" {
+		bits, err := parseMode(mode)
+		if err != nil {
+			return nil, err
+		}
+		perms.Mode = bits
+		perms.Exact = true
+	}
+	return perms, nil
+}
+
+// parseMode parses octal permission bits such as "0755" or "750"
+func parseMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid mode %q (expected octal permission bits such as 0755)", s)
+	}
+	if n&0100 == 0 {
+		return 0, fmt.Errorf("mode %s doesn't let the owner execute the file", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// target returns the permission bits a file with the current bits should
+// end up with
+func (p *Permissions) target(current os.FileMode) os.FileMode {
+	if p.Exact {
+		if p.Umask {
+			return p.Mode &^ umask()
+		}
+		return p.Mode
+	}
+	bits := p.Execute
+	if p.Umask {
+		bits &^= umask()
+	}
+	return current | bits
+}
+
+// satisfied reports whether a file already has the permissions the policy
+// asks for
+func (p *Permissions) satisfied(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode().Perm() == p.target(info.Mode().Perm())
+}
+
+// apply sets the policy's permissions on a file
+func (p *Permissions) apply(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	target := p.target(info.Mode().Perm())
+	if target == info.Mode().Perm() {
+		return nil
+	}
+	return os.Chmod(path, target)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// presetPrefix marks a preset in place of a script's arguments, as in
+// "scripts gitprune @weekly"
+const presetPrefix = "@"
+
+// presetNamePattern matches a preset name
+var presetNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func printPresetUsage() {
+	fmt.Println("Usage: scripts preset <command> [args...]")
+	fmt.Println("  save <script> <preset> -- [args...]    Save arguments to run as 'scripts <script> @<preset>'")
+	fmt.Println("  list [<script>]                        List the presets")
+	fmt.Println("  rm <script> <preset>                   Remove a preset")
+}
+
+// runPresetCommand handles the "preset" command group
+func runPresetCommand(args []string, config *Config) {
+	if len(args) == 0 {
+		printPresetUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "save":
+		dash := -1
+		for i, arg := range args {
+			if arg == "--" {
+				dash = i
+				break
+			}
+		}
+		if dash != 3 {
+			fmt.Println("Usage: scripts preset save <script> <preset> -- [args...]")
+			os.Exit(1)
+		}
+		err = savePreset(args[1], args[2], args[4:], config)
+	case "list", "ls":
+		if len(args) > 2 {
+			fmt.Println("Usage: scripts preset list [<script>]")
+			os.Exit(1)
+		}
+		var script string
+		if len(args) == 2 {
+			script = args[1]
+		}
+		err = printPresets(script, config)
+	case "rm", "remove":
+		if len(args) != 3 {
+			fmt.Println("Usage: scripts preset rm <script> <preset>")
+			os.Exit(1)
+		}
+		err = removePreset(args[1], args[2], config)
+	default:
+		fmt.Printf("Unknown preset command: %s\n", args[0])
+		printPresetUsage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// presetScript returns the name presets of a script are saved under,
+// which is its file name as runs are recorded
+func presetScript(name string, config *Config) (string, error) {
+	path, err := findScript(name, config)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(filepath.Base(path), ".sh"), nil
+}
+
+// savePreset saves arguments for a script under a name, replacing a
+// preset of that name
+func savePreset(name, preset string, args []string, config *Config) error {
+	if !presetNamePattern.MatchString(preset) {
+		return fmt.Errorf("invalid preset name %q (use letters, digits, _, . and -)", preset)
+	}
+	script, err := presetScript(name, config)
+	if err != nil {
+		return err
+	}
+	if config.Presets == nil {
+		config.Presets = map[string]map[string][]string{}
+	}
+	if config.Presets[script] == nil {
+		config.Presets[script] = map[string][]string{}
+	}
+	_, replaced := config.Presets[script][preset]
+	config.Presets[script][preset] = append([]string{}, args...)
+	if err := saveConfig(config); err != nil {
+		return err
+	}
+	if replaced {
+		fmt.Printf("Replaced preset %s of %s (run it with 'scripts %s @%s')\n", preset, script, script, preset)
+	} else {
+		fmt.Printf("Saved preset %s of %s (run it with 'scripts %s @%s')\n", preset, script, script, preset)
+	}
+	return nil
+}
+
+// removePreset removes a preset of a script
+func removePreset(name, preset string, config *Config) error {
+	script, err := presetScript(name, config)
+	if err != nil {
+		// The script may be gone while its presets remain
+		script = name
+	}
+	if _, ok := config.Presets[script][preset]; !ok {
+		return fmt.Errorf("no preset %s for %s", preset, script)
+	}
+	delete(config.Presets[script], preset)
+	if len(config.Presets[script]) == 0 {
+		delete(config.Presets, script)
+	}
+	if err := saveConfig(config); err != nil {
+		return err
+	}
+	fmt.Printf("Removed preset %s of %s\n", preset, script)
+	return nil
+}
+
+// printPresets lists the presets of every script, or of one
+func printPresets(name string, config *Config) error {
+	scripts := make([]string, 0, len(config.Presets))
+	if name != "" {
+		script, err := presetScript(name, config)
+		if err != nil {
+			script = name
+		}
+		if len(config.Presets[script]) == 0 {
+			fmt.Printf("No presets for %s (save one with 'scripts preset save %s <preset> -- [args...]')\n", script, script)
+			return nil
+		}
+		scripts = append(scripts, script)
+	} else {
+		for script := range config.Presets {
+			scripts = append(scripts, script)
+		}
+		sort.Strings(scripts)
+	}
+	if len(scripts) == 0 {
+		fmt.Println("No presets (save one with 'scripts preset save <script> <preset> -- [args...]')")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCRIPT\tPRESET\tARGS")
+	for _, script := range scripts {
+		presets := make([]string, 0, len(config.Presets[script]))
+		for preset := range config.Presets[script] {
+			presets = append(presets, preset)
+		}
+		sort.Strings(presets)
+		for _, preset := range presets {
+			fmt.Fprintf(w, "%s\t@%s\t%s\n", script, preset, strings.Join(config.Presets[script][preset], " "))
+		}
+	}
+	return w.Flush()
+}
+
+// expandPreset replaces a leading "@<preset>" in a script's arguments with
+// the preset's arguments; those after it are appended
+func expandPreset(script string, args []string, config *Config) ([]string, error) {
+	if len(args) == 0 || !strings.HasPrefix(args[0], presetPrefix) {
+		return args, nil
+	}
+	preset := strings.TrimPrefix(args[0], presetPrefix)
+	saved, ok := config.Presets[script][preset]
+	if !ok {
+		return nil, fmt.Errorf("no preset %s for %s (see 'scripts preset list %s')", preset, script, script)
+	}
+	return append(append([]string{}, saved...), args[1:]...), nil
+}
+
+// presetNames returns the presets of a script as they are typed
+func presetNames(script string, config *Config) []string {
+	var names []string
+	for preset := range config.Presets[script] {
+		names = append(names, presetPrefix+preset)
+	}
+	sort.Strings(names)
+	return names
+}
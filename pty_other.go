@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// openPty opens a new pseudo-terminal pair; only supported on Linux, so
+// recordings elsewhere capture the output streams instead
+func openPty() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("pseudo-terminals are not supported on this platform")
+}
+
+func ptyProcAttr(current *syscall.SysProcAttr) *syscall.SysProcAttr { return current }
+
+func resizePty(ptmx *os.File) {}
+
+func watchResize(ptmx *os.File) func() { return func() {} }
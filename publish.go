@@ -0,0 +1,384 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// bundleManifest describes a published archive's contents
+const bundleManifest = "bundle.json"
+
+// Bundle is the manifest of a published script archive
+type Bundle struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+	// Requires lists the commands the scripts need that aren't bundled
+	Requires []string `json:"requires,omitempty"`
+	// Scripts holds the published script first, then the scripts it
+	// depends on
+	Scripts []*BundleScript `json:"scripts"`
+}
+
+// BundleScript is a script in a published archive
+type BundleScript struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// PublishOptions describes a publish request from the command line
+type PublishOptions struct {
+	Name    string
+	Version string // default: "# scripts:version", then the script's date
+	Output  string // directory the archive is written to
+	Tap     string // tap to push to instead
+	// IncludePrivate allows bundling scripts marked private
+	IncludePrivate bool
+}
+
+func parsePublishArgs(args []string, config *Config) (*PublishOptions, error) {
+	opts := &PublishOptions{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--version" || arg == "--output" || arg == "-o" || arg == "--tap":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			switch arg {
+			case "--version":
+				opts.Version = args[i]
+			case "--tap":
+				opts.Tap = args[i]
+			default:
+				opts.Output = args[i]
+			}
+		case arg == "--include-private":
+			opts.IncludePrivate = true
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			if opts.Name != "" {
+				return nil, fmt.Errorf("only one script may be given")
+			}
+			opts.Name = strings.TrimSuffix(arg, ".sh")
+		}
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("no script given")
+	}
+	if opts.Tap != "" && opts.Output != "" {
+		return nil, fmt.Errorf("--tap and --output can't be combined")
+	}
+	// The configured tap is the default destination, unless an archive
+	// was asked for
+	if opts.Tap == "" && opts.Output == "" {
+		opts.Tap = config.PublishTap
+	}
+	if opts.Output == "" {
+		opts.Output = "."
+	}
+	if strings.ContainsAny(opts.Version, " /") {
+		return nil, fmt.Errorf("invalid version %q: no spaces or slashes", opts.Version)
+	}
+	return opts, nil
+}
+
+// buildBundle collects a script and the scripts it requires, following
+// "# scripts:requires" transitively. Requirements that aren't installed
+// scripts are listed as commands.
+func buildBundle(opts *PublishOptions, config *Config) (*Bundle, map[string][]byte, error) {
+	mainPath := filepath.Join(config.ScriptDir, opts.Name+".sh")
+	info, err := os.Stat(mainPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("script %s not found", opts.Name)
+	}
+	meta, err := readMetadata(mainPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bundle := &Bundle{
+		Name:        opts.Name,
+		Version:     opts.Version,
+		Description: meta.value("description"),
+	}
+	if bundle.Version == "" {
+		bundle.Version = meta.value("version")
+	}
+	if bundle.Version == "" {
+		bundle.Version = info.ModTime().Format("2006.01.02.1504")
+	}
+
+	files := map[string][]byte{}
+	commands := map[string]bool{}
+	queue := []string{opts.Name}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := files[name]; ok {
+			continue
+		}
+		path := filepath.Join(config.ScriptDir, name+".sh")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if !opts.IncludePrivate && isPrivate(path) {
+			return nil, nil, privateError(name, "published")
+		}
+		files[name] = content
+		bundle.Scripts = append(bundle.Scripts, &BundleScript{Name: name, SHA256: checksum(content)})
+
+		meta, err := readMetadata(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, req := range meta.fields("requires") {
+			if _, err := os.Stat(filepath.Join(config.ScriptDir, req+".sh")); err == nil && validName(req) {
+				queue = append(queue, req)
+			} else if !commands[req] {
+				commands[req] = true
+				bundle.Requires = append(bundle.Requires, req)
+			}
+		}
+	}
+	return bundle, files, nil
+}
+
+// publish bundles a script with its dependencies, as an archive or by
+// pushing it to a tap
+func publish(opts *PublishOptions, config *Config) error {
+	bundle, files, err := buildBundle(opts, config)
+	if err != nil {
+		return err
+	}
+	if opts.Tap != "" {
+		return publishToTap(opts.Tap, bundle, files, config)
+	}
+
+	manifest, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := bundle.Name + "-" + bundle.Version
+	entries := []tarEntry{{Name: dir + "/" + bundleManifest, Mode: 0644, Data: manifest}}
+	for _, script := range bundle.Scripts {
+		entries = append(entries, tarEntry{Name: dir + "/" + script.Name + ".sh", Mode: 0755, Data: files[script.Name]})
+	}
+
+	if err := os.MkdirAll(opts.Output, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", opts.Output, err)
+	}
+	archivePath := filepath.Join(opts.Output, dir+".tar.gz")
+	var buf bytes.Buffer
+	if err := writeTarGz(&buf, entries); err != nil {
+		return err
+	}
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", archivePath, err)
+	}
+	sum := checksum(buf.Bytes())
+	sumLine := fmt.Sprintf("%s  %s\n", sum, filepath.Base(archivePath))
+	if err := os.WriteFile(archivePath+".sha256", []byte(sumLine), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum: %v", err)
+	}
+
+	fmt.Printf("Published %s %s as %s\n", bundle.Name, bundle.Version, archivePath)
+	if len(bundle.Scripts) > 1 {
+		fmt.Printf("Bundled dependencies: %s\n", strings.Join(bundleScriptNames(bundle)[1:], ", "))
+	}
+	if len(bundle.Requires) > 0 {
+		fmt.Printf("Requires: %s\n", strings.Join(bundle.Requires, ", "))
+	}
+	fmt.Printf("sha256: %s\n", sum)
+	return nil
+}
+
+// bundleScriptNames lists the bundled scripts, the published one first
+func bundleScriptNames(bundle *Bundle) []string {
+	names := make([]string, len(bundle.Scripts))
+	for i, script := range bundle.Scripts {
+		names[i] = script.Name
+	}
+	return names
+}
+
+// publishToTap commits a bundle's scripts to a tap and pushes it
+func publishToTap(name string, bundle *Bundle, files map[string][]byte, config *Config) error {
+	if isOffline() {
+		return errOffline("publishing to tap " + name)
+	}
+	tap, err := loadTap(name, config)
+	if err != nil {
+		return err
+	}
+	// Publish next to the tap's existing scripts
+	dir := tap.Dir
+	if info, err := os.Stat(filepath.Join(tap.Dir, "scripts")); err == nil && info.IsDir() {
+		dir = filepath.Join(tap.Dir, "scripts")
+	}
+
+	git := func(args ...string) error {
+		cmd := exec.Command("git", append([]string{"-C", tap.Dir}, args...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s failed: %v", args[0], err)
+		}
+		return nil
+	}
+	if err := git("pull", "--quiet", "--ff-only"); err != nil {
+		return err
+	}
+	var paths []string
+	for _, script := range bundle.Scripts {
+		path := filepath.Join(dir, script.Name+".sh")
+		if err := os.WriteFile(path, files[script.Name], 0755); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+	if err := git(append([]string{"add", "--"}, paths...)...); err != nil {
+		return err
+	}
+	if exec.Command("git", "-C", tap.Dir, "diff", "--cached", "--quiet").Run() == nil {
+		fmt.Printf("%s already has %s %s\n", tap.Name, bundle.Name, bundle.Version)
+		return nil
+	}
+	message := fmt.Sprintf("Publish %s %s", bundle.Name, bundle.Version)
+	if err := git("commit", "--quiet", "-m", message); err != nil {
+		return err
+	}
+	if err := git("push", "--quiet", "origin", "HEAD"); err != nil {
+		return err
+	}
+	fmt.Printf("Published %s %s to %s - install it with 'scripts install %s/%s'\n",
+		bundle.Name, bundle.Version, tap.Name, tap.Name, bundle.Name)
+	return nil
+}
+
+// isArchive reports whether an install argument names a published archive
+func isArchive(ref string) bool {
+	return strings.HasSuffix(ref, ".tar.gz") || strings.HasSuffix(ref, ".tgz")
+}
+
+// readBundle unpacks a published archive, verifying each script against
+// the manifest's checksum
+func readBundle(data []byte) (*Bundle, map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a published archive: %v", err)
+	}
+	defer gz.Close()
+
+	var bundle *Bundle
+	contents := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxDownload))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		base := path.Base(hdr.Name)
+		if base == bundleManifest {
+			bundle = &Bundle{}
+			if err := json.Unmarshal(data, bundle); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %v", bundleManifest, err)
+			}
+			continue
+		}
+		contents[strings.TrimSuffix(base, ".sh")] = data
+	}
+	if bundle == nil || len(bundle.Scripts) == 0 {
+		return nil, nil, fmt.Errorf("not a published archive: no %s", bundleManifest)
+	}
+
+	files := map[string][]byte{}
+	for _, script := range bundle.Scripts {
+		content, ok := contents[script.Name]
+		if !validName(script.Name) || !ok {
+			return nil, nil, fmt.Errorf("archive is missing %s.sh", script.Name)
+		}
+		if got := checksum(content); !strings.EqualFold(got, script.SHA256) {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s.sh: expected %s, got %s", script.Name, script.SHA256, got)
+		}
+		files[script.Name] = content
+	}
+	return bundle, files, nil
+}
+
+// installArchive installs a published archive from a path or URL. Bundled
+// dependencies are installed when missing; ones that differ from an
+// existing script are only replaced with force.
+func installArchive(location string, force bool, mode string, config *Config) error {
+	data, err := fetch(location)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", location, err)
+	}
+	bundle, files, err := readBundle(data)
+	if err != nil {
+		return err
+	}
+
+	name := bundle.Scripts[0].Name
+	if _, err := installScript(name, files[name], force, mode, config); err != nil {
+		return err
+	}
+	for _, dep := range bundle.Scripts[1:] {
+		existing, err := os.ReadFile(filepath.Join(config.ScriptDir, dep.Name+".sh"))
+		if err == nil && !force {
+			if checksum(existing) != dep.SHA256 {
+				fmt.Printf("Warning: keeping the existing %s.sh, which differs from the bundled one (use --force to replace it)\n", dep.Name)
+			}
+			continue
+		}
+		if _, err := installScript(dep.Name, files[dep.Name], true, mode, config); err != nil {
+			return err
+		}
+		fmt.Printf("Installed dependency %s.sh\n", dep.Name)
+	}
+	fmt.Printf("Installed %s %s as %s.sh\n", bundle.Name, bundle.Version, name)
+
+	var missing []string
+	for _, command := range bundle.Requires {
+		if _, err := exec.LookPath(command); err != nil {
+			missing = append(missing, command)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Warning: %s requires commands that aren't installed: %s\n", name, strings.Join(missing, ", "))
+	}
+	// Local archives are found again by absolute path when upgrading
+	if !strings.Contains(location, "://") {
+		if abs, err := filepath.Abs(location); err == nil {
+			location = abs
+		}
+	}
+	return recordOrigin(name, &Origin{
+		Kind:     originArchive,
+		Source:   location,
+		Version:  bundle.Version,
+		Checksum: checksum(files[name]),
+	}, config)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pythonBackends maps each Python packaging backend to the tool key used to
+// resolve its command (see defaultCompilers)
+var pythonBackends = map[string]string{
+	"pyinstaller": "python",
+	"nuitka":      "nuitka",
+	"shiv":        "shiv",
+	"zipapp":      "zipapp",
+}
+
+// defaultPythonBackend is used when neither --backend nor the config picks one
+const defaultPythonBackend = "pyinstaller"
+
+// pythonBackend returns the backend to package Python with: the one given on
+// the command line, else the configured one, else PyInstaller
+func pythonBackend(opts *CompileOptions, config *Config) (string, error) {
+	backend := opts.Backend
+	if backend == "" {
+		backend = config.PythonBackend
+	}
+	if backend == "" {
+		return defaultPythonBackend, nil
+	}
+	if _, ok := pythonBackends[backend]; !ok {
+		var names []string
+		for name := range pythonBackends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown Python backend %q (supported: %s)", backend, strings.Join(names, ", "))
+	}
+	return backend, nil
+}
+
+func compilePython(job *CompileJob) error {
+	switch job.Backend {
+	case "nuitka":
+		return compilePythonNuitka(job)
+	case "shiv":
+		return compilePythonShiv(job)
+	case "zipapp":
+		return compilePythonZipapp(job)
+	}
+	return compilePythonPyInstaller(job)
+}
+
+func compilePythonPyInstaller(job *CompileJob) error {
+	// Use PyInstaller to create standalone executable
+	outputPath := job.OutputPath
+	args := []string{"--onefile", "--distpath", filepath.Dir(outputPath), "--name", filepath.Base(outputPath)}
+	args = append(args, job.Flags...)
+	args = append(args, job.SourcePath)
+	err := job.command("python", args...).Run()
+	if err != nil {
+		return fmt.Errorf("PyInstaller compilation failed: %v (make sure PyInstaller is installed)", err)
+	}
+
+	// PyInstaller creates files in dist directory, move to final location
+	distPath := filepath.Join(filepath.Dir(outputPath), filepath.Base(outputPath))
+	if _, err := os.Stat(distPath); err == nil {
+		return os.Rename(distPath, outputPath)
+	}
+	return nil
+}
+
+// compilePythonNuitka compiles the script to a native onefile executable
+func compilePythonNuitka(job *CompileJob) error {
+	// Nuitka leaves build directories next to its output, so keep them out
+	// of BinDir
+	tmpDir, err := os.MkdirTemp("", "scripts-nuitka-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	name := filepath.Base(job.OutputPath)
+	args := []string{"--onefile", "--assume-yes-for-downloads", "--output-dir=" + tmpDir, "--output-filename=" + name}
+	args = append(args, job.Flags...)
+	args = append(args, job.SourcePath)
+	if err := job.command("nuitka", args...).Run(); err != nil {
+		return fmt.Errorf("Nuitka compilation failed: %v (make sure Nuitka is installed)", err)
+	}
+	return moveFile(filepath.Join(tmpDir, name), job.OutputPath)
+}
+
+// compilePythonShiv bundles the script and the dependencies listed in a
+// sibling requirements.txt into a self-extracting zipapp
+func compilePythonShiv(job *CompileJob) error {
+	stageDir, module, err := stagePythonScript(job.SourcePath, "")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stageDir)
+
+	// shiv entry points must be callables, so wrap the script in one that
+	// runs it as __main__
+	wrapper := fmt.Sprintf("import runpy\n\n\ndef main():\n    runpy.run_module(%q, run_name=\"__main__\")\n", module)
+	if err := os.WriteFile(filepath.Join(stageDir, "_scripts_entry.py"), []byte(wrapper), 0644); err != nil {
+		return fmt.Errorf("failed to write entry point: %v", err)
+	}
+
+	args := []string{"--site-packages", stageDir, "-e", "_scripts_entry:main", "-p", "/usr/bin/env python3", "-o", job.OutputPath}
+	if requirements := siblingRequirements(job.SourcePath); requirements != "" {
+		args = append(args, "-r", requirements)
+	}
+	args = append(args, job.Flags...)
+	if err := job.command("shiv", args...).Run(); err != nil {
+		return fmt.Errorf("shiv packaging failed: %v (make sure shiv is installed)", err)
+	}
+	return nil
+}
+
+// compilePythonZipapp packages the script with the standard library zipapp
+// module; it needs no extra tools but can't bundle dependencies
+func compilePythonZipapp(job *CompileJob) error {
+	stageDir, _, err := stagePythonScript(job.SourcePath, "__main__")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stageDir)
+
+	if siblingRequirements(job.SourcePath) != "" {
+		fmt.Fprintln(job.out(), "Warning: zipapp does not bundle requirements.txt dependencies; use --backend shiv to include them")
+	}
+	args := []string{stageDir, "-o", job.OutputPath, "-p", "/usr/bin/env python3"}
+	args = append(args, job.Flags...)
+	if err := job.command("zipapp", args...).Run(); err != nil {
+		return fmt.Errorf("zipapp packaging failed: %v", err)
+	}
+	return nil
+}
+
+// stagePythonScript copies a script into a fresh directory as a module,
+// named after the script unless a module name is given. It returns the
+// directory and the module name.
+func stagePythonScript(sourcePath, module string) (string, string, error) {
+	if module == "" {
+		module = strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+		module = strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(module)
+	}
+	stageDir, err := os.MkdirTemp("", "scripts-python-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	if err := copyFile(sourcePath, filepath.Join(stageDir, module+".py")); err != nil {
+		os.RemoveAll(stageDir)
+		return "", "", err
+	}
+	return stageDir, module, nil
+}
+
+// siblingRequirements returns the requirements.txt next to a script, if any
+func siblingRequirements(sourcePath string) string {
+	requirements := filepath.Join(filepath.Dir(sourcePath), "requirements.txt")
+	if _, err := os.Stat(requirements); err != nil {
+		return ""
+	}
+	return requirements
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// readOnlyEnv turns on read-only mode without touching the config
+const readOnlyEnv = "SCRIPTS_READONLY"
+
+// mutatingCommands change the installed scripts or binaries, so read-only
+// mode refuses them. A list limits a command group to those subcommands.
+var mutatingCommands = map[string][]string{
+	"add":         nil,
+	"adopt":       nil,
+	"archive":     nil,
+	"deprecate":   nil,
+	"sync":        nil,
+	"unarchive":   nil,
+	"rm":          nil,
+	"new":         nil,
+	"install":     nil,
+	"upgrade":     nil,
+	"update":      nil,
+	"ready":       nil,
+	"compile":     nil,
+	"compile-git": nil,
+	"rebuild":     nil,
+	"tap":         {"add", "update", "remove", "rm"},
+	"bin":         {"rollback", "rm"},
+}
+
+// isReadOnly reports whether the tool is locked down to running scripts,
+// for hosts whose scripts are provisioned by configuration management
+func isReadOnly(config *Config) bool {
+	switch strings.ToLower(os.Getenv(readOnlyEnv)) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return config.ReadOnly
+}
+
+// blockedCommand returns the command line's command (with its subcommand)
+// when read-only mode refuses it, or "" when it may run
+func blockedCommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	subcommands, ok := mutatingCommands[args[0]]
+	if !ok {
+		return ""
+	}
+	if subcommands == nil {
+		// Checking permissions or the sync status changes nothing
+		if (args[0] == "ready" && slices.Contains(args, "--check")) || (args[0] == "sync" && len(args) > 1 && args[1] == "status") {
+			return ""
+		}
+		return args[0]
+	}
+	if len(args) > 1 && slices.Contains(subcommands, args[1]) {
+		return args[0] + " " + args[1]
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// scriptExts are the extensions of interpreted scripts ready looks at;
+// extensionless files count when they start with a shebang
+var scriptExts = map[string]bool{
+	".sh": true, ".bash": true, ".zsh": true, ".fish": true,
+	".py": true, ".rb": true, ".pl": true,
+}
+
+// ReadyOptions controls which scripts ready covers and the permissions it
+// applies to them
+type ReadyOptions struct {
+	Recursive bool         // include scripts in subdirectories
+	Perms     *Permissions // permission policy to apply
+}
+
+// isScriptFile reports whether path is a script ready should manage
+func isScriptFile(path string) bool {
+	ext := filepath.Ext(path)
+	if ext != "" {
+		return scriptExts[ext]
+	}
+	return hasShebang(path)
+}
+
+// hasShebang reports whether a file starts with "#!"
+func hasShebang(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	prefix := make([]byte, 2)
+	n, _ := bufio.NewReader(f).Read(prefix)
+	return n == 2 && string(prefix) == "#!"
+}
+
+// shebangCommand returns the interpreter command line from a script's
+// shebang, e.g. ["/usr/bin/env", "python3"], or nil if it has none
+func shebangCommand(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "#!") {
+		return nil
+	}
+	return strings.Fields(strings.TrimPrefix(scanner.Text(), "#!"))
+}
+
+// configuredInterp returns the interpreter command line the interp config
+// sets for a script, by its file name or its name without the extension,
+// or nil if it sets none
+func configuredInterp(path string, config *Config) ([]string, error) {
+	base := filepath.Base(path)
+	interp, ok := config.Interp[base]
+	if !ok {
+		interp, ok = config.Interp[strings.TrimSuffix(base, filepath.Ext(base))]
+	}
+	if !ok {
+		return nil, nil
+	}
+	command, err := splitQuoted(interp)
+	if err != nil || len(command) == 0 {
+		return nil, fmt.Errorf("invalid interp for %s: %q", base, interp)
+	}
+	return command, nil
+}
+
+// strictCommand is the interpreter shell scripts run with in strict mode
+var strictCommand = []string{"bash", "-euo", "pipefail"}
+
+// runsStrict reports whether strict mode applies to a script: a shell
+// script (a bash or sh shebang, or a .sh file without one) that doesn't
+// opt out with "# scripts:no-strict"
+func runsStrict(path string, config *Config) bool {
+	if !config.StrictMode {
+		return false
+	}
+	shebang := shebangCommand(path)
+	switch {
+	case len(shebang) == 0:
+		if filepath.Ext(path) != ".sh" {
+			return false
+		}
+	default:
+		interpreter := filepath.Base(shebang[0])
+		if interpreter == "env" && len(shebang) > 1 {
+			interpreter = shebang[1]
+		}
+		if interpreter != "bash" && interpreter != "sh" {
+			return false
+		}
+	}
+	meta, err := readMetadata(path)
+	if err != nil {
+		return false
+	}
+	_, optOut := meta.Values["no-strict"]
+	return !optOut
+}
+
+// scriptCommand builds the command that runs a script. An interpreter set
+// for it in the config wins over its shebang, as does bash in strict mode
+// for shell scripts. A script that lost its
+// execute bit (e.g. after a checkout) is made executable when autoReady is
+// set, and otherwise run through its shebang interpreter.
+func scriptCommand(path string, args []string, config *Config) (*exec.Cmd, error) {
+	interp, err := configuredInterp(path, config)
+	if err != nil {
+		return nil, err
+	}
+	if interp != nil {
+		return exec.Command(interp[0], append(append(interp[1:], path), args...)...), nil
+	}
+	if runsStrict(path, config) {
+		return exec.Command(strictCommand[0], append(append(strictCommand[1:], path), args...)...), nil
+	}
+	if isExecutable(path) {
+		return exec.Command(path, args...), nil
+	}
+	// Read-only hosts keep the provisioned permissions
+	if config.AutoReady && !isReadOnly(config) {
+		perms, err := resolvePermissions(config, "")
+		if err != nil {
+			return nil, err
+		}
+		if err := perms.apply(path); err != nil {
+			return nil, fmt.Errorf("failed to make %s executable: %v", filepath.Base(path), err)
+		}
+		return exec.Command(path, args...), nil
+	}
+	interpreter := shebangCommand(path)
+	if len(interpreter) == 0 {
+		interpreter = []string{"sh"}
+	}
+	return exec.Command(interpreter[0], append(append(interpreter[1:], path), args...)...), nil
+}
+
+// scriptFiles expands paths into the script files they name. Directories
+// contribute their scripts (and those of subdirectories when recursive),
+// except what their .scriptsignore matches; other paths get a .sh extension
+// unless they exist as given.
+func scriptFiles(paths []string, opts *ReadyOptions) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() {
+			root := path
+			ignore := loadIgnore(root)
+			err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if entry.IsDir() {
+					// Stay at the top level unless asked, and out of .git and
+					// friends and the archive
+					if path != root && (!opts.Recursive || strings.HasPrefix(entry.Name(), ".") || path == filepath.Join(root, archivedDir) || ignore.ignored(path, true)) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if entry.Type().IsRegular() && isScriptFile(path) && !ignore.ignored(path, false) {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to scan %s: %v", path, err)
+			}
+			continue
+		}
+		if err != nil && !strings.HasSuffix(path, ".sh") {
+			path = path + ".sh"
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// displayName names a script relative to the directory it was found under
+func displayName(file string, paths []string) string {
+	for _, path := range paths {
+		if rel, err := filepath.Rel(path, file); err == nil && !strings.HasPrefix(rel, "..") && rel != "." {
+			return rel
+		}
+	}
+	return filepath.Base(file)
+}
+
+func readyScripts(paths []string, opts *ReadyOptions) error {
+	files, err := scriptFiles(paths, opts)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := displayName(file, paths)
+		if !opts.Perms.satisfied(file) {
+			fmt.Printf("Making %s executable\n", name)
+			if err := opts.Perms.apply(file); err != nil {
+				return fmt.Errorf("failed to make %s executable: %v", file, err)
+			}
+		} else {
+			fmt.Printf("%s is already executable\n", name)
+		}
+	}
+	return nil
+}
+
+// checkScripts reports how many scripts paths cover and describes those not
+// matching the permission policy, without changing anything
+func checkScripts(paths []string, opts *ReadyOptions) (int, []string, error) {
+	files, err := scriptFiles(paths, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	var problems []string
+	for _, file := range files {
+		if opts.Perms.satisfied(file) {
+			continue
+		}
+		name := displayName(file, paths)
+		info, err := os.Stat(file)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+		case info.Mode().Perm()&0100 == 0:
+			problems = append(problems, fmt.Sprintf("%s is not executable", name))
+		default:
+			problems = append(problems, fmt.Sprintf("%s has mode %04o, expected %04o", name, info.Mode().Perm(), opts.Perms.target(info.Mode().Perm())))
+		}
+	}
+	return len(files), problems, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// BuildOutcome is the result of building a single binary as part of a batch
+type BuildOutcome struct {
+	Name   string
+	Status string // "rebuilt", "up to date" or "failed"
+	Detail string
+}
+
+// parseJobs parses the value of a --jobs flag
+func parseJobs(value string) (int, error) {
+	jobs, err := strconv.Atoi(value)
+	if err != nil || jobs < 1 {
+		return 0, fmt.Errorf("--jobs requires a positive number, got %q", value)
+	}
+	return jobs, nil
+}
+
+// rebuildBinaries recompiles tracked binaries from the manifest using a pool
+// of workers. Only binaries whose sources changed are rebuilt unless all is
+// set. names restricts the rebuild to specific binaries.
+func rebuildBinaries(names []string, all bool, jobs int, config *Config) ([]BuildOutcome, error) {
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		for name := range manifest.Binaries {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if manifest.Binaries[name] == nil {
+			return nil, fmt.Errorf("binary %s has no build record (compile it with 'scripts compile' first)", name)
+		}
+	}
+	return runBuildPool(len(names), jobs, func(i int) BuildOutcome {
+		return rebuildOne(names[i], manifest.Binaries[names[i]], all, config)
+	}), nil
+}
+
+// runBuildPool runs count builds on a pool of workers, reporting each one as
+// it finishes, and returns the outcomes in their original order
+func runBuildPool(count, jobs int, build func(i int) BuildOutcome) []BuildOutcome {
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	outcomes := make([]BuildOutcome, count)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				outcomes[i] = build(i)
+				fmt.Printf("  %s: %s\n", outcomes[i].Name, outcomes[i].Status)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return outcomes
+}
+
+// buildOutcome converts the result of a compile into a summary row
+func buildOutcome(name string, result *CompileResult, err error, output string) BuildOutcome {
+	outcome := BuildOutcome{Name: name}
+	switch {
+	case err != nil:
+		outcome.Status = "failed"
+		outcome.Detail = err.Error()
+		// The first error says why; the last line is usually a note or a count
+		reason := lastLine(output)
+		if diagnostics := parseDiagnostics(output); len(diagnostics) > 0 {
+			reason = diagnostics[0].String()
+		}
+		if reason != "" && !strings.Contains(outcome.Detail, reason) {
+			outcome.Detail += ": " + reason
+		}
+	case result.UpToDate:
+		outcome.Status = "up to date"
+	default:
+		outcome.Status = "rebuilt"
+	}
+	return outcome
+}
+
+// rebuildOne recompiles a single binary with the options it was built with,
+// capturing the build output so parallel builds don't interleave
+func rebuildOne(name string, record *BinaryRecord, force bool, config *Config) BuildOutcome {
+	outcome := BuildOutcome{Name: name}
+	var output bytes.Buffer
+	opts := &CompileOptions{
+		SourcePath: record.Source,
+		BinaryName: name,
+		Flags:      record.Flags,
+		CargoBin:   record.CargoBin,
+		Artifact:   record.Artifact,
+		Small:      record.Small,
+		Static:     record.Static,
+		BuildEnv:   record.BuildEnv,
+		Tags:       record.Tags,
+		Features:   record.Features,
+		Backend:    record.Backend,
+		Target:     record.Target,
+		Mode:       record.Mode,
+		Force:      force,
+		Output:     &output,
+	}
+
+	var result *CompileResult
+	var err error
+	switch {
+	case record.Git != nil:
+		origin := *record.Git
+		opts.SourcePath = origin.URL
+		result, err = compileFromGit(opts, &origin, config)
+	case record.WorkDir != "":
+		// Import paths are resolved relative to the module they were built in
+		result, err = compileInDir(record.WorkDir, opts, config)
+	default:
+		if _, statErr := os.Stat(record.Source); statErr != nil {
+			outcome.Status = "failed"
+			outcome.Detail = "source " + record.Source + " no longer exists"
+			return outcome
+		}
+		result, err = compileSource(opts, config)
+	}
+
+	return buildOutcome(name, result, err, output.String())
+}
+
+// compileInDir compiles a Go import path from inside the module directory it
+// was originally built from
+func compileInDir(dir string, opts *CompileOptions, config *Config) (*CompileResult, error) {
+	cmd := exec.Command("go", "list", opts.SourcePath)
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("package %s cannot be resolved from %s", opts.SourcePath, dir)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	// Rebuilds run in parallel, so take the working directory for the
+	// duration of this build only
+	chdirMu.Lock()
+	defer chdirMu.Unlock()
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	defer os.Chdir(cwd)
+	return compileSource(opts, config)
+}
+
+// chdirMu guards the process working directory during import path rebuilds
+var chdirMu sync.Mutex
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// printBuildSummary prints a per-binary table and returns the failure count
+func printBuildSummary(outcomes []BuildOutcome) int {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BINARY\tSTATUS\tDETAIL")
+	for _, outcome := range outcomes {
+		if outcome.Status == "failed" {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", outcome.Name, outcome.Status, outcome.Detail)
+	}
+	w.Flush()
+	return failed
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// recordingsDir holds the asciicast recordings of --record runs, inside the
+// state directory
+const recordingsDir = "recordings"
+
+// replayIdleLimit caps pauses during replay so long waits don't stall it
+const replayIdleLimit = 2 * time.Second
+
+// recordingPath returns where the session of a run is recorded
+func recordingPath(id string, config *Config) string {
+	return filepath.Join(stateDir(config), recordingsDir, id+".cast")
+}
+
+// castHeader is the first line of an asciicast v2 recording
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castWriter appends everything written to it to a recording as output
+// events timed from the start of the run
+type castWriter struct {
+	mu    sync.Mutex
+	file  *os.File
+	start time.Time
+	// pending holds a UTF-8 sequence split across writes, since events
+	// must be valid strings
+	pending []byte
+}
+
+func (c *castWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := append(c.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	c.pending = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return len(p), nil
+	}
+	return len(p), c.event(string(data[:cut]))
+}
+
+func (c *castWriter) event(data string) error {
+	line, err := json.Marshal([]interface{}{time.Since(c.start).Seconds(), "o", data})
+	if err != nil {
+		return err
+	}
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close writes any incomplete trailing bytes and closes the recording
+func (c *castWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) > 0 {
+		c.event(string(c.pending))
+		c.pending = nil
+	}
+	return c.file.Close()
+}
+
+// session records one run of a script
+type session struct {
+	cmd  *exec.Cmd
+	cast *castWriter
+}
+
+// startSession creates the recording for a run and writes its header
+func startSession(record *RunRecord, cmd *exec.Cmd, config *Config) (*session, error) {
+	path := recordingPath(record.ID, config)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %v", err)
+	}
+
+	width, height := 80, 24
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 && h > 0 {
+		width, height = w, h
+	}
+	header, _ := json.Marshal(&castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: record.Start.Unix(),
+		Command:   strings.Join(cmd.Args, " "),
+		Title:     record.Script,
+		Env:       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	})
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write recording: %v", err)
+	}
+	return &session{cmd: cmd, cast: &castWriter{file: file, start: record.Start}}, nil
+}
+
+// run runs the command while recording it. On a terminal the script gets a
+// pseudo-terminal of its own, so colours and prompts are captured as seen;
+// otherwise its output streams are recorded.
+func (s *session) run() error {
+	defer s.cast.Close()
+	if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		if ptmx, tty, err := openPty(); err == nil {
+			return s.runInPty(ptmx, tty)
+		}
+	}
+	s.cmd.Stdout = teeWriter(s.cmd.Stdout, s.cast)
+	s.cmd.Stderr = teeWriter(s.cmd.Stderr, s.cast)
+	return s.cmd.Run()
+}
+
+func (s *session) runInPty(ptmx, tty *os.File) error {
+	defer ptmx.Close()
+	out := s.cmd.Stdout
+	if out == nil {
+		out = os.Stdout
+	}
+	s.cmd.Stdin, s.cmd.Stdout, s.cmd.Stderr = tty, tty, tty
+	s.cmd.SysProcAttr = ptyProcAttr(s.cmd.SysProcAttr)
+	resizePty(ptmx)
+	err := s.cmd.Start()
+	tty.Close()
+	if err != nil {
+		return err
+	}
+
+	stopResize := watchResize(ptmx)
+	defer stopResize()
+	if state, err := term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+		defer term.Restore(int(os.Stdin.Fd()), state)
+	}
+	go io.Copy(ptmx, os.Stdin)
+	copied := make(chan struct{})
+	go func() {
+		// Reading fails with EIO once the script and its children exit
+		io.Copy(io.MultiWriter(out, s.cast), ptmx)
+		close(copied)
+	}()
+	err = s.cmd.Wait()
+	<-copied
+	return err
+}
+
+// findRecording resolves a run ID, or a script name for its latest
+// recorded run, to a recording
+func findRecording(target string, config *Config) (string, error) {
+	path := recordingPath(target, config)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	records, err := loadHistory(config)
+	if err != nil {
+		return "", err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Script == target && records[i].Recorded {
+			return recordingPath(records[i].ID, config), nil
+		}
+	}
+	return "", fmt.Errorf("no recording of %s (record a run with 'scripts --record <script_name>')", target)
+}
+
+// replaySession plays a recording back with its original timing, sped up
+// by speed and with long pauses shortened
+func replaySession(target string, speed float64, config *Config) error {
+	path, err := findRecording(target, config)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return fmt.Errorf("recording %s is empty", path)
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("%s is not an asciicast v2 recording", path)
+	}
+
+	previous := 0.0
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			continue
+		}
+		at, _ := event[0].(float64)
+		kind, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if kind != "o" {
+			continue
+		}
+		delay := time.Duration((at - previous) / speed * float64(time.Second))
+		if delay > replayIdleLimit {
+			delay = replayIdleLimit
+		}
+		time.Sleep(delay)
+		previous = at
+		os.Stdout.WriteString(data)
+	}
+	return scanner.Err()
+}
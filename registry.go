@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// maxDownload caps what is read from a registry, to fail fast on a wrong URL
+const maxDownload = 256 << 20
+
+// registryClient is used for index and package downloads. Its default
+// transport honours HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+var registryClient = &http.Client{Timeout: 2 * time.Minute}
+
+// RegistryIndex is the static JSON index a registry serves:
+//
+//	{"packages": [{"name": "rotate-logs", "version": "1.2.0", "url": "rotate-logs.sh", "sha256": "..."}]}
+type RegistryIndex struct {
+	Packages []*RegistryPackage `json:"packages"`
+}
+
+// RegistryPackage is a script or binary listed in a registry index. URLs
+// may be relative to the index.
+type RegistryPackage struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type is "script" (the default) or "binary"
+	Type   string `json:"type,omitempty"`
+	URL    string `json:"url,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Files holds a binary's downloads per platform, keyed like "linux/amd64"
+	Files map[string]*RegistryFile `json:"files,omitempty"`
+}
+
+// RegistryFile is one downloadable file
+type RegistryFile struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// isBinary reports whether the package installs a binary into BinDir
+func (p *RegistryPackage) isBinary() bool {
+	return p.Type == "binary"
+}
+
+// String names the package with its version, when the index has one
+func (p *RegistryPackage) String() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + " " + p.Version
+}
+
+// file returns the download for this machine
+func (p *RegistryPackage) file() (*RegistryFile, error) {
+	if !p.isBinary() || len(p.Files) == 0 {
+		if p.URL == "" {
+			return nil, fmt.Errorf("%s has no download URL in the registry", p.Name)
+		}
+		return &RegistryFile{URL: p.URL, SHA256: p.SHA256}, nil
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	if file, ok := p.Files[platform]; ok {
+		return file, nil
+	}
+	platforms := make([]string, 0, len(p.Files))
+	for key := range p.Files {
+		platforms = append(platforms, key)
+	}
+	sort.Strings(platforms)
+	return nil, fmt.Errorf("%s has no build for %s (available: %s)", p.Name, platform, strings.Join(platforms, ", "))
+}
+
+// fetch downloads a URL, or reads a local path or file:// URL. Plain HTTP
+// is only accepted from this machine, since the index vouches for the
+// checksums.
+func fetch(location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// Not a URL (or a Windows drive letter): a local path
+		return os.ReadFile(location)
+	}
+	switch u.Scheme {
+	case "file":
+		return os.ReadFile(u.Path)
+	case "https":
+	case "http":
+		if !isLoopback(u.Hostname()) {
+			return nil, fmt.Errorf("refusing to download %s over plain HTTP (use https)", location)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q in %s", u.Scheme, location)
+	}
+	if isOffline() {
+		return nil, errOffline("downloading " + location)
+	}
+
+	resp, err := registryClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownload {
+		return nil, fmt.Errorf("%s is larger than %d MB", location, maxDownload>>20)
+	}
+	return data, nil
+}
+
+// resolveURL resolves a package URL relative to the index it came from
+func resolveURL(index, ref string) string {
+	if u, err := url.Parse(ref); err == nil && u.IsAbs() {
+		return ref
+	}
+	base, err := url.Parse(index)
+	if err != nil || base.Scheme == "" || len(base.Scheme) == 1 {
+		if filepath.IsAbs(ref) {
+			return ref
+		}
+		return filepath.Join(filepath.Dir(index), filepath.FromSlash(ref))
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// fetchIndex downloads the configured registry index
+func fetchIndex(config *Config) (*RegistryIndex, error) {
+	if config.Registry == "" {
+		return nil, fmt.Errorf("no registry configured - set \"registry\" in the config to the URL of an index.json")
+	}
+	data, err := fetch(config.Registry)
+	if err == nil {
+		cacheIndex(data, config)
+	} else {
+		// Fall back to the index last fetched, offline or not
+		cached, fetched, cacheErr := cachedIndex(config)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("failed to fetch registry index: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: using the registry index cached on %s (%v)\n", fetched.Local().Format("2006-01-02 15:04"), err)
+		data = cached
+	}
+	index := &RegistryIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid registry index %s: %v", config.Registry, err)
+	}
+	return index, nil
+}
+
+// findPackage looks a package up by name in the registry
+func findPackage(name string, config *Config) (*RegistryPackage, error) {
+	index, err := fetchIndex(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range index.Packages {
+		if pkg.Name == name {
+			return pkg, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not in the registry (try 'scripts search %s --remote')", name, name)
+}
+
+// matchesTerm reports whether any of the fields contains term, ignoring case
+func matchesTerm(term string, fields ...string) bool {
+	term = strings.ToLower(term)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchRegistry lists the registry packages whose name or description
+// contains term
+func searchRegistry(term string, config *Config) error {
+	index, err := fetchIndex(config)
+	if err != nil {
+		return err
+	}
+	var matches []*RegistryPackage
+	for _, pkg := range index.Packages {
+		if matchesTerm(term, pkg.Name, pkg.Description) {
+			matches = append(matches, pkg)
+		}
+	}
+	if len(matches) == 0 {
+		fmt.Printf("No packages matching %q in the registry\n", term)
+		return nil
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tTYPE\tDESCRIPTION")
+	for _, pkg := range matches {
+		kind := "script"
+		if pkg.isBinary() {
+			kind = "binary"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pkg.Name, pkg.Version, kind, pkg.Description)
+	}
+	return w.Flush()
+}
+
+// searchLocal lists installed scripts and tap scripts whose name contains term
+func searchLocal(term string, config *Config) error {
+	var matches []string
+	for _, script := range listScripts(config) {
+		if matchesTerm(term, script.Name) {
+			matches = append(matches, script.Name+" (installed)")
+		}
+	}
+	taps, err := loadTaps(config)
+	if err != nil {
+		return err
+	}
+	for _, tap := range taps {
+		for name := range tap.scripts() {
+			if matchesTerm(term, name) {
+				matches = append(matches, tap.Name+"/"+name)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		fmt.Printf("No scripts matching %q (add --remote to search the registry)\n", term)
+		return nil
+	}
+	sort.Strings(matches)
+	for _, match := range matches {
+		fmt.Println(match)
+	}
+	return nil
+}
+
+// downloadPackage fetches a package's file for this machine and verifies
+// it against the checksum in the index
+func downloadPackage(pkg *RegistryPackage, config *Config) ([]byte, error) {
+	file, err := pkg.file()
+	if err != nil {
+		return nil, err
+	}
+	if file.SHA256 == "" {
+		return nil, fmt.Errorf("%s has no sha256 checksum in the registry, refusing to install it", pkg.Name)
+	}
+	data, err := fetch(resolveURL(config.Registry, file.URL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", pkg.Name, err)
+	}
+	if got := checksum(data); !strings.EqualFold(got, file.SHA256) {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", pkg.Name, file.SHA256, got)
+	}
+	return data, nil
+}
+
+// checksum returns the hex SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// installFromRegistry downloads a package, verifies its checksum and
+// installs it as a script, or into BinDir for binaries
+func installFromRegistry(name string, force bool, mode string, config *Config) error {
+	pkg, err := findPackage(name, config)
+	if err != nil {
+		return err
+	}
+	if !validName(pkg.Name) {
+		return fmt.Errorf("invalid package name %q in the registry", pkg.Name)
+	}
+	fmt.Printf("Downloading %s\n", pkg)
+	data, err := downloadPackage(pkg, config)
+	if err != nil {
+		return err
+	}
+
+	var path string
+	if pkg.isBinary() {
+		path, err = installDownloadedBinary(pkg.Name, data, force, mode, config)
+	} else {
+		path, err = installScript(pkg.Name, data, force, mode, config)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s to %s\n", pkg, path)
+	return recordOrigin(pkg.Name, &Origin{
+		Kind:     originRegistry,
+		Source:   pkg.Name,
+		Version:  pkg.Version,
+		Checksum: checksum(data),
+		Binary:   pkg.isBinary(),
+	}, config)
+}
+
+// installFromURL downloads a script and installs it under the name of the
+// file it points to
+func installFromURL(location string, force bool, mode string, config *Config) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", location, err)
+	}
+	name := strings.TrimSuffix(path.Base(u.Path), ".sh")
+	if !validName(name) {
+		return fmt.Errorf("can't name a script after %s", location)
+	}
+	data, err := fetch(location)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", location, err)
+	}
+	if _, err := installScript(name, data, force, mode, config); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s as %s.sh\n", location, name)
+	return recordOrigin(name, &Origin{Kind: originURL, Source: location, Checksum: checksum(data)}, config)
+}
+
+// installDownloadedBinary writes a downloaded binary into BinDir, keeping
+// the replaced build for rollback
+func installDownloadedBinary(name string, data []byte, force bool, mode string, config *Config) (string, error) {
+	perms, err := resolvePermissions(config, mode)
+	if err != nil {
+		return "", err
+	}
+	if _, err := binaryPath(name, config); err == nil && !force {
+		return "", fmt.Errorf("binary %s already exists (use --force to replace it)", name)
+	}
+	if err := os.MkdirAll(config.BinDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create bin directory: %v", err)
+	}
+	if err := archiveBinary(name, config); err != nil {
+		return "", err
+	}
+	path := filepath.Join(config.BinDir, name)
+	tmp := path + ".download"
+	if err := os.WriteFile(tmp, data, 0755); err != nil {
+		return "", fmt.Errorf("failed to write binary: %v", err)
+	}
+	if err := perms.apply(tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to make binary executable: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to install binary: %v", err)
+	}
+	return path, placeBinary(name, path, config)
+}
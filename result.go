@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// resultEnv names the file a script may write a JSON result to, which is
+// kept with the run in the history
+const resultEnv = "SCRIPTS_RESULT"
+
+// maxResultSize is the largest result kept; results are for outcomes, not
+// data dumps
+const maxResultSize = 64 * 1024
+
+// RunResult is what "scripts --json" prints once a run is over
+type RunResult struct {
+	Script     string          `json:"script"`
+	RunID      string          `json:"runId"`
+	ExitCode   int             `json:"exitCode"`
+	DurationMs int64           `json:"durationMs"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+// newResultFile returns the path a run's script may write its result to,
+// in a directory of its own. When the script runs as another user (runAs)
+// only that user may write the result: root hands the file over, while
+// through sudo the script creates it, under a name other users can't find.
+func newResultFile(runAs string) (string, error) {
+	dir, err := os.MkdirTemp("", "scripts-result-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the result file: %v", err)
+	}
+	if runAs != "" && os.Geteuid() != 0 {
+		// Other users may enter the directory and create files, but not list it
+		if err := os.Chmod(dir, 0733); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to share the result directory with %s: %v", runAs, err)
+		}
+		return filepath.Join(dir, "result-"+unguessableName()+".json"), nil
+	}
+	path := filepath.Join(dir, "result.json")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to create the result file: %v", err)
+	}
+	if runAs != "" {
+		if err := chownToUser(runAs, dir, path); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to give the result file to %s: %v", runAs, err)
+		}
+	}
+	return path, nil
+}
+
+// readResult reads and removes a run's result file. It returns nil when the
+// script wrote nothing, and an error when it wrote something that isn't
+// JSON or is too large.
+func readResult(path string) (json.RawMessage, error) {
+	defer os.RemoveAll(filepath.Dir(path))
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read $%s: %v", resultEnv, err)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) > maxResultSize {
+		return nil, fmt.Errorf("the result written to $%s is larger than %s", resultEnv, humanSize(maxResultSize))
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, fmt.Errorf("the result written to $%s isn't valid JSON: %v", resultEnv, err)
+	}
+	return compact.Bytes(), nil
+}
+
+// printRunResult prints a run as JSON, for "scripts --json"
+func printRunResult(record *RunRecord) error {
+	data, err := json.MarshalIndent(&RunResult{
+		Script:     record.Script,
+		RunID:      record.ID,
+		ExitCode:   record.ExitCode,
+		DurationMs: record.DurationMs,
+		Result:     record.Result,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runUsage describes the flags accepted before a script name
+const runUsage = "Usage: scripts [--record] [--no-prompt] [--sudo] [--user <name>] [--skip-unchanged --inputs <glob>...] [--force | --wait] [--queue] [--var key=value...] [--filter jq:<program> | --grep <pattern>...] [--json] [--cwd <dir>] [--strict] <script_name> [args...]"
+
+// RunOptions holds the flags given before a script name, which apply to
+// the run rather than being passed to the script
+type RunOptions struct {
+	Record   bool   // capture the terminal session for 'scripts replay'
+	NoPrompt bool   // use defaults instead of asking for missing parameters
+	Sudo     bool   // run the script as root through sudo
+	User     string // run the script as this user
+	// SkipUnchanged skips the run when the Inputs globs match the same
+	// files as at the last successful run
+	SkipUnchanged bool
+	Inputs        []string
+	InputsHash    string // fingerprint of the inputs, recorded with the run
+	// Force runs despite the script's cooldown; Wait waits it out instead
+	Force bool
+	Wait  bool
+	// Queue waits for a running exclusive script instead of failing
+	Queue bool
+	// Vars are the template variables given with --var, which take
+	// precedence over stored and configured ones
+	Vars map[string]string
+	// Filters post-process the script's stdout, in order
+	Filters []*OutputFilter
+	// JSON prints the run and its $SCRIPTS_RESULT as JSON once it is
+	// over, sending the script's stdout to stderr
+	JSON bool
+	// Cwd is the directory the script runs in, over the workdir it declares
+	Cwd string
+	// Strict refuses to run deprecated scripts instead of warning
+	Strict bool
+	// NoAlerts keeps the run from triggering alert rules, for the scripts
+	// those rules run
+	NoAlerts bool
+	// RunID is the ID to record the run under, when it is needed before
+	// the run starts (default: a new one)
+	RunID string
+}
+
+// parseRunArgs splits leading run flags from the script name and its
+// arguments
+func parseRunArgs(args []string) (*RunOptions, []string, error) {
+	opts := &RunOptions{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "--record":
+			opts.Record = true
+		case "--no-prompt":
+			opts.NoPrompt = true
+		case "--sudo":
+			opts.Sudo = true
+		case "--json":
+			opts.JSON = true
+		case "--queue":
+			opts.Queue = true
+		case "--strict":
+			opts.Strict = true
+		case "--force":
+			opts.Force = true
+		case "--wait":
+			opts.Wait = true
+		case "--skip-unchanged":
+			opts.SkipUnchanged = true
+		case "--inputs":
+			if len(args) < 2 {
+				return nil, nil, fmt.Errorf("--inputs requires a glob")
+			}
+			args = args[1:]
+			opts.Inputs = append(opts.Inputs, args[0])
+		case "--var":
+			if len(args) < 2 {
+				return nil, nil, fmt.Errorf("--var requires key=value")
+			}
+			args = args[1:]
+			key, value, ok := strings.Cut(args[0], "=")
+			if !ok || !varNamePattern.MatchString(key) {
+				return nil, nil, fmt.Errorf("invalid --var %q (expected key=value)", args[0])
+			}
+			if opts.Vars == nil {
+				opts.Vars = map[string]string{}
+			}
+			opts.Vars[key] = value
+		case "--filter", "--grep":
+			if len(args) < 2 {
+				return nil, nil, fmt.Errorf("%s requires a value", args[0])
+			}
+			spec := args[1]
+			if args[0] == "--grep" {
+				spec = "grep:" + spec
+			}
+			args = args[1:]
+			filter, err := parseFilter(spec)
+			if err != nil {
+				return nil, nil, err
+			}
+			opts.Filters = append(opts.Filters, filter)
+		case "--cwd":
+			if len(args) < 2 {
+				return nil, nil, fmt.Errorf("--cwd requires a directory")
+			}
+			args = args[1:]
+			opts.Cwd = args[0]
+		case "--user":
+			if len(args) < 2 {
+				return nil, nil, fmt.Errorf("--user requires a user name")
+			}
+			args = args[1:]
+			opts.User = args[0]
+		default:
+			known := []string{"--offline", "--plain"}
+			for flag := range runFlags {
+				known = append(known, flag)
+			}
+			sort.Strings(known)
+			return nil, nil, unknownFlagError(args[0], known)
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("no script given")
+	}
+	if opts.SkipUnchanged != (len(opts.Inputs) > 0) {
+		return nil, nil, fmt.Errorf("--skip-unchanged and --inputs <glob> go together")
+	}
+	if opts.Record && len(opts.Filters) > 0 {
+		return nil, nil, fmt.Errorf("--record can't be combined with --filter or --grep")
+	}
+	if opts.JSON && len(opts.Filters) > 0 {
+		return nil, nil, fmt.Errorf("--json can't be combined with --filter or --grep")
+	}
+	if opts.Force && opts.Wait {
+		return nil, nil, fmt.Errorf("--force and --wait can't be combined")
+	}
+	return opts, args, nil
+}
+
+// newRunID returns an identifier for a run starting at start, sortable by
+// time and unique even for runs started in the same second
+func newRunID(start time.Time) string {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return start.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// runRecorded runs a script's command and records the run, forwarding it to
+// the system log when logForward is set and capturing the session with
+// --record. The exit code is -1 when the command couldn't be started.
+func runRecorded(name string, cmd *exec.Cmd, opts *RunOptions, config *Config) (*RunRecord, error) {
+	finish, err := forwardRun(name, cmd, config)
+	if err != nil {
+		// A missing system log shouldn't stop the script
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	cmd.Env = runEnv(cmd.Env, config)
+	resultPath, err := newResultFile(opts.User)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	} else {
+		cmd.Env = append(cmd.Env, resultEnv+"="+resultPath)
+	}
+	start := time.Now()
+	record := &RunRecord{ID: opts.RunID, Script: name, Start: start, InputsHash: opts.InputsHash}
+	if record.ID == "" {
+		record.ID = newRunID(start)
+	}
+
+	run := cmd.Run
+	if opts.Record {
+		session, err := startSession(record, cmd, config)
+		if err != nil {
+			record.ExitCode = -1
+			return record, err
+		}
+		record.Recorded = true
+		run = session.run
+	}
+	err = run()
+	var resultErr error
+	record.DurationMs = time.Since(start).Milliseconds()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		record.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		record.ExitCode = -1
+	}
+	if resultPath != "" {
+		if record.Result, resultErr = readResult(resultPath); resultErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", name, resultErr)
+		}
+	}
+	if finish != nil {
+		finish(record)
+	}
+	if histErr := recordRun(record, config); histErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", histErr)
+	}
+	if !opts.NoAlerts {
+		applyAlerts(record, config)
+	}
+	if record.Recorded {
+		fmt.Fprintf(os.Stderr, "Recorded run %s (replay it with 'scripts replay %s')\n", record.ID, record.ID)
+	}
+	return record, err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// runLogsDir holds the output of daemon runs, inside the state directory,
+// for GET /runs/<id>/log
+const runLogsDir = "logs"
+
+// runLogsKept is how many run logs are kept; older ones are removed
+const runLogsKept = 500
+
+// runLogPath returns where a daemon run's output is kept
+func runLogPath(id string, config *Config) string {
+	return filepath.Join(stateDir(config), runLogsDir, id+".log")
+}
+
+// createRunLog creates the log of a daemon run, removing the oldest logs
+// beyond runLogsKept
+func createRunLog(id string, config *Config) (*os.File, error) {
+	dir := filepath.Join(stateDir(config), runLogsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create run logs directory: %v", err)
+	}
+	// Run IDs start with their time, so names sort oldest first
+	if logs, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(logs) >= runLogsKept {
+		sort.Strings(logs)
+		for _, old := range logs[:len(logs)-runLogsKept+1] {
+			os.Remove(old)
+		}
+	}
+	file, err := os.OpenFile(runLogPath(id, config), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run log: %v", err)
+	}
+	return file, nil
+}
+
+// eventStream writes server-sent events, flushing each one to the client
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// wantsEvents reports whether a request asks for a stream of server-sent
+// events rather than one response at the end
+func wantsEvents(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.URL.Query().Get("stream") != ""
+}
+
+// newEventStream starts a response of server-sent events
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming isn't supported by this connection")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &eventStream{w: w, flusher: flusher}, nil
+}
+
+// send writes an event; each line of data becomes a data field, which
+// clients join back together with newlines
+func (e *eventStream) send(event, data string) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	e.w.Write(buf.Bytes())
+	e.flusher.Flush()
+}
+
+// sendJSON writes an event whose data is v as JSON
+func (e *eventStream) sendJSON(event string, v interface{}) {
+	data, _ := json.Marshal(v)
+	e.send(event, string(data))
+}
+
+// runOutput passes an output stream of a daemon run to the client, through
+// send, and to the run's log. The streams of a run share mu, keeping their
+// writes in order.
+type runOutput struct {
+	mu   *sync.Mutex
+	send func(p []byte)
+	log  io.Writer
+}
+
+func (o *runOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.send(p)
+	if o.log != nil {
+		o.log.Write(p)
+	}
+	return len(p), nil
+}
+
+// validRunID reports whether id can name a run log, keeping requests inside
+// the logs directory
+func validRunID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\.`)
+}
+
+// RunInfo is a finished daemon run, as GET /runs/<id> shows it
+type RunInfo struct {
+	*RunRecord
+	// Log is where its output can be fetched, when it was kept
+	Log string `json:"log,omitempty"`
+}
+
+// handleRuns serves finished runs: GET /runs/<id> for the record and GET
+// /runs/<id>/log for the output of runs the daemon started
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request, token *ServeToken) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	id, wantLog := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/runs/"), "/log")
+	if !validRunID(id) {
+		writeError(w, http.StatusNotFound, "no such run")
+		return
+	}
+	record, err := findRun(id, token, s.config)
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+
+	logPath := runLogPath(id, s.config)
+	_, statErr := os.Stat(logPath)
+	if !wantLog {
+		info := &RunInfo{RunRecord: record}
+		if statErr == nil {
+			info.Log = "/runs/" + id + "/log"
+		}
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+	if statErr != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no log kept for run %s", id))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, r, logPath)
+}
+
+// findRun returns a recorded run the token may see; errors are runErrors
+func findRun(id string, token *ServeToken, config *Config) (*RunRecord, error) {
+	records, err := loadHistory(config)
+	if err != nil {
+		return nil, &runError{http.StatusInternalServerError, err.Error()}
+	}
+	for _, record := range records {
+		if record.ID == id && (token == nil || token.allows(record.Script)) {
+			return record, nil
+		}
+	}
+	return nil, &runError{http.StatusNotFound, fmt.Sprintf("no run %s", id)}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// defaultSystemScriptDir holds scripts an administrator provisions for
+// every user of a machine
+const defaultSystemScriptDir = "/usr/local/share/scripts"
+
+// Script is a script found in one of the script directories
+type Script struct {
+	Name   string
+	Path   string
+	System bool // from the system-wide directory
+}
+
+// systemScriptDir returns the system-wide scripts directory, or "" when
+// there is none
+func systemScriptDir(config *Config) string {
+	dir := expandPath(config.SystemScriptDir)
+	if dir == "" {
+		if runtime.GOOS == "windows" {
+			return ""
+		}
+		dir = defaultSystemScriptDir
+	}
+	if filepath.Clean(dir) == filepath.Clean(config.ScriptDir) {
+		return ""
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
+
+// scriptDirs returns the directories scripts are found in, by precedence:
+// the user's ScriptDir shadows the system-wide directory
+func scriptDirs(config *Config) []string {
+	dirs := []string{config.ScriptDir}
+	if system := systemScriptDir(config); system != "" {
+		dirs = append(dirs, system)
+	}
+	return dirs
+}
+
+// findScript returns the path of a script by name. An exact match always
+// wins; with normalizeNames, a single script whose name matches ignoring
+// case, dashes and underscores is found next.
+func findScript(name string, config *Config) (string, error) {
+	dirs := scriptDirs(config)
+	for _, dir := range dirs {
+		if path := scriptInDir(dir, name); path != "" {
+			return path, nil
+		}
+	}
+
+	if config.NormalizeNames {
+		var matches []*Script
+		for _, script := range listScripts(config) {
+			if normalizeName(script.Name) == normalizeName(name) {
+				matches = append(matches, script)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0].Path, nil
+		}
+		if len(matches) > 1 {
+			names := make([]string, len(matches))
+			for i, script := range matches {
+				names[i] = script.Name
+			}
+			return "", fmt.Errorf("%s is ambiguous: it could be %s", name, strings.Join(names, ", "))
+		}
+	}
+	if scriptInDir(archiveDir(config), name) != "" {
+		return "", fmt.Errorf("script %s is archived (restore it with 'scripts unarchive %s')", name, name)
+	}
+	return "", fmt.Errorf("script %s not found in %s", name, strings.Join(dirs, " or "))
+}
+
+// scriptFileNames returns the file names a script name can refer to. Dots
+// are part of the name ("backup.daily" is backup.daily.sh), but a name
+// already ending in a script extension is also taken as the full file name.
+func scriptFileNames(name string) []string {
+	if scriptExts[filepath.Ext(name)] {
+		return []string{name, name + ".sh"}
+	}
+	return []string{name + ".sh"}
+}
+
+// scriptInDir returns the path of the script name refers to in dir, or ""
+func scriptInDir(dir, name string) string {
+	for _, file := range scriptFileNames(name) {
+		path := filepath.Join(dir, file)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// normalizeName folds a script name for loose matching: lowercase, without
+// dashes or underscores
+func normalizeName(name string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+}
+
+// listScripts returns every script by name, leaving out tests, files the
+// directory's .scriptsignore matches and system scripts shadowed by the
+// user's own
+func listScripts(config *Config) []*Script {
+	seen := map[string]bool{}
+	var scripts []*Script
+	for i, dir := range scriptDirs(config) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.sh"))
+		ignore := loadIgnore(dir)
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".sh")
+			if isTestScript(file) || seen[name] || ignore.ignored(file, false) {
+				continue
+			}
+			seen[name] = true
+			scripts = append(scripts, &Script{Name: name, Path: file, System: i > 0})
+		}
+	}
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].Name < scripts[j].Name })
+	return scripts
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// testSuffix marks script tests: backup_test.sh tests backup.sh
+const testSuffix = "_test.sh"
+
+// testDir is the optional directory of tests under ScriptDir
+const testDir = "tests"
+
+// testFunction matches the test_* functions a test file defines
+var testFunction = regexp.MustCompile(`(?m)^\s*(?:function\s+)?(test_\w+)\s*(?:\(\s*\))?\s*\{?\s*$`)
+
+// assertLibrary is sourced before every test. Assertions record failures
+// and let the test continue; the test fails if any assertion did.
+const assertLibrary = `
+_scripts_failures=0
+
+_scripts_fail() {
+	_scripts_failures=$((_scripts_failures + 1))
+	echo "FAIL: $1 (${BASH_SOURCE[2]##*/}:${BASH_LINENO[1]})" >&2
+}
+
+_scripts_finish() {
+	local status=$?
+	if [ "$_scripts_failures" -gt 0 ]; then
+		exit 1
+	fi
+	exit "$status"
+}
+trap _scripts_finish EXIT
+
+# fail <message>: fail the test
+fail() { _scripts_fail "${1:-failed}"; }
+
+# assert_eq <expected> <actual> [message]
+assert_eq() {
+	[ "$1" = "$2" ] || _scripts_fail "${3:-expected '$1' but got '$2'}"
+}
+
+# assert_ne <unexpected> <actual> [message]
+assert_ne() {
+	[ "$1" != "$2" ] || _scripts_fail "${3:-expected something other than '$1'}"
+}
+
+# assert_contains <haystack> <needle> [message]
+assert_contains() {
+	case "$1" in
+	*"$2"*) ;;
+	*) _scripts_fail "${3:-expected '$1' to contain '$2'}" ;;
+	esac
+}
+
+# assert_file_exists <path> [message]
+assert_file_exists() {
+	[ -e "$1" ] || _scripts_fail "${2:-expected $1 to exist}"
+}
+
+# run <command...>: run a command, setting $status and $output
+run() {
+	output=$("$@" 2>&1)
+	status=$?
+}
+
+# assert_success <command...>: the command exits 0
+assert_success() {
+	run "$@"
+	[ "$status" -eq 0 ] || _scripts_fail "expected '$*' to succeed, exited $status: $output"
+}
+
+# assert_failure <command...>: the command exits non-zero
+assert_failure() {
+	run "$@"
+	[ "$status" -ne 0 ] || _scripts_fail "expected '$*' to fail"
+}
+`
+
+// ScriptTest is one test: a test_* function in a test file, or the whole
+// file when it defines none
+type ScriptTest struct {
+	File     string
+	Function string
+}
+
+// Name identifies the test in reports
+func (t *ScriptTest) Name() string {
+	name := strings.TrimSuffix(filepath.Base(t.File), testSuffix)
+	if filepath.Base(filepath.Dir(t.File)) == testDir {
+		name = testDir + "/" + name
+	}
+	if t.Function != "" {
+		name += ": " + t.Function
+	}
+	return name
+}
+
+// isTestScript reports whether a file in ScriptDir is a test rather than a
+// script to run
+func isTestScript(path string) bool {
+	return strings.HasSuffix(path, testSuffix)
+}
+
+// discoverTests finds the tests for name (or every test when name is empty)
+// in ScriptDir and its tests directory
+func discoverTests(name string, config *Config) ([]*ScriptTest, error) {
+	pattern := "*" + testSuffix
+	if name != "" {
+		pattern = name + testSuffix
+	}
+	var files []string
+	for _, dir := range []string{config.ScriptDir, filepath.Join(config.ScriptDir, testDir)} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	var tests []*ScriptTest
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		functions := testFunction.FindAllStringSubmatch(string(content), -1)
+		if len(functions) == 0 {
+			tests = append(tests, &ScriptTest{File: file})
+			continue
+		}
+		for _, m := range functions {
+			tests = append(tests, &ScriptTest{File: file, Function: m[1]})
+		}
+	}
+	return tests, nil
+}
+
+// runTests runs each test in its own bash process with the assertion
+// library loaded and a fresh TMPDIR as the working directory. It returns
+// the number of failed tests.
+func runTests(tests []*ScriptTest, config *Config) (int, error) {
+	libDir, err := os.MkdirTemp("", "scripts-test-")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(libDir)
+	library := filepath.Join(libDir, "assert.sh")
+	if err := os.WriteFile(library, []byte(assertLibrary), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write assertion library: %v", err)
+	}
+
+	failed := 0
+	for _, test := range tests {
+		start := time.Now()
+		output, err := runTest(test, library, config)
+		elapsed := formatDuration(time.Since(start))
+		if err == nil {
+			fmt.Println(statusMark(true) + test.Name() + " (" + elapsed + ")")
+			continue
+		}
+		failed++
+		fmt.Println(statusMark(false) + test.Name() + " (" + elapsed + ")")
+		for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+			if line != "" {
+				fmt.Println("    " + line)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// runTest runs a single test and returns its combined output
+func runTest(test *ScriptTest, library string, config *Config) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "scripts-test-tmp-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The library and test file are sourced so assertions share the test's
+	// shell; a test function is then called by name
+	script := `source "$1"; source "$2"`
+	if test.Function != "" {
+		script += `; ` + test.Function
+	}
+	cmd := exec.Command("bash", "--noprofile", "--norc", "-c", script, "scripts-test", library, test.File)
+	cmd.Dir = tmpDir
+	cmd.Env = append(os.Environ(),
+		"TMPDIR="+tmpDir,
+		"SCRIPTS_DIR="+config.ScriptDir,
+		"TEST_DIR="+filepath.Dir(test.File),
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	return output.String(), err
+}
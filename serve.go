@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// defaultServeAddr only accepts local connections; set serve.addr (or pass
+// --addr) to expose the daemon
+const defaultServeAddr = "127.0.0.1:7878"
+
+// ServeConfig configures the daemon started by "scripts serve"
+type ServeConfig struct {
+	Addr string `json:"addr,omitempty"`
+	// GRPCAddr, when set, also serves the daemon over gRPC there
+	GRPCAddr string `json:"grpcAddr,omitempty"`
+	// Token, when set, must be sent as "Authorization: Bearer <token>" to
+	// list, run or compile anything
+	Token string `json:"token,omitempty"`
+	// Tokens are further tokens, each limited to a role and, optionally,
+	// some scripts
+	Tokens []*ServeToken `json:"tokens,omitempty"`
+}
+
+// Roles a daemon token can have
+const (
+	serveRoleRun    = "run"    // list and run scripts
+	serveRoleManage = "manage" // also compile
+)
+
+// ServeToken is a daemon token with its own permissions, e.g. for a CI
+// system that may trigger exactly one script
+type ServeToken struct {
+	// Name says whose token it is, in errors
+	Name  string `json:"name,omitempty"`
+	Token string `json:"token"`
+	// Role is serveRoleRun (the default) or serveRoleManage
+	Role string `json:"role,omitempty"`
+	// Scripts limits the scripts the token lists and runs to these names
+	// or globs (default: every script)
+	Scripts []string `json:"scripts,omitempty"`
+}
+
+// serveTokens returns the configured tokens, the single serve.token being
+// one that may do anything
+func serveTokens(config *Config) []*ServeToken {
+	if config.Serve == nil {
+		return nil
+	}
+	var tokens []*ServeToken
+	if config.Serve.Token != "" {
+		tokens = append(tokens, &ServeToken{Name: "token", Token: config.Serve.Token, Role: serveRoleManage})
+	}
+	return append(tokens, config.Serve.Tokens...)
+}
+
+// checkServeTokens rejects tokens that can't be used
+func checkServeTokens(config *Config) error {
+	for i, token := range serveTokens(config) {
+		label := token.Name
+		if label == "" {
+			label = fmt.Sprintf("serve.tokens[%d]", i)
+		}
+		if token.Token == "" {
+			return fmt.Errorf("%s has no token", label)
+		}
+		if token.Role != "" && token.Role != serveRoleRun && token.Role != serveRoleManage {
+			return fmt.Errorf("%s has unknown role %q (use %s or %s)", label, token.Role, serveRoleRun, serveRoleManage)
+		}
+		for _, pattern := range token.Scripts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s has invalid script pattern %q", label, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// can reports whether the token has a role; manage includes run
+func (t *ServeToken) can(role string) bool {
+	return role == serveRoleRun || t.Role == serveRoleManage
+}
+
+// allows reports whether the token may list and run a script
+func (t *ServeToken) allows(name string) bool {
+	if len(t.Scripts) == 0 {
+		return true
+	}
+	for _, pattern := range t.Scripts {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// String names the token in errors
+func (t *ServeToken) String() string {
+	if t.Name != "" {
+		return "token " + t.Name
+	}
+	return "this token"
+}
+
+// Server is the HTTP daemon that runs scripts and compiles binaries on
+// request and exposes their metrics
+type Server struct {
+	config  *Config
+	metrics *Metrics
+	// compileMu serialises compiles, which share the build manifest
+	compileMu sync.Mutex
+}
+
+// RunRequest is the optional body of POST /run/<name>
+type RunRequest struct {
+	Args []string `json:"args"`
+	// Force runs the script within its cooldown
+	Force bool `json:"force,omitempty"`
+	// Queue waits for a running exclusive script instead of failing
+	Queue bool `json:"queue,omitempty"`
+	// Vars are template variables, as given with --var but shell-quoted
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// RunResponse reports a finished run
+type RunResponse struct {
+	// ID is the run's ID, for GET /runs/<id> and /runs/<id>/log
+	ID         string `json:"id,omitempty"`
+	Script     string `json:"script"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Output     string `json:"output"`
+	// Result is the JSON the script wrote to $SCRIPTS_RESULT
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// CompileRequest is the body of POST /compile
+type CompileRequest struct {
+	Source string `json:"source"`
+	Name   string `json:"name,omitempty"`
+	Force  bool   `json:"force,omitempty"`
+}
+
+// CompileResponse reports a finished compile
+type CompileResponse struct {
+	Name       string `json:"name"`
+	OutputPath string `json:"outputPath,omitempty"`
+	UpToDate   bool   `json:"upToDate"`
+	Output     string `json:"output"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newServer(config *Config) *Server {
+	return &Server{config: config, metrics: newMetrics()}
+}
+
+// handler routes the daemon's endpoints
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/scripts", s.authenticated(serveRoleRun, s.handleScripts))
+	mux.HandleFunc("/run/", s.authenticated(serveRoleRun, s.handleRun))
+	mux.HandleFunc("/runs/", s.authenticated(serveRoleRun, s.handleRuns))
+	mux.HandleFunc("/compile", s.authenticated(serveRoleManage, s.handleCompile))
+	return mux
+}
+
+// authenticated rejects requests without one of the configured tokens, or
+// whose token lacks the role, and passes the token on to the handler. With
+// no tokens configured, anyone may do anything and the token is nil.
+func (s *Server) authenticated(role string, next func(http.ResponseWriter, *http.Request, *ServeToken)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(serveTokens(s.config)) == 0 {
+			next(w, r, nil)
+			return
+		}
+		token := matchToken(r.Header.Get("Authorization"), s.config)
+		if token == nil {
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		if !token.can(role) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s may only run scripts", token))
+			return
+		}
+		next(w, r, token)
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w)
+}
+
+// StatusResponse reports whether alert rules marked any script degraded
+type StatusResponse struct {
+	// Status is "ok" or "degraded"
+	Status   string                  `json:"status"`
+	Degraded map[string]*Degradation `json:"degraded"`
+}
+
+// handleStatus serves the health of the scripts: 200 when none is
+// degraded, 503 otherwise
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	degraded, err := loadDegraded(s.config)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(degraded) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, &StatusResponse{Status: "degraded", Degraded: degraded})
+		return
+	}
+	writeJSON(w, http.StatusOK, &StatusResponse{Status: "ok", Degraded: degraded})
+}
+
+func (s *Server) handleScripts(w http.ResponseWriter, r *http.Request, token *ServeToken) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	names := []string{}
+	for _, script := range listScripts(s.config) {
+		if token == nil || token.allows(script.Name) {
+			names = append(names, script.Name)
+		}
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request, token *ServeToken) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req RunRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	_, canStream := w.(http.Flusher)
+	if wantsEvents(r) && !canStream {
+		writeError(w, http.StatusInternalServerError, "streaming isn't supported by this connection")
+		return
+	}
+	run, err := s.prepareRun(strings.TrimPrefix(r.URL.Path, "/run/"), &req, token)
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+	defer run.close()
+
+	// When asked for, the output streams as server-sent events while the
+	// script runs
+	var output bytes.Buffer
+	stdout, stderr := func(p []byte) { output.Write(p) }, (func([]byte))(nil)
+	var events *eventStream
+	if wantsEvents(r) {
+		events, _ = newEventStream(w)
+		events.sendJSON("start", map[string]string{"id": run.id, "script": run.name})
+		stdout = func(p []byte) { events.send("stdout", string(p)) }
+		stderr = func(p []byte) { events.send("stderr", string(p)) }
+	}
+	record, err := s.execute(run, stdout, stderr)
+	if err != nil {
+		if events != nil {
+			events.sendJSON("error", map[string]string{"error": err.Error()})
+			return
+		}
+		writeRunError(w, err)
+		return
+	}
+	resp := &RunResponse{
+		ID:         record.ID,
+		Script:     run.name,
+		ExitCode:   record.ExitCode,
+		DurationMs: record.DurationMs,
+		Output:     output.String(),
+		Result:     record.Result,
+	}
+	if events != nil {
+		events.sendJSON("exit", resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// runError is a request the daemon refused, with the HTTP status that says
+// why; gRPC maps the status to a code
+type runError struct {
+	status  int
+	message string
+}
+
+func (e *runError) Error() string {
+	return e.message
+}
+
+// writeRunError writes an error, with its status if it is a runError
+func writeRunError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var re *runError
+	if errors.As(err, &re) {
+		status = re.status
+	}
+	writeError(w, status, err.Error())
+}
+
+// daemonRun is a script run the daemon prepared for a client, over HTTP or
+// gRPC
+type daemonRun struct {
+	id   string
+	name string
+	cmd  *exec.Cmd
+	// log keeps the output for GET /runs/<id>/log; it is nil when the log
+	// couldn't be created
+	log     io.Writer
+	cleanup []func()
+}
+
+// close releases the run's lock and temporary files
+func (run *daemonRun) close() {
+	for i := len(run.cleanup) - 1; i >= 0; i-- {
+		run.cleanup[i]()
+	}
+}
+
+// prepareRun checks that a script may run with a request and builds its
+// command; errors are runErrors
+func (s *Server) prepareRun(name string, req *RunRequest, token *ServeToken) (*daemonRun, error) {
+	if !validName(name) {
+		return nil, &runError{http.StatusBadRequest, fmt.Sprintf("invalid script name %q", name)}
+	}
+	if token != nil && !token.allows(name) {
+		return nil, &runError{http.StatusForbidden, fmt.Sprintf("%s may not run %s", token, name)}
+	}
+	scriptPath, err := findScript(name, s.config)
+	if err != nil {
+		return nil, &runError{http.StatusNotFound, fmt.Sprintf("script %s not found", name)}
+	}
+
+	// Nobody can answer prompts, so missing parameters take their defaults
+	args, err := scriptArgs(scriptPath, req.Args, &RunOptions{NoPrompt: true})
+	if err != nil {
+		return nil, &runError{http.StatusBadRequest, err.Error()}
+	}
+	run := &daemonRun{id: newRunID(time.Now()), name: name}
+	unlock, err := lockRun(name, scriptPath, req.Queue, s.config)
+	if err != nil {
+		return nil, &runError{http.StatusConflict, err.Error()}
+	}
+	run.cleanup = append(run.cleanup, unlock)
+	if err := checkCooldown(name, scriptPath, &RunOptions{Force: req.Force}, s.config); err != nil {
+		run.close()
+		return nil, &runError{http.StatusTooManyRequests, err.Error()}
+	}
+	// A client that may only run a script mustn't be able to change what it
+	// does, so its values reach a template as single shell words
+	vars := map[string]string{}
+	for key, value := range req.Vars {
+		vars[key] = shellQuote(value)
+	}
+	renderedPath, removeRendered, err := renderTemplate(scriptPath, vars, "", s.config)
+	if err != nil {
+		run.close()
+		return nil, &runError{http.StatusBadRequest, err.Error()}
+	}
+	run.cleanup = append(run.cleanup, removeRendered)
+	workdir, err := scriptWorkdir(scriptPath, "", s.config)
+	if err != nil {
+		run.close()
+		return nil, &runError{http.StatusInternalServerError, err.Error()}
+	}
+	run.cmd, err = scriptCommand(renderedPath, args, s.config)
+	if err != nil {
+		run.close()
+		return nil, &runError{http.StatusInternalServerError, err.Error()}
+	}
+	run.cmd.Dir = workdir
+
+	// The output is kept for GET /runs/<id>/log
+	if file, err := createRunLog(run.id, s.config); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	} else {
+		run.cleanup = append(run.cleanup, func() { file.Close() })
+		run.log = file
+	}
+	return run, nil
+}
+
+// execute runs a prepared run, passing its output to stdout and stderr as
+// well as to its log, and records it. With stderr nil, both streams go to
+// stdout, in the order they were written.
+func (s *Server) execute(run *daemonRun, stdout, stderr func(p []byte)) (*RunRecord, error) {
+	var mu sync.Mutex
+	run.cmd.Stdout = &runOutput{mu: &mu, send: stdout, log: run.log}
+	run.cmd.Stderr = run.cmd.Stdout
+	if stderr != nil {
+		run.cmd.Stderr = &runOutput{mu: &mu, send: stderr, log: run.log}
+	}
+
+	finish := s.metrics.startRun()
+	record, err := runRecorded(run.name, run.cmd, &RunOptions{RunID: run.id}, s.config)
+	finish(run.name, record.Duration(), record.ExitCode != 0)
+	if err != nil && record.ExitCode == -1 {
+		return nil, &runError{http.StatusInternalServerError, fmt.Sprintf("failed to run %s: %v", run.name, err)}
+	}
+	return record, nil
+}
+
+func (s *Server) handleCompile(w http.ResponseWriter, r *http.Request, _ *ServeToken) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if isReadOnly(s.config) {
+		writeError(w, http.StatusForbidden, "compiling is disabled in read-only mode")
+		return
+	}
+	var req CompileRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Source == "" {
+		writeError(w, http.StatusBadRequest, "source is required")
+		return
+	}
+
+	var output bytes.Buffer
+	opts := &CompileOptions{SourcePath: req.Source, BinaryName: req.Name, Force: req.Force, Output: &output}
+	s.compileMu.Lock()
+	start := time.Now()
+	result, err := compileSource(opts, s.config)
+	duration := time.Since(start)
+	s.compileMu.Unlock()
+
+	resp := &CompileResponse{Name: req.Name, Output: output.String()}
+	if resp.Name == "" {
+		resp.Name = strings.TrimSuffix(filepath.Base(req.Source), filepath.Ext(req.Source))
+	}
+	if result != nil {
+		resp.Name, resp.OutputPath, resp.UpToDate = result.Name, result.OutputPath, result.UpToDate
+	}
+	s.metrics.recordCompile(resp.Name, duration, err != nil)
+	if err != nil {
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusUnprocessableEntity, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// decodeBody reads an optional JSON request body into v
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// serve runs the daemon until interrupted, over gRPC too when grpcAddr or
+// serve.grpcAddr is set
+func serve(addr, grpcAddr string, config *Config) error {
+	if addr == "" && config.Serve != nil {
+		addr = config.Serve.Addr
+	}
+	if addr == "" {
+		addr = defaultServeAddr
+	}
+	if grpcAddr == "" && config.Serve != nil {
+		grpcAddr = config.Serve.GRPCAddr
+	}
+	if err := checkServeTokens(config); err != nil {
+		return err
+	}
+	if err := checkTriggers(config); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	if host, _, _ := net.SplitHostPort(addr); len(serveTokens(config)) == 0 && !isLoopback(host) {
+		fmt.Println("Warning: no serve.token is configured, so anyone who can reach this address can run scripts")
+	}
+
+	daemon := newServer(config)
+	server := &http.Server{Handler: daemon.handler(), ReadHeaderTimeout: 10 * time.Second}
+	var rpc *grpc.Server
+	var rpcAddr net.Addr
+	if grpcAddr != "" {
+		rpc = newGRPCServer(daemon)
+		if rpcAddr, err = listenGRPC(grpcAddr, rpc); err != nil {
+			listener.Close()
+			return err
+		}
+		if host, _, _ := net.SplitHostPort(grpcAddr); len(serveTokens(config)) == 0 && !isLoopback(host) {
+			fmt.Println("Warning: no serve.token is configured, so anyone who can reach the gRPC address can run scripts")
+		}
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	stopAutoPull := make(chan struct{})
+	if autoSync(config) {
+		go autoPullLoop(config, stopAutoPull)
+	}
+	go func() {
+		<-interrupt
+		close(stopAutoPull)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if rpc != nil {
+			rpc.Stop()
+		}
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Printf("Listening on http://%s (metrics at /metrics)\n", listener.Addr())
+	if rpcAddr != nil {
+		fmt.Printf("Serving gRPC on %s (scriptspb/scripts.proto)\n", rpcAddr)
+	}
+	stopTriggers, err := startTriggers(daemon)
+	if err != nil {
+		server.Close()
+		return err
+	}
+	defer stopTriggers()
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// isLoopback reports whether host only accepts local connections
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// shellPrompt is shown by "scripts shell"
+const shellPrompt = "scripts> "
+
+// runShell reads commands and script names line by line and runs them as
+// if each were prefixed with "scripts". On a terminal it has line editing,
+// a history of the session (up and down) and tab completion; otherwise
+// lines are read from stdin without a prompt.
+func runShell(config *Config) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the scripts executable: %v", err)
+	}
+	// Ctrl-C interrupts the running script, not the shell
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+		}
+	}()
+
+	if !isTerminal(os.Stdin) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !shellLine(exe, scanner.Text(), false) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	fd := int(os.Stdin.Fd())
+	screen := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	terminal := term.NewTerminal(screen, colorize(colorBold, shellPrompt))
+	terminal.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return completeLine(line, pos, config)
+	}
+	fmt.Println("Type a command or script name without 'scripts', 'help' for the commands, or 'exit'")
+	for {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to set up the terminal: %v", err)
+		}
+		line, err := terminal.ReadLine()
+		term.Restore(fd, state)
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !shellLine(exe, line, true) {
+			return nil
+		}
+	}
+}
+
+// shellLine runs one line of the shell, reporting false when it asks to
+// exit. Without interactive set, the line's command doesn't get stdin,
+// which holds the lines that follow.
+func shellLine(exe, line string, interactive bool) bool {
+	args, err := splitQuoted(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	// "scripts" is implied, but typing it anyway works too
+	if len(args) > 0 && args[0] == "scripts" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return true
+	}
+	switch args[0] {
+	case "exit", "quit":
+		return false
+	case "shell":
+		fmt.Println("Already in the scripts shell")
+		return true
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if interactive {
+		cmd.Stdin = os.Stdin
+	}
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+	return true
+}
+
+// completeLine completes the word before the cursor, as far as the
+// candidates agree
+func completeLine(line string, pos int, config *Config) (string, int, bool) {
+	before := line[:pos]
+	words := strings.Fields(before)
+	if len(words) == 0 || strings.HasSuffix(before, " ") {
+		words = append(words, "")
+	}
+	candidates := complete(words, config)
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	completion := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(candidates) == 1 {
+		completion += " "
+	}
+	current := words[len(words)-1]
+	if completion == current {
+		return "", 0, false
+	}
+	before = before[:len(before)-len(current)] + completion
+	return before + line[pos:], len(before), true
+}
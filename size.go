@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// sizeFlags are the compiler flags --small adds for each tool, ahead of the
+// config and command line flags so those can still override them
+var sizeFlags = map[string][]string{
+	"go":     {"-trimpath", "-ldflags=-s -w"},
+	"rust":   {"-C", "opt-level=z", "-C", "strip=symbols"},
+	"cargo":  {"--config", `profile.release.opt-level="z"`, "--config", "profile.release.strip=true"},
+	"c":      {"-Os", "-s"},
+	"cpp":    {"-Os", "-s"},
+	"python": {"--strip"},
+}
+
+// stripAfterBuild lists tools whose builds can't be told to strip symbols,
+// so the installed executable is stripped afterwards instead
+var stripAfterBuild = map[string]bool{
+	"cmake": true,
+	"make":  true,
+	"meson": true,
+}
+
+// noPacking lists tools whose executables carry an appended payload that
+// UPX would corrupt, or aren't native executables at all
+var noPacking = map[string]bool{
+	"python": true,
+	"nuitka": true,
+	"shiv":   true,
+	"zipapp": true,
+	"csharp": true,
+}
+
+// toolAvailable reports whether the resolved command for tool is installed
+func toolAvailable(tool string, config *Config) bool {
+	_, err := exec.LookPath(resolveCompiler(tool, config)[0])
+	return err == nil
+}
+
+// shrinkBinary strips and UPX-packs a freshly built binary when the tools are
+// available, then reports its size against the build it replaces (before,
+// or -1 when there was none)
+func shrinkBinary(job *CompileJob, tool string, before int64) error {
+	if stripAfterBuild[tool] && toolAvailable("strip", job.Config) {
+		if err := job.command("strip", job.OutputPath).Run(); err != nil {
+			return fmt.Errorf("failed to strip binary: %v", err)
+		}
+	}
+
+	switch {
+	case noPacking[tool], job.Target == targetWasm:
+	case !toolAvailable("upx", job.Config):
+		fmt.Fprintln(job.out(), "UPX not found, skipping compression")
+	default:
+		if err := job.command("upx", "--best", "-q", job.OutputPath).Run(); err != nil {
+			// Some executables can't be packed; an unpacked binary still works
+			fmt.Fprintf(job.out(), "Warning: UPX failed, keeping the unpacked binary: %v\n", err)
+		}
+	}
+
+	after, err := os.Stat(job.OutputPath)
+	if err != nil {
+		return err
+	}
+	switch {
+	case before < 0:
+		fmt.Fprintf(job.out(), "Size: %s\n", humanSize(after.Size()))
+	case after.Size() < before:
+		saved := 100 * (before - after.Size()) / before
+		fmt.Fprintf(job.out(), "Size: %s -> %s (-%d%%)\n", humanSize(before), humanSize(after.Size()), saved)
+	default:
+		fmt.Fprintf(job.out(), "Size: %s -> %s\n", humanSize(before), humanSize(after.Size()))
+	}
+	return nil
+}
+
+// humanSize formats a byte count using binary units
+func humanSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
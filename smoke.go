@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// smokeTimeout bounds how long a smoke test may run, so a binary that waits
+// for input or hangs doesn't stall the build
+const smokeTimeout = 10 * time.Second
+
+// defaultSmokeArgs is what a freshly built binary is run with when --smoke
+// is given without arguments
+var defaultSmokeArgs = []string{"--help"}
+
+// smokeTest runs a freshly built binary once and fails if it can't start,
+// exits non-zero, crashes or hangs
+func smokeTest(binPath string, args []string, opts *CompileOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), smokeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	output, err := cmd.CombinedOutput()
+	// The binary runs from a staging directory, so report it by name
+	invocation := strings.Join(append([]string{filepath.Base(binPath)}, args...), " ")
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s did not exit within %s", invocation, smokeTimeout)
+	}
+	if err != nil {
+		if detail := lastLine(string(output)); detail != "" {
+			return fmt.Errorf("%s: %v (%s)", invocation, err, detail)
+		}
+		return fmt.Errorf("%s: %v", invocation, err)
+	}
+	fmt.Fprintf(opts.out(), "Smoke test passed: %s\n", invocation)
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// staticFlags are the compiler flags --static adds for each tool
+var staticFlags = map[string][]string{
+	"c":   {"-static"},
+	"cpp": {"-static"},
+}
+
+// staticEnv is the environment --static sets for each tool
+var staticEnv = map[string][]string{
+	"go": {"CGO_ENABLED=0"},
+}
+
+// muslTarget returns the Rust target triple used for static builds: the
+// configured one, or the musl target for the host architecture
+func muslTarget(config *Config) string {
+	if config.MuslTarget != "" {
+		return config.MuslTarget
+	}
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "arm64":
+		arch = "aarch64"
+	case "386":
+		arch = "i686"
+	}
+	return arch + "-unknown-linux-musl"
+}
+
+// prepareStatic sets up a job for a statically linked build, failing early
+// when the language or the installed toolchain can't produce one
+func prepareStatic(job *CompileJob, lang, tool string) error {
+	switch lang {
+	case "go", "c", "cpp":
+		job.Flags = append(append([]string{}, staticFlags[tool]...), job.Flags...)
+		job.Env = append(job.Env, staticEnv[tool]...)
+		return nil
+	case "rust":
+		target := muslTarget(job.Config)
+		if !rustTargetInstalled(target, job.Config) {
+			return fmt.Errorf("rust target %s is not installed (run 'rustup target add %s' or set muslTarget in config)", target, target)
+		}
+		job.RustTarget = target
+		return nil
+	}
+	return fmt.Errorf("--static is not supported for %s (supported: Go, C, C++, Rust)", lang)
+}
+
+// rustTargetInstalled reports whether the standard library for a target is
+// present in the active Rust toolchain
+func rustTargetInstalled(target string, config *Config) bool {
+	compiler := resolveCompiler("rust", config)
+	output, err := exec.Command(compiler[0], append(compiler[1:], "--print", "sysroot")...).Output()
+	if err != nil {
+		return false
+	}
+	sysroot := strings.TrimSpace(string(output))
+	_, err = os.Stat(filepath.Join(sysroot, "lib", "rustlib", target))
+	return err == nil
+}
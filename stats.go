@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// ScriptStats summarises the recorded runs of one script
+type ScriptStats struct {
+	Script    string
+	Runs      int
+	Succeeded int
+	Durations []time.Duration // sorted ascending
+	Total     time.Duration
+	LastRun   *RunRecord
+}
+
+// SuccessRate returns the share of runs that exited 0, as a percentage
+func (s *ScriptStats) SuccessRate() float64 {
+	if s.Runs == 0 {
+		return 0
+	}
+	return 100 * float64(s.Succeeded) / float64(s.Runs)
+}
+
+// Average returns the mean run duration
+func (s *ScriptStats) Average() time.Duration {
+	if s.Runs == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Runs)
+}
+
+// Percentile returns the nearest-rank percentile of the run durations
+func (s *ScriptStats) Percentile(p int) time.Duration {
+	if len(s.Durations) == 0 {
+		return 0
+	}
+	rank := (p*len(s.Durations) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return s.Durations[rank-1]
+}
+
+// collectStats groups runs by script
+func collectStats(records []*RunRecord) map[string]*ScriptStats {
+	stats := map[string]*ScriptStats{}
+	for _, record := range records {
+		// Skipped runs did no work to measure
+		if record.Skipped {
+			continue
+		}
+		s := stats[record.Script]
+		if s == nil {
+			s = &ScriptStats{Script: record.Script}
+			stats[record.Script] = s
+		}
+		s.Runs++
+		if record.ExitCode == 0 {
+			s.Succeeded++
+		}
+		s.Durations = append(s.Durations, record.Duration())
+		s.Total += record.Duration()
+		if s.LastRun == nil || record.Start.After(s.LastRun.Start) {
+			s.LastRun = record
+		}
+	}
+	for _, s := range stats {
+		sort.Slice(s.Durations, func(i, j int) bool { return s.Durations[i] < s.Durations[j] })
+	}
+	return stats
+}
+
+// printStats shows run statistics for every script, or only the most run
+// ones when top is positive
+func printStats(top int, config *Config) error {
+	records, err := loadHistory(config)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Printf("No runs recorded yet (history is kept in %s)\n", historyPath(config))
+		return nil
+	}
+
+	var all []*ScriptStats
+	for _, s := range collectStats(records) {
+		all = append(all, s)
+	}
+	if top > 0 {
+		// Most used first, so the list shows what is actually used
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].Runs != all[j].Runs {
+				return all[i].Runs > all[j].Runs
+			}
+			return all[i].Script < all[j].Script
+		})
+		if len(all) > top {
+			all = all[:top]
+		}
+	} else {
+		sort.Slice(all, func(i, j int) bool { return all[i].Script < all[j].Script })
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCRIPT\tRUNS\tSUCCESS\tAVG\tP50\tP95\tLAST RUN")
+	for _, s := range all {
+		fmt.Fprintf(w, "%s\t%d\t%.0f%%\t%s\t%s\t%s\t%s\n", s.Script, s.Runs, s.SuccessRate(),
+			formatDuration(s.Average()), formatDuration(s.Percentile(50)), formatDuration(s.Percentile(95)),
+			s.LastRun.Start.Local().Format("2006-01-02 15:04"))
+	}
+	return w.Flush()
+}
+
+// printScriptStats shows detailed run statistics for one script
+func printScriptStats(name string, config *Config) error {
+	records, err := loadHistory(config)
+	if err != nil {
+		return err
+	}
+	s := collectStats(records)[name]
+	if s == nil {
+		return fmt.Errorf("no runs of %s recorded", name)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Script:\t%s\n", s.Script)
+	fmt.Fprintf(w, "Runs:\t%d (%d succeeded, %d failed)\n", s.Runs, s.Succeeded, s.Runs-s.Succeeded)
+	fmt.Fprintf(w, "Success rate:\t%.1f%%\n", s.SuccessRate())
+	fmt.Fprintf(w, "Average:\t%s\n", formatDuration(s.Average()))
+	fmt.Fprintf(w, "Percentiles:\tp50 %s, p90 %s, p95 %s, p99 %s\n", formatDuration(s.Percentile(50)),
+		formatDuration(s.Percentile(90)), formatDuration(s.Percentile(95)), formatDuration(s.Percentile(99)))
+	fmt.Fprintf(w, "Slowest:\t%s\n", formatDuration(s.Durations[len(s.Durations)-1]))
+	fmt.Fprintf(w, "Last run:\t%s (exit status %d, %s)\n", s.LastRun.Start.Local().Format("2006-01-02 15:04:05"),
+		s.LastRun.ExitCode, formatDuration(s.LastRun.Duration()))
+	return w.Flush()
+}
+
+// formatDuration rounds a duration for display
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// jsonFields returns the fields of a struct type by their JSON names,
+// including those of embedded structs, which encoding/json flattens
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for key, fieldType := range jsonFields(embedded) {
+					fields[key] = fieldType
+				}
+				continue
+			}
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// checkConfigKeys rejects keys of the config file that aren't settings,
+// at any depth, which would otherwise be ignored in favour of the defaults
+func checkConfigKeys(data []byte) error {
+	var problems []string
+	unknownConfigKeys(data, reflect.TypeOf(Config{}), "", &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%s (strict is on)", strings.Join(problems, "; "))
+}
+
+// unknownConfigKeys walks a JSON value alongside the type it decodes into,
+// adding the unknown keys it finds to problems by their full path, like
+// "sync.encrpyt" or "triggers.rules[0].topc"
+func unknownConfigKeys(data json.RawMessage, t reflect.Type, path string, problems *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types decoding themselves have formats of their own
+	if t.Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return
+	}
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	// Values of the wrong type are reported by decoding the config itself
+	switch t.Kind() {
+	case reflect.Struct:
+		var raw map[string]json.RawMessage
+		if json.Unmarshal(data, &raw) != nil {
+			return
+		}
+		fields := jsonFields(t)
+		known := make([]string, 0, len(fields))
+		for name := range fields {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		for key, value := range raw {
+			fieldType, ok := fields[key]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("unknown config key %q%s", join(key), didYouMean(key, known)))
+				continue
+			}
+			unknownConfigKeys(value, fieldType, join(key), problems)
+		}
+	case reflect.Map:
+		var raw map[string]json.RawMessage
+		if json.Unmarshal(data, &raw) != nil {
+			return
+		}
+		for key, value := range raw {
+			unknownConfigKeys(value, t.Elem(), join(key), problems)
+		}
+	case reflect.Slice, reflect.Array:
+		var raw []json.RawMessage
+		if json.Unmarshal(data, &raw) != nil {
+			return
+		}
+		for i, value := range raw {
+			unknownConfigKeys(value, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	}
+}
+
+// jsonUnmarshalerType is the interface of types with their own JSON format
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// editDistance is the Levenshtein distance between two words, ignoring case
+func editDistance(a, b string) int {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// didYouMean suggests the candidate closest to a mistyped word, as
+// ` (did you mean "x"?)`, or "" when none is close
+func didYouMean(word string, candidates []string) string {
+	best, bestDistance := "", max(2, len(word)/3)+1
+	for _, candidate := range candidates {
+		if d := editDistance(word, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// unknownFlagError rejects a flag, suggesting the known one it was
+// probably meant to be
+func unknownFlagError(flag string, known []string) error {
+	return fmt.Errorf("unknown flag: %s%s", flag, didYouMean(flag, known))
+}
+
+// checkScriptFlags rejects, for scripts declaring their flags with
+// "# scripts:flag", arguments that look like flags but aren't declared.
+// Scripts that declare none take anything, and "--" ends the flags.
+func checkScriptFlags(path string, args []string) error {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return err
+	}
+	var declared []string
+	for _, value := range meta.Values["flag"] {
+		if fields := strings.Fields(value); len(fields) > 0 {
+			declared = append(declared, fields[0])
+		}
+	}
+	if len(declared) == 0 {
+		return nil
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		// "-" is stdin and "-5" a number, not flags
+		if len(arg) < 2 || arg[0] != '-' || (arg[1] >= '0' && arg[1] <= '9') {
+			continue
+		}
+		flag, _, _ := strings.Cut(arg, "=")
+		if !slices.Contains(declared, flag) {
+			return unknownFlagError(flag, declared)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sudoEnv lists the environment variables kept through sudo, from the
+// sudoEnv config: names, or prefixes ending in "*" like "AWS_*"
+func sudoEnv(config *Config) []string {
+	keep := map[string]bool{}
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		for _, pattern := range config.SudoEnv {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); (ok && strings.HasPrefix(name, prefix)) || name == pattern {
+				keep[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(keep))
+	for name := range keep {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runEnvNames are the variables scripts sets for every run, which are kept
+// through sudo whatever sudoEnv says
+var runEnvNames = []string{cacheEnv, projectRootEnv, resultEnv}
+
+// preservedEnv lists the environment variables kept through sudo: those
+// allowlisted by sudoEnv and those scripts sets for the run
+func preservedEnv(config *Config) []string {
+	names := sudoEnv(config)
+	for _, name := range runEnvNames {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withSudo rewrites cmd to run through sudo, keeping the allowlisted
+// environment. The history is still recorded by this process, as the user.
+func withSudo(cmd *exec.Cmd, config *Config) (*exec.Cmd, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("--sudo isn't supported on Windows")
+	}
+	if os.Geteuid() == 0 {
+		return cmd, nil
+	}
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return nil, fmt.Errorf("--sudo needs sudo, which isn't installed")
+	}
+	return sudoCommand(sudo, nil, cmd, config), nil
+}
+
+// asUser makes cmd run as another user: directly when running as root,
+// otherwise through "sudo -u", checking first that sudoers allows it
+func asUser(cmd *exec.Cmd, name string, config *Config) (*exec.Cmd, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("--user isn't supported on Windows")
+	}
+	target, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s", name)
+	}
+	if current, err := user.Current(); err == nil && current.Uid == target.Uid {
+		return cmd, nil
+	}
+
+	if os.Geteuid() == 0 {
+		if err := setCredential(cmd, target); err != nil {
+			return nil, err
+		}
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = append(env, "HOME="+target.HomeDir, "USER="+target.Username, "LOGNAME="+target.Username)
+		return cmd, nil
+	}
+
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return nil, fmt.Errorf("running as %s needs root or sudo, which isn't installed", name)
+	}
+	// A refusal from sudo would otherwise look like the script failing
+	check := exec.Command(sudo, append([]string{"-l", "-u", name, "--", cmd.Path}, cmd.Args[1:]...)...)
+	check.Stdin = os.Stdin
+	check.Stderr = os.Stderr
+	if err := check.Run(); err != nil {
+		return nil, fmt.Errorf("you aren't allowed to run %s as %s (see 'sudo -l')", cmd.Path, name)
+	}
+	return sudoCommand(sudo, []string{"-u", name}, cmd, config), nil
+}
+
+// sudoCommand wraps cmd in a sudo invocation with the given options
+func sudoCommand(sudo string, options []string, cmd *exec.Cmd, config *Config) *exec.Cmd {
+	args := append(options, "--preserve-env="+strings.Join(preservedEnv(config), ","))
+	args = append(append(args, "--", cmd.Path), cmd.Args[1:]...)
+	wrapped := exec.Command(sudo, args...)
+	wrapped.Dir = cmd.Dir
+	wrapped.Env = cmd.Env
+	wrapped.Stdin = cmd.Stdin
+	wrapped.Stdout = cmd.Stdout
+	wrapped.Stderr = cmd.Stderr
+	return wrapped
+}
+
+// chownToUser gives paths to the named user; only root may do this
+func chownToUser(name string, paths ...string) error {
+	target, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(target.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(target.Gid)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unguessableName returns a random file name, for files shared with another
+// user in a directory others can enter but not list
+func unguessableName() string {
+	name := make([]byte, 16)
+	rand.Read(name)
+	return hex.EncodeToString(name)
+}
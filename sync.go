@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Ways "scripts sync" resolves a script changed on both machines
+const (
+	resolveAsk    = ""       // show the diff and ask
+	resolveOurs   = "ours"   // keep this machine's version
+	resolveTheirs = "theirs" // take the remote's version
+)
+
+// SyncOptions controls "scripts sync"
+type SyncOptions struct {
+	Resolve string // how conflicts are resolved without asking
+	Pull    bool   // only fast-forward to the remote
+	Quiet   bool   // with Pull, report changes on the next invocation instead
+}
+
+// syncGit runs git in the scripts directory, returning its output; a
+// failure includes what git printed
+func syncGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("git %s failed: %v\n%s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// syncRepo makes sure the scripts directory is a git repository with the
+// configured remote as origin
+func syncRepo(config *Config) error {
+	dir := config.ScriptDir
+	if config.Sync == nil || config.Sync.Remote == "" {
+		return fmt.Errorf("no remote to sync with (set \"sync\": {\"remote\": \"<git url>\"} in the config)")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if _, err := syncGit(dir, "init", "--quiet"); err != nil {
+			return err
+		}
+	}
+	current, err := syncGit(dir, "remote", "get-url", "origin")
+	switch {
+	case err != nil:
+		_, err = syncGit(dir, "remote", "add", "origin", config.Sync.Remote)
+	case current != config.Sync.Remote:
+		_, err = syncGit(dir, "remote", "set-url", "origin", config.Sync.Remote)
+	}
+	return err
+}
+
+// editorCommand returns the user's editor command line
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if words, err := splitQuoted(os.Getenv(env)); err == nil && len(words) > 0 {
+			return words
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// conflictVersion returns one side of a conflicted file from the index,
+// stage 2 being ours and 3 theirs, and whether that side has the file at
+// all (it doesn't when it was deleted there)
+func conflictVersion(dir, file string, stage int) ([]byte, bool) {
+	output, err := exec.Command("git", "-C", dir, "show", fmt.Sprintf(":%d:%s", stage, file)).Output()
+	if err != nil {
+		return nil, false
+	}
+	return output, true
+}
+
+// takeVersion resolves a conflict with one side's version, or by removing
+// the file when that side deleted it
+func takeVersion(dir, file string, stage int) error {
+	content, ok := conflictVersion(dir, file, stage)
+	if !ok {
+		_, err := syncGit(dir, "rm", "--quiet", "--", file)
+		return err
+	}
+	path := filepath.Join(dir, file)
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, content, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	_, err := syncGit(dir, "add", "--", file)
+	return err
+}
+
+// showConflict prints how the remote's version of a conflicted file differs
+// from this machine's, decrypting encrypted files
+func showConflict(file string, config *Config) error {
+	dir := config.ScriptDir
+	ours, hasOurs := conflictVersion(dir, file, 2)
+	theirs, hasTheirs := conflictVersion(dir, file, 3)
+	switch {
+	case !hasOurs:
+		fmt.Printf("%s was deleted here but changed on the remote\n", file)
+		return nil
+	case !hasTheirs:
+		fmt.Printf("%s was changed here but deleted on the remote\n", file)
+		return nil
+	}
+	if strings.HasSuffix(file, encryptedSuffix) {
+		var err error
+		if ours, err = ageRun(ours, "-d", "-i", ageIdentity(config)); err != nil {
+			return err
+		}
+		if theirs, err = ageRun(theirs, "-d", "-i", ageIdentity(config)); err != nil {
+			return err
+		}
+		file = strings.TrimSuffix(file, encryptedSuffix)
+	}
+	tmp, err := os.MkdirTemp("", "scripts-sync-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	oursPath, theirsPath := filepath.Join(tmp, "ours"), filepath.Join(tmp, "theirs")
+	if err := os.WriteFile(oursPath, ours, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(theirsPath, theirs, 0600); err != nil {
+		return err
+	}
+	_, err = diffFiles(oursPath, theirsPath, file+" (this machine)", file+" (remote)", colorEnabled())
+	return err
+}
+
+// editConflict opens a conflicted file, with git's conflict markers, in the
+// user's editor until the markers are gone
+func editConflict(dir, file string, in *bufio.Reader) error {
+	path := filepath.Join(dir, file)
+	for {
+		editor := editorCommand()
+		cmd := exec.Command(editor[0], append(editor[1:], path)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to run %s: %v", editor[0], err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		if !bytes.Contains(content, []byte("\n<<<<<<< ")) && !bytes.HasPrefix(content, []byte("<<<<<<< ")) {
+			_, err := syncGit(dir, "add", "--", file)
+			return err
+		}
+		fmt.Printf("%s still has conflict markers. Edit it again? [Y/n] ", file)
+		answer, _ := in.ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "" && answer != "y" && answer != "yes" {
+			return fmt.Errorf("%s still has conflict markers", file)
+		}
+	}
+}
+
+// resolveConflicts settles the scripts changed both here and on the remote:
+// with opts.Resolve, one side wins throughout; otherwise each is shown as a
+// diff and the user keeps ours, takes theirs or edits the merge
+func resolveConflicts(files []string, opts *SyncOptions, config *Config) error {
+	dir := config.ScriptDir
+	in := bufio.NewReader(os.Stdin)
+	for _, file := range files {
+		switch opts.Resolve {
+		case resolveOurs:
+			if err := takeVersion(dir, file, 2); err != nil {
+				return err
+			}
+			fmt.Printf("Kept this machine's %s\n", file)
+			continue
+		case resolveTheirs:
+			if err := takeVersion(dir, file, 3); err != nil {
+				return err
+			}
+			fmt.Printf("Took the remote's %s\n", file)
+			continue
+		}
+
+		fmt.Println(colorize(colorBold, "==> "+file+" changed on both machines"))
+		if err := showConflict(file, config); err != nil {
+			return err
+		}
+		for resolved := false; !resolved; {
+			fmt.Printf("Keep [o]urs, take [t]heirs, [e]dit the merge, or [a]bort the sync? ")
+			answer, err := in.ReadString('\n')
+			if err != nil && strings.TrimSpace(answer) == "" {
+				return fmt.Errorf("sync aborted")
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "o", "ours":
+				err, resolved = takeVersion(dir, file, 2), true
+			case "t", "theirs":
+				err, resolved = takeVersion(dir, file, 3), true
+			case "e", "edit":
+				if strings.HasSuffix(file, encryptedSuffix) {
+					fmt.Println("Encrypted files can't be merged by hand; keep ours or take theirs, then edit the file")
+					continue
+				}
+				err, resolved = editConflict(dir, file, in), true
+			case "a", "abort":
+				return fmt.Errorf("sync aborted")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runSync commits local changes to the scripts directory, merges the
+// remote's with a three-way merge and pushes the result. Scripts changed
+// on only one side merge by themselves; scripts changed on both are
+// resolved rather than one machine clobbering the other.
+func runSync(opts *SyncOptions, config *Config) error {
+	if isOffline() {
+		return errOffline("syncing")
+	}
+	if err := syncRepo(config); err != nil {
+		return err
+	}
+	dir := config.ScriptDir
+	if err := keepLocal(config); err != nil {
+		return err
+	}
+	if err := sealFiles(config); err != nil {
+		return err
+	}
+	if _, err := syncGit(dir, "add", "--all"); err != nil {
+		return err
+	}
+	if exec.Command("git", "-C", dir, "diff", "--cached", "--quiet").Run() != nil {
+		host, _ := os.Hostname()
+		if _, err := syncGit(dir, "commit", "--quiet", "-m", "Sync from "+host); err != nil {
+			return err
+		}
+	}
+	if _, err := syncGit(dir, "fetch", "--quiet", "origin"); err != nil {
+		return err
+	}
+
+	branch, err := syncGit(dir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+	remote := "origin/" + branch
+	if _, err := syncGit(dir, "rev-parse", "--verify", "--quiet", remote); err == nil {
+		if _, err := syncGit(dir, "merge", "--no-edit", "--quiet", "--allow-unrelated-histories", remote); err != nil {
+			conflicts, _ := syncGit(dir, "diff", "--name-only", "--diff-filter=U")
+			if conflicts == "" {
+				syncGit(dir, "merge", "--abort")
+				return err
+			}
+			files := strings.Split(conflicts, "\n")
+			if opts.Resolve == resolveAsk && !canPrompt() {
+				syncGit(dir, "merge", "--abort")
+				return fmt.Errorf("%d scripts changed on this machine and the remote: %s (run 'scripts sync' in a terminal to choose, or pass --ours or --theirs)", len(files), strings.Join(files, ", "))
+			}
+			if err := resolveConflicts(files, opts, config); err != nil {
+				syncGit(dir, "merge", "--abort")
+				return err
+			}
+			if _, err := syncGit(dir, "commit", "--quiet", "--no-edit"); err != nil {
+				return err
+			}
+		}
+	}
+	if err := openFiles(config); err != nil {
+		return err
+	}
+	if _, err := syncGit(dir, "push", "--quiet", "origin", "HEAD"); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := updateSyncState(config, func(state *SyncState) { state.LastSync, state.LastPull = now, now }); err != nil {
+		return err
+	}
+	fmt.Printf("Synced %s with %s\n", dir, config.Sync.Remote)
+	return nil
+}
+
+// SyncStatus compares the scripts here with the remote's since they last
+// had the same history
+type SyncStatus struct {
+	Remote     string
+	Branch     string
+	LocalOnly  []string // added here
+	RemoteOnly []string // added on the remote
+	Modified   []string // changed here
+	Outdated   []string // changed on the remote
+	Conflicted []string // changed on both, so 'scripts sync' will ask
+	Removed    []string // removed here
+	Gone       []string // removed on the remote
+	Fetched    bool
+}
+
+// clean reports whether the scripts match the remote's
+func (s *SyncStatus) clean() bool {
+	return len(s.LocalOnly)+len(s.RemoteOnly)+len(s.Modified)+len(s.Outdated)+len(s.Conflicted)+len(s.Removed)+len(s.Gone) == 0
+}
+
+// syncStatus fetches the remote, unless offline, and compares the scripts
+// directory, uncommitted changes included, with it
+func syncStatus(config *Config) (*SyncStatus, error) {
+	if err := syncRepo(config); err != nil {
+		return nil, err
+	}
+	dir := config.ScriptDir
+	status := &SyncStatus{Remote: config.Sync.Remote}
+	if !isOffline() {
+		if _, err := syncGit(dir, "fetch", "--quiet", "origin"); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: can't reach the remote, comparing with what was last fetched: %v\n", err)
+		} else {
+			status.Fetched = true
+		}
+	}
+	branch, err := syncGit(dir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	status.Branch = branch
+
+	// Both sides' changes since the last commit they share
+	remote := "origin/" + branch
+	hasRemote := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", remote).Run() == nil
+	base := emptyTree
+	if hasRemote {
+		if mergeBase, err := syncGit(dir, "merge-base", "HEAD", remote); err == nil {
+			base = mergeBase
+		}
+	}
+	local, err := scriptChanges(dir, base, "")
+	if err != nil {
+		return nil, err
+	}
+	var remoteChanges []*SyncChange
+	if hasRemote {
+		if remoteChanges, err = scriptChanges(dir, base, remote); err != nil {
+			return nil, err
+		}
+	}
+
+	theirs := map[string]string{}
+	for _, c := range remoteChanges {
+		theirs[c.Name] = c.Change
+	}
+	for _, c := range local {
+		other, changedThere := theirs[c.Name]
+		delete(theirs, c.Name)
+		switch {
+		case changedThere && !(c.Change == "removed" && other == "removed"):
+			status.Conflicted = append(status.Conflicted, c.Name)
+		case changedThere:
+		case c.Change == "added":
+			status.LocalOnly = append(status.LocalOnly, c.Name)
+		case c.Change == "updated":
+			status.Modified = append(status.Modified, c.Name)
+		default:
+			status.Removed = append(status.Removed, c.Name)
+		}
+	}
+	for _, c := range remoteChanges {
+		switch change, ok := theirs[c.Name]; {
+		case !ok:
+		case change == "added":
+			status.RemoteOnly = append(status.RemoteOnly, c.Name)
+		case change == "updated":
+			status.Outdated = append(status.Outdated, c.Name)
+		default:
+			status.Gone = append(status.Gone, c.Name)
+		}
+	}
+	return status, nil
+}
+
+// printSyncStatus prints "scripts sync status": what a sync would push and
+// pull, and when the scripts were last synced
+func printSyncStatus(config *Config) error {
+	status, err := syncStatus(config)
+	if err != nil {
+		return err
+	}
+	state, err := loadSyncState(config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scripts: %s\n", config.ScriptDir)
+	fmt.Printf("Remote:  %s (%s)\n", status.Remote, status.Branch)
+	lastSync := "never"
+	if !state.LastSync.IsZero() {
+		lastSync = state.LastSync.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Printf("Last sync: %s\n", lastSync)
+	if !state.LastPull.IsZero() && state.LastPull.After(state.LastSync) {
+		fmt.Printf("Last pull: %s\n", state.LastPull.Local().Format("2006-01-02 15:04"))
+	}
+	if !status.Fetched {
+		fmt.Println(colorize(colorYellow, "(the remote wasn't fetched, so its side may be out of date)"))
+	}
+	fmt.Println()
+
+	if status.clean() {
+		fmt.Println("Up to date with the remote")
+		return nil
+	}
+	sections := []struct {
+		title string
+		color string
+		names []string
+	}{
+		{"Local only (pushed by 'scripts sync')", colorGreen, status.LocalOnly},
+		{"Modified here", colorGreen, status.Modified},
+		{"Removed here", colorGreen, status.Removed},
+		{"Remote only (pulled by 'scripts sync')", colorCyan, status.RemoteOnly},
+		{"Modified on the remote", colorCyan, status.Outdated},
+		{"Removed on the remote", colorCyan, status.Gone},
+		{"Modified on both (a sync asks which to keep)", colorRed, status.Conflicted},
+	}
+	for _, section := range sections {
+		if len(section.names) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", section.title)
+		for _, name := range section.names {
+			fmt.Printf("  %s\n", colorize(section.color, name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// tapsDir holds the clones of tapped repositories, inside the state directory
+const tapsDir = "taps"
+
+// tapScriptDirs are where a tap's scripts live, relative to its root
+var tapScriptDirs = []string{".", "scripts"}
+
+// Tap is a git repository of shared scripts
+type Tap struct {
+	Name    string
+	Dir     string
+	URL     string
+	Commit  string
+	Updated time.Time
+}
+
+// tapDir returns where a tap is cloned
+func tapDir(name string, config *Config) string {
+	return filepath.Join(stateDir(config), tapsDir, name)
+}
+
+// loadTap reads the state of a tapped repository
+func loadTap(name string, config *Config) (*Tap, error) {
+	tap := &Tap{Name: name, Dir: tapDir(name, config)}
+	if _, err := os.Stat(tap.Dir); err != nil {
+		return nil, fmt.Errorf("no tap named %s (see 'scripts tap list')", name)
+	}
+	if url, err := exec.Command("git", "-C", tap.Dir, "remote", "get-url", "origin").Output(); err == nil {
+		tap.URL = strings.TrimSpace(string(url))
+	}
+	if head, err := exec.Command("git", "-C", tap.Dir, "log", "-1", "--format=%H %ct").Output(); err == nil {
+		fields := strings.Fields(string(head))
+		if len(fields) == 2 {
+			tap.Commit = fields[0]
+			if ts, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				tap.Updated = time.Unix(ts, 0)
+			}
+		}
+	}
+	return tap, nil
+}
+
+// loadTaps reads every tap, sorted by name
+func loadTaps(config *Config) ([]*Tap, error) {
+	entries, err := os.ReadDir(filepath.Join(stateDir(config), tapsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read taps: %v", err)
+	}
+	var taps []*Tap
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		tap, err := loadTap(entry.Name(), config)
+		if err != nil {
+			return nil, err
+		}
+		taps = append(taps, tap)
+	}
+	return taps, nil
+}
+
+// scripts lists the names of the scripts a tap provides, mapped to their
+// paths; tests are left out
+func (tap *Tap) scripts() map[string]string {
+	scripts := map[string]string{}
+	for _, dir := range tapScriptDirs {
+		files, _ := filepath.Glob(filepath.Join(tap.Dir, dir, "*.sh"))
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".sh")
+			if _, seen := scripts[name]; !seen && !isTestScript(file) {
+				scripts[name] = file
+			}
+		}
+	}
+	return scripts
+}
+
+// addTap clones a repository of scripts
+func addTap(url, name string, config *Config) error {
+	if name == "" {
+		name = repoName(url)
+	}
+	if !validName(name) {
+		return fmt.Errorf("invalid tap name %q", name)
+	}
+	dir := tapDir(name, config)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("tap %s already exists (use 'scripts tap update %s', or --name to pick another name)", name, name)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create taps directory: %v", err)
+	}
+
+	if isOffline() {
+		return errOffline("tapping " + url)
+	}
+	fmt.Printf("Cloning %s\n", url)
+	cmd := exec.Command("git", "clone", "--quiet", "--depth", "1", url, dir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("git clone failed: %v", err)
+	}
+	tap, err := loadTap(name, config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Tapped %s (%d scripts) - install one with 'scripts install %s/<script>'\n", name, len(tap.scripts()), name)
+	return nil
+}
+
+// updateTaps pulls the latest commits of the named taps, or of all taps
+func updateTaps(names []string, config *Config) error {
+	var taps []*Tap
+	if len(names) == 0 {
+		all, err := loadTaps(config)
+		if err != nil {
+			return err
+		}
+		taps = all
+	}
+	for _, name := range names {
+		tap, err := loadTap(name, config)
+		if err != nil {
+			return err
+		}
+		taps = append(taps, tap)
+	}
+
+	if isOffline() {
+		return errOffline("updating taps")
+	}
+	for _, tap := range taps {
+		cmd := exec.Command("git", "-C", tap.Dir, "pull", "--quiet", "--ff-only")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to update %s: %v", tap.Name, err)
+		}
+		updated, err := loadTap(tap.Name, config)
+		if err != nil {
+			return err
+		}
+		if updated.Commit == tap.Commit {
+			fmt.Printf("%s is up to date\n", tap.Name)
+		} else {
+			fmt.Printf("Updated %s: %s -> %s\n", tap.Name, shortCommit(tap.Commit), shortCommit(updated.Commit))
+		}
+	}
+	return nil
+}
+
+// removeTap deletes a tap's clone; installed scripts are kept
+func removeTap(name string, config *Config) error {
+	tap, err := loadTap(name, config)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(tap.Dir); err != nil {
+		return fmt.Errorf("failed to remove tap %s: %v", name, err)
+	}
+	return nil
+}
+
+// printTaps lists the taps, or the scripts of one tap
+func printTaps(name string, config *Config) error {
+	if name != "" {
+		tap, err := loadTap(name, config)
+		if err != nil {
+			return err
+		}
+		scripts := tap.scripts()
+		names := make([]string, 0, len(scripts))
+		for script := range scripts {
+			names = append(names, script)
+		}
+		sort.Strings(names)
+		fmt.Printf("%s (%s):\n", tap.Name, tap.URL)
+		for _, script := range names {
+			fmt.Printf("  %s/%s\n", tap.Name, script)
+		}
+		return nil
+	}
+
+	taps, err := loadTaps(config)
+	if err != nil {
+		return err
+	}
+	if len(taps) == 0 {
+		fmt.Println("No taps (add one with 'scripts tap add <git-url>')")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAP\tSCRIPTS\tCOMMIT\tUPDATED\tURL")
+	for _, tap := range taps {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", tap.Name, len(tap.scripts()), shortCommit(tap.Commit),
+			tap.Updated.Local().Format("2006-01-02"), tap.URL)
+	}
+	return w.Flush()
+}
+
+// installFromTap installs "tap/script" into ScriptDir
+func installFromTap(ref string, force bool, mode string, config *Config) error {
+	tapName, scriptName, ok := strings.Cut(strings.TrimSuffix(ref, ".sh"), "/")
+	if !ok || !validName(tapName) || !validName(scriptName) {
+		return fmt.Errorf("invalid script %q (expected <tap>/<script>)", ref)
+	}
+	tap, err := loadTap(tapName, config)
+	if err != nil {
+		return err
+	}
+	path, ok := tap.scripts()[scriptName]
+	if !ok {
+		return fmt.Errorf("tap %s has no script %s (see 'scripts tap list %s')", tapName, scriptName, tapName)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if _, err := installScript(scriptName, content, force, mode, config); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s/%s as %s.sh\n", tapName, scriptName, scriptName)
+	return recordOrigin(scriptName, &Origin{
+		Kind:     originTap,
+		Source:   tapName + "/" + scriptName,
+		Version:  shortCommit(tap.Commit),
+		Checksum: checksum(content),
+	}, config)
+}
+
+func printTapUsage() {
+	fmt.Println("Usage: scripts tap <command> [args...]")
+	fmt.Println("  add <git-url> [--name <name>]   Clone a repository of shared scripts")
+	fmt.Println("  list [<tap>]                    List taps, or the scripts in one")
+	fmt.Println("  update [<tap>...]               Pull the latest scripts")
+	fmt.Println("  remove <tap>                    Remove a tap (installed scripts are kept)")
+}
+
+// runTapCommand handles the "tap" command group
+func runTapCommand(args []string, config *Config) {
+	if len(args) == 0 {
+		printTapUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		var url, name string
+		for i := 1; i < len(args); i++ {
+			switch {
+			case args[i] == "--name" && i+1 < len(args):
+				i++
+				name = args[i]
+			case strings.HasPrefix(args[i], "-") || url != "":
+				url = ""
+				i = len(args)
+			default:
+				url = args[i]
+			}
+		}
+		if url == "" {
+			fmt.Println("Usage: scripts tap add <git-url> [--name <name>]")
+			os.Exit(1)
+		}
+		err = addTap(url, name, config)
+	case "list", "ls":
+		if len(args) > 2 {
+			fmt.Println("Usage: scripts tap list [<tap>]")
+			os.Exit(1)
+		}
+		name := ""
+		if len(args) == 2 {
+			name = args[1]
+		}
+		err = printTaps(name, config)
+	case "update":
+		err = updateTaps(args[1:], config)
+	case "remove", "rm":
+		if len(args) != 2 {
+			fmt.Println("Usage: scripts tap remove <tap>")
+			os.Exit(1)
+		}
+		if err = removeTap(args[1], config); err == nil {
+			fmt.Printf("Removed tap %s\n", args[1])
+		}
+	default:
+		fmt.Printf("Unknown tap command: %s\n", args[0])
+		printTapUsage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
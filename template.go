@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// varsFile holds the variables set with "scripts var set", inside the
+// state directory
+const varsFile = "vars.json"
+
+// varNamePattern matches a variable name
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// placeholderPattern matches a template placeholder like {{env}} or
+// {{ db.host }}
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+
+// isTemplate reports whether a script opts into templating with
+// "# scripts:template"
+func isTemplate(path string) bool {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return false
+	}
+	_, ok := meta.Values["template"]
+	return ok
+}
+
+// varsPath returns where the stored variables are kept
+func varsPath(config *Config) string {
+	return filepath.Join(stateDir(config), varsFile)
+}
+
+// loadVars reads the stored variables
+func loadVars(config *Config) (map[string]string, error) {
+	vars := map[string]string{}
+	data, err := os.ReadFile(varsPath(config))
+	if os.IsNotExist(err) {
+		return vars, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read variables: %v", err)
+	}
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", varsPath(config), err)
+	}
+	return vars, nil
+}
+
+// saveVars writes the stored variables, readable only by the user as
+// they may hold credentials
+func saveVars(vars map[string]string, config *Config) error {
+	data, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir(config), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(varsPath(config), data, 0600); err != nil {
+		return fmt.Errorf("failed to save variables: %v", err)
+	}
+	return nil
+}
+
+func printVarUsage() {
+	fmt.Println("Usage: scripts var <command> [args...]")
+	fmt.Println("  set <key> <value>             Store a template variable")
+	fmt.Println("  get <key>                     Print a stored variable")
+	fmt.Println("  list                          List the stored variables")
+	fmt.Println("  rm <key>                      Remove a stored variable")
+}
+
+// runVarCommand handles the "var" command group
+func runVarCommand(args []string, config *Config) {
+	if len(args) == 0 {
+		printVarUsage()
+		os.Exit(1)
+	}
+	usages := map[string]string{
+		"set":  "Usage: scripts var set <key> <value>",
+		"get":  "Usage: scripts var get <key>",
+		"list": "Usage: scripts var list",
+		"rm":   "Usage: scripts var rm <key>",
+	}
+	counts := map[string]int{"set": 3, "get": 2, "list": 1, "rm": 2}
+	if _, ok := usages[args[0]]; !ok {
+		fmt.Printf("Unknown var command: %s\n", args[0])
+		printVarUsage()
+		os.Exit(1)
+	}
+	if len(args) != counts[args[0]] {
+		fmt.Println(usages[args[0]])
+		os.Exit(1)
+	}
+
+	vars, err := loadVars(config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "set":
+		if !varNamePattern.MatchString(args[1]) {
+			fmt.Printf("Error: invalid variable name %q (use letters, digits, _, . and -)\n", args[1])
+			os.Exit(1)
+		}
+		vars[args[1]] = args[2]
+		err = saveVars(vars, config)
+	case "get":
+		value, ok := vars[args[1]]
+		if !ok {
+			fmt.Printf("Error: no variable %s\n", args[1])
+			os.Exit(1)
+		}
+		fmt.Println(value)
+	case "list":
+		keys := make([]string, 0, len(vars))
+		for key := range vars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s=%s\n", key, vars[key])
+		}
+	case "rm":
+		if _, ok := vars[args[1]]; !ok {
+			fmt.Printf("Error: no variable %s\n", args[1])
+			os.Exit(1)
+		}
+		delete(vars, args[1])
+		err = saveVars(vars, config)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// templateVars merges the variables a template can use: the config's
+// "vars", then the stored ones, then those given with --var
+func templateVars(given map[string]string, config *Config) (map[string]string, error) {
+	stored, err := loadVars(config)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	for _, layer := range []map[string]string{config.Vars, stored, given} {
+		for key, value := range layer {
+			vars[key] = value
+		}
+	}
+	return vars, nil
+}
+
+// renderTemplate renders a template script into a temporary copy with the
+// same name, returning its path and a function removing it. Scripts that
+// aren't templates are returned as they are. Every placeholder must have a
+// value. A copy for a script run as another user (runAs) is readable by them.
+func renderTemplate(path string, given map[string]string, runAs string, config *Config) (string, func(), error) {
+	if !isTemplate(path) {
+		return path, func() {}, nil
+	}
+	vars, err := templateVars(given, config)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var missing []string
+	rendered := placeholderPattern.ReplaceAllStringFunc(string(content), func(placeholder string) string {
+		key := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		value, ok := vars[key]
+		if !ok {
+			if !slices.Contains(missing, key) {
+				missing = append(missing, key)
+			}
+			return placeholder
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", nil, fmt.Errorf("%s uses undefined variables %s (give them with --var key=value, 'scripts var set' or \"vars\" in the config)",
+			filepath.Base(path), strings.Join(missing, ", "))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "scripts-template-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to render %s: %v", filepath.Base(path), err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	// Only the user running the script may read the copy, which holds the
+	// variables' values. Through sudo that user can't be given the copy, so
+	// it is readable by others but kept where they can't find it.
+	copyDir, perm := dir, info.Mode().Perm()&0700
+	if runAs != "" && os.Geteuid() != 0 {
+		copyDir, perm = filepath.Join(dir, unguessableName()), info.Mode().Perm()&0755
+		if err := os.Chmod(dir, 0711); err == nil {
+			err = os.Mkdir(copyDir, 0711)
+		}
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to render %s for %s: %v", filepath.Base(path), runAs, err)
+		}
+	}
+	renderedPath := filepath.Join(copyDir, filepath.Base(path))
+	if err := os.WriteFile(renderedPath, []byte(rendered), perm); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to render %s: %v", filepath.Base(path), err)
+	}
+	if runAs != "" && os.Geteuid() == 0 {
+		if err := chownToUser(runAs, dir, renderedPath); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to give the rendered %s to %s: %v", filepath.Base(path), runAs, err)
+		}
+	}
+	return renderedPath, cleanup, nil
+}
+
+// shellQuote quotes a value as a single word for the shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -1,9 +1,11 @@
 package tests
 
 import (
+	"encoding/json"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -52,6 +54,45 @@ func main() {
 	}
 }
 
+func TestCompileGoPackageDirectory(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// Create a package directory with more than one file
+	pkgDir := filepath.Join(dirs.Root, "cmd", "pkgtool")
+	err := os.MkdirAll(pkgDir, 0755)
+	AssertNil(t, err, "Should create package directory")
+	CreateTestSourceFile(t, pkgDir, "main", ".go", `package main
+
+func main() {
+    greet()
+}`)
+	CreateTestSourceFile(t, pkgDir, "greet", ".go", `package main
+
+import "fmt"
+
+func greet() {
+    fmt.Println("Hello from a Go package!")
+}`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// Compile the directory - the binary should be named after it
+	cmd := exec.Command(scriptsPath, "compile", pkgDir)
+	output, err := cmd.CombinedOutput()
+
+	outputStr := string(output)
+	AssertFalse(t, strings.Contains(outputStr, "does not exist"), "Directory should be accepted as a source")
+	if err == nil {
+		AssertTrue(t, strings.Contains(outputStr, "pkgtool"), "Binary should be named after the package directory")
+	} else {
+		AssertTrue(t, strings.Contains(outputStr, "go") ||
+			strings.Contains(outputStr, "not found"), "Should attempt Go compilation")
+	}
+}
+
 func TestCompilePythonLanguage(t *testing.T) {
 	// Setup
 	dirs := SetupTestDirs(t)
@@ -156,6 +197,125 @@ int main() {
 	}
 }
 
+func TestCompileCSharpLanguage(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// Create C# source file (top-level statements, no project file)
+	csFile := CreateTestSourceFile(t, dirs.Root, "hello", ".cs", `Console.WriteLine("Hello from C# compilation test!");`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// Attempt compilation
+	cmd := exec.Command(scriptsPath, "compile", csFile, "--name", "cstest")
+	output, err := cmd.CombinedOutput()
+
+	// C# compilation requires the .NET SDK (and package restore)
+	outputStr := string(output)
+	if err == nil {
+		AssertTrue(t, strings.Contains(outputStr, "Compiled"), "Should report successful compilation")
+	} else {
+		AssertFalse(t, strings.Contains(outputStr, "unsupported file extension"), "C# should be a supported language")
+		AssertTrue(t, strings.Contains(outputStr, "dotnet") ||
+			strings.Contains(outputStr, "not found"), "Should attempt C# compilation")
+	}
+}
+
+func TestCompileCargoBinOnlyForRust(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "hello", ".c", `int main() { return 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// --bin selects a Cargo binary and makes no sense for other languages
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--bin", "hello")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "--bin on a C source should fail")
+	AssertTrue(t, strings.Contains(string(output), "only supported for Rust"), "Should explain --bin is Cargo only")
+}
+
+func TestCompileMakefileProject(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// A Makefile project producing a single executable
+	projectDir := filepath.Join(dirs.Root, "mkproject")
+	err := os.MkdirAll(projectDir, 0755)
+	AssertNil(t, err, "Should create project directory")
+	CreateTestSourceFile(t, projectDir, "main", ".c", `int main() { return 0; }`)
+	err = os.WriteFile(filepath.Join(projectDir, "Makefile"), []byte("mktool: main.c\n\tcc -o mktool main.c\n"), 0644)
+	AssertNil(t, err, "Should create Makefile")
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", projectDir, "--name", "mktest")
+	output, err := cmd.CombinedOutput()
+
+	outputStr := string(output)
+	AssertFalse(t, strings.Contains(outputStr, "no supported project"), "Makefile directory should be recognised")
+	if err == nil {
+		AssertTrue(t, strings.Contains(outputStr, "Compiled"), "Should install the built executable")
+	} else {
+		AssertTrue(t, strings.Contains(outputStr, "make") ||
+			strings.Contains(outputStr, "not found"), "Should attempt a make build")
+	}
+}
+
+func TestCompileMesonProject(t *testing.T) {
+	// Setup: fake meson and ninja that log their arguments; ninja builds
+	// two executables into the build directory
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	argsLog := filepath.Join(dirs.Root, "args.log")
+	fakeBin := filepath.Join(dirs.Root, "fakebin")
+	os.MkdirAll(fakeBin, 0755)
+	fakeMeson := "#!/bin/bash\necho \"meson $*\" >> " + argsLog + "\nmkdir -p \"$2\" && touch \"$2/build.ninja\"\n"
+	fakeNinja := "#!/bin/bash\necho \"ninja $*\" >> " + argsLog + "\nfor name in mesontool helper; do\n  printf '#!/bin/sh\\necho built %s\\n' $name > \"$2/$name\"\n  chmod +x \"$2/$name\"\ndone\n"
+	os.WriteFile(filepath.Join(fakeBin, "meson"), []byte(fakeMeson), 0755)
+	os.WriteFile(filepath.Join(fakeBin, "ninja"), []byte(fakeNinja), 0755)
+	scriptsPath := SetupIsolatedScripts(t, dirs, nil)
+
+	projectDir := filepath.Join(dirs.Root, "mesonproject")
+	err := os.MkdirAll(projectDir, 0755)
+	AssertNil(t, err, "Should create project directory")
+	CreateTestSourceFile(t, projectDir, "main", "c", `int main() { return 0; }`)
+	err = os.WriteFile(filepath.Join(projectDir, "meson.build"), []byte("project('mesontool', 'c')\nexecutable('mesontool', 'main.c')\n"), 0644)
+	AssertNil(t, err, "Should create meson.build")
+	buildDir := filepath.Join(projectDir, "builddir")
+	env := append(os.Environ(), "PATH="+fakeBin+":"+os.Getenv("PATH"))
+
+	// Several executables and no --artifact can't be decided without a terminal
+	cmd := exec.Command(scriptsPath, "compile", projectDir, "--name", "mesontest")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	AssertNotNil(t, err, "Should not guess between executables")
+	AssertTrue(t, strings.Contains(string(output), "several executables (helper, mesontool)"), "Should list the candidates: "+string(output))
+	log := ReadFileContent(t, argsLog)
+	AssertTrue(t, strings.Contains(log, "meson setup "+buildDir+" "+projectDir+" --buildtype=release\n"), "Should set up a release build: "+log)
+	AssertTrue(t, strings.Contains(log, "ninja -C "+buildDir+"\n"), "Should build with ninja: "+log)
+
+	// An existing build directory is reconfigured and --artifact picks the binary
+	cmd = exec.Command(scriptsPath, "compile", projectDir, "--name", "mesontest", "--artifact", "mesontool", "--", "-Dfeature=on")
+	cmd.Env = env
+	output, err = cmd.CombinedOutput()
+	AssertNil(t, err, "Meson build should succeed: "+string(output))
+	log = ReadFileContent(t, argsLog)
+	AssertTrue(t, strings.Contains(log, "meson setup "+buildDir+" "+projectDir+" --buildtype=release --reconfigure -Dfeature=on\n"), "Should reconfigure with the build flags: "+log)
+
+	output, err = exec.Command(filepath.Join(dirs.BinDir, "mesontest")).CombinedOutput()
+	AssertNil(t, err, "Installed binary should run")
+	AssertEqual(t, "built mesontool", strings.TrimSpace(string(output)), "Should install the chosen artifact")
+}
+
 func TestCompileUnsupportedLanguage(t *testing.T) {
 	// Setup
 	dirs := SetupTestDirs(t)
@@ -216,6 +376,398 @@ func main() {
 	}
 }
 
+func TestCompileFlagPassthrough(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// The greeting only exists if the -D flag reaches the compiler
+	cFile := CreateTestSourceFile(t, dirs.Root, "flags", ".c", `#include <stdio.h>
+
+int main() {
+    printf("%s\n", GREETING);
+    return 0;
+}`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "flagstest", "--", "-DGREETING=\"hi\"", "-Wall")
+	output, err := cmd.CombinedOutput()
+
+	outputStr := string(output)
+	AssertFalse(t, strings.Contains(outputStr, "Usage:"), "Flags after -- should not be parsed as scripts flags")
+	if err == nil {
+		AssertTrue(t, strings.Contains(outputStr, "Compiled"), "Should compile with passthrough flags")
+	} else {
+		AssertTrue(t, strings.Contains(outputStr, "gcc") ||
+			strings.Contains(outputStr, "not found"), "Should attempt C compilation")
+	}
+
+	// Clean up any test binary that was created
+	testBinaryPath := filepath.Join("..", "opt", "programs", "flagstest")
+	if FileExists(t, testBinaryPath) {
+		_ = os.Remove(testBinaryPath) // Ignore error - cleanup
+	}
+}
+
+func TestCompileConfiguredCompiler(t *testing.T) {
+	// Setup: a fake C compiler that logs its arguments and writes a
+	// script to the -o path
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	argsLog := filepath.Join(dirs.Root, "args.log")
+	fakeCC := filepath.Join(dirs.Root, "fakecc")
+	err := os.WriteFile(fakeCC, []byte(`#!/bin/bash
+if [ "$2" = "--version" ]; then
+    echo "fakecc 1.0"
+    exit 0
+fi
+echo "$@" >> `+argsLog+`
+while [ $# -gt 0 ]; do
+    [ "$1" = "-o" ] && out="$2"
+    shift
+done
+printf '#!/bin/sh\necho built by fakecc\n' > "$out"
+`), 0755)
+	AssertNil(t, err, "Should write the fake compiler")
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers": map[string]string{"c": fakeCC + " --fake"},
+	})
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "faked", "c", `int main() { return 0; }`)
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "fakedtest", "--", "-DFAKE")
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Compile with the configured compiler should succeed: "+string(output))
+
+	// The configured command and its arguments come first, then the build's
+	args := strings.Fields(ReadFileContent(t, argsLog))
+	AssertTrue(t, len(args) == 5 && args[0] == "--fake" && args[1] == "-o" && args[3] == cFile && args[4] == "-DFAKE",
+		"Should invoke the configured compiler with the build arguments: "+strings.Join(args, " "))
+
+	binary := filepath.Join(dirs.BinDir, "fakedtest")
+	output, err = exec.Command(binary).CombinedOutput()
+	AssertNil(t, err, "Installed binary should run")
+	AssertEqual(t, "built by fakecc", strings.TrimSpace(string(output)), "Should install the fake compiler's output")
+}
+
+// writeFakeCC writes a fake C compiler that writes a script printing message
+// to the -o path
+func writeFakeCC(t *testing.T, path, message string) {
+	t.Helper()
+	script := `#!/bin/bash
+if [ "$2" = "--version" ]; then
+    echo "fakecc 1.0"
+    exit 0
+fi
+while [ $# -gt 0 ]; do
+    [ "$1" = "-o" ] && out="$2"
+    shift
+done
+printf '#!/bin/sh\necho ` + message + `\n' > "$out"
+`
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake compiler: %v", err)
+	}
+}
+
+func TestCompileCodesignGating(t *testing.T) {
+	// Setup: a fake codesign on PATH that logs its arguments
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	signLog := filepath.Join(dirs.Root, "sign.log")
+	fakeBin := filepath.Join(dirs.Root, "fakebin")
+	os.MkdirAll(fakeBin, 0755)
+	os.WriteFile(filepath.Join(fakeBin, "codesign"), []byte("#!/bin/bash\necho \"codesign $*\" >> "+signLog+"\n"), 0755)
+	fakeCC := filepath.Join(dirs.Root, "fakecc")
+	writeFakeCC(t, fakeCC, "built by fakecc")
+	cFile := CreateTestSourceFile(t, dirs.Root, "signed", "c", `int main() { return 0; }`)
+	env := append(os.Environ(), "PATH="+fakeBin+":"+os.Getenv("PATH"))
+
+	// Without an identity nothing is signed
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{"compilers": map[string]string{"c": fakeCC}})
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "signtest", "--force")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Compile should succeed: "+string(output))
+	AssertFalse(t, FileExists(t, signLog), "Should not sign without an identity")
+
+	// With one, binaries are signed on macOS only
+	scriptsPath = SetupIsolatedScripts(t, dirs, map[string]interface{}{"compilers": map[string]string{"c": fakeCC}, "codesignIdentity": "-"})
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "signtest", "--force")
+	cmd.Env = env
+	output, err = cmd.CombinedOutput()
+	AssertNil(t, err, "Compile should succeed: "+string(output))
+	if runtime.GOOS != "darwin" {
+		AssertFalse(t, FileExists(t, signLog), "Should only sign on macOS")
+		return
+	}
+	log := ReadFileContent(t, signLog)
+	AssertTrue(t, strings.HasPrefix(log, "codesign --force --sign - "), "Should sign ad hoc without the hardened runtime: "+log)
+
+	// Without codesign installed the build fails rather than installing unsigned
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "unsignedtest")
+	cmd.Env = append(os.Environ(), "PATH="+filepath.Dir(fakeCC))
+	output, err = cmd.CombinedOutput()
+	AssertNotNil(t, err, "Should fail without codesign")
+	AssertTrue(t, strings.Contains(string(output), "codesign not found"), "Should explain what's missing: "+string(output))
+	AssertFalse(t, FileExists(t, filepath.Join(dirs.BinDir, "unsignedtest")), "Should not install an unsigned binary")
+}
+
+func TestCompileCodesignNotarize(t *testing.T) {
+	if runtime.GOOS != "darwin" {
+		t.Skip("signing only happens on macOS")
+	}
+	// Setup: fake upx, codesign, ditto and xcrun on PATH that log in order
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	toolLog := filepath.Join(dirs.Root, "tools.log")
+	fakeBin := filepath.Join(dirs.Root, "fakebin")
+	os.MkdirAll(fakeBin, 0755)
+	for name, body := range map[string]string{
+		"upx":      "",
+		"codesign": "",
+		"ditto":    "touch \"$5\"\n",
+		"xcrun":    "exit $(cat " + filepath.Join(dirs.Root, "notary-status") + ")\n",
+	} {
+		os.WriteFile(filepath.Join(fakeBin, name), []byte("#!/bin/bash\necho \""+name+" $*\" >> "+toolLog+"\n"+body), 0755)
+	}
+	os.WriteFile(filepath.Join(dirs.Root, "notary-status"), []byte("0"), 0644)
+	fakeCC := filepath.Join(dirs.Root, "fakecc")
+	writeFakeCC(t, fakeCC, "first build")
+	cFile := CreateTestSourceFile(t, dirs.Root, "notarized", "c", `int main() { return 0; }`)
+	env := append(os.Environ(), "PATH="+fakeBin+":"+os.Getenv("PATH"))
+	identity := "Developer ID Application: Test (TEAMID)"
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers":        map[string]string{"c": fakeCC},
+		"codesignIdentity": identity,
+		"notaryProfile":    "test-profile",
+	})
+
+	// Signing follows --small, which would invalidate the signature
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "notarytest", "--small")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Compile should succeed: "+string(output))
+	AssertTrue(t, strings.Contains(string(output), "Notarized"), "Should report notarization: "+string(output))
+	lines := strings.Split(strings.TrimSpace(ReadFileContent(t, toolLog)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Should pack, sign, zip and submit, got: %s", strings.Join(lines, "; "))
+	}
+	AssertTrue(t, strings.HasPrefix(lines[0], "upx "), "Should pack first: "+lines[0])
+	AssertTrue(t, strings.HasPrefix(lines[1], "codesign --force --sign "+identity+" --options runtime --timestamp "), "Should sign for notarization: "+lines[1])
+	AssertTrue(t, strings.HasPrefix(lines[2], "ditto -c -k --keepParent "), "Should zip the binary: "+lines[2])
+	AssertTrue(t, strings.HasPrefix(lines[3], "xcrun notarytool submit ") && strings.HasSuffix(lines[3], "--keychain-profile test-profile --wait"), "Should submit with the profile: "+lines[3])
+
+	// A failed notarization keeps the binary being replaced
+	writeFakeCC(t, fakeCC, "second build")
+	os.WriteFile(filepath.Join(dirs.Root, "notary-status"), []byte("1"), 0644)
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "notarytest", "--force")
+	cmd.Env = env
+	output, err = cmd.CombinedOutput()
+	AssertNotNil(t, err, "Compile should fail when notarization does")
+	AssertTrue(t, strings.Contains(string(output), "notarization failed, notarytest was not installed"), "Should explain the failure: "+string(output))
+	output, err = exec.Command(filepath.Join(dirs.BinDir, "notarytest")).CombinedOutput()
+	AssertNil(t, err, "Installed binary should still run")
+	AssertEqual(t, "first build", strings.TrimSpace(string(output)), "Should keep the notarized build")
+}
+
+func TestCompileLanguageLayout(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	fakeCC := filepath.Join(dirs.Root, "fakecc")
+	writeFakeCC(t, fakeCC, "built by fakecc")
+	cFile := CreateTestSourceFile(t, dirs.Root, "laid", "c", `int main() { return 0; }`)
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers": map[string]string{"c": fakeCC},
+		"binLayout": "language",
+		"binShims":  true,
+	})
+
+	output, err := exec.Command(scriptsPath, "compile", cFile, "--name", "layouttest").CombinedOutput()
+	AssertNil(t, err, "Compile should succeed: "+string(output))
+
+	// The binary goes into its language's subdirectory, linked from shims
+	binary := filepath.Join(dirs.BinDir, "c", "layouttest")
+	AssertTrue(t, FileExists(t, binary), "Should install into the language subdirectory")
+	AssertFalse(t, FileExists(t, filepath.Join(dirs.BinDir, "layouttest")), "Should not install into BinDir itself")
+	shim := filepath.Join(dirs.BinDir, "shims", "layouttest")
+	target, err := os.Readlink(shim)
+	AssertNil(t, err, "Should link a shim")
+	AssertEqual(t, filepath.Join("..", "c", "layouttest"), target, "Shim should point at the binary")
+	output, err = exec.Command(shim).CombinedOutput()
+	AssertNil(t, err, "Shim should run")
+	AssertEqual(t, "built by fakecc", strings.TrimSpace(string(output)), "Shim should run the binary")
+
+	output, err = exec.Command(scriptsPath, "which", "layouttest").CombinedOutput()
+	AssertNil(t, err, "which should find the binary: "+string(output))
+	AssertEqual(t, binary, strings.TrimSpace(string(output)), "which should print the binary's path")
+
+	// Switching back to a flat layout moves the binary on its next build
+	scriptsPath = SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers": map[string]string{"c": fakeCC},
+		"binShims":  true,
+	})
+	output, err = exec.Command(scriptsPath, "compile", cFile, "--name", "layouttest", "--force").CombinedOutput()
+	AssertNil(t, err, "Rebuild should succeed: "+string(output))
+	AssertFalse(t, FileExists(t, binary), "Should remove the copy left by the old layout")
+	output, _ = exec.Command(scriptsPath, "which", "layouttest").CombinedOutput()
+	AssertEqual(t, filepath.Join(dirs.BinDir, "layouttest"), strings.TrimSpace(string(output)), "which should follow the move")
+	target, _ = os.Readlink(shim)
+	AssertEqual(t, filepath.Join("..", "layouttest"), target, "Shim should follow the move")
+}
+
+func TestBinVerifyAndRemove(t *testing.T) {
+	// Setup: two binaries built with a fake compiler
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	fakeCC := filepath.Join(dirs.Root, "fakecc")
+	writeFakeCC(t, fakeCC, "built by fakecc")
+	cFile := CreateTestSourceFile(t, dirs.Root, "verified", "c", `int main() { return 0; }`)
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{"compilers": map[string]string{"c": fakeCC}})
+	for _, name := range []string{"intact", "tampered"} {
+		output, err := exec.Command(scriptsPath, "compile", cFile, "--name", name).CombinedOutput()
+		AssertNil(t, err, "Compile should succeed: "+string(output))
+	}
+
+	output, err := exec.Command(scriptsPath, "bin", "verify").CombinedOutput()
+	AssertNil(t, err, "Unchanged binaries should verify: "+string(output))
+	AssertTrue(t, strings.Contains(string(output), "intact    ok") && strings.Contains(string(output), "tampered  ok"), "Should report both as ok: "+string(output))
+
+	// Changing a binary after it was built fails verification
+	tampered := filepath.Join(dirs.BinDir, "tampered")
+	f, err := os.OpenFile(tampered, os.O_APPEND|os.O_WRONLY, 0)
+	AssertNil(t, err, "Should open the binary")
+	f.WriteString("# tampered\n")
+	f.Close()
+	output, err = exec.Command(scriptsPath, "bin", "verify").CombinedOutput()
+	AssertNotNil(t, err, "A modified binary should fail verification")
+	AssertTrue(t, strings.Contains(string(output), "tampered  MODIFIED since it was built"), "Should flag the modified binary: "+string(output))
+	AssertTrue(t, strings.Contains(string(output), "intact    ok"), "Should still pass the intact binary: "+string(output))
+	AssertTrue(t, strings.Contains(string(output), "1 binaries failed verification"), "Should count the failure: "+string(output))
+	output, err = exec.Command(scriptsPath, "bin", "verify", "intact").CombinedOutput()
+	AssertNil(t, err, "Verifying only the intact binary should pass: "+string(output))
+
+	// Removing a binary forgets it too, so verify no longer reports it missing
+	output, err = exec.Command(scriptsPath, "bin", "rm", "tampered").CombinedOutput()
+	AssertNil(t, err, "bin rm should succeed: "+string(output))
+	AssertFalse(t, FileExists(t, tampered), "Should remove the binary")
+	var manifest struct {
+		Binaries map[string]json.RawMessage `json:"binaries"`
+	}
+	err = json.Unmarshal([]byte(ReadFileContent(t, filepath.Join(dirs.BinDir, ".scripts-manifest.json"))), &manifest)
+	AssertNil(t, err, "Manifest should be valid JSON")
+	_, recorded := manifest.Binaries["tampered"]
+	AssertFalse(t, recorded, "Should remove the manifest entry")
+	_, recorded = manifest.Binaries["intact"]
+	AssertTrue(t, recorded, "Should keep other manifest entries")
+	output, err = exec.Command(scriptsPath, "bin", "verify").CombinedOutput()
+	AssertNil(t, err, "Remaining binaries should verify: "+string(output))
+	AssertFalse(t, strings.Contains(string(output), "tampered"), "Should not report the removed binary: "+string(output))
+}
+
+func TestCompileGitRecordsOrigin(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("Go not available")
+	}
+
+	// Setup: a local repository with a Go tool in a subdirectory
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	scriptsPath := SetupIsolatedScripts(t, dirs, nil)
+	repo := filepath.Join(dirs.Root, "gittool")
+	CommitTestRepo(t, repo, map[string]string{
+		"go.mod":            "module example.com/gittool\n\ngo 1.21\n",
+		"cmd/hello/main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello from git\")\n}\n",
+	})
+	head, err := exec.Command("git", "-C", repo, "rev-parse", "HEAD").Output()
+	AssertNil(t, err, "Should read the repository's commit")
+
+	cmd := exec.Command(scriptsPath, "compile-git", repo, "--path", "cmd/hello", "--name", "gittool")
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "compile-git should succeed: "+string(output))
+
+	binary := filepath.Join(dirs.BinDir, "gittool")
+	output, err = exec.Command(binary).CombinedOutput()
+	AssertNil(t, err, "Installed binary should run")
+	AssertEqual(t, "hello from git", strings.TrimSpace(string(output)), "Should install the binary built from the repository")
+
+	// The manifest remembers where the binary came from
+	var manifest struct {
+		Binaries map[string]struct {
+			Git *struct {
+				URL    string `json:"url"`
+				Path   string `json:"path"`
+				Commit string `json:"commit"`
+			} `json:"git"`
+		} `json:"binaries"`
+	}
+	err = json.Unmarshal([]byte(ReadFileContent(t, filepath.Join(dirs.BinDir, ".scripts-manifest.json"))), &manifest)
+	AssertNil(t, err, "Manifest should be valid JSON")
+	origin := manifest.Binaries["gittool"].Git
+	AssertTrue(t, origin != nil, "Should record the origin")
+	if origin != nil {
+		AssertEqual(t, repo, origin.URL, "Should record the repository")
+		AssertEqual(t, "cmd/hello", origin.Path, "Should record the path in the repository")
+		AssertEqual(t, strings.TrimSpace(string(head)), origin.Commit, "Should record the commit")
+	}
+}
+
+func TestCompileSkipsUnchangedSources(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "cached", ".c", `int main() { return 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// The first build may or may not happen depending on earlier runs
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "cachetest", "--force")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("C compiler not available: %s", output)
+	}
+
+	// Nothing changed, so the second build is skipped
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "cachetest", "--if-changed")
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Up to date compile should succeed")
+	AssertTrue(t, strings.Contains(string(output), "up to date"), "Should report the binary as up to date")
+
+	// Different flags invalidate the cache
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "cachetest", "--", "-O2")
+	output, err = cmd.CombinedOutput()
+	AssertNil(t, err, "Compile with new flags should succeed")
+	AssertTrue(t, strings.Contains(string(output), "Compiled"), "Changed flags should trigger a rebuild")
+}
+
+func TestCompileUnknownFlag(t *testing.T) {
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// Compiler flags without a -- separator are rejected
+	cmd := exec.Command(scriptsPath, "compile", "hello.c", "-O3")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "Unknown flag should fail")
+	AssertTrue(t, strings.Contains(string(output), "unknown flag"), "Should report unknown flag")
+	AssertTrue(t, strings.Contains(string(output), "Usage:"), "Should show usage")
+}
+
+func TestCompileRunRequiresWatch(t *testing.T) {
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", "hello.c", "--run")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "--run without --watch should fail")
+	AssertTrue(t, strings.Contains(string(output), "--run requires --watch"), "Should explain --run needs --watch")
+}
+
 func TestCompileMissingSourceFile(t *testing.T) {
 	// Change to scripts directory
 	// Scripts binary is in parent directory
@@ -294,3 +846,530 @@ func main() {
 		_ = os.Remove(testBinaryPath) // Ignore error - cleanup
 	}
 }
+
+func TestCompileDirectoryBatch(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// A directory of tools, plus a file that isn't a supported source
+	toolsDir := filepath.Join(dirs.Root, "tools")
+	err := os.MkdirAll(toolsDir, 0755)
+	AssertNil(t, err, "Should create tools directory")
+	CreateTestSourceFile(t, toolsDir, "batch_one", ".c", `int main() { return 0; }`)
+	CreateTestSourceFile(t, toolsDir, "batch_two", ".c", `int main() { return 1; }`)
+	CreateTestSourceFile(t, toolsDir, "notes", ".txt", "not a source")
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", "--dir", toolsDir, "--jobs", "2")
+	output, _ := cmd.CombinedOutput()
+
+	outputStr := string(output)
+	AssertTrue(t, strings.Contains(outputStr, "batch_one"), "Should build the first source")
+	AssertTrue(t, strings.Contains(outputStr, "batch_two"), "Should build the second source")
+	AssertFalse(t, strings.Contains(outputStr, "notes"), "Should skip unsupported files")
+	AssertTrue(t, strings.Contains(outputStr, "STATUS"), "Should print a summary table")
+}
+
+func TestCompileDirectoryBatchFailureReason(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	scriptsPath := SetupIsolatedScripts(t, dirs, nil)
+
+	// gcc ends its output with a note, not the error
+	toolsDir := filepath.Join(dirs.Root, "tools")
+	err := os.MkdirAll(toolsDir, 0755)
+	AssertNil(t, err, "Should create tools directory")
+	CreateTestSourceFile(t, toolsDir, "broken", "c", "int main() {\n    return missing;\n}\n")
+
+	cmd := exec.Command(scriptsPath, "compile", "--dir", toolsDir)
+	output, err := cmd.CombinedOutput()
+	if !strings.Contains(string(output), "STATUS") {
+		t.Skipf("C compiler not available: %s", output)
+	}
+	AssertNotNil(t, err, "A failed build should fail the batch")
+	var row string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "broken ") {
+			row = line
+		}
+	}
+	AssertTrue(t, strings.Contains(row, "broken.c:2:12: ") && strings.Contains(row, "missing"), "Should give the first error as the reason: "+row)
+	AssertFalse(t, strings.Contains(row, "note:"), "Should not give a trailing note as the reason: "+row)
+}
+
+func TestRebuildUntrackedBinary(t *testing.T) {
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// Binaries without a build record cannot be rebuilt
+	cmd := exec.Command(scriptsPath, "rebuild", "definitely_not_tracked_binary")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "Rebuilding an untracked binary should fail")
+	AssertTrue(t, strings.Contains(string(output), "no build record"), "Should explain the binary is not tracked")
+}
+
+func TestRebuildInvalidJobs(t *testing.T) {
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "rebuild", "--jobs", "zero")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "Invalid --jobs should fail")
+	AssertTrue(t, strings.Contains(string(output), "positive number"), "Should explain --jobs needs a number")
+}
+
+func TestBinRollbackRestoresPreviousBuild(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "versioned", ".c", `int main() { return 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "versiontest", "--force")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("C compiler not available: %s", output)
+	}
+
+	// Recompiling keeps the build it replaces
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "versiontest", "--force")
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Second compile should succeed")
+
+	cmd = exec.Command(scriptsPath, "bin", "versions", "versiontest")
+	output, err = cmd.CombinedOutput()
+	AssertNil(t, err, "bin versions should succeed")
+	AssertTrue(t, strings.Contains(string(output), "versiontest@"), "Should list the previous build")
+
+	cmd = exec.Command(scriptsPath, "bin", "rollback", "versiontest")
+	output, err = cmd.CombinedOutput()
+	AssertNil(t, err, "bin rollback should succeed")
+	AssertTrue(t, strings.Contains(string(output), "Rolled back versiontest"), "Should report the rollback")
+
+	// Clean up
+	exec.Command(scriptsPath, "bin", "rm", "versiontest").Run()
+}
+
+func TestFailedCompileKeepsVersions(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+	scriptsPath := SetupIsolatedScripts(t, dirs, nil)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "failing", "c", `int main() { return 0; }`)
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "failtest", "--force")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("C compiler not available: %s", output)
+	}
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "failtest", "--force")
+	_, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Second compile should succeed")
+
+	cmd = exec.Command(scriptsPath, "bin", "versions", "failtest")
+	before, err := cmd.CombinedOutput()
+	AssertNil(t, err, "bin versions should succeed")
+
+	// Broken builds neither replace the binary nor save another copy of it
+	CreateTestSourceFile(t, dirs.Root, "failing", "c", `int main() { return missing; }`)
+	for i := 0; i < 2; i++ {
+		cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "failtest", "--force")
+		_, err = cmd.CombinedOutput()
+		AssertNotNil(t, err, "Broken compile should fail")
+	}
+
+	cmd = exec.Command(scriptsPath, "bin", "versions", "failtest")
+	after, err := cmd.CombinedOutput()
+	AssertNil(t, err, "bin versions should succeed")
+	AssertEqual(t, string(before), string(after), "Failed compiles should not change the saved versions")
+	AssertTrue(t, FileExists(t, filepath.Join(dirs.BinDir, "failtest")), "Failed compiles should keep the installed binary")
+}
+
+func TestCompileSmokeTestBlocksBrokenBuild(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// Exits non-zero whenever it is given arguments, e.g. --help
+	cFile := CreateTestSourceFile(t, dirs.Root, "smoky", ".c", `int main(int argc, char **argv) { return argc > 1 ? 3 : 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "smoketest", "--force", "--smoke")
+	output, err := cmd.CombinedOutput()
+	if err != nil && !strings.Contains(string(output), "smoke test failed") {
+		t.Skipf("C compiler not available: %s", output)
+	}
+	AssertNotNil(t, err, "Failing smoke test should fail the compile")
+	AssertTrue(t, strings.Contains(string(output), "was not installed"), "Should report that the binary was not installed")
+
+	// Without arguments the binary succeeds, so it is installed
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "smoketest", "--force", "--smoke=")
+	output, err = cmd.CombinedOutput()
+	AssertNil(t, err, "Passing smoke test should install the binary")
+	AssertTrue(t, strings.Contains(string(output), "Smoke test passed"), "Should report the smoke test")
+
+	// Clean up
+	exec.Command(scriptsPath, "bin", "rm", "smoketest").Run()
+}
+
+func TestCompileSmallReportsSize(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	scriptsPath := SetupIsolatedScripts(t, dirs, nil)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "tiny", "c", `int main() { return 0; }`)
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "smalltest", "--force")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("C compiler not available: %s", output)
+	}
+
+	// The size is reported against the unoptimised build being replaced
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--name", "smalltest", "--force", "--small")
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "Small compile should succeed: "+string(output))
+	AssertTrue(t, strings.Contains(string(output), "Size:") && strings.Contains(string(output), " -> "), "Should report the size before and after: "+string(output))
+}
+
+func TestCompileStaticUnsupportedLanguage(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	pyFile := CreateTestSourceFile(t, dirs.Root, "hello", ".py", `print("hello")`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// Python bundles can't be statically linked, so fail before building
+	cmd := exec.Command(scriptsPath, "compile", pyFile, "--static")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "Static Python build should fail")
+	AssertTrue(t, strings.Contains(string(output), "--static is not supported"), "Should explain static linking is unsupported")
+}
+
+func TestCompileBuildEnvRequiresKeyValue(t *testing.T) {
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", "hello.c", "--build-env", "CC")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "Malformed --build-env should fail")
+	AssertTrue(t, strings.Contains(string(output), "expected KEY=VAL"), "Should explain the expected format")
+}
+
+func TestCompileTagsAndFeaturesPerLanguage(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "hello", ".c", `int main() { return 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--tags", "netgo")
+	output, err := cmd.CombinedOutput()
+	AssertNotNil(t, err, "--tags should be rejected for C")
+	AssertTrue(t, strings.Contains(string(output), "--tags is only supported for Go"), "Should explain --tags is Go only")
+
+	cmd = exec.Command(scriptsPath, "compile", cFile, "--features", "tls")
+	output, err = cmd.CombinedOutput()
+	AssertNotNil(t, err, "--features should be rejected for C")
+	AssertTrue(t, strings.Contains(string(output), "--features is only supported"), "Should explain --features is Cargo only")
+}
+
+func TestCompilePythonZipappBackend(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	pyFile := CreateTestSourceFile(t, dirs.Root, "zipped", ".py", `print("Hello from a zipapp!")`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	// zipapp only needs the Python standard library
+	cmd := exec.Command(scriptsPath, "compile", pyFile, "--name", "zipapptest", "--force", "--backend", "zipapp")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		AssertTrue(t, strings.Contains(string(output), "zipapp"), "Should attempt zipapp packaging")
+		return
+	}
+	AssertTrue(t, strings.Contains(string(output), "Compiled"), "Should report successful packaging")
+
+	// Clean up
+	exec.Command(scriptsPath, "bin", "rm", "zipapptest").Run()
+}
+
+func TestCompileUnknownPythonBackend(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	pyFile := CreateTestSourceFile(t, dirs.Root, "hello", ".py", `print("hello")`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", pyFile, "--backend", "py2exe")
+	output, err := cmd.CombinedOutput()
+
+	AssertNotNil(t, err, "Unknown backend should fail")
+	AssertTrue(t, strings.Contains(string(output), "unknown Python backend"), "Should name the problem")
+	AssertTrue(t, strings.Contains(string(output), "zipapp"), "Should list the supported backends")
+}
+
+func TestCompileDetectsLanguageWithoutExtension(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// No extension - the #include gives it away as C
+	cFile := filepath.Join(dirs.Root, "extless")
+	err := os.WriteFile(cFile, []byte(`#include <stdio.h>
+
+int main() {
+    printf("Hello from an extensionless source!\n");
+    return 0;
+}`), 0644)
+	AssertNil(t, err, "Should create extensionless source")
+	unknownFile := filepath.Join(dirs.Root, "notes")
+	err = os.WriteFile(unknownFile, []byte("just some notes"), 0644)
+	AssertNil(t, err, "Should create extensionless notes")
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "extlesstest", "--force")
+	output, err := cmd.CombinedOutput()
+	outputStr := string(output)
+	AssertFalse(t, strings.Contains(outputStr, "could not detect"), "Should detect C from the source")
+	if err == nil {
+		AssertTrue(t, strings.Contains(outputStr, "Compiled"), "Should report successful compilation")
+		exec.Command(scriptsPath, "bin", "rm", "extlesstest").Run()
+	}
+
+	cmd = exec.Command(scriptsPath, "compile", unknownFile)
+	output, err = cmd.CombinedOutput()
+	AssertNotNil(t, err, "Undetectable source should fail")
+	AssertTrue(t, strings.Contains(string(output), "could not detect the language"), "Should explain detection failed")
+}
+
+func TestCompileWasmTarget(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	goFile := CreateTestSourceFile(t, dirs.Root, "wasmy", ".go", `package main
+
+import "fmt"
+
+func main() {
+    fmt.Println("Hello from WASI!")
+}`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", goFile, "--name", "wasmtest", "--force", "--target", "wasm")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Skipf("Go WASI toolchain not available: %s", output)
+	}
+	AssertTrue(t, strings.Contains(string(output), "wasmtest.wasm"), "Should produce a .wasm module")
+
+	// Clean up
+	exec.Command(scriptsPath, "bin", "rm", "wasmtest.wasm").Run()
+
+	// Unknown targets are rejected up front
+	cmd = exec.Command(scriptsPath, "compile", goFile, "--target", "riscv")
+	output, err = cmd.CombinedOutput()
+	AssertNotNil(t, err, "Unknown target should fail")
+	AssertTrue(t, strings.Contains(string(output), "unsupported --target"), "Should report the unsupported target")
+}
+
+func TestContainerizeWritesBuildContext(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "boxed", ".c", `int main() { return 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "containertest", "--force")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("C compiler not available: %s", output)
+	}
+	defer exec.Command(scriptsPath, "bin", "rm", "containertest").Run()
+
+	// --output writes the Dockerfile without needing a container engine
+	contextDir := filepath.Join(dirs.Root, "context")
+	cmd = exec.Command(scriptsPath, "containerize", "containertest", "--base", "debian:stable-slim", "--output", contextDir)
+	output, err := cmd.CombinedOutput()
+	AssertNil(t, err, "containerize --output should succeed")
+	AssertTrue(t, strings.Contains(string(output), "Wrote Dockerfile"), "Should report the build context")
+
+	dockerfile := ReadFileContent(t, filepath.Join(contextDir, "Dockerfile"))
+	AssertTrue(t, strings.Contains(dockerfile, "FROM debian:stable-slim"), "Should use the requested base image")
+	AssertTrue(t, strings.Contains(dockerfile, `ENTRYPOINT ["/usr/local/bin/containertest"]`), "Should run the binary")
+	AssertTrue(t, FileExists(t, filepath.Join(contextDir, "containertest")), "Should copy the binary into the context")
+}
+
+func TestPackageWritesDebAndTarball(t *testing.T) {
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "packed", ".c", `int main() { return 0; }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", cFile, "--name", "packagetest", "--force")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("C compiler not available: %s", output)
+	}
+	defer exec.Command(scriptsPath, "bin", "rm", "packagetest").Run()
+
+	distDir := filepath.Join(dirs.Root, "dist")
+	for _, format := range []string{"tar.gz", "deb"} {
+		cmd = exec.Command(scriptsPath, "package", "packagetest", "--format", format, "--version", "1.2.3", "--output", distDir)
+		output, err := cmd.CombinedOutput()
+		AssertNil(t, err, "package --format "+format+" should succeed")
+		AssertTrue(t, strings.Contains(string(output), "Packaged packagetest 1.2.3"), "Should report the package")
+	}
+
+	tarball := filepath.Join(distDir, "packagetest-1.2.3-"+runtime.GOOS+"-"+runtime.GOARCH+".tar.gz")
+	AssertTrue(t, FileExists(t, tarball), "Should write the tarball")
+	AssertTrue(t, strings.Contains(ReadFileContent(t, tarball+".sha256"), filepath.Base(tarball)), "Should write a checksum file")
+
+	debs, _ := filepath.Glob(filepath.Join(distDir, "packagetest_1.2.3_*.deb"))
+	AssertTrue(t, len(debs) == 1, "Should write the deb")
+	AssertTrue(t, strings.HasPrefix(ReadFileContent(t, debs[0]), "!<arch>\ndebian-binary"), "Deb should be an ar archive")
+	if _, err := exec.LookPath("dpkg-deb"); err == nil {
+		output, err := exec.Command("dpkg-deb", "--info", debs[0]).CombinedOutput()
+		AssertNil(t, err, "dpkg-deb should accept the package: "+string(output))
+		AssertTrue(t, strings.Contains(string(output), "Package: packagetest"), "Should name the package")
+	}
+}
+
+func TestCompileMissingCompilerSuggestsInstall(t *testing.T) {
+	if _, err := exec.LookPath("v"); err == nil {
+		t.Skip("V is installed")
+	}
+
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	vFile := CreateTestSourceFile(t, dirs.Root, "missing", ".v", `fn main() { println("hi") }`)
+
+	// Scripts binary is in parent directory
+	scriptsPath := filepath.Join("..", "scripts")
+
+	cmd := exec.Command(scriptsPath, "compile", vFile, "--name", "missingcompilertest")
+	output, err := cmd.CombinedOutput()
+	AssertNotNil(t, err, "compile should fail without a compiler")
+	AssertTrue(t, strings.Contains(string(output), "v not found - install it"), "Should say how to install the compiler")
+	AssertFalse(t, strings.Contains(string(output), "exit status"), "Should not surface the bare exec error")
+}
+
+func TestCompilePinnedToolchain(t *testing.T) {
+	gccVersion, err := exec.Command("gcc", "-dumpfullversion").Output()
+	if err != nil {
+		t.Skip("gcc not available")
+	}
+
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "pinned", "c", `int main() { return 0; }`)
+
+	// A pin the installed compiler doesn't satisfy fails before building
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers":  map[string]string{"c": "gcc"},
+		"toolchains": map[string]string{"c": "1.0"},
+	})
+	output, err := exec.Command(scriptsPath, "compile", cFile).CombinedOutput()
+	AssertNotNil(t, err, "compile should fail on a toolchain mismatch")
+	AssertTrue(t, strings.Contains(string(output), "c toolchain mismatch: config pins 1.0"), "Should explain the mismatch")
+	AssertFalse(t, FileExists(t, filepath.Join(dirs.BinDir, "pinned")), "Should not build with the wrong toolchain")
+
+	// The installed version satisfies a pin on it
+	scriptsPath = SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers":  map[string]string{"c": "gcc"},
+		"toolchains": map[string]string{"c": strings.TrimSpace(string(gccVersion))},
+	})
+	output, err = exec.Command(scriptsPath, "compile", cFile).CombinedOutput()
+	AssertNil(t, err, "compile should succeed with the pinned toolchain: "+string(output))
+	AssertTrue(t, FileExists(t, filepath.Join(dirs.BinDir, "pinned")), "Should install the binary")
+}
+
+func TestCompileFailureShowsBuildLog(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not available")
+	}
+
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	// A link error has no source location, so the end of the output is shown
+	cFile := CreateTestSourceFile(t, dirs.Root, "unlinked", "c", `int missing(void); int main() { return missing(); }`)
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers": map[string]string{"c": "gcc"},
+	})
+
+	output, err := exec.Command(scriptsPath, "compile", cFile).CombinedOutput()
+	AssertNotNil(t, err, "compile should fail")
+	logPath := filepath.Join(dirs.BinDir, ".logs", "unlinked.log")
+	AssertTrue(t, strings.Contains(string(output), "lines of build output"), "Should show the end of the build output")
+	AssertTrue(t, strings.Contains(string(output), "Full build log: "+logPath), "Should point at the build log")
+	AssertTrue(t, strings.Contains(ReadFileContent(t, logPath), "missing"), "Log should hold the linker output")
+
+	// --verbose-build streams the output as it happens
+	output, _ = exec.Command(scriptsPath, "compile", cFile, "--verbose-build").CombinedOutput()
+	AssertTrue(t, strings.Contains(string(output), "undefined reference"), "Should stream the compiler output")
+	AssertFalse(t, strings.Contains(string(output), "lines of build output"), "Should not repeat streamed output")
+}
+
+func TestCompileErrorSummary(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not available")
+	}
+
+	// Setup
+	dirs := SetupTestDirs(t)
+	defer CleanupTestDirs(t, dirs.Root)
+
+	cFile := CreateTestSourceFile(t, dirs.Root, "broken", "c", "int main() {\n    return undefined_thing;\n}\n")
+	scriptsPath := SetupIsolatedScripts(t, dirs, map[string]interface{}{
+		"compilers": map[string]string{"c": "gcc"},
+	})
+
+	output, err := exec.Command(scriptsPath, "compile", cFile).CombinedOutput()
+	AssertNotNil(t, err, "compile should fail")
+	AssertTrue(t, strings.Contains(string(output), "Build failed with 1 error"), "Should count the errors")
+	AssertTrue(t, strings.Contains(string(output), "broken.c:2:12: 'undefined_thing' undeclared"), "Should summarise the error with its location")
+	AssertTrue(t, strings.Contains(string(output), "2 |     return undefined_thing;"), "Should show the offending line")
+	AssertTrue(t, strings.Contains(string(output), "Error: failed to compile broken"), "Should not end with a bare exit status")
+	AssertFalse(t, strings.Contains(string(output), "exit status"), "Should not show the exit status")
+}
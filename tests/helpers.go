@@ -1,11 +1,17 @@
 package tests
 
 import (
+	"bufio"
 	"encoding/json"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // TestConfig represents the configuration structure
@@ -86,6 +92,248 @@ func CreateTestConfig(t *testing.T, configFile string, scriptDir, binDir string)
 	}
 }
 
+// SetupIsolatedScripts copies the scripts binary into the test root with its
+// own config (the test directories plus any extra settings), so config
+// driven behaviour can be tested without touching the real config
+func SetupIsolatedScripts(t *testing.T, dirs *TestDirs, extra map[string]interface{}) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "scripts"))
+	if err != nil {
+		t.Fatalf("Failed to read scripts binary: %v", err)
+	}
+	scriptsPath := filepath.Join(dirs.Root, "scripts")
+	if err := os.WriteFile(scriptsPath, data, 0755); err != nil {
+		t.Fatalf("Failed to copy scripts binary: %v", err)
+	}
+
+	config := map[string]interface{}{
+		"scriptDir": dirs.ScriptsBin,
+		"binDir":    dirs.BinDir,
+	}
+	for key, value := range extra {
+		config[key] = value
+	}
+	data, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if err := os.WriteFile(dirs.ConfigFile, data, 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return scriptsPath
+}
+
+// StartServe starts "scripts serve" on a free local port and returns its
+// base URL. The daemon is stopped when the test ends.
+func StartServe(t *testing.T, scriptsPath string) string {
+	t.Helper()
+	base, _ := startServe(t, scriptsPath, false)
+	return base
+}
+
+// StartServeGRPC starts "scripts serve" with gRPC on free local ports and
+// returns its base URL and gRPC address
+func StartServeGRPC(t *testing.T, scriptsPath string) (string, string) {
+	t.Helper()
+	return startServe(t, scriptsPath, true)
+}
+
+func startServe(t *testing.T, scriptsPath string, withGRPC bool) (string, string) {
+	t.Helper()
+
+	args := []string{"serve", "--addr", "127.0.0.1:0"}
+	if withGRPC {
+		args = append(args, "--grpc-addr", "127.0.0.1:0")
+	}
+	cmd := exec.Command(scriptsPath, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to capture serve output: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start serve: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read serve output: %v", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "Listening" {
+		t.Fatalf("Unexpected serve output: %s", line)
+	}
+	if !withGRPC {
+		return fields[2], ""
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read serve output: %v", err)
+	}
+	grpcFields := strings.Fields(line)
+	if len(grpcFields) < 4 || grpcFields[1] != "gRPC" {
+		t.Fatalf("Unexpected serve output: %s", line)
+	}
+	return fields[2], grpcFields[3]
+}
+
+// FakeMQTTBroker is a minimal MQTT 3.1.1 broker for tests: it accepts a
+// client, acknowledges its subscriptions and publishes to it at QoS 0
+type FakeMQTTBroker struct {
+	Addr       string
+	subscribed chan string
+	mu         sync.Mutex
+	conn       net.Conn
+}
+
+// StartFakeMQTTBroker listens on a free local port until the test ends
+func StartFakeMQTTBroker(t *testing.T) *FakeMQTTBroker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start MQTT broker: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	broker := &FakeMQTTBroker{Addr: listener.Addr().String(), subscribed: make(chan string, 16)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			broker.mu.Lock()
+			broker.conn = conn
+			broker.mu.Unlock()
+			go broker.serve(conn)
+		}
+	}()
+	return broker
+}
+
+func (b *FakeMQTTBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			digit, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(digit&127) * multiplier
+			multiplier *= 128
+			if digit&128 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 8: // SUBSCRIBE: packet ID, then length-prefixed filters and QoS
+			ack := []byte{0x90, 2, body[0], body[1]}
+			for i := 2; i+2 <= len(body); {
+				n := int(body[i])<<8 | int(body[i+1])
+				b.subscribed <- string(body[i+2 : i+2+n])
+				i += 2 + n + 1
+				ack = append(ack, 0)
+				ack[1]++
+			}
+			b.write(ack)
+		case 12: // PINGREQ
+			b.write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (b *FakeMQTTBroker) write(packet []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Write(packet)
+	}
+}
+
+// WaitSubscribed waits for the client to subscribe to a topic filter
+func (b *FakeMQTTBroker) WaitSubscribed(t *testing.T, topic string) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case subscribed := <-b.subscribed:
+			if subscribed == topic {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a subscription to %s", topic)
+		}
+	}
+}
+
+// Publish sends a message to the client
+func (b *FakeMQTTBroker) Publish(topic, payload string) {
+	body := append([]byte{byte(len(topic) >> 8), byte(len(topic))}, topic...)
+	body = append(body, payload...)
+	packet := []byte{0x30}
+	for length := len(body); ; {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 128
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	b.write(append(packet, body...))
+}
+
+// CommitTestRepo writes files (relative path to content) into a git
+// repository at dir, creating it if needed, and commits them
+func CommitTestRepo(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v\n%s", args[0], err, output)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create repository: %v", err)
+		}
+		git("init", "--quiet")
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "update")
+}
+
 // CreateTestScript creates a test script file
 func CreateTestScript(t *testing.T, dir, name, content string) string {
 	t.Helper()
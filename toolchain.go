@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// toolchainPlugins are the mise/asdf plugin names for languages whose
+// pinned version is selected through a version manager
+var toolchainPlugins = map[string]string{
+	"python": "python",
+	"csharp": "dotnet",
+	"v":      "vlang",
+	"cmake":  "cmake",
+}
+
+// pinnedVersion returns the version pinned for lang without any tool prefix,
+// e.g. "1.22.3" for "go1.22.3" and "1.78" for "rustc 1.78"
+func pinnedVersion(lang string, config *Config) string {
+	pin := strings.TrimSpace(config.Toolchains[lang])
+	switch lang {
+	case "go":
+		return strings.TrimPrefix(pin, "go")
+	case "rust":
+		return strings.TrimSpace(strings.TrimPrefix(pin, "rustc"))
+	}
+	return pin
+}
+
+// toolchainEnv returns the environment that selects the pinned toolchain for
+// lang: GOTOOLCHAIN for Go, RUSTUP_TOOLCHAIN for Rust, and the mise or asdf
+// version variables for other languages
+func toolchainEnv(lang string, config *Config) []string {
+	version := pinnedVersion(lang, config)
+	if version == "" {
+		return nil
+	}
+	switch lang {
+	case "go":
+		return []string{"GOTOOLCHAIN=go" + version}
+	case "rust":
+		return []string{"RUSTUP_TOOLCHAIN=" + version}
+	}
+	plugin, ok := toolchainPlugins[lang]
+	if !ok {
+		return nil
+	}
+	var env []string
+	if _, err := exec.LookPath("mise"); err == nil {
+		env = append(env, "MISE_"+strings.ToUpper(plugin)+"_VERSION="+version)
+	}
+	if _, err := exec.LookPath("asdf"); err == nil {
+		env = append(env, "ASDF_"+strings.ToUpper(plugin)+"_VERSION="+version)
+	}
+	return env
+}
+
+// checkToolchain verifies that the toolchain a job will run is the pinned
+// version, so a build never silently uses whatever happens to be installed
+func checkToolchain(job *CompileJob, lang, tool string) error {
+	version := pinnedVersion(lang, job.Config)
+	if version == "" || !strings.ContainsAny(version[:1], "0123456789") {
+		// Channels such as "stable" are selected but can't be verified
+		return nil
+	}
+
+	found := toolchainVersion(job, lang, tool)
+	if found == "" {
+		return fmt.Errorf("could not run the %s toolchain pinned to %s%s", lang, job.Config.Toolchains[lang], toolchainHint(lang, version))
+	}
+	if !versionMatches(found, version) {
+		return fmt.Errorf("%s toolchain mismatch: config pins %s but found %q%s", lang, job.Config.Toolchains[lang], found, toolchainHint(lang, version))
+	}
+	return nil
+}
+
+// toolchainVersion reports the version of the toolchain a job runs. Python
+// builds run a packager, so the interpreter's version is checked instead.
+func toolchainVersion(job *CompileJob, lang, tool string) string {
+	if lang == "python" {
+		cmd := exec.Command("python3", "--version")
+		if len(job.Env) > 0 {
+			cmd.Env = append(os.Environ(), job.Env...)
+		}
+		output, err := cmd.Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(output))
+	}
+	return compilerVersion(tool, job.Env, job.Config)
+}
+
+// versionMatches reports whether a version line contains version, either
+// exactly or as a prefix of a longer version ("1.78" matches "1.78.0")
+func versionMatches(line, version string) bool {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	for _, field := range fields {
+		if field == version || strings.HasPrefix(field, version+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// toolchainHint tells the user how to get the pinned toolchain
+func toolchainHint(lang, version string) string {
+	switch lang {
+	case "go":
+		return " (GOTOOLCHAIN selection needs Go 1.21 or newer and network access the first time)"
+	case "rust":
+		return fmt.Sprintf(" (run 'rustup toolchain install %s')", version)
+	}
+	if plugin, ok := toolchainPlugins[lang]; ok {
+		return fmt.Sprintf(" (run 'mise install %s@%s' or 'asdf install %s %s')", plugin, version, plugin, version)
+	}
+	return " (install it and point compilers." + lang + " in the config at it)"
+}
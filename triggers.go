@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+)
+
+// TriggersConfig runs scripts when messages arrive from a message broker,
+// while "scripts serve" runs
+type TriggersConfig struct {
+	// Broker is the broker's URL: mqtt://, mqtts://, ws:// or wss:// for
+	// MQTT, nats:// for NATS, with credentials as user:password@
+	Broker string `json:"broker"`
+	// ClientID identifies the daemon to an MQTT broker (default:
+	// "scripts-<hostname>")
+	ClientID string `json:"clientId,omitempty"`
+	// Rules map topics or subjects to script runs
+	Rules []*TriggerRule `json:"rules"`
+	// MaxRuns limits how many triggered runs happen at once (default: 4);
+	// messages arriving while that many run are dropped
+	MaxRuns int `json:"maxRuns,omitempty"`
+}
+
+// defaultTriggerRuns is how many triggered runs happen at once by default
+const defaultTriggerRuns = 4
+
+// TriggerRule runs a script for every message on a topic. Args and vars may
+// use {{payload}}, {{payload.<field>}} (of a JSON payload; nested fields as
+// a.b), {{topic}} and {{topic.<n>}} (the n-th level, from 1). Anyone who
+// can publish to the topic controls these values, so vars, which are pasted
+// into the script's source, are shell-quoted like any daemon run's: use
+// such a variable unquoted in the template, e.g. echo {{who}}.
+type TriggerRule struct {
+	// Topic is an MQTT topic filter ("home/+/motion", "home/#") or a NATS
+	// subject ("home.*.motion", "home.>")
+	Topic  string   `json:"topic"`
+	Script string   `json:"script"`
+	Args   []string `json:"args,omitempty"`
+	// Vars are template variables, as given with --var
+	Vars map[string]string `json:"vars,omitempty"`
+	// Queue waits for a running exclusive script instead of dropping the
+	// message
+	Queue bool `json:"queue,omitempty"`
+}
+
+// triggerBus is a broker connection triggers subscribe through
+type triggerBus interface {
+	subscribe(topic string, handle func(topic string, payload []byte)) error
+	close()
+}
+
+// checkTriggers rejects trigger settings that can't be used
+func checkTriggers(config *Config) error {
+	triggers := config.Triggers
+	if triggers == nil || len(triggers.Rules) == 0 {
+		return nil
+	}
+	if _, err := brokerKind(triggers.Broker); err != nil {
+		return err
+	}
+	if triggers.MaxRuns < 0 {
+		return fmt.Errorf("triggers.maxRuns must not be negative")
+	}
+	for i, rule := range triggers.Rules {
+		label := fmt.Sprintf("triggers.rules[%d]", i)
+		if rule.Topic == "" {
+			return fmt.Errorf("%s has no topic", label)
+		}
+		if !validName(rule.Script) {
+			return fmt.Errorf("%s has invalid script name %q", label, rule.Script)
+		}
+		templates := slices.Clone(rule.Args)
+		for _, value := range rule.Vars {
+			templates = append(templates, value)
+		}
+		for _, template := range templates {
+			for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+				if root, _, _ := strings.Cut(match[1], "."); root != "payload" && root != "topic" {
+					return fmt.Errorf("%s uses unknown placeholder {{%s}} (use payload or topic)", label, match[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// brokerKind returns "mqtt" or "nats" for a broker URL
+func brokerKind(broker string) (string, error) {
+	if broker == "" {
+		return "", fmt.Errorf("triggers.broker is required for trigger rules")
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", fmt.Errorf("invalid triggers.broker %q: %v", broker, err)
+	}
+	switch u.Scheme {
+	case "mqtt", "mqtts", "tcp", "ssl", "tls", "ws", "wss":
+		return "mqtt", nil
+	case "nats":
+		return "nats", nil
+	}
+	return "", fmt.Errorf("unsupported triggers.broker scheme %q (use mqtt, mqtts, ws, wss or nats)", u.Scheme)
+}
+
+// connectBus connects to the configured broker
+func connectBus(triggers *TriggersConfig) (triggerBus, error) {
+	kind, err := brokerKind(triggers.Broker)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "nats" {
+		conn, err := nats.Connect(triggers.Broker, nats.Name("scripts"), nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %v", redactURL(triggers.Broker), err)
+		}
+		return &natsBus{conn: conn}, nil
+	}
+
+	clientID := triggers.ClientID
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = "scripts-" + host
+	}
+	bus := &mqttBus{handlers: map[string]mqtt.MessageHandler{}}
+	opts := mqtt.NewClientOptions().
+		AddBroker(triggers.Broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		// A reconnect may start a clean session, so subscribe again
+		SetOnConnectHandler(func(client mqtt.Client) { bus.resubscribe(client) })
+	bus.client = mqtt.NewClient(opts)
+	token := bus.client.Connect()
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		bus.client.Disconnect(0)
+		err := token.Error()
+		if err == nil {
+			err = fmt.Errorf("timed out")
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %v", redactURL(triggers.Broker), err)
+	}
+	return bus, nil
+}
+
+// redactURL hides a broker URL's password in messages
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
+
+// mqttBus subscribes to MQTT topics at QoS 1
+type mqttBus struct {
+	client   mqtt.Client
+	mu       sync.Mutex
+	handlers map[string]mqtt.MessageHandler
+}
+
+func (b *mqttBus) subscribe(topic string, handle func(topic string, payload []byte)) error {
+	handler := func(_ mqtt.Client, msg mqtt.Message) { handle(msg.Topic(), msg.Payload()) }
+	b.mu.Lock()
+	b.handlers[topic] = handler
+	b.mu.Unlock()
+	token := b.client.Subscribe(topic, 1, handler)
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", topic, token.Error())
+	}
+	return nil
+}
+
+func (b *mqttBus) resubscribe(client mqtt.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for topic, handler := range b.handlers {
+		client.Subscribe(topic, 1, handler)
+	}
+}
+
+func (b *mqttBus) close() {
+	b.client.Disconnect(250)
+}
+
+// natsBus subscribes to NATS subjects
+type natsBus struct {
+	conn *nats.Conn
+}
+
+func (b *natsBus) subscribe(subject string, handle func(topic string, payload []byte)) error {
+	if _, err := b.conn.Subscribe(subject, func(msg *nats.Msg) { handle(msg.Subject, msg.Data) }); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", subject, err)
+	}
+	return b.conn.Flush()
+}
+
+func (b *natsBus) close() {
+	b.conn.Drain()
+}
+
+// startTriggers connects to the broker and subscribes to the rules' topics,
+// running their scripts through the daemon for each message. The returned
+// function disconnects.
+func startTriggers(s *Server) (func(), error) {
+	triggers := s.config.Triggers
+	if triggers == nil || len(triggers.Rules) == 0 {
+		return func() {}, nil
+	}
+	bus, err := connectBus(triggers)
+	if err != nil {
+		return nil, err
+	}
+	// A flood of messages mustn't start a run for each at once
+	limit := triggers.MaxRuns
+	if limit == 0 {
+		limit = defaultTriggerRuns
+	}
+	slots := make(chan struct{}, limit)
+	for _, rule := range triggers.Rules {
+		rule := rule
+		if err := bus.subscribe(rule.Topic, func(topic string, payload []byte) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				fmt.Printf("Trigger %s: dropped, %d triggered runs are already running\n", topic, limit)
+				return
+			}
+			// Runs may take a while, and mustn't hold up the connection
+			go func() {
+				defer func() { <-slots }()
+				s.runTrigger(rule, topic, payload)
+			}()
+		}); err != nil {
+			bus.close()
+			return nil, err
+		}
+	}
+	fmt.Printf("Triggering scripts from %d topics on %s\n", len(triggers.Rules), redactURL(triggers.Broker))
+	return bus.close, nil
+}
+
+// runTrigger runs a rule's script for a message, logging the outcome
+func (s *Server) runTrigger(rule *TriggerRule, topic string, payload []byte) {
+	req := &RunRequest{Queue: rule.Queue, Vars: map[string]string{}}
+	for _, arg := range rule.Args {
+		req.Args = append(req.Args, expandTrigger(arg, topic, payload))
+	}
+	for key, value := range rule.Vars {
+		req.Vars[key] = expandTrigger(value, topic, payload)
+	}
+	run, err := s.prepareRun(rule.Script, req, nil)
+	if err != nil {
+		fmt.Printf("Trigger %s: %v\n", topic, err)
+		return
+	}
+	defer run.close()
+	record, err := s.execute(run, func([]byte) {}, nil)
+	if err != nil {
+		fmt.Printf("Trigger %s: %v\n", topic, err)
+		return
+	}
+	fmt.Printf("Trigger %s: ran %s (run %s, exit code %d)\n", topic, rule.Script, record.ID, record.ExitCode)
+}
+
+// expandTrigger fills in a rule's placeholders from a message; fields the
+// payload lacks are empty
+func expandTrigger(template, topic string, payload []byte) string {
+	return placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return triggerValue(placeholder, topic, payload)
+	})
+}
+
+// triggerValue returns what a placeholder stands for in a message
+func triggerValue(placeholder, topic string, payload []byte) string {
+	name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+	root, field, _ := strings.Cut(name, ".")
+	switch {
+	case root == "topic" && field == "":
+		return topic
+	case root == "topic":
+		levels := strings.FieldsFunc(topic, func(r rune) bool { return r == '/' || r == '.' })
+		if n, err := strconv.Atoi(field); err == nil && n >= 1 && n <= len(levels) {
+			return levels[n-1]
+		}
+		return ""
+	case field == "":
+		return string(payload)
+	}
+	return payloadField(payload, field)
+}
+
+// payloadField returns a field of a JSON payload as text: strings as they
+// are, other values as JSON
+func payloadField(payload []byte, field string) string {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value interface{}
+	if decoder.Decode(&value) != nil {
+		return ""
+	}
+	for _, key := range strings.Split(field, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return ""
+			}
+			value = v[i]
+		default:
+			return ""
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
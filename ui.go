@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// plainEnv turns on plain output like --plain
+const plainEnv = "SCRIPTS_PLAIN"
+
+// plain is set by --plain before the command, or plain in the config
+var plain bool
+
+// ANSI colour codes used for status output
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+	colorCyan   = "36"
+	colorBold   = "1"
+)
+
+// stripPlainFlag removes --plain from the flags before the command or
+// script name, turning plain output on
+func stripPlainFlag(args []string) []string {
+	args, plain = stripLeadingFlag(args, "--plain", plain)
+	return args
+}
+
+// isPlain reports whether output is kept plain for screen readers and dumb
+// terminals: no colours, spinners or symbols, just stable lines of text
+func isPlain() bool {
+	switch strings.ToLower(os.Getenv(plainEnv)) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return plain || os.Getenv("TERM") == "dumb"
+}
+
+// colorEnabled reports whether stdout should receive ANSI colours
+func colorEnabled() bool {
+	if os.Getenv("NO_COLOR") != "" || isPlain() {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+// colorize wraps text in an ANSI colour when stdout is a colour terminal
+func colorize(code, text string) string {
+	if !colorEnabled() {
+		return text
+	}
+	return "\033[" + code + "m" + text + "\033[0m"
+}
+
+// statusMark starts a line reporting success or failure: a coloured tick or
+// cross, or a word in plain mode
+func statusMark(ok bool) string {
+	switch {
+	case ok && isPlain():
+		return "PASS "
+	case isPlain():
+		return "FAIL "
+	case ok:
+		return colorize(colorGreen, "✓ ")
+	}
+	return colorize(colorRed, "✗ ")
+}
+
+// canPrompt reports whether questions can be asked: answers come from a
+// terminal and the question reaches one. Otherwise commands fall back to
+// defaults, flags such as --yes, or a clear error.
+func canPrompt() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// umask returns the process umask; Windows has none
+func umask() os.FileMode {
+	return 0
+}
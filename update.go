@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// scriptVersionsDir holds previous versions of each script, one
+// subdirectory per script, inside the state directory
+const scriptVersionsDir = "versions"
+
+// ScriptVersion is a previous version of a script saved by "scripts update"
+type ScriptVersion struct {
+	ID      string
+	Path    string
+	SavedAt time.Time
+}
+
+// scriptVersions returns the saved versions of a script, oldest first
+func scriptVersions(name string, config *Config) ([]*ScriptVersion, error) {
+	dir := filepath.Join(stateDir(config), scriptVersionsDir, name)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []*ScriptVersion
+	for _, entry := range entries {
+		id, ok := strings.CutPrefix(strings.TrimSuffix(entry.Name(), ".sh"), name+"@")
+		info, err := entry.Info()
+		if !ok || err != nil {
+			continue
+		}
+		versions = append(versions, &ScriptVersion{ID: id, Path: filepath.Join(dir, entry.Name()), SavedAt: info.ModTime()})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID < versions[j].ID })
+	return versions, nil
+}
+
+// saveScriptVersion keeps a copy of a script before it is replaced, named
+// after when it was last changed, and prunes the oldest copies beyond
+// keepVersions. It returns the saved version's ID, or "" when versioning
+// is disabled.
+func saveScriptVersion(name, path string, config *Config) (string, error) {
+	keep := keepVersions(config)
+	if keep == 0 {
+		return "", nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	versions, err := scriptVersions(name, config)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(stateDir(config), scriptVersionsDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create versions directory: %v", err)
+	}
+	id := info.ModTime().Format("20060102-150405")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, name+"@"+id+".sh")); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", info.ModTime().Format("20060102-150405"), n)
+	}
+	versionPath := filepath.Join(dir, name+"@"+id+".sh")
+	if err := copyFile(path, versionPath); err != nil {
+		return "", fmt.Errorf("failed to save previous version of %s: %v", name, err)
+	}
+	// The copy's time records when it was replaced
+	now := time.Now()
+	os.Chtimes(versionPath, now, now)
+
+	for len(versions) >= keep {
+		os.Remove(versions[0].Path)
+		versions = versions[1:]
+	}
+	return id, nil
+}
+
+// updateScript replaces a managed script with the file at path when their
+// contents differ, showing the diff and saving the replaced version. The
+// script keeps its permissions and its recorded origin.
+func updateScript(name, path string, config *Config) error {
+	current := scriptInDir(config.ScriptDir, name)
+	if current == "" {
+		if _, err := findScript(name, config); err == nil {
+			return fmt.Errorf("%s is a system-wide script; only an administrator can update it", name)
+		}
+		return fmt.Errorf("script %s isn't managed yet (add it with 'scripts add %s')", name, path)
+	}
+	name = strings.TrimSuffix(filepath.Base(current), ".sh")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	existing, err := os.ReadFile(current)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", current, err)
+	}
+	if bytes.Equal(content, existing) {
+		fmt.Printf("%s is already up to date\n", filepath.Base(current))
+		return nil
+	}
+	warnOwner(name, current)
+
+	if err := showDiff(current, content); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't show the changes: %v\n", err)
+	}
+	info, err := os.Stat(current)
+	if err != nil {
+		return err
+	}
+	id, err := saveScriptVersion(name, current, config)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(current, content, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %v", current, err)
+	}
+	if err := os.Chmod(current, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to keep the permissions of %s: %v", current, err)
+	}
+
+	fmt.Printf("Updated %s\n", filepath.Base(current))
+	if id != "" {
+		fmt.Printf("Previous version saved as %s@%s (see 'scripts versions %s')\n", name, id, name)
+	}
+	return nil
+}
+
+// printScriptVersions lists the saved versions of a script, newest first
+func printScriptVersions(name string, config *Config) error {
+	versions, err := scriptVersions(name, config)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		if _, err := findScript(name, config); err != nil {
+			return err
+		}
+		fmt.Printf("No previous versions of %s are saved\n", name)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tREPLACED\tPATH")
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		fmt.Fprintf(w, "%s@%s\t%s\t%s\n", name, version.ID, version.SavedAt.Format("2006-01-02 15:04"), version.Path)
+	}
+	return w.Flush()
+}
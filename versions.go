@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// versionsDir holds previous builds of each binary, one subdirectory per
+// binary, inside BinDir
+const versionsDir = ".versions"
+
+// defaultKeepVersions is how many previous builds are kept per binary when
+// the config doesn't say otherwise
+const defaultKeepVersions = 5
+
+// BinaryVersion is a previous build of a binary kept for rollback
+type BinaryVersion struct {
+	ID      string        `json:"id"`
+	Path    string        `json:"path"` // relative to BinDir
+	SavedAt time.Time     `json:"savedAt"`
+	Record  *BinaryRecord `json:"record,omitempty"`
+}
+
+// keepVersions returns how many previous builds to keep per binary
+func keepVersions(config *Config) int {
+	if config.KeepVersions == 0 {
+		return defaultKeepVersions
+	}
+	if config.KeepVersions < 0 {
+		return 0
+	}
+	return config.KeepVersions
+}
+
+// archiveBinary saves the current build of a binary before it is replaced,
+// pruning the oldest saved builds beyond the configured limit
+func archiveBinary(name string, config *Config) error {
+	keep := keepVersions(config)
+	binPath, err := binaryPath(name, config)
+	if keep == 0 || err != nil {
+		return nil
+	}
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return nil
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return err
+	}
+
+	// Versions are named after when they were built, which is what the user
+	// remembers when picking one to go back to
+	record := manifest.Binaries[name]
+	builtAt := info.ModTime()
+	if record != nil && !record.BuiltAt.IsZero() {
+		builtAt = record.BuiltAt
+	}
+	id := builtAt.Format("20060102-150405")
+	for n := 2; versionIndex(manifest.Versions[name], id) >= 0; n++ {
+		id = fmt.Sprintf("%s-%d", builtAt.Format("20060102-150405"), n)
+	}
+
+	relPath := filepath.Join(versionsDir, name, name+"@"+id)
+	if err := os.MkdirAll(filepath.Join(config.BinDir, versionsDir, name), 0755); err != nil {
+		return fmt.Errorf("failed to create versions directory: %v", err)
+	}
+	if err := copyFile(binPath, filepath.Join(config.BinDir, relPath)); err != nil {
+		return fmt.Errorf("failed to save previous version of %s: %v", name, err)
+	}
+
+	if manifest.Versions == nil {
+		manifest.Versions = map[string][]*BinaryVersion{}
+	}
+	versions := append(manifest.Versions[name], &BinaryVersion{
+		ID:      id,
+		Path:    relPath,
+		SavedAt: time.Now(),
+		Record:  record,
+	})
+	for len(versions) > keep {
+		os.Remove(filepath.Join(config.BinDir, versions[0].Path))
+		versions = versions[1:]
+	}
+	manifest.Versions[name] = versions
+	return saveManifest(manifest, config)
+}
+
+// versionIndex finds a saved version by ID, returning -1 if there is none
+func versionIndex(versions []*BinaryVersion, id string) int {
+	for i, version := range versions {
+		if version.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// printBinaryVersions lists the saved builds of a binary, newest first
+func printBinaryVersions(name string, config *Config) error {
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return err
+	}
+	versions := manifest.Versions[name]
+	if len(versions) == 0 {
+		if _, err := binaryPath(name, config); err != nil {
+			return err
+		}
+		fmt.Printf("No previous versions of %s are saved\n", name)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tREPLACED\tBUILD")
+	if record := manifest.Binaries[name]; record != nil {
+		fmt.Fprintf(w, "current\t-\t%s\n", binarySummary(record))
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		fmt.Fprintf(w, "%s@%s\t%s\t%s\n", name, version.ID, version.SavedAt.Format("2006-01-02 15:04"), binarySummary(version.Record))
+	}
+	return w.Flush()
+}
+
+// rollbackBinary restores a saved build of a binary, the most recent one if
+// id is empty. The build it replaces is discarded.
+func rollbackBinary(name, id string, config *Config) (*BinaryVersion, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifest, err := loadManifest(config)
+	if err != nil {
+		return nil, err
+	}
+	versions := manifest.Versions[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no previous versions of %s are saved", name)
+	}
+
+	index := len(versions) - 1
+	if id != "" {
+		if index = versionIndex(versions, id); index < 0 {
+			return nil, fmt.Errorf("version %s of %s not found (see 'scripts bin versions %s')", id, name, name)
+		}
+	}
+	version := versions[index]
+
+	// Restore into the current layout, which may differ from when the
+	// version was built
+	binPath, err := binaryPath(name, config)
+	if err != nil {
+		lang := ""
+		if version.Record != nil {
+			lang = version.Record.Language
+		}
+		dir, err := installDir(lang, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+		binPath = filepath.Join(dir, name)
+	}
+	versionPath := filepath.Join(config.BinDir, version.Path)
+	if err := copyFile(versionPath, binPath); err != nil {
+		return nil, fmt.Errorf("failed to restore %s@%s: %v", name, version.ID, err)
+	}
+	os.Remove(versionPath)
+	if err := placeBinary(name, binPath, config); err != nil {
+		return nil, err
+	}
+
+	if version.Record != nil {
+		manifest.Binaries[name] = version.Record
+	} else {
+		delete(manifest.Binaries, name)
+	}
+	manifest.Versions[name] = append(versions[:index:index], versions[index+1:]...)
+	if len(manifest.Versions[name]) == 0 {
+		delete(manifest.Versions, name)
+	}
+	return version, saveManifest(manifest, config)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// visibilityKey sets who a script is for: "# scripts:visibility private"
+// keeps it out of exports, published bundles and the sync remote;
+// "shared", the default, doesn't
+const visibilityKey = "visibility"
+
+// Visibility levels
+const (
+	visibilityShared  = "shared"
+	visibilityPrivate = "private"
+)
+
+// scriptVisibility returns a script's visibility level
+func scriptVisibility(path string) string {
+	meta, err := readMetadata(path)
+	if err != nil {
+		return visibilityShared
+	}
+	if strings.EqualFold(meta.value(visibilityKey), visibilityPrivate) {
+		return visibilityPrivate
+	}
+	return visibilityShared
+}
+
+// isPrivate reports whether a script is marked private
+func isPrivate(path string) bool {
+	return scriptVisibility(path) == visibilityPrivate
+}
+
+// privateScripts returns the private scripts of the scripts directory, by
+// path relative to it
+func privateScripts(config *Config) []string {
+	var files []string
+	filepath.WalkDir(config.ScriptDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, encryptedSuffix) && isPrivate(path) {
+			rel, _ := filepath.Rel(config.ScriptDir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files
+}
+
+// privateError refuses to share a private script
+func privateError(name, action string) error {
+	return fmt.Errorf("%s is private (# scripts:%s %s), so it isn't %s; pass --include-private to include it anyway", name, metadataPrefix+visibilityKey, visibilityPrivate, action)
+}
+
+// localOnlyMarker starts the block of .git/info/exclude that scripts sync
+// manages: files that stay on this machine
+const localOnlyMarker = "# Kept out of the sync remote by scripts (private scripts and plaintext of encrypted files)"
+
+// keepLocal keeps private scripts and the plaintext of encrypted files out
+// of the sync remote: they are listed in the repository's local exclude
+// file, and untracked if an earlier sync committed them. Private scripts
+// named in sync.encrypt are still synced, encrypted.
+func keepLocal(config *Config) error {
+	dir := config.ScriptDir
+	var files []string
+	if encrypting(config) {
+		encrypted, err := encryptedFiles(config)
+		if err != nil {
+			return err
+		}
+		files = append(files, encrypted...)
+	}
+	if sealed, err := syncGit(dir, "ls-files", "--", "*"+encryptedSuffix); err == nil && sealed != "" {
+		for _, file := range strings.Split(sealed, "\n") {
+			files = append(files, strings.TrimSuffix(file, encryptedSuffix))
+		}
+	}
+	encrypted := slices.Clone(files)
+	private := privateScripts(config)
+	files = append(files, private...)
+
+	exclude := filepath.Join(dir, ".git", "info", "exclude")
+	data, _ := os.ReadFile(exclude)
+	kept, _, _ := strings.Cut(string(data), localOnlyMarker)
+	content := strings.TrimRight(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	seen := map[string]bool{}
+	var lines []string
+	for _, file := range files {
+		if !seen[file] {
+			seen[file] = true
+			lines = append(lines, "/"+file)
+		}
+	}
+	if len(lines) > 0 {
+		content += localOnlyMarker + "\n" + strings.Join(lines, "\n") + "\n"
+	}
+	if content != string(data) {
+		if err := os.MkdirAll(filepath.Dir(exclude), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(exclude, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to update %s: %v", exclude, err)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+	tracked, err := syncGit(dir, append([]string{"ls-files", "--"}, files...)...)
+	if err != nil || tracked == "" {
+		return err
+	}
+	for _, file := range strings.Split(tracked, "\n") {
+		if _, err := syncGit(dir, "rm", "--cached", "--quiet", "--", file); err != nil {
+			return err
+		}
+		if slices.Contains(private, file) && !slices.Contains(encrypted, file) {
+			fmt.Printf("Stopped syncing %s, which is private: it stays here, but leaves the remote (and other machines on their next sync)\n", file)
+		}
+	}
+	return nil
+}
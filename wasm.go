@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// targetWasm builds a WASI module instead of a native binary
+const targetWasm = "wasm"
+
+// wasmRustTargets are the Rust WASI targets tried in order; older toolchains
+// only know the wasm32-wasi name
+var wasmRustTargets = []string{"wasm32-wasip1", "wasm32-wasi"}
+
+// wasmDir returns where WASI modules are stored, away from native binaries
+func wasmDir(config *Config) string {
+	if config.WasmDir != "" {
+		return expandPath(config.WasmDir)
+	}
+	return filepath.Join(config.BinDir, "wasm")
+}
+
+// wasmName returns the file name a module is stored under
+func wasmName(name string) string {
+	return strings.TrimSuffix(name, ".wasm") + ".wasm"
+}
+
+// wasmTool returns the tool key used to compile lang to WASI
+func wasmTool(lang string) string {
+	if lang == "c" || lang == "cpp" {
+		// GCC has no WASM backend
+		return "wasm-" + lang
+	}
+	return lang
+}
+
+// prepareWasm sets up a job to produce a WASI module, failing early when the
+// language or the installed toolchain can't produce one
+func prepareWasm(job *CompileJob, lang string) error {
+	switch lang {
+	case "go":
+		job.Env = append(job.Env, "GOOS=wasip1", "GOARCH=wasm")
+		return nil
+	case "rust":
+		for _, target := range wasmRustTargets {
+			if rustTargetInstalled(target, job.Config) {
+				job.RustTarget = target
+				return nil
+			}
+		}
+		return fmt.Errorf("no Rust WASI target is installed (run 'rustup target add %s')", wasmRustTargets[0])
+	case "c", "cpp":
+		flags := []string{"--target=wasm32-wasi"}
+		if job.Config.WasiSysroot != "" {
+			flags = append(flags, "--sysroot="+expandPath(job.Config.WasiSysroot))
+		}
+		job.Flags = append(flags, job.Flags...)
+		return nil
+	}
+	return fmt.Errorf("--target wasm is not supported for %s (supported: Go, Rust, C, C++)", lang)
+}
+
+// runWasm runs a stored WASI module with wasmtime, giving it access to the
+// current directory
+func runWasm(name string, args []string, config *Config) error {
+	modulePath := filepath.Join(wasmDir(config), wasmName(name))
+	if _, err := os.Stat(modulePath); err != nil {
+		return fmt.Errorf("module %s not found in %s (build it with 'scripts compile <source> --target wasm')", wasmName(name), wasmDir(config))
+	}
+	runtime := resolveCompiler("wasmtime", config)
+	if _, err := exec.LookPath(runtime[0]); err != nil {
+		return fmt.Errorf("%s not found - install wasmtime to run WASM modules", runtime[0])
+	}
+	runArgs := append(append([]string{}, runtime[1:]...), "run", "--dir=.", modulePath)
+	cmd := exec.Command(runtime[0], append(runArgs, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
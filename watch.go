@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long the watcher waits for a burst of file events
+// (editors often write, rename and chmod on a single save) to settle
+const watchDebounce = 300 * time.Millisecond
+
+// watchAndCompile rebuilds the source whenever it changes until interrupted.
+// With run set, the fresh binary is started after every successful build,
+// replacing the previous instance.
+func watchAndCompile(opts *CompileOptions, run bool, config *Config) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start file watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	absSource, err := filepath.Abs(opts.SourcePath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(absSource)
+	if err != nil {
+		return fmt.Errorf("source file %s does not exist", opts.SourcePath)
+	}
+
+	// Watch the containing directory rather than the file itself, since many
+	// editors save by writing a new file and renaming it into place
+	relevant := func(path string) bool { return path == absSource }
+	if info.IsDir() {
+		err = filepath.WalkDir(absSource, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			if path != absSource && (hashSkipDirs[d.Name()] || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		})
+		relevant = func(path string) bool {
+			return !strings.HasPrefix(filepath.Base(path), ".") && !isExecutable(path)
+		}
+	} else {
+		err = watcher.Add(filepath.Dir(absSource))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %v", opts.SourcePath, err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	// The previous instance of the binary, if --run started one; done is
+	// closed once it has exited
+	var running *exec.Cmd
+	var done chan struct{}
+	stopRunning := func() {
+		if running == nil {
+			return
+		}
+		select {
+		case <-done:
+		default:
+			_ = running.Process.Signal(syscall.SIGTERM)
+			<-done
+		}
+		running = nil
+	}
+	defer stopRunning()
+
+	build := func() {
+		fmt.Println(colorize(colorBold, fmt.Sprintf("[%s] Building %s", time.Now().Format("15:04:05"), opts.SourcePath)))
+		result, err := compileSource(opts, config)
+		if err != nil {
+			fmt.Println(statusMark(false) + colorize(colorRed, "BUILD FAILED: "+err.Error()))
+			return
+		}
+		fmt.Println(statusMark(true) + colorize(colorGreen, "BUILD OK: "+result.OutputPath))
+		if run {
+			stopRunning()
+			running = exec.Command(result.OutputPath)
+			running.Stdout = os.Stdout
+			running.Stderr = os.Stderr
+			if err := running.Start(); err != nil {
+				fmt.Println(statusMark(false) + colorize(colorRed, "failed to run "+result.Name+": "+err.Error()))
+				running = nil
+				return
+			}
+			done = make(chan struct{})
+			go func(cmd *exec.Cmd, done chan struct{}) {
+				_ = cmd.Wait()
+				close(done)
+			}(running, done)
+		}
+	}
+
+	// Rebuilds after the first one are always real builds
+	build()
+	opts.Force = true
+	fmt.Printf("Watching %s for changes (Ctrl-C to stop)\n", opts.SourcePath)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod || !relevant(event.Name) {
+				continue
+			}
+			// New directories inside a watched project need watching too
+			if event.Op&fsnotify.Create != 0 && info.IsDir() {
+				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+					_ = watcher.Add(event.Name)
+				}
+			}
+			debounce = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Printf("Watch error: %v\n", err)
+		case <-debounce:
+			debounce = nil
+			build()
+		case <-interrupt:
+			fmt.Println()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// projectRootEnv tells scripts the root of the project they were run in
+const projectRootEnv = "SCRIPTS_PROJECT_ROOT"
+
+// gitRootWorkdir is the workdir running a script from the project root
+const gitRootWorkdir = "git-root"
+
+// projectMarkers returns the files or directories marking a project root
+// (default: .git)
+func projectMarkers(config *Config) []string {
+	if len(config.ProjectMarkers) > 0 {
+		return config.ProjectMarkers
+	}
+	return []string{".git"}
+}
+
+// projectRoot walks up from dir to the nearest directory holding one of
+// the project markers, returning "" when there is none
+func projectRoot(dir string, config *Config) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, marker := range projectMarkers(config) {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// scriptWorkdir returns the directory a script runs in: cwd when given
+// (--cwd), else the directory it declares with "# scripts:workdir <dir>",
+// relative to the script's own directory, else "" for the current
+// directory. "git-root" is the project root above the current directory.
+func scriptWorkdir(path, cwd string, config *Config) (string, error) {
+	dir := cwd
+	if dir == "" {
+		meta, err := readMetadata(path)
+		if err != nil {
+			return "", err
+		}
+		workdir := meta.value("workdir")
+		if workdir == "" {
+			return "", nil
+		}
+		if workdir == gitRootWorkdir {
+			current, err := os.Getwd()
+			if err != nil {
+				return "", err
+			}
+			root := projectRoot(current, config)
+			if root == "" {
+				return "", fmt.Errorf("%s runs from the project root, but no %s was found in %s or above it",
+					filepath.Base(path), strings.Join(projectMarkers(config), " or "), current)
+			}
+			return root, nil
+		}
+		dir = expandPath(workdir)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("working directory %s of %s doesn't exist", abs, filepath.Base(path))
+	}
+	return abs, nil
+}